@@ -0,0 +1,135 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+type chatHub struct {
+	Hub
+}
+
+func (c *chatHub) Name() string {
+	return "chat"
+}
+
+type metricsHub struct {
+	Hub
+}
+
+func (m *metricsHub) Name() string {
+	return "metrics"
+}
+
+// runAddHubConnection runs a testingConnection on server and returns it when it is connected
+func runAddHubConnection(server *Server) *testingConnection {
+	started := make(chan struct{}, 1)
+	unsubscribe := server.SubscribeEvents(func(event ServerEvent) { started <- struct{}{} }, ConnectionStarted)
+	defer unsubscribe()
+	conn := newTestingConnection()
+	conn.ConnectionID()
+	go server.Run(conn)
+	Eventually(started).Should(Receive())
+	return conn
+}
+
+func expectNoClientFunc(conn *testingConnection) {
+	Consistently(func() bool {
+		select {
+		case message := <-conn.received:
+			_, ok := message.(invocationMessage)
+			return ok
+		default:
+			return false
+		}
+	}, 100*time.Millisecond).Should(BeFalse())
+}
+
+var _ = Describe("AddHub", func() {
+
+	newChatServer := func(options ...Option) (*Server, *Server) {
+		chat, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&chatHub{}),
+			Logger(log.NewNopLogger(), false)}, options...)...)
+		Expect(err).To(BeNil())
+		metrics, err := chat.AddHub(SimpleHubFactory(&metricsHub{}))
+		Expect(err).To(BeNil())
+		return chat, metrics
+	}
+
+	Context("When two hubs are mapped on different paths", func() {
+		It("should route each path to its hub", func() {
+			chat, metrics := newChatServer()
+			router := http.NewServeMux()
+			chat.MapHTTP(router, "/chat")
+			metrics.MapHTTP(router, "/metrics")
+			testServer := httptest.NewServer(router)
+			defer testServer.Close()
+			for _, path := range []string{"chat", "metrics"} {
+				client, err := Dial(testServer.URL+"/"+path, WithLogger(log.NewNopLogger(), false))
+				Expect(err).To(BeNil())
+				var name string
+				Expect(client.Invoke(context.Background(), "name", &name)).To(BeNil())
+				Expect(name).To(Equal(path))
+				Expect(client.Close()).To(BeNil())
+			}
+		})
+	})
+
+	Context("When connections of both hubs are in groups with the same name", func() {
+		It("should only invoke the group of the hub", func() {
+			chat, metrics := newChatServer()
+			chatConn, metricsConn := runAddHubConnection(chat), runAddHubConnection(metrics)
+			chat.Groups().AddToGroup("room", chatConn.ConnectionID())
+			metrics.Groups().AddToGroup("room", metricsConn.ConnectionID())
+			chat.HubClients().Group("room").Send("clientFunc")
+			expectClientFunc(chatConn)
+			expectNoClientFunc(metricsConn)
+		})
+	})
+
+	Context("When a connection of one hub starts", func() {
+		It("should not raise events on the other hub", func() {
+			chat, metrics := newChatServer()
+			events := make(chan ServerEvent, 10)
+			chat.SubscribeEvents(func(event ServerEvent) { events <- event })
+			runAddHubConnection(metrics)
+			Consistently(events, 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+
+	Context("When both hubs share a backplane", func() {
+		It("should only pass the messages to the clients of the same hub", func() {
+			chat, metrics := newChatServer(UseBackplane(&memoryBackplane{}))
+			chatConn, metricsConn := runAddHubConnection(chat), runAddHubConnection(metrics)
+			chat.HubClients().All().Send("clientFunc")
+			expectClientFunc(chatConn)
+			expectNoClientFunc(metricsConn)
+		})
+	})
+
+	Context("When the server is shut down", func() {
+		It("should close the connections of the added hubs, too", func() {
+			chat, metrics := newChatServer()
+			chatConn, metricsConn := runAddHubConnection(chat), runAddHubConnection(metrics)
+			Expect(chat.Shutdown(context.Background())).To(BeNil())
+			receiveClose(chatConn)
+			receiveClose(metricsConn)
+		})
+	})
+
+	Context("When the server drains", func() {
+		It("should drain the added hubs, too", func() {
+			chat, metrics := newChatServer()
+			chat.Drain()
+			Expect(metrics.Draining()).To(BeTrue())
+			other, err := chat.AddHub(SimpleHubFactory(&metricsHub{}))
+			Expect(err).To(BeNil())
+			Expect(other.Draining()).To(BeTrue())
+		})
+	})
+})
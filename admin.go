@@ -0,0 +1,178 @@
+package signalr
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// AdminConnection describes a connection of the server in the responses of the AdminHandler
+type AdminConnection struct {
+	ConnectionID string   `json:"connectionId"`
+	UserID       string   `json:"userId,omitempty"`
+	Transport    string   `json:"transport"`
+	Groups       []string `json:"groups"`
+}
+
+// AdminBroadcast is the request of the AdminHandler to invoke a client method.
+// The method is invoked on the connection with ConnectionID, or if it is empty, the connections of Group
+// or User. If all of them are empty, it is invoked on all connections
+type AdminBroadcast struct {
+	Target       string        `json:"target"`
+	Arguments    []interface{} `json:"arguments"`
+	ConnectionID string        `json:"connectionId,omitempty"`
+	Group        string        `json:"group,omitempty"`
+	User         string        `json:"user,omitempty"`
+}
+
+type adminError struct {
+	Error string `json:"error"`
+}
+
+// connectionGroupLister is implemented by GroupStores which can list the groups of a connection
+type connectionGroupLister interface {
+	ConnectionGroups(connectionID string) ([]string, error)
+}
+
+// AdminHandler returns a http.Handler for the operation of the connections and groups of the server.
+// Each request must be allowed by authorize, otherwise it is answered with 401 Unauthorized. authorize should
+// at least check a secret of the operators, as the handler can close or invoke any connection. The routes are
+//
+//	GET    /connections                       lists the connections as JSON array of AdminConnection
+//	DELETE /connections/{connectionID}        closes the connection without allowing it to reconnect
+//	PUT    /groups/{group}/{connectionID}     adds the connection to the group
+//	DELETE /groups/{group}/{connectionID}     removes the connection from the group
+//	POST   /broadcast                         invokes the client method of the AdminBroadcast in the request body
+//
+// Mount it with the prefix stripped, e.g.
+//
+//	router.Handle("/admin/", http.StripPrefix("/admin", server.AdminHandler(authorize)))
+//
+// The connections of hubs added with AddHub are managed by the AdminHandler of their own server
+func (s *Server) AdminHandler(authorize func(req *http.Request) bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if authorize == nil || !authorize(req) {
+			writeAdminResponse(w, http.StatusUnauthorized, adminError{Error: "unauthorized"})
+			return
+		}
+		segments, err := adminPathSegments(req.URL)
+		if err != nil {
+			writeAdminResponse(w, http.StatusBadRequest, adminError{Error: err.Error()})
+			return
+		}
+		switch {
+		case len(segments) == 1 && segments[0] == "connections" && req.Method == http.MethodGet:
+			writeAdminResponse(w, http.StatusOK, s.adminConnections())
+		case len(segments) == 2 && segments[0] == "connections" && req.Method == http.MethodDelete:
+			s.adminResult(w, req, s.HubClients().Client(segments[1]).Abort("connection closed by the administrator"),
+				http.StatusNotFound)
+		case len(segments) == 3 && segments[0] == "groups" && req.Method == http.MethodPut:
+			s.Groups().AddToGroup(segments[1], segments[2])
+			w.WriteHeader(http.StatusNoContent)
+		case len(segments) == 3 && segments[0] == "groups" && req.Method == http.MethodDelete:
+			s.Groups().RemoveFromGroup(segments[1], segments[2])
+			w.WriteHeader(http.StatusNoContent)
+		case len(segments) == 1 && segments[0] == "broadcast" && req.Method == http.MethodPost:
+			s.adminBroadcast(w, req)
+		default:
+			writeAdminResponse(w, http.StatusNotFound, adminError{Error: fmt.Sprintf("no admin route for %v %v", req.Method, req.URL.Path)})
+		}
+	})
+}
+
+// adminPathSegments splits the escaped path, so group names and connection ids may contain escaped slashes
+func adminPathSegments(u *url.URL) ([]string, error) {
+	var segments []string
+	for _, segment := range strings.Split(strings.Trim(u.EscapedPath(), "/"), "/") {
+		unescaped, err := url.PathUnescape(segment)
+		if err != nil {
+			return nil, err
+		}
+		segments = append(segments, unescaped)
+	}
+	return segments, nil
+}
+
+func (s *Server) adminConnections() []AdminConnection {
+	info, _ := s.prefixLogger()
+	var lister connectionGroupLister
+	if local := s.localLifetimeManager(); local != nil {
+		lister, _ = local.groupStore().(connectionGroupLister)
+	}
+	s.loopsMx.Lock()
+	loops := make([]*serverLoop, 0, len(s.loops))
+	for loop := range s.loops {
+		loops = append(loops, loop)
+	}
+	s.loopsMx.Unlock()
+	connections := make([]AdminConnection, 0, len(loops))
+	for _, loop := range loops {
+		connection := AdminConnection{
+			ConnectionID: loop.hubConn.GetConnectionID(),
+			UserID:       UserIDFromContext(loop.ctx),
+			Transport:    loop.transport,
+			Groups:       []string{},
+		}
+		if lister != nil {
+			if groups, err := lister.ConnectionGroups(connection.ConnectionID); err == nil {
+				sort.Strings(groups)
+				connection.Groups = groups
+			} else {
+				_ = info.Log(evt, "list groups", "error", err, "connection", connection.ConnectionID,
+					react, "groups not listed")
+			}
+		}
+		connections = append(connections, connection)
+	}
+	sort.Slice(connections, func(i, j int) bool { return connections[i].ConnectionID < connections[j].ConnectionID })
+	return connections
+}
+
+func (s *Server) adminBroadcast(w http.ResponseWriter, req *http.Request) {
+	var broadcast AdminBroadcast
+	if err := json.NewDecoder(req.Body).Decode(&broadcast); err != nil {
+		writeAdminResponse(w, http.StatusBadRequest, adminError{Error: fmt.Sprintf("invalid broadcast: %v", err)})
+		return
+	}
+	if broadcast.Target == "" {
+		writeAdminResponse(w, http.StatusBadRequest, adminError{Error: "broadcast needs a target"})
+		return
+	}
+	var proxy ClientProxy
+	failStatus := http.StatusInternalServerError
+	switch {
+	case broadcast.ConnectionID != "":
+		proxy = s.HubClients().Client(broadcast.ConnectionID)
+		failStatus = http.StatusNotFound
+	case broadcast.Group != "":
+		proxy = s.HubClients().Group(broadcast.Group)
+	case broadcast.User != "":
+		proxy = s.HubClients().User(broadcast.User)
+	default:
+		proxy = s.HubClients().All()
+	}
+	s.adminResult(w, req, proxy.Send(broadcast.Target, broadcast.Arguments...), failStatus)
+}
+
+// adminResult waits for result and answers with 204 NoContent, or failStatus and the error of result
+func (s *Server) adminResult(w http.ResponseWriter, req *http.Request, result <-chan error, failStatus int) {
+	select {
+	case err := <-result:
+		if err != nil {
+			writeAdminResponse(w, failStatus, adminError{Error: err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case <-req.Context().Done():
+		writeAdminResponse(w, http.StatusServiceUnavailable, adminError{Error: "request canceled"})
+	}
+}
+
+func writeAdminResponse(w http.ResponseWriter, status int, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,128 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+)
+
+var _ = Describe("AdminHandler", func() {
+
+	const adminSecret = "secret"
+
+	newAdminServer := func() (*Server, http.Handler) {
+		server, err := NewServer(context.Background(), SimpleHubFactory(&chatHub{}), Logger(log.NewNopLogger(), false))
+		Expect(err).To(BeNil())
+		return server, server.AdminHandler(func(req *http.Request) bool {
+			return req.Header.Get("X-Admin-Secret") == adminSecret
+		})
+	}
+	request := func(handler http.Handler, method string, path string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("X-Admin-Secret", adminSecret)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	Context("When the request is not authorized", func() {
+		It("should answer with 401 Unauthorized", func() {
+			_, handler := newAdminServer()
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/connections", nil))
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		})
+		It("should answer with 401 Unauthorized without authorize func", func() {
+			server, _ := newAdminServer()
+			recorder := httptest.NewRecorder()
+			server.AdminHandler(nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/connections", nil))
+			Expect(recorder.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+
+	Context("When the connections are listed", func() {
+		It("should return the connections with their groups", func() {
+			server, handler := newAdminServer()
+			first, second := runAddHubConnection(server), runAddHubConnection(server)
+			server.Groups().AddToGroup("room", first.ConnectionID())
+			server.Groups().AddToGroup("lobby", first.ConnectionID())
+			recorder := request(handler, http.MethodGet, "/connections", "")
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			var connections []AdminConnection
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &connections)).To(BeNil())
+			Expect(connections).To(ConsistOf(
+				AdminConnection{ConnectionID: first.ConnectionID(), Transport: "*signalr.testingConnection",
+					Groups: []string{"lobby", "room"}},
+				AdminConnection{ConnectionID: second.ConnectionID(), Transport: "*signalr.testingConnection",
+					Groups: []string{}}))
+		})
+	})
+
+	Context("When a connection is deleted", func() {
+		It("should close it", func() {
+			server, handler := newAdminServer()
+			conn := runAddHubConnection(server)
+			recorder := request(handler, http.MethodDelete, "/connections/"+url.PathEscape(conn.ConnectionID()), "")
+			Expect(recorder.Code).To(Equal(http.StatusNoContent))
+			Expect(receiveClose(conn).AllowReconnect).To(BeFalse())
+		})
+		It("should answer with 404 NotFound for unknown connections", func() {
+			_, handler := newAdminServer()
+			Expect(request(handler, http.MethodDelete, "/connections/unknown", "").Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("When group memberships are changed", func() {
+		It("should add and remove the connection", func() {
+			server, handler := newAdminServer()
+			conn := runAddHubConnection(server)
+			path := "/groups/" + url.PathEscape("room/1") + "/" + url.PathEscape(conn.ConnectionID())
+			Expect(request(handler, http.MethodPut, path, "").Code).To(Equal(http.StatusNoContent))
+			server.HubClients().Group("room/1").Send("clientFunc")
+			expectClientFunc(conn)
+			Expect(request(handler, http.MethodDelete, path, "").Code).To(Equal(http.StatusNoContent))
+			server.HubClients().Group("room/1").Send("clientFunc")
+			expectNoClientFunc(conn)
+		})
+	})
+
+	Context("When a message is broadcast", func() {
+		It("should invoke the clients", func() {
+			server, handler := newAdminServer()
+			conn, other := runAddHubConnection(server), runAddHubConnection(server)
+			server.Groups().AddToGroup("room", conn.ConnectionID())
+			Expect(request(handler, http.MethodPost, "/broadcast", `{"target":"clientFunc","arguments":[1]}`).Code).
+				To(Equal(http.StatusNoContent))
+			expectClientFunc(conn)
+			expectClientFunc(other)
+			Expect(request(handler, http.MethodPost, "/broadcast", `{"target":"clientFunc","group":"room"}`).Code).
+				To(Equal(http.StatusNoContent))
+			expectClientFunc(conn)
+			expectNoClientFunc(other)
+		})
+		It("should answer with 404 NotFound for unknown connections", func() {
+			_, handler := newAdminServer()
+			Expect(request(handler, http.MethodPost, "/broadcast", `{"target":"clientFunc","connectionId":"unknown"}`).Code).
+				To(Equal(http.StatusNotFound))
+		})
+		It("should reject requests without target", func() {
+			_, handler := newAdminServer()
+			for _, body := range []string{`{"arguments":[1]}`, `not json`} {
+				Expect(request(handler, http.MethodPost, "/broadcast", body).Code).To(Equal(http.StatusBadRequest))
+			}
+		})
+	})
+
+	Context("When the route is unknown", func() {
+		It("should answer with 404 NotFound", func() {
+			_, handler := newAdminServer()
+			Expect(request(handler, http.MethodGet, "/groups", "").Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
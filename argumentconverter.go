@@ -0,0 +1,33 @@
+package signalr
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ArgumentConverter converts an argument sent by the client to the type of a hub method parameter.
+// unmarshal unmarshals the argument into a value of any type, e.g. a string which is then parsed.
+// If the argument is invalid, the converter returns an error, which is sent to the client as error of the completion
+type ArgumentConverter func(unmarshal func(value interface{}) error) (interface{}, error)
+
+// convertArgument converts the argument with the converter for parameter type t
+func convertArgument(converter ArgumentConverter, protocol HubProtocol, argument interface{}, t reflect.Type) (reflect.Value, error) {
+	value, err := converter(func(value interface{}) error {
+		return protocol.UnmarshalArgument(argument, value)
+	})
+	if err != nil {
+		return reflect.Value{}, err
+	}
+	if value == nil {
+		// nil is allowed for the types which can be nil
+		switch t.Kind() {
+		case reflect.Chan, reflect.Func, reflect.Interface, reflect.Map, reflect.Ptr, reflect.Slice:
+			return reflect.Zero(t), nil
+		}
+		return reflect.Value{}, fmt.Errorf("converter for %v returned nil", t)
+	}
+	if !reflect.TypeOf(value).AssignableTo(t) {
+		return reflect.Value{}, fmt.Errorf("converter for %v returned %T", t, value)
+	}
+	return reflect.ValueOf(value), nil
+}
@@ -0,0 +1,96 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+type civilDate struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+type converterHub struct {
+	Hub
+}
+
+func (c *converterHub) Weekday(date civilDate) string {
+	return time.Date(date.Year, date.Month, date.Day, 0, 0, 0, 0, time.UTC).Weekday().String()
+}
+
+func (c *converterHub) Optional(date *civilDate) bool {
+	return date == nil
+}
+
+func convertCivilDate(unmarshal func(value interface{}) error) (interface{}, error) {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return nil, err
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return nil, errors.New("date must be YYYY-MM-DD")
+	}
+	return civilDate{Year: t.Year(), Month: t.Month(), Day: t.Day()}, nil
+}
+
+func connectConverterHub(options ...Option) *testingConnection {
+	server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&converterHub{}),
+		Logger(log.NewNopLogger(), false)}, options...)...)
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	go server.Run(conn)
+	return conn
+}
+
+var _ = Describe("ArgumentConverter", func() {
+
+	Context("When a converter is registered for a parameter type", func() {
+		It("should convert the argument", func() {
+			conn := connectConverterHub(UseArgumentConverter(civilDate{}, convertCivilDate))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"weekday","arguments":["2026-10-14"]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(BeEmpty())
+			Expect(completion.Result).To(Equal("Wednesday"))
+		})
+		It("should send the error of the converter as completion error", func() {
+			conn := connectConverterHub(UseArgumentConverter(civilDate{}, convertCivilDate))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"weekday","arguments":["14.10.2026"]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(Equal("invalid argument 0 of method weekday: date must be YYYY-MM-DD"))
+		})
+	})
+
+	Context("When a converter returns a value of the wrong type", func() {
+		It("should send a completion error", func() {
+			conn := connectConverterHub(UseArgumentConverter(civilDate{},
+				func(unmarshal func(value interface{}) error) (interface{}, error) { return "2026-10-14", nil }))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"weekday","arguments":["2026-10-14"]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(ContainSubstring("returned string"))
+		})
+	})
+
+	Context("When a converter for a pointer type returns nil", func() {
+		It("should pass nil", func() {
+			conn := connectConverterHub(UseArgumentConverter((*civilDate)(nil),
+				func(unmarshal func(value interface{}) error) (interface{}, error) { return nil, nil }))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"optional","arguments":[""]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(BeEmpty())
+			Expect(completion.Result).To(Equal(true))
+		})
+	})
+
+	Context("When the converter option gets no converter", func() {
+		It("should return an error", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&converterHub{}), UseArgumentConverter(civilDate{}, nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,123 @@
+package signalr
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Claims are the properties of an authenticated user, e.g. the claims of a JWT
+type Claims map[string]interface{}
+
+type claimsKey struct{}
+
+type userIDKey struct{}
+
+type requestKey struct{}
+
+// ClaimsFromContext returns the claims of the user of the connection, which were returned by the
+// Authenticate function of the server. Hub methods get the context of the connection by a context.Context parameter.
+// If the connection was not authenticated, ClaimsFromContext returns nil
+func ClaimsFromContext(ctx context.Context) Claims {
+	claims, _ := ctx.Value(claimsKey{}).(Claims)
+	return claims
+}
+
+// UserIDFromContext returns the id of the user of the connection, which was derived from its claims
+// by the UserIDProvider of the server. If the connection has no user id, UserIDFromContext returns ""
+func UserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDKey{}).(string)
+	return userID
+}
+
+// subjectUserID is the default UserIDProvider. It returns the "sub" claim
+func subjectUserID(claims Claims) string {
+	userID, _ := claims["sub"].(string)
+	return userID
+}
+
+// BearerToken returns the access token of the request. Clients send it in the Authorization header or,
+// when the transport does not allow to set headers like WebSockets and ServerSentEvents in browsers,
+// as access_token query parameter
+func BearerToken(req *http.Request) string {
+	if auth := req.Header.Get("Authorization"); len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return auth[7:]
+	}
+	return req.URL.Query().Get("access_token")
+}
+
+// PeerCertificate returns the client certificate of a mutual TLS request, which was verified by the TLS config
+// of the http.Server, e.g. with ClientAuth tls.VerifyClientCertIfGiven and ClientCAs. The Authenticate function
+// can use it to derive the claims of the connection. Without verified client certificate, PeerCertificate returns nil
+func PeerCertificate(req *http.Request) *x509.Certificate {
+	if req == nil || req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return req.TLS.VerifiedChains[0][0]
+}
+
+// PeerCertificateFromContext returns the verified client certificate of the http request which started the connection.
+// If the connection was not started by a mutual TLS request, PeerCertificateFromContext returns nil
+func PeerCertificateFromContext(ctx context.Context) *x509.Certificate {
+	return PeerCertificate(RequestFromContext(ctx))
+}
+
+var errNoClientCertificate = errors.New("no verified client certificate")
+
+// certificateClaims are the claims of a connection authenticated only by its client certificate
+func certificateClaims(cert *x509.Certificate) Claims {
+	return Claims{"sub": cert.Subject.CommonName}
+}
+
+// authenticateRequest calls the Authenticate function of the server and returns the request with the claims
+// in its context. If the function rejects the request, or a required client certificate is missing,
+// authenticateRequest answers it with 401 Unauthorized
+func (s *Server) authenticateRequest(w http.ResponseWriter, req *http.Request) (*http.Request, bool) {
+	cert := PeerCertificate(req)
+	if s.requireClientCertificate && cert == nil {
+		info, _ := s.prefixLogger()
+		_ = info.Log(evt, "authenticate", "error", errNoClientCertificate, react, "reject request")
+		w.WriteHeader(http.StatusUnauthorized)
+		return req, false
+	}
+	if s.authenticate == nil {
+		if cert != nil && s.requireClientCertificate {
+			return req.WithContext(context.WithValue(req.Context(), claimsKey{}, certificateClaims(cert))), true
+		}
+		return req, true
+	}
+	claims, err := s.authenticate(req)
+	if err != nil {
+		info, _ := s.prefixLogger()
+		_ = info.Log(evt, "authenticate", "error", err, react, "reject request")
+		w.WriteHeader(http.StatusUnauthorized)
+		return req, false
+	}
+	return req.WithContext(context.WithValue(req.Context(), claimsKey{}, claims)), true
+}
+
+// RequestFromContext returns the http request which started the connection, e.g. to read its headers, cookies,
+// query string, remote address or TLS state. The body of the request can not be read.
+// If the connection was not started by a http request, RequestFromContext returns nil
+func RequestFromContext(ctx context.Context) *http.Request {
+	req, _ := ctx.Value(requestKey{}).(*http.Request)
+	return req
+}
+
+// connectionContext is the parent context for a connection started by req.
+// It must not be req.Context(), because the connection might outlive the request
+func (s *Server) connectionContext(req *http.Request) context.Context {
+	// The request is done when the connection is upgraded, so keep a copy without its context and body
+	connReq := req.Clone(context.Background())
+	connReq.Body = http.NoBody
+	ctx := context.WithValue(s.ctx, requestKey{}, connReq)
+	if claims := ClaimsFromContext(req.Context()); claims != nil {
+		ctx = context.WithValue(ctx, claimsKey{}, claims)
+	}
+	if s.tracer != nil {
+		ctx = s.tracer.Extract(ctx, req.Header)
+	}
+	return ctx
+}
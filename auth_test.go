@@ -0,0 +1,293 @@
+package signalr
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+type authHub struct {
+	Hub
+}
+
+func (a *authHub) WhoAmI(ctx context.Context) string {
+	return fmt.Sprint(ClaimsFromContext(ctx)["sub"])
+}
+
+func (a *authHub) Certificate(ctx context.Context) string {
+	if cert := PeerCertificateFromContext(ctx); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+type userHub struct {
+	Hub
+}
+
+func (u *userHub) CallUser(userID string) {
+	u.Clients().User(userID).Send("clientFunc")
+}
+
+var userHubConnected = make(chan string, 3)
+
+func (u *userHub) OnConnected(connectionID string) {
+	userHubConnected <- connectionID
+}
+
+func newAuthTestServer() *httptest.Server {
+	server, err := NewServer(context.Background(), SimpleHubFactory(&authHub{}), Logger(log.NewNopLogger(), false),
+		Authenticate(func(req *http.Request) (Claims, error) {
+			if BearerToken(req) != "secret" {
+				return nil, errors.New("invalid token")
+			}
+			return Claims{"sub": "alice"}, nil
+		}))
+	Expect(err).To(BeNil())
+	router := http.NewServeMux()
+	server.MapHTTP(router, "/hub")
+	return httptest.NewServer(router)
+}
+
+// newClientCertificate creates a CA and a client certificate with commonName signed by it
+func newClientCertificate(commonName string) (*x509.CertPool, tls.Certificate) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	Expect(err).To(BeNil())
+	ca, err := x509.ParseCertificate(caDER)
+	Expect(err).To(BeNil())
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).To(BeNil())
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, &key.PublicKey, caKey)
+	Expect(err).To(BeNil())
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// newMutualTLSTestServer starts a https server which verifies client certificates signed by clientCAs
+func newMutualTLSTestServer(clientCAs *x509.CertPool, options ...Option) *httptest.Server {
+	server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&authHub{}),
+		Logger(log.NewNopLogger(), false)}, options...)...)
+	Expect(err).To(BeNil())
+	router := http.NewServeMux()
+	server.MapHTTP(router, "/hub")
+	testServer := httptest.NewUnstartedServer(router)
+	testServer.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: clientCAs}
+	testServer.StartTLS()
+	return testServer
+}
+
+var _ = Describe("RequireClientCertificate option", func() {
+
+	Context("When a client connects with a verified certificate", func() {
+		It("should pass the certificate and its common name as claims to the hub methods", func() {
+			clientCAs, cert := newClientCertificate("machine-1")
+			testServer := newMutualTLSTestServer(clientCAs, RequireClientCertificate())
+			defer testServer.Close()
+			rootCAs := x509.NewCertPool()
+			rootCAs.AddCert(testServer.Certificate())
+			client, err := Dial(testServer.URL+"/hub", WithTLSConfig(&tls.Config{RootCAs: rootCAs, Certificates: []tls.Certificate{cert}}),
+				WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var commonName string
+			Expect(client.Invoke(context.Background(), "certificate", &commonName)).To(BeNil())
+			Expect(commonName).To(Equal("machine-1"))
+			var sub string
+			Expect(client.Invoke(context.Background(), "whoami", &sub)).To(BeNil())
+			Expect(sub).To(Equal("machine-1"))
+		})
+	})
+
+	Context("When a client connects without certificate", func() {
+		It("should reject the connection", func() {
+			clientCAs, _ := newClientCertificate("machine-1")
+			testServer := newMutualTLSTestServer(clientCAs, RequireClientCertificate())
+			defer testServer.Close()
+			rootCAs := x509.NewCertPool()
+			rootCAs.AddCert(testServer.Certificate())
+			_, err := Dial(testServer.URL+"/hub", WithTLSConfig(&tls.Config{RootCAs: rootCAs}),
+				WithLogger(log.NewNopLogger(), false))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When an Authenticate function is set", func() {
+		It("should pass the certificate to it", func() {
+			clientCAs, cert := newClientCertificate("machine-2")
+			testServer := newMutualTLSTestServer(clientCAs, Authenticate(func(req *http.Request) (Claims, error) {
+				if cert := PeerCertificate(req); cert != nil {
+					return Claims{"sub": "cert:" + cert.Subject.CommonName}, nil
+				}
+				return nil, errors.New("no certificate")
+			}))
+			defer testServer.Close()
+			rootCAs := x509.NewCertPool()
+			rootCAs.AddCert(testServer.Certificate())
+			client, err := Dial(testServer.URL+"/hub", WithTLSConfig(&tls.Config{RootCAs: rootCAs, Certificates: []tls.Certificate{cert}}),
+				WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var sub string
+			Expect(client.Invoke(context.Background(), "whoami", &sub)).To(BeNil())
+			Expect(sub).To(Equal("cert:machine-2"))
+		})
+	})
+})
+
+var _ = Describe("Authenticate option", func() {
+
+	Context("When negotiate is called without a valid token", func() {
+		It("should reject the request", func() {
+			testServer := newAuthTestServer()
+			defer testServer.Close()
+			resp, err := http.Post(testServer.URL+"/hub/negotiate", "text/plain", nil)
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusUnauthorized))
+			req, _ := http.NewRequest("POST", testServer.URL+"/hub/negotiate", nil)
+			req.Header.Set("Authorization", "Bearer secret")
+			resp, err = http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("When a websocket connects without a valid token", func() {
+		It("should reject the connection", func() {
+			testServer := newAuthTestServer()
+			defer testServer.Close()
+			_, err := websocket.Dial(strings.Replace(testServer.URL, "http", "ws", 1)+"/hub?access_token=wrong", "", testServer.URL)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When a websocket connects with a valid token", func() {
+		It("should pass the claims to the hub methods", func() {
+			testServer := newAuthTestServer()
+			defer testServer.Close()
+			ws, err := websocket.Dial(strings.Replace(testServer.URL, "http", "ws", 1)+"/hub?access_token=secret", "", testServer.URL)
+			Expect(err).To(BeNil())
+			defer ws.Close()
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			conn := newWebSocketConnection(ws, "", webSocketOptions{})
+			_, _ = conn.Write(append([]byte(`{"protocol": "json","version": 1}`), 30))
+			_, _ = conn.Write(append([]byte(`{"type":1,"invocationId":"1","target":"whoami"}`), 30))
+			hubConn := newHubConnection(context.Background(), conn, protocol, 0, log.NewNopLogger(), log.NewNopLogger())
+			result := make(chan interface{}, 1)
+			go func() {
+				for {
+					message, err := hubConn.Receive()
+					if err != nil {
+						return
+					}
+					if completion, ok := message.(completionMessage); ok {
+						result <- completion.Result
+						return
+					}
+				}
+			}()
+			select {
+			case r := <-result:
+				Expect(r).To(Equal("alice"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+		})
+	})
+
+	Context("When a hub invokes a user", func() {
+		It("should invoke all connections of the user", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&userHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conns := make([]*testingConnection, 3)
+			for i, user := range []string{"alice", "alice", "bob"} {
+				conns[i] = newTestingConnection()
+				go server.run(context.WithValue(context.Background(), claimsKey{}, Claims{"sub": user}), conns[i])
+				<-userHubConnected
+			}
+			conns[2].ClientSend(`{"type":1,"target":"calluser","arguments":["alice"]}`)
+			expectClientFunc(conns[0])
+			expectClientFunc(conns[1])
+			select {
+			case message := <-conns[2].received:
+				Fail(fmt.Sprintf("bob received %v", message))
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+})
+
+type requestHub struct {
+	Hub
+}
+
+var requestHubConnected = make(chan string, 1)
+
+func (r *requestHub) OnConnected(string) {
+	requestHubConnected <- RequestFromContext(r.Context()).URL.Query().Get("name")
+}
+
+func (r *requestHub) Name(ctx context.Context) string {
+	return RequestFromContext(ctx).URL.Query().Get("name")
+}
+
+var _ = Describe("RequestFromContext", func() {
+
+	Context("When a connection was started by a http request", func() {
+		It("should return the request in hub methods and OnConnected", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&requestHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub")
+			testServer := httptest.NewServer(router)
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub?name=gopher", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Eventually(requestHubConnected).Should(Receive(Equal("gopher")))
+			var name string
+			Expect(client.Invoke(context.Background(), "name", &name)).To(Succeed())
+			Expect(name).To(Equal("gopher"))
+		})
+	})
+
+	Context("When a connection was not started by a http request", func() {
+		It("should return nil", func() {
+			Expect(RequestFromContext(context.Background())).To(BeNil())
+		})
+	})
+})
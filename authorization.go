@@ -0,0 +1,91 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// AuthorizationRequirement declares who may invoke hub methods.
+// The claims of the connection must contain one of the Roles, if any are given, and satisfy all Policies.
+// A requirement without Roles and Policies only requires an authenticated connection
+type AuthorizationRequirement struct {
+	Roles    []string
+	Policies []string
+}
+
+// PolicyEvaluator decides if the claims of a connection satisfy the named policy
+type PolicyEvaluator interface {
+	Evaluate(ctx context.Context, policy string, claims Claims) (bool, error)
+}
+
+// PolicyEvaluatorFunc is an adapter to use a func as PolicyEvaluator
+type PolicyEvaluatorFunc func(ctx context.Context, policy string, claims Claims) (bool, error)
+
+// Evaluate calls f(ctx, policy, claims)
+func (f PolicyEvaluatorFunc) Evaluate(ctx context.Context, policy string, claims Claims) (bool, error) {
+	return f(ctx, policy, claims)
+}
+
+var errUnauthorized = errors.New("unauthorized")
+
+// roleClaim is the claim which holds the roles of a user, either as one string or as list of strings
+const roleClaim = "role"
+
+// authorize checks the requirements of the hub and of the method against the claims in ctx
+func (s *Server) authorize(ctx context.Context, method string) error {
+	requirements := []*AuthorizationRequirement{s.hubRequirement}
+	if requirement, ok := s.methodRequirements[strings.ToLower(method)]; ok {
+		requirements = append(requirements, requirement)
+	}
+	claims := ClaimsFromContext(ctx)
+	for _, requirement := range requirements {
+		if requirement == nil {
+			continue
+		}
+		if claims == nil {
+			return fmt.Errorf("%w: connection is not authenticated", errUnauthorized)
+		}
+		if len(requirement.Roles) > 0 && !hasRole(claims, requirement.Roles) {
+			return fmt.Errorf("%w: one of the roles %v is required", errUnauthorized, requirement.Roles)
+		}
+		for _, policy := range requirement.Policies {
+			if s.policyEvaluator == nil {
+				return fmt.Errorf("%w: no PolicyEvaluator for policy %v", errUnauthorized, policy)
+			}
+			ok, err := s.policyEvaluator.Evaluate(ctx, policy, claims)
+			if err != nil {
+				return fmt.Errorf("%w: policy %v: %v", errUnauthorized, policy, err)
+			}
+			if !ok {
+				return fmt.Errorf("%w: policy %v is not satisfied", errUnauthorized, policy)
+			}
+		}
+	}
+	return nil
+}
+
+func hasRole(claims Claims, roles []string) bool {
+	var userRoles []string
+	switch value := claims[roleClaim].(type) {
+	case string:
+		userRoles = []string{value}
+	case []string:
+		userRoles = value
+	case []interface{}:
+		for _, role := range value {
+			if role, ok := role.(string); ok {
+				userRoles = append(userRoles, role)
+			}
+		}
+	}
+	for _, userRole := range userRoles {
+		for _, role := range roles {
+			if userRole == role {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,81 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type authorizationHub struct {
+	Hub
+}
+
+func (a *authorizationHub) Read() string {
+	return "read"
+}
+
+func (a *authorizationHub) Delete() string {
+	return "deleted"
+}
+
+func connectAuthorizationHub(claims Claims, options ...Option) *testingConnection {
+	server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&authorizationHub{}),
+		Logger(log.NewNopLogger(), false)}, options...)...)
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	ctx := context.Background()
+	if claims != nil {
+		ctx = context.WithValue(ctx, claimsKey{}, claims)
+	}
+	go server.run(ctx, conn)
+	return conn
+}
+
+func invokeAuthorizationHub(conn *testingConnection, method string) completionMessage {
+	conn.ClientSend(`{"type":1,"invocationId":"` + method + `","target":"` + method + `"}`)
+	return receiveCompletion(conn)
+}
+
+var _ = Describe("Authorization", func() {
+
+	Context("When the hub requires authentication", func() {
+		It("should reject invocations of unauthenticated connections", func() {
+			conn := connectAuthorizationHub(nil, AuthorizeHub(AuthorizationRequirement{}))
+			Expect(invokeAuthorizationHub(conn, "read").Error).To(ContainSubstring(errUnauthorized.Error()))
+			conn = connectAuthorizationHub(Claims{"sub": "alice"}, AuthorizeHub(AuthorizationRequirement{}))
+			Expect(invokeAuthorizationHub(conn, "read").Result).To(Equal("read"))
+		})
+	})
+
+	Context("When a method requires a role", func() {
+		It("should only allow connections with the role", func() {
+			conn := connectAuthorizationHub(Claims{"role": []interface{}{"user"}},
+				AuthorizeMethod("Delete", AuthorizationRequirement{Roles: []string{"admin"}}))
+			Expect(invokeAuthorizationHub(conn, "read").Result).To(Equal("read"))
+			Expect(invokeAuthorizationHub(conn, "delete").Error).To(ContainSubstring(errUnauthorized.Error()))
+			conn = connectAuthorizationHub(Claims{"role": "admin"},
+				AuthorizeMethod("Delete", AuthorizationRequirement{Roles: []string{"admin"}}))
+			Expect(invokeAuthorizationHub(conn, "delete").Result).To(Equal("deleted"))
+		})
+	})
+
+	Context("When a method requires a policy", func() {
+		It("should ask the PolicyEvaluator", func() {
+			evaluator := PolicyEvaluatorFunc(func(ctx context.Context, policy string, claims Claims) (bool, error) {
+				return policy == "owner" && claims["sub"] == "alice", nil
+			})
+			options := []Option{AuthorizationPolicies(evaluator),
+				AuthorizeMethod("delete", AuthorizationRequirement{Policies: []string{"owner"}})}
+			conn := connectAuthorizationHub(Claims{"sub": "bob"}, options...)
+			Expect(invokeAuthorizationHub(conn, "delete").Error).To(ContainSubstring("owner"))
+			conn = connectAuthorizationHub(Claims{"sub": "alice"}, options...)
+			Expect(invokeAuthorizationHub(conn, "delete").Result).To(Equal("deleted"))
+		})
+		It("should reject the invocation without PolicyEvaluator", func() {
+			conn := connectAuthorizationHub(Claims{"sub": "alice"},
+				AuthorizeHub(AuthorizationRequirement{Policies: []string{"owner"}}))
+			Expect(invokeAuthorizationHub(conn, "read").Error).To(ContainSubstring(errUnauthorized.Error()))
+		})
+	})
+})
@@ -0,0 +1,238 @@
+package signalr
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+)
+
+// Backplane connects servers running the same hub, e.g. behind a load balancer.
+// Invocations of clients, groups or all clients are distributed over the backplane,
+// so they reach the clients connected to the other servers.
+// Publish() sends a message to all servers connected to the backplane
+// Subscribe() registers the handler which is called with each message published on the backplane
+type Backplane interface {
+	Publish(message []byte) error
+	Subscribe(handler func(message []byte)) error
+}
+
+// PartitionedBackplane is a Backplane which distributes messages by key.
+// Messages with the same key are delivered in the order they were published.
+// The key of a group message is the group name, of a user message the user id and of a client message the connection id.
+// Messages to all clients have the empty key
+type PartitionedBackplane interface {
+	Backplane
+	PublishKey(key string, message []byte) error
+}
+
+type backplaneMessage struct {
+	ServerID      string         `json:"serverId"`
+	Hub           string         `json:"hub,omitempty"`
+	Kind          string         `json:"kind"`
+	ConnectionIDs []string       `json:"connectionIds,omitempty"`
+	GroupName     string         `json:"groupName,omitempty"`
+	UserID        string         `json:"userId,omitempty"`
+	Target        string         `json:"target,omitempty"`
+	Arguments     []interface{}  `json:"arguments,omitempty"`
+	Headers       MessageHeaders `json:"headers,omitempty"`
+	Reason        string         `json:"reason,omitempty"`
+}
+
+// partitionKey is the key for a PartitionedBackplane
+func (m backplaneMessage) partitionKey() string {
+	switch m.Kind {
+	case backplaneInvokeGroup, backplaneAddToGroup, backplaneRemoveFromGroup:
+		return m.GroupName
+	case backplaneInvokeUser:
+		return m.UserID
+	case backplaneInvokeClient, backplaneAbort:
+		// Messages from other implementations might not carry the connection id
+		if len(m.ConnectionIDs) == 0 {
+			return m.Target
+		}
+		return m.ConnectionIDs[0]
+	default:
+		return ""
+	}
+}
+
+// Kinds of backplane messages
+const (
+	backplaneInvokeAll       = "invokeAll"
+	backplaneInvokeAllExcept = "invokeAllExcept"
+	backplaneInvokeClient    = "invokeClient"
+	backplaneInvokeGroup     = "invokeGroup"
+	backplaneInvokeUser      = "invokeUser"
+	backplaneAddToGroup      = "addToGroup"
+	backplaneRemoveFromGroup = "removeFromGroup"
+	backplaneAbort           = "abort"
+)
+
+// backplaneHubLifetimeManager invokes the connections of this server and publishes the invocation
+// for the connections of the other servers. Group membership is managed by the server the connection belongs to.
+// As the arguments are sent as JSON over the backplane, clients of other servers receive
+// JSON compatible arguments, e.g. all numbers are float64
+type backplaneHubLifetimeManager struct {
+	serverID string
+	// hub is the type of the hub. Servers for different hubs can share a backplane
+	hub       string
+	local     *defaultHubLifetimeManager
+	backplane Backplane
+	info      StructuredLogger
+}
+
+func newBackplaneHubLifetimeManager(local *defaultHubLifetimeManager, backplane Backplane, hub string,
+	info StructuredLogger) (*backplaneHubLifetimeManager, error) {
+	b := &backplaneHubLifetimeManager{
+		serverID:  uuid.New().String(),
+		hub:       hub,
+		local:     local,
+		backplane: backplane,
+		info:      withPrefix(info, "ts", defaultTimestampUTC, "class", LogSubsystemBackplane),
+	}
+	if err := backplane.Subscribe(b.receive); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func (b *backplaneHubLifetimeManager) OnConnected(conn hubConnection) {
+	b.local.OnConnected(conn)
+}
+
+func (b *backplaneHubLifetimeManager) OnDisconnected(conn hubConnection) {
+	b.local.OnDisconnected(conn)
+}
+
+func (b *backplaneHubLifetimeManager) InvokeAll(target string, args []interface{}) <-chan error {
+	return mergeSendResults([]<-chan error{
+		b.local.InvokeAll(target, args),
+		b.publish(backplaneMessage{Kind: backplaneInvokeAll, Target: target, Arguments: args}),
+	})
+}
+
+func (b *backplaneHubLifetimeManager) InvokeAllExcept(excludedConnectionIDs []string, target string, args []interface{}) <-chan error {
+	return mergeSendResults([]<-chan error{
+		b.local.InvokeAllExcept(excludedConnectionIDs, target, args),
+		b.publish(backplaneMessage{
+			Kind:          backplaneInvokeAllExcept,
+			ConnectionIDs: excludedConnectionIDs,
+			Target:        target,
+			Arguments:     args,
+		}),
+	})
+}
+
+func (b *backplaneHubLifetimeManager) InvokeClient(connectionID string, target string, args []interface{}) <-chan error {
+	if b.isLocal(connectionID) {
+		return b.local.InvokeClient(connectionID, target, args)
+	}
+	return b.publish(backplaneMessage{
+		Kind:          backplaneInvokeClient,
+		ConnectionIDs: []string{connectionID},
+		Target:        target,
+		Arguments:     args,
+	})
+}
+
+func (b *backplaneHubLifetimeManager) InvokeGroup(groupName string, target string, args []interface{}) <-chan error {
+	return mergeSendResults([]<-chan error{
+		b.local.InvokeGroup(groupName, target, args),
+		b.publish(backplaneMessage{Kind: backplaneInvokeGroup, GroupName: groupName, Target: target, Arguments: args}),
+	})
+}
+
+func (b *backplaneHubLifetimeManager) InvokeUser(userID string, target string, args []interface{}) <-chan error {
+	return mergeSendResults([]<-chan error{
+		b.local.InvokeUser(userID, target, args),
+		b.publish(backplaneMessage{Kind: backplaneInvokeUser, UserID: userID, Target: target, Arguments: args}),
+	})
+}
+
+func (b *backplaneHubLifetimeManager) AddToGroup(groupName, connectionID string) {
+	if b.isLocal(connectionID) {
+		b.local.AddToGroup(groupName, connectionID)
+	} else {
+		b.publish(backplaneMessage{Kind: backplaneAddToGroup, GroupName: groupName, ConnectionIDs: []string{connectionID}})
+	}
+}
+
+func (b *backplaneHubLifetimeManager) RemoveFromGroup(groupName, connectionID string) {
+	if b.isLocal(connectionID) {
+		b.local.RemoveFromGroup(groupName, connectionID)
+	} else {
+		b.publish(backplaneMessage{Kind: backplaneRemoveFromGroup, GroupName: groupName, ConnectionIDs: []string{connectionID}})
+	}
+}
+
+func (b *backplaneHubLifetimeManager) Abort(connectionID string, reason string) <-chan error {
+	if b.isLocal(connectionID) {
+		return b.local.Abort(connectionID, reason)
+	}
+	return b.publish(backplaneMessage{Kind: backplaneAbort, ConnectionIDs: []string{connectionID}, Reason: reason})
+}
+
+func (b *backplaneHubLifetimeManager) isLocal(connectionID string) bool {
+	_, ok := b.local.clients.Load(connectionID)
+	return ok
+}
+
+// publish publishes the message for the other servers. The returned channel receives the error of the Backplane.
+// Whether the clients of the other servers received the message is unknown
+func (b *backplaneHubLifetimeManager) publish(message backplaneMessage) <-chan error {
+	message.ServerID, message.Hub = b.serverID, b.hub
+	message.Headers, message.Arguments = splitHeaders(message.Arguments)
+	data, err := json.Marshal(message)
+	if err == nil {
+		if partitioned, ok := b.backplane.(PartitionedBackplane); ok {
+			err = partitioned.PublishKey(message.partitionKey(), data)
+		} else {
+			err = b.backplane.Publish(data)
+		}
+	}
+	if err != nil {
+		_ = b.info.Log(evt, "publish", "error", err, msg, message, react, "not sent to other servers")
+	}
+	return sendResult(err)
+}
+
+// receive invokes the connections of this server with the messages of the other servers
+func (b *backplaneHubLifetimeManager) receive(data []byte) {
+	message := backplaneMessage{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		_ = b.info.Log(evt, "receive", "error", err, msg, string(data), react, "ignore")
+		return
+	}
+	if message.ServerID == b.serverID || message.Hub != b.hub {
+		// Already handled locally or for another hub
+		return
+	}
+	message.Arguments = withHeaders(message.Arguments, message.Headers)
+	switch message.Kind {
+	case backplaneInvokeAll:
+		b.local.InvokeAll(message.Target, message.Arguments)
+	case backplaneInvokeAllExcept:
+		b.local.InvokeAllExcept(message.ConnectionIDs, message.Target, message.Arguments)
+	case backplaneInvokeClient:
+		for _, connectionID := range message.ConnectionIDs {
+			b.local.InvokeClient(connectionID, message.Target, message.Arguments)
+		}
+	case backplaneInvokeGroup:
+		b.local.InvokeGroup(message.GroupName, message.Target, message.Arguments)
+	case backplaneInvokeUser:
+		b.local.InvokeUser(message.UserID, message.Target, message.Arguments)
+	case backplaneAddToGroup:
+		for _, connectionID := range message.ConnectionIDs {
+			b.local.AddToGroup(message.GroupName, connectionID)
+		}
+	case backplaneRemoveFromGroup:
+		for _, connectionID := range message.ConnectionIDs {
+			b.local.RemoveFromGroup(message.GroupName, connectionID)
+		}
+	case backplaneAbort:
+		for _, connectionID := range message.ConnectionIDs {
+			b.local.Abort(connectionID, message.Reason)
+		}
+	default:
+		_ = b.info.Log(evt, "receive", "error", "unknown message kind", msg, string(data), react, "ignore")
+	}
+}
@@ -0,0 +1,118 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryBackplane connects servers in the same process
+type memoryBackplane struct {
+	mx       sync.Mutex
+	handlers []func(message []byte)
+}
+
+func (m *memoryBackplane) Publish(message []byte) error {
+	m.mx.Lock()
+	handlers := make([]func(message []byte), len(m.handlers))
+	copy(handlers, m.handlers)
+	m.mx.Unlock()
+	for _, handler := range handlers {
+		handler(message)
+	}
+	return nil
+}
+
+func (m *memoryBackplane) Subscribe(handler func(message []byte)) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.handlers = append(m.handlers, handler)
+	return nil
+}
+
+type backplaneHub struct {
+	Hub
+}
+
+var backplaneHubConnected = make(chan string, 10)
+
+func (b *backplaneHub) OnConnected(connectionID string) {
+	backplaneHubConnected <- connectionID
+}
+
+func (b *backplaneHub) CallAll() {
+	b.Clients().All().Send("clientFunc")
+}
+
+func (b *backplaneHub) CallClient(connectionID string) {
+	b.Clients().Client(connectionID).Send("clientFunc")
+}
+
+func (b *backplaneHub) JoinAndCallGroup(connectionID string) {
+	b.Groups().AddToGroup("remote", connectionID)
+	b.Clients().Group("remote").Send("clientFunc")
+}
+
+// connectBackplaneServers runs two servers connected by backplane and connects one client to each server
+func connectBackplaneServers(backplane Backplane) (*testingConnection, *testingConnection) {
+	conns := make([]*testingConnection, 2)
+	for i := range conns {
+		server, err := NewServer(context.Background(), SimpleHubFactory(&backplaneHub{}), UseBackplane(backplane),
+			Logger(log.NewNopLogger(), false))
+		Expect(err).To(BeNil())
+		conns[i] = newTestingConnection()
+		// Assign the id before the server uses it
+		conns[i].ConnectionID()
+		go server.Run(conns[i])
+		<-backplaneHubConnected
+	}
+	return conns[0], conns[1]
+}
+
+func expectClientFunc(conn *testingConnection) {
+	for {
+		select {
+		case message := <-conn.received:
+			if invocation, ok := message.(invocationMessage); ok {
+				Expect(strings.ToLower(invocation.Target)).To(Equal("clientfunc"))
+				return
+			}
+		case <-time.After(time.Second):
+			Fail("timed out")
+			return
+		}
+	}
+}
+
+var _ = Describe("Backplane", func() {
+
+	Context("When a hub on one server invokes all clients", func() {
+		It("should invoke the clients of the other server, too", func() {
+			connA, connB := connectBackplaneServers(&memoryBackplane{})
+			connA.ClientSend(`{"type":1,"target":"callall"}`)
+			expectClientFunc(connA)
+			expectClientFunc(connB)
+		})
+	})
+
+	Context("When a hub on one server invokes a client of the other server", func() {
+		It("should invoke the client", func() {
+			connA, connB := connectBackplaneServers(&memoryBackplane{})
+			connA.ClientSend(fmt.Sprintf(`{"type":1,"target":"callclient","arguments":["%v"]}`, connB.ConnectionID()))
+			expectClientFunc(connB)
+		})
+	})
+
+	Context("When a hub on one server adds a client of the other server to a group and invokes the group", func() {
+		It("should invoke the client", func() {
+			connA, connB := connectBackplaneServers(&memoryBackplane{})
+			connA.ClientSend(fmt.Sprintf(`{"type":1,"target":"joinandcallgroup","arguments":["%v"]}`, connB.ConnectionID()))
+			expectClientFunc(connB)
+		})
+	})
+})
@@ -0,0 +1,90 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-kit/kit/log"
+	"io/ioutil"
+	"testing"
+)
+
+// The benchmarks measure the hot paths of single connections. End-to-end benchmarks with many clients are in
+// the loadtest package
+
+func benchmarkProtocols() map[string]HubProtocol {
+	_, dbg := buildInfoDebugLogger(log.NewNopLogger(), false, nil, nil)
+	protocols := map[string]HubProtocol{"JSON": &JSONHubProtocol{}, "MessagePack": &MessagePackHubProtocol{}}
+	for _, protocol := range protocols {
+		protocol.setDebugLogger(dbg)
+	}
+	return protocols
+}
+
+var benchmarkInvocation interface{} = sendOnlyHubInvocationMessage{Type: 1, Target: "receive",
+	Arguments: []interface{}{"message", 42, true, 1.5}}
+
+func BenchmarkWriteInvocation(b *testing.B) {
+	for name, protocol := range benchmarkProtocols() {
+		protocol := protocol
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := protocol.WriteMessage(benchmarkInvocation, ioutil.Discard); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParseInvocation(b *testing.B) {
+	for name, protocol := range benchmarkProtocols() {
+		protocol := protocol
+		var buf bytes.Buffer
+		if err := protocol.WriteMessage(invocationMessage{Type: 1, Target: "add", InvocationID: "1",
+			Arguments: []interface{}{1, 2}}, &buf); err != nil {
+			b.Fatal(err)
+		}
+		_, frame, err := protocol.splitFrame(buf.Bytes(), true)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := protocol.parseFrame(frame); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSendInvocation(b *testing.B) {
+	for name, protocol := range benchmarkProtocols() {
+		conn := newHubConnection(context.Background(), &discardConnection{"bench"}, protocol, 0,
+			log.NewNopLogger(), log.NewNopLogger())
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := <-conn.SendInvocation("receive", "message", 42); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkBroadcast(b *testing.B) {
+	manager := &defaultHubLifetimeManager{}
+	for i := 0; i < 100; i++ {
+		manager.OnConnected(newDiscardHubConnection(string(rune('a' + i))))
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := <-manager.InvokeAll("receive", []interface{}{"message", 42}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package signalr
+
+import (
+	"bytes"
+	"sync"
+)
+
+// maxPooledBufferSize is the capacity up to which buffers are returned to the pool.
+// Larger buffers, e.g. for huge messages, are left to the GC
+const maxPooledBufferSize = 1 << 16
+
+// readBufferSize is the size of the slices connections are read into
+const readBufferSize = 1 << 12 // 4K
+
+var bufferPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+var readBufferPool = sync.Pool{
+	New: func() interface{} {
+		data := make([]byte, readBufferSize)
+		return &data
+	},
+}
+
+// getBuffer returns an empty buffer from the pool
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns buf to the pool. buf and its content must not be used afterwards
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(buf)
+	}
+}
+
+var frameBufferPool = sync.Pool{
+	New: func() interface{} {
+		data := make([]byte, 0, readBufferSize)
+		return &data
+	},
+}
+
+// getFrameBuffer returns a slice from the pool, which messages are appended to
+func getFrameBuffer() *[]byte {
+	return frameBufferPool.Get().(*[]byte)
+}
+
+// putFrameBuffer returns data to the pool. The slice must not be used afterwards
+func putFrameBuffer(data *[]byte) {
+	if cap(*data) <= maxPooledBufferSize {
+		frameBufferPool.Put(data)
+	}
+}
+
+// getReadBuffer returns a slice of readBufferSize bytes from the pool
+func getReadBuffer() *[]byte {
+	return readBufferPool.Get().(*[]byte)
+}
+
+func putReadBuffer(data *[]byte) {
+	readBufferPool.Put(data)
+}
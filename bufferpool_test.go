@@ -0,0 +1,47 @@
+package signalr
+
+import (
+	"bytes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Buffer pooling", func() {
+
+	Context("When a buffer is taken from the pool", func() {
+		It("should be empty", func() {
+			buf := getBuffer()
+			buf.WriteString("stale")
+			putBuffer(buf)
+			Expect(getBuffer().Len()).To(Equal(0))
+		})
+	})
+
+	Context("When messages are written one after another", func() {
+		It("should write each message on its own", func() {
+			protocol := newMessagePackProtocol()
+			var first, second bytes.Buffer
+			Expect(protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "1", Result: "a long result"}, &first)).To(Succeed())
+			Expect(protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "2", Result: 1}, &second)).To(Succeed())
+			message, _, err := protocol.ReadMessage(&first)
+			Expect(err).To(BeNil())
+			Expect(message.(completionMessage).InvocationID).To(Equal("1"))
+			message, _, err = protocol.ReadMessage(&second)
+			Expect(err).To(BeNil())
+			Expect(message.(completionMessage).InvocationID).To(Equal("2"))
+		})
+	})
+
+	Context("When a message can not be decoded", func() {
+		It("should keep the raw message in the error after the buffer is reused", func() {
+			protocol := newMessagePackProtocol()
+			buf := bytes.NewBuffer([]byte{0x02, 0x91, 0xc1})
+			_, _, err := protocol.ReadMessage(buf)
+			Expect(err).NotTo(BeNil())
+			text := err.Error()
+			buf.Reset()
+			buf.Write([]byte{0xff, 0xff, 0xff})
+			Expect(err.Error()).To(Equal(text))
+		})
+	})
+})
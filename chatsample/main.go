@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/philippseith/signalr"
 	"log"
@@ -19,7 +20,7 @@ func (c *chat) OnConnected(connectionID string) {
 	c.Groups().AddToGroup("group", connectionID)
 }
 
-func (c *chat) OnDisconnected(connectionID string) {
+func (c *chat) OnDisconnected(connectionID string, err error) {
 	fmt.Printf("%s disconnected\n", connectionID)
 	c.Groups().RemoveFromGroup("group", connectionID)
 }
@@ -103,7 +104,7 @@ func runTCP(address string, hub signalr.HubInterface) {
 
 	fmt.Printf("Listening for TCP connection on %s\n", listener.Addr())
 
-	server, _ := signalr.NewServer(signalr.UseHub(hub))
+	server, _ := signalr.NewServer(context.Background(), signalr.UseHub(hub))
 
 	for {
 		conn, err := listener.Accept()
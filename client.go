@@ -0,0 +1,823 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/websocket"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client is a SignalR client which is connected to a hub
+type Client struct {
+	httpClient *http.Client
+	// unixSocket is the path of the Unix domain socket the http(s) url of the hub is served on
+	unixSocket string
+	webSocket  webSocketOptions
+	// transport is the http.Transport configured by the options. It is nil if the options did not configure one
+	transport *http.Transport
+	// header contains the headers of the negotiate requests and the WebSocket upgrades
+	header            http.Header
+	keepAliveInterval time.Duration
+	retryPolicy       RetryPolicy
+	// statefulReconnectTimeout is the time the client tries to resume a stateful connection. 0 disables stateful reconnect
+	statefulReconnectTimeout time.Duration
+	skipNegotiation          bool
+	maxNegotiateRedirects    int
+	events                   clientEvents
+	logger                   StructuredLogger
+	debug                    bool
+	logLevels                map[string]LogLevel
+	logLevelValues           *logLevelValues
+	info                     StructuredLogger
+	dbg                      StructuredLogger
+	protocol                 HubProtocol
+	jsonEncoder              JSONEncoder
+	strictProtocol           bool
+	hubURL                   *url.URL
+	conn                     *clientConnection
+	handlers                 sync.Map
+	dispatch                 chan invocationMessage
+	mx                       sync.Mutex
+	pending                  map[string]chan completionMessage
+	streams                  map[string]*clientStream
+	lastID                   uint64
+	done                     chan struct{}
+	closeOnce                sync.Once
+	closeErr                 error
+	// itemsToken is the last items token of the server, which is sent with the next handshake, see PersistItems
+	itemsToken string
+}
+
+// defaultMaxNegotiateRedirects is the default of the ASP.NET Core client
+const defaultMaxNegotiateRedirects = 100
+
+// Dial connects a Client to the hub at address. It negotiates the connection, connects over WebSockets and
+// processes the handshake. address is the http(s) url of the hub, e.g. http://localhost:5000/chat.
+// A tcp:// address, e.g. tcp://localhost:5001, connects directly over TCP to a server running Serve,
+// a unix:// address, e.g. unix:///run/chat.sock, directly over a Unix domain socket
+func Dial(address string, options ...func(*Client) error) (*Client, error) {
+	c := &Client{
+		httpClient:            http.DefaultClient,
+		keepAliveInterval:     defaultKeepAliveInterval,
+		maxNegotiateRedirects: defaultMaxNegotiateRedirects,
+		logger:                newLogfmtLogger(os.Stderr),
+		logLevels:             make(map[string]LogLevel),
+		dispatch:              make(chan invocationMessage, 64),
+		pending:               make(map[string]chan completionMessage),
+		streams:               make(map[string]*clientStream),
+		done:                  make(chan struct{}),
+	}
+	for _, option := range options {
+		if option != nil {
+			if err := option(c); err != nil {
+				return nil, err
+			}
+		}
+	}
+	info, dbg := buildInfoDebugLogger(c.logger, c.debug, c.logLevels, c.logLevelValues)
+	c.info = withPrefix(info, "ts", defaultTimestampUTC, "class", LogSubsystemClient)
+	c.dbg = withPrefix(dbg, "ts", defaultTimestampUTC, "class", LogSubsystemClient)
+	hubURL, err := url.Parse(address)
+	if err != nil {
+		return nil, err
+	}
+	c.hubURL = hubURL
+	protocol := &JSONHubProtocol{encoder: c.jsonEncoder}
+	protocol.setDebugLogger(c.dbg)
+	protocol.setStrict(c.strictProtocol)
+	c.protocol = protocol
+	conn, err := c.connect()
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	go c.receiveLoop(conn)
+	go c.dispatchLoop()
+	return c, nil
+}
+
+// clientConnection is one connection of the Client to the hub. It is replaced when the Client reconnects
+type clientConnection struct {
+	hubConn   hubConnection
+	transport io.Closer
+	done      chan struct{}
+	endOnce   sync.Once
+	err       error
+}
+
+// end ends the connection. If the connection is still connected, the server gets a close message
+func (cc *clientConnection) end(err error) {
+	cc.endOnce.Do(func() {
+		cc.err = err
+		close(cc.done)
+		if cc.hubConn.IsConnected() {
+			if err == errClientClosed {
+				cc.hubConn.Close("")
+			} else {
+				cc.hubConn.Close(err.Error())
+			}
+		}
+		_ = cc.transport.Close()
+	})
+}
+
+var errClientClosed = errors.New("client closed")
+
+// connect negotiates, connects over WebSockets and processes the handshake.
+// Hubs with a tcp:// or unix:// url are connected directly without negotiation, as are all hubs with WithSkipNegotiation
+func (c *Client) connect() (*clientConnection, error) {
+	if c.hubURL.Scheme == "tcp" || c.hubURL.Scheme == "unix" {
+		address := c.hubURL.Host
+		if c.hubURL.Scheme == "unix" {
+			address = c.hubURL.Path
+		}
+		netConn, err := net.Dial(c.hubURL.Scheme, address)
+		if err != nil {
+			return nil, err
+		}
+		conn := newNetConnection(netConn, "")
+		return c.startConnection(conn, conn, conn, 1)
+	}
+	endpoint := hubEndpoint{url: c.hubURL, header: c.header}
+	if c.skipNegotiation {
+		ws, err := c.dialWebSocket(endpoint, "")
+		if err != nil {
+			return nil, err
+		}
+		wsConn := newWebSocketConnection(ws, "", c.webSocket)
+		return c.startConnection(wsConn, wsConn, wsConn, 1)
+	}
+	negotiated, endpoint, err := c.negotiateRedirected(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := c.dialWebSocket(endpoint, negotiated.ConnectionToken)
+	if err != nil {
+		return nil, err
+	}
+	wsConn := newWebSocketConnection(ws, negotiated.ConnectionID, c.webSocket)
+	var conn Connection = wsConn
+	var transport io.Closer = wsConn
+	version := 1
+	if negotiated.UseStatefulReconnect {
+		// Version 2 adds the messages for stateful reconnect
+		version = 2
+		stateful := newStatefulConnection(negotiated.ConnectionID, wsConn, c.statefulReconnectTimeout,
+			func() (Connection, error) {
+				ws, err := c.dialWebSocket(endpoint, negotiated.ConnectionToken)
+				if err != nil {
+					return nil, err
+				}
+				return newWebSocketConnection(ws, negotiated.ConnectionID, c.webSocket), nil
+			}, c.info)
+		conn, transport = stateful, stateful
+	}
+	return c.startConnection(wsConn, conn, transport, version)
+}
+
+// startConnection processes the handshake over handshakeConn and starts the hubConnection over conn
+func (c *Client) startConnection(handshakeConn Connection, conn Connection, transport io.Closer, version int) (*clientConnection, error) {
+	if err := c.processHandshake(handshakeConn, "json", version); err != nil {
+		_ = transport.Close()
+		return nil, err
+	}
+	hubConn := newHubConnection(context.Background(), conn, c.protocol, 0, c.info, c.dbg)
+	hubConn.Start()
+	cc := &clientConnection{hubConn: hubConn, transport: transport, done: make(chan struct{})}
+	startPingClientLoop(hubConn, c.keepAliveInterval, cc.done)
+	return cc, nil
+}
+
+func (c *Client) currentConnection() *clientConnection {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.conn
+}
+
+// ConnectionID is the id the server assigned to the connection
+func (c *Client) ConnectionID() string {
+	return c.currentConnection().hubConn.GetConnectionID()
+}
+
+// ItemsToken returns the last token the server sent with the Items of the connection, see PersistItems.
+// It can be passed to WithItemsToken to restore the Items from another Client, e.g. after a restart
+func (c *Client) ItemsToken() string {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.itemsToken
+}
+
+// On registers handler as handler for invocations of target from the server.
+// handler must be a func. The arguments of the invocation are unmarshaled into the parameters of handler.
+// Handlers are called one after another in the order the invocations were received
+func (c *Client) On(target string, handler interface{}) error {
+	if reflect.TypeOf(handler).Kind() != reflect.Func {
+		return fmt.Errorf("handler for %v is not a func", target)
+	}
+	c.handlers.Store(strings.ToLower(target), reflect.ValueOf(handler))
+	return nil
+}
+
+// Invoke invokes method on the server and waits for its completion.
+// If the method returns a value and result is not nil, the value is unmarshaled into result,
+// which must be a pointer. If the method failed on the server, Invoke returns the error sent by the server.
+// Arguments which are channels are streamed to the server, see Send.
+// When ctx is canceled before the completion arrived, Invoke returns ctx.Err() and a late completion is ignored
+func (c *Client) Invoke(ctx context.Context, method string, result interface{}, arguments ...interface{}) error {
+	c.mx.Lock()
+	id := c.nextID()
+	arguments, streamIds, uploads := c.uploadStreams(arguments)
+	completionChan := make(chan completionMessage, 1)
+	c.pending[id] = completionChan
+	c.mx.Unlock()
+	defer func() {
+		c.mx.Lock()
+		delete(c.pending, id)
+		c.mx.Unlock()
+	}()
+	conn := c.currentConnection()
+	if err := <-conn.hubConn.Invoke(id, method, arguments, streamIds); err != nil {
+		return err
+	}
+	sendUploadStreams(conn, streamIds, uploads)
+	select {
+	case completion := <-completionChan:
+		if completion.Error != "" {
+			return completionError(completion)
+		}
+		if result != nil && completion.Result != nil {
+			return unmarshalResult(completion.Result, result)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-conn.done:
+		// The completion will never arrive, even if the client reconnects
+		select {
+		case <-c.done:
+			return c.closeErr
+		default:
+			return conn.err
+		}
+	}
+}
+
+// StreamResult is an item of a stream invoked with Client.Stream. If the stream failed, the last StreamResult has an Err
+type StreamResult struct {
+	Item interface{}
+	Err  error
+}
+
+// Unmarshal converts the Item, which has been unmarshaled without type information, into value, which must be a pointer
+func (s StreamResult) Unmarshal(value interface{}) error {
+	return unmarshalResult(s.Item, value)
+}
+
+// clientStream receives the streamItemMessages and the completionMessage of a stream
+type clientStream struct {
+	messages chan interface{}
+	// done is closed when nobody receives messages anymore
+	done chan struct{}
+}
+
+// clientStreamBufferSize is the number of stream items buffered before the receive loop waits for the caller of Stream
+const clientStreamBufferSize = 16
+
+// Stream invokes the streaming method on the server. The returned channel receives the items of the stream.
+// It is closed when the stream is completed. If the stream failed, the last StreamResult contains the error.
+// When ctx is canceled, the invocation is canceled on the server and the channel is closed.
+// Arguments which are channels are streamed to the server, see Send
+func (c *Client) Stream(ctx context.Context, method string, arguments ...interface{}) <-chan StreamResult {
+	c.mx.Lock()
+	id := c.nextID()
+	arguments, streamIds, uploads := c.uploadStreams(arguments)
+	stream := &clientStream{messages: make(chan interface{}, clientStreamBufferSize), done: make(chan struct{})}
+	c.streams[id] = stream
+	c.mx.Unlock()
+	results := make(chan StreamResult)
+	conn := c.currentConnection()
+	go func() {
+		defer func() {
+			c.mx.Lock()
+			delete(c.streams, id)
+			c.mx.Unlock()
+			close(stream.done)
+			close(results)
+		}()
+		send := func(result StreamResult) bool {
+			select {
+			case results <- result:
+				return true
+			case <-ctx.Done():
+				<-conn.hubConn.CancelInvocation(id)
+				return false
+			}
+		}
+		if err := <-conn.hubConn.StreamInvoke(id, method, arguments, streamIds); err != nil {
+			send(StreamResult{Err: err})
+			return
+		}
+		sendUploadStreams(conn, streamIds, uploads)
+		for {
+			select {
+			case message := <-stream.messages:
+				switch message := message.(type) {
+				case streamItemMessage:
+					if !send(StreamResult{Item: message.Item}) {
+						return
+					}
+				case completionMessage:
+					if message.Error != "" {
+						send(StreamResult{Err: completionError(message)})
+					}
+					return
+				}
+			case <-ctx.Done():
+				<-conn.hubConn.CancelInvocation(id)
+				return
+			case <-conn.done:
+				// The stream will never be completed, even if the client reconnects
+				select {
+				case <-c.done:
+					send(StreamResult{Err: c.closeErr})
+				default:
+					send(StreamResult{Err: conn.err})
+				}
+				return
+			}
+		}
+	}()
+	return results
+}
+
+// nextID returns a new invocation id. c.mx must be locked
+func (c *Client) nextID() string {
+	c.lastID++
+	return strconv.FormatUint(c.lastID, 10)
+}
+
+// Send invokes method on the server but does not wait for its completion.
+// It returns an error if the invocation could not be sent.
+// Arguments which are channels are not sent with the invocation, but streamed to the server:
+// Each item received from the channel is sent as stream item, closing the channel completes the stream.
+// The hub method receives the stream with a parameter of type <-chan
+func (c *Client) Send(method string, arguments ...interface{}) error {
+	select {
+	case <-c.done:
+		return c.closeErr
+	default:
+	}
+	c.mx.Lock()
+	arguments, streamIds, uploads := c.uploadStreams(arguments)
+	c.mx.Unlock()
+	conn := c.currentConnection()
+	if len(streamIds) == 0 {
+		return <-conn.hubConn.SendInvocation(method, arguments...)
+	}
+	if err := <-conn.hubConn.Invoke("", method, arguments, streamIds); err != nil {
+		return err
+	}
+	sendUploadStreams(conn, streamIds, uploads)
+	return nil
+}
+
+// uploadStreams removes the channels from arguments. It returns the other arguments,
+// the stream ids for the channels and the channels. c.mx must be locked
+func (c *Client) uploadStreams(arguments []interface{}) ([]interface{}, []string, []reflect.Value) {
+	var streamIds []string
+	var uploads []reflect.Value
+	remaining := make([]interface{}, 0, len(arguments))
+	for _, argument := range arguments {
+		if value := reflect.ValueOf(argument); value.Kind() == reflect.Chan && value.Type().ChanDir()&reflect.RecvDir != 0 {
+			streamIds = append(streamIds, c.nextID())
+			uploads = append(uploads, value)
+		} else {
+			remaining = append(remaining, argument)
+		}
+	}
+	return remaining, streamIds, uploads
+}
+
+// sendUploadStreams sends the items of the channels in uploads as stream items with the streamIds.
+// It stops when conn ends
+func sendUploadStreams(conn *clientConnection, streamIds []string, uploads []reflect.Value) {
+	for i, upload := range uploads {
+		go func(id string, upload reflect.Value) {
+			cases := []reflect.SelectCase{
+				{Dir: reflect.SelectRecv, Chan: upload},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(conn.done)},
+			}
+			for {
+				chosen, item, ok := reflect.Select(cases)
+				switch {
+				case chosen == 1:
+					return
+				case !ok:
+					<-conn.hubConn.Completion(id, nil, "")
+					return
+				}
+				if err := <-conn.hubConn.StreamItem(id, item.Interface()); err != nil {
+					return
+				}
+			}
+		}(streamIds[i], upload)
+	}
+}
+
+// Close closes the connection to the server
+func (c *Client) Close() error {
+	c.close(errClientClosed)
+	return nil
+}
+
+// close closes the client for good
+func (c *Client) close(err error) {
+	closing := false
+	c.closeOnce.Do(func() {
+		closing = true
+		c.closeErr = err
+		close(c.done)
+		c.currentConnection().end(err)
+	})
+	// Outside of closeOnce, so the handlers can call Close
+	if closing {
+		c.events.raiseClosed(err)
+	}
+}
+
+func (c *Client) receiveLoop(conn *clientConnection) {
+	messages := conn.hubConn.Messages()
+	for {
+		var message interface{}
+		var err error
+		select {
+		case result, ok := <-messages:
+			if !ok {
+				result.err = errHubConnectionClosed
+			}
+			message, err = result.message, result.err
+		case <-conn.done:
+			// Ended by the client
+			return
+		}
+		if err != nil {
+			select {
+			case <-conn.done:
+				// Ended by the client
+				return
+			default:
+			}
+			_ = c.info.Log(evt, msgRecv, "error", err, react, "connection lost")
+			c.connectionLost(conn, err, true)
+			return
+		}
+		switch message := message.(type) {
+		case invocationMessage:
+			if message.Target == ItemsTokenTarget {
+				c.receiveItemsToken(message)
+				continue
+			}
+			select {
+			case c.dispatch <- message:
+			case <-c.done:
+				return
+			}
+		case completionMessage:
+			c.mx.Lock()
+			completionChan, ok := c.pending[message.InvocationID]
+			stream, isStream := c.streams[message.InvocationID]
+			c.mx.Unlock()
+			switch {
+			case ok:
+				completionChan <- message
+			case isStream:
+				stream.receive(message)
+			default:
+				_ = c.info.Log(evt, msgRecv, "error", "unknown invocation id", msg, message, react, "ignore")
+			}
+		case streamItemMessage:
+			c.mx.Lock()
+			stream, ok := c.streams[message.InvocationID]
+			c.mx.Unlock()
+			if ok {
+				stream.receive(message)
+			} else {
+				// Items which arrive after the stream was canceled
+				_ = c.dbg.Log(evt, msgRecv, "error", "unknown invocation id", msg, message, react, "ignore")
+			}
+		case closeMessage:
+			_ = c.dbg.Log(evt, msgRecv, msg, message)
+			if message.Error != "" {
+				c.connectionLost(conn, fmt.Errorf("server closed the connection: %v", message.Error), message.AllowReconnect)
+			} else {
+				c.connectionLost(conn, errors.New("server closed the connection"), message.AllowReconnect)
+			}
+			return
+		case hubMessage:
+			// Ping
+		default:
+			_ = c.info.Log(evt, msgRecv, "error", "unexpected message", msg, message, react, "ignore")
+		}
+	}
+}
+
+// receive passes message to the caller of Stream. It waits while the buffer of the stream is full
+func (s *clientStream) receive(message interface{}) {
+	select {
+	case s.messages <- message:
+	case <-s.done:
+	}
+}
+
+// connectionLost ends conn. If the client has a RetryPolicy and reconnecting is allowed, it reconnects,
+// otherwise the client is closed
+func (c *Client) connectionLost(conn *clientConnection, err error, allowReconnect bool) {
+	conn.end(err)
+	if c.retryPolicy == nil || !allowReconnect {
+		c.close(err)
+		return
+	}
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	c.reconnect(err)
+}
+
+// reconnect tries to connect again as long as the RetryPolicy allows it
+func (c *Client) reconnect(err error) {
+	c.events.raiseReconnecting(err)
+	start := time.Now()
+	for retryCount := 0; ; retryCount++ {
+		delay, ok := c.retryPolicy.NextRetryDelay(retryCount, time.Since(start))
+		if !ok {
+			_ = c.info.Log(evt, "reconnect", "error", err, react, "close client")
+			c.close(err)
+			return
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-c.done:
+			timer.Stop()
+			return
+		}
+		conn, connectErr := c.connect()
+		if connectErr != nil {
+			_ = c.info.Log(evt, "reconnect", "error", connectErr, react, "retry")
+			err = connectErr
+			continue
+		}
+		c.mx.Lock()
+		select {
+		case <-c.done:
+			// Closed while connecting
+			c.mx.Unlock()
+			conn.end(errClientClosed)
+			return
+		default:
+		}
+		c.conn = conn
+		c.mx.Unlock()
+		go c.receiveLoop(conn)
+		c.events.raiseReconnected(conn.hubConn.GetConnectionID())
+		return
+	}
+}
+
+func (c *Client) dispatchLoop() {
+	for {
+		select {
+		case invocation := <-c.dispatch:
+			c.handleInvocation(invocation)
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Client) handleInvocation(invocation invocationMessage) {
+	h, ok := c.handlers.Load(strings.ToLower(invocation.Target))
+	if !ok {
+		_ = c.info.Log(evt, "handleInvocation", "error", "missing handler", "name", invocation.Target, react, "ignore")
+		return
+	}
+	handler := h.(reflect.Value)
+	if handler.Type().NumIn() != len(invocation.Arguments) {
+		_ = c.info.Log(evt, "handleInvocation", "error", "argument count mismatch", "name", invocation.Target, react, "ignore")
+		return
+	}
+	in := make([]reflect.Value, len(invocation.Arguments))
+	for i, argument := range invocation.Arguments {
+		arg := reflect.New(handler.Type().In(i))
+		if err := c.protocol.UnmarshalArgument(argument, arg.Interface()); err != nil {
+			_ = c.info.Log(evt, "handleInvocation", "error", err, "name", invocation.Target, react, "ignore")
+			return
+		}
+		in[i] = arg.Elem()
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			_ = c.info.Log(evt, "recover", "error", err, "name", invocation.Target, react, "ignore")
+		}
+	}()
+	handler.Call(in)
+}
+
+// negotiate negotiates with negotiateVersion 1. If the server only supports version 0,
+// the ConnectionToken of the response is the ConnectionID
+// hubEndpoint is the url of the hub and the headers of the requests to it
+type hubEndpoint struct {
+	url    *url.URL
+	header http.Header
+}
+
+// negotiateRedirected negotiates with the hub at endpoint. If the server redirects to another url,
+// the Client negotiates again with that url and the access token of the redirect.
+// It returns the endpoint the negotiation ended with
+func (c *Client) negotiateRedirected(endpoint hubEndpoint) (*negotiateResponse, hubEndpoint, error) {
+	for redirects := 0; ; redirects++ {
+		negotiated, err := c.negotiate(endpoint)
+		if err != nil {
+			return nil, endpoint, err
+		}
+		if negotiated.URL == "" {
+			return negotiated, endpoint, nil
+		}
+		if redirects >= c.maxNegotiateRedirects {
+			return nil, endpoint, fmt.Errorf("negotiate exceeded the maximum of %v redirects", c.maxNegotiateRedirects)
+		}
+		if endpoint.url, err = url.Parse(negotiated.URL); err != nil {
+			return nil, endpoint, err
+		}
+		if negotiated.AccessToken != "" {
+			header := endpoint.header.Clone()
+			if header == nil {
+				header = make(http.Header)
+			}
+			header.Set("Authorization", "Bearer "+negotiated.AccessToken)
+			endpoint.header = header
+		}
+	}
+}
+
+func (c *Client) negotiate(endpoint hubEndpoint) (*negotiateResponse, error) {
+	negotiateURL := *endpoint.url
+	negotiateURL.Path = strings.TrimSuffix(negotiateURL.Path, "/") + "/negotiate"
+	query := negotiateURL.Query()
+	query.Set("negotiateVersion", "1")
+	if c.statefulReconnectTimeout > 0 {
+		query.Set("useStatefulReconnect", "true")
+	}
+	negotiateURL.RawQuery = query.Encode()
+	req, err := http.NewRequest(http.MethodPost, negotiateURL.String(), &bytes.Buffer{})
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range endpoint.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	req.Header.Set("Content-Type", "text/plain;charset=UTF-8")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	response := negotiateResponse{}
+	if resp.StatusCode != http.StatusOK {
+		if json.Unmarshal(body, &response) == nil && response.Error != "" {
+			return nil, fmt.Errorf("negotiate failed with status %v: %v", resp.Status, response.Error)
+		}
+		return nil, fmt.Errorf("negotiate failed with status %v", resp.Status)
+	}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if response.Error != "" {
+		return nil, fmt.Errorf("negotiate failed: %v", response.Error)
+	}
+	if response.URL != "" {
+		return &response, nil
+	}
+	for _, transport := range response.AvailableTransports {
+		if transport.Transport == "WebSockets" {
+			if response.NegotiateVersion < 1 {
+				response.ConnectionToken = response.ConnectionID
+				response.UseStatefulReconnect = false
+			}
+			return &response, nil
+		}
+	}
+	return nil, errors.New("server does not support WebSockets")
+}
+
+// dialWebSocket connects the WebSocket of the connection with connectionToken to the hub at endpoint.
+// Without connectionToken, the connection was not negotiated
+func (c *Client) dialWebSocket(endpoint hubEndpoint, connectionToken string) (*websocket.Conn, error) {
+	wsURL, origin := *endpoint.url, *endpoint.url
+	switch wsURL.Scheme {
+	case "https", "wss":
+		wsURL.Scheme, origin.Scheme = "wss", "https"
+	default:
+		wsURL.Scheme, origin.Scheme = "ws", "http"
+	}
+	if connectionToken != "" {
+		query := wsURL.Query()
+		query.Set("id", connectionToken)
+		wsURL.RawQuery = query.Encode()
+	}
+	config, err := websocket.NewConfig(wsURL.String(), origin.String())
+	if err != nil {
+		return nil, err
+	}
+	config.Header = endpoint.header.Clone()
+	conn, err := c.dialWebSocketConn(config.Location)
+	if err != nil {
+		return nil, err
+	}
+	if conn, err = newReadDeadlineConn(conn, c.webSocket.readTimeout); err != nil {
+		return nil, err
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+func (c *Client) processHandshake(conn Connection, protocol string, version int) error {
+	request, _ := json.Marshal(handshakeRequest{Protocol: protocol, Version: version, ItemsToken: c.ItemsToken(),
+		AcceptItemsTokens: true})
+	if _, err := conn.Write(append(request, 30)); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	data := make([]byte, 1<<12)
+	for {
+		n, err := conn.Read(data)
+		if err != nil {
+			return err
+		}
+		buf.Write(data[:n])
+		if bytes.IndexByte(buf.Bytes(), 30) < 0 {
+			// Partial message, read more data
+			continue
+		}
+		rawResponse, _ := parseTextMessageFormat(&buf)
+		unreadRemainder(conn, &buf)
+		_ = c.dbg.Log(evt, "handshake received", msg, string(rawResponse))
+		response := handshakeResponse{}
+		if err = json.Unmarshal(rawResponse, &response); err != nil {
+			return err
+		}
+		if response.Error != "" {
+			return fmt.Errorf("handshake failed: %v", response.Error)
+		}
+		return nil
+	}
+}
+
+// receiveItemsToken keeps the items token sent by the server for the next handshake
+func (c *Client) receiveItemsToken(invocation invocationMessage) {
+	var token string
+	if len(invocation.Arguments) != 1 {
+		_ = c.info.Log(evt, msgRecv, "error", "invalid items token", msg, invocation, react, "ignore")
+		return
+	}
+	if err := c.protocol.UnmarshalArgument(invocation.Arguments[0], &token); err != nil {
+		_ = c.info.Log(evt, msgRecv, "error", err, msg, invocation, react, "ignore")
+		return
+	}
+	c.mx.Lock()
+	c.itemsToken = token
+	c.mx.Unlock()
+}
+
+// unmarshalResult converts value, which has been unmarshaled without type information by the protocol,
+// into result. As all protocols deliver JSON compatible values, JSON is used for the conversion.
+func unmarshalResult(value interface{}, result interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, result)
+}
@@ -0,0 +1,556 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type clientTestHub struct {
+	Hub
+}
+
+type clientTestPerson struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+func (c *clientTestHub) Add(a int, b int) int {
+	return a + b
+}
+
+func (c *clientTestHub) Birthday(p clientTestPerson) clientTestPerson {
+	p.Age++
+	return p
+}
+
+func (c *clientTestHub) Echo(message string) {
+	c.Clients().Caller().Send("receive", message, len(message))
+}
+
+func (c *clientTestHub) Count(to int) <-chan clientTestPerson {
+	ch := make(chan clientTestPerson)
+	go func() {
+		defer close(ch)
+		for i := 1; i <= to; i++ {
+			ch <- clientTestPerson{Name: "Bob", Age: i}
+		}
+	}()
+	return ch
+}
+
+var clientTestTicksStopped = make(chan struct{}, 1)
+
+func (c *clientTestHub) Sum(numbers <-chan int, factor int) int {
+	sum := 0
+	for number := range numbers {
+		sum += number
+	}
+	return sum * factor
+}
+
+var clientTestUploaded = make(chan []string, 1)
+
+var clientTestRelease = make(chan struct{})
+
+func (c *clientTestHub) Wait() string {
+	<-clientTestRelease
+	return "released"
+}
+
+func (c *clientTestHub) Upload(words <-chan string) {
+	var uploaded []string
+	for word := range words {
+		uploaded = append(uploaded, word)
+	}
+	clientTestUploaded <- uploaded
+}
+
+func (c *clientTestHub) Ticks(ctx context.Context) <-chan int {
+	ch := make(chan int)
+	go func() {
+		defer func() { clientTestTicksStopped <- struct{}{} }()
+		for i := 0; ; i++ {
+			select {
+			case ch <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+func startClientTestServer() *httptest.Server {
+	server, _ := NewServer(context.Background(), SimpleHubFactory(&clientTestHub{}), Logger(log.NewNopLogger(), false))
+	router := http.NewServeMux()
+	server.MapHTTP(router, "/hub")
+	return httptest.NewServer(router)
+}
+
+// testProxy is a http proxy which forwards plain requests and tunnels CONNECT requests
+type testProxy struct {
+	mx       sync.Mutex
+	requests []string
+}
+
+func (p *testProxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	p.mx.Lock()
+	p.requests = append(p.requests, req.Method+" "+req.Host)
+	p.mx.Unlock()
+	if req.Method != http.MethodConnect {
+		outReq := req.Clone(req.Context())
+		outReq.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(outReq)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		for key, values := range resp.Header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	conn, rw, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		_ = target.Close()
+		return
+	}
+	_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+	go func() {
+		_, _ = io.Copy(target, rw)
+		_ = target.Close()
+	}()
+	_, _ = io.Copy(conn, target)
+	_ = conn.Close()
+}
+
+func (p *testProxy) received() []string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return append([]string(nil), p.requests...)
+}
+
+var _ = Describe("Client", func() {
+
+	Context("When the client connects through a proxy", func() {
+		It("should negotiate through the proxy and tunnel the WebSocket with CONNECT", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			proxy := &testProxy{}
+			proxyServer := httptest.NewServer(proxy)
+			defer proxyServer.Close()
+			proxyURL, _ := url.Parse(proxyServer.URL)
+			client, err := Dial(testServer.URL+"/hub", WithTransport(&http.Transport{Proxy: http.ProxyURL(proxyURL)}),
+				WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+			host := strings.TrimPrefix(testServer.URL, "http://")
+			Expect(proxy.received()).To(Equal([]string{"POST " + host, "CONNECT " + host}))
+		})
+	})
+
+	Context("When the client skips negotiation", func() {
+		It("should connect over WebSockets without negotiate request", func() {
+			server, _ := NewServer(context.Background(), SimpleHubFactory(&clientTestHub{}), Logger(log.NewNopLogger(), false))
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub")
+			negotiated := make(chan string, 1)
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if strings.HasSuffix(req.URL.Path, "/negotiate") {
+					negotiated <- req.URL.Path
+				}
+				router.ServeHTTP(w, req)
+			}))
+			defer testServer.Close()
+			for _, address := range []string{testServer.URL + "/hub", strings.Replace(testServer.URL, "http", "ws", 1) + "/hub"} {
+				client, err := Dial(address, WithSkipNegotiation(), WithLogger(log.NewNopLogger(), false))
+				Expect(err).To(BeNil())
+				var sum int
+				Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+				Expect(sum).To(Equal(3))
+				client.Close()
+			}
+			Expect(negotiated).NotTo(Receive())
+		})
+	})
+
+	Context("When negotiate redirects to another url", func() {
+		It("should negotiate and connect with the url and access token of the redirect", func() {
+			testServer := newAuthTestServer()
+			defer testServer.Close()
+			redirects := 0
+			redirectServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				redirects++
+				_ = json.NewEncoder(w).Encode(negotiateResponse{URL: testServer.URL + "/hub", AccessToken: "secret"})
+			}))
+			defer redirectServer.Close()
+			client, err := Dial(redirectServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(redirects).To(Equal(1))
+			var sub string
+			Expect(client.Invoke(context.Background(), "whoami", &sub)).To(BeNil())
+			Expect(sub).To(Equal("alice"))
+		})
+		It("should fail when the redirects exceed the maximum", func() {
+			var redirectServer *httptest.Server
+			redirectServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				_ = json.NewEncoder(w).Encode(negotiateResponse{URL: redirectServer.URL + "/hub"})
+			}))
+			defer redirectServer.Close()
+			_, err := Dial(redirectServer.URL+"/hub", WithMaxNegotiateRedirects(2), WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(MatchError("negotiate exceeded the maximum of 2 redirects"))
+		})
+	})
+
+	Context("When the client has a custom dialer", func() {
+		It("should open all connections with it", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			dialed := make(chan string, 10)
+			client, err := Dial(testServer.URL+"/hub", WithDialContext(func(ctx context.Context, network, address string) (net.Conn, error) {
+				dialed <- address
+				var dialer net.Dialer
+				return dialer.DialContext(ctx, network, address)
+			}), WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			host := strings.TrimPrefix(testServer.URL, "http://")
+			Expect(dialed).To(Receive(Equal(host)))
+			Expect(dialed).To(Receive(Equal(host)))
+		})
+	})
+
+	Context("When the client has http headers", func() {
+		It("should send them with negotiate and the WebSocket upgrade", func() {
+			testServer := newAuthTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithHTTPHeaders(http.Header{"Authorization": {"Bearer secret"}}),
+				WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var sub string
+			Expect(client.Invoke(context.Background(), "whoami", &sub)).To(BeNil())
+			Expect(sub).To(Equal("alice"))
+		})
+	})
+
+	Context("When the client dials a server", func() {
+		It("should be connected with the connection id the server negotiated", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(client.ConnectionID()).NotTo(Equal(""))
+		})
+	})
+
+	Context("When the client pings a server which answers", func() {
+		It("should stay connected beyond the read timeout", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithWebSocketPingInterval(50*time.Millisecond),
+				WithWebSocketReadTimeout(300*time.Millisecond), WithWebSocketWriteTimeout(time.Second),
+				WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			time.Sleep(time.Second)
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+		})
+	})
+
+	Context("When the negotiation fails", func() {
+		It("should return an error", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			_, err := Dial(testServer.URL+"/nohub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("Invoke", func() {
+		It("should return typed results", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+			var person clientTestPerson
+			Expect(client.Invoke(context.Background(), "birthday", &person, clientTestPerson{Name: "Bob", Age: 41})).To(BeNil())
+			Expect(person).To(Equal(clientTestPerson{Name: "Bob", Age: 42}))
+		})
+		It("should return the error of the server", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(client.Invoke(context.Background(), "missing", nil)).NotTo(BeNil())
+		})
+		It("should return the error of the context when it is canceled before the completion arrives", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			var result string
+			Expect(client.Invoke(ctx, "wait", &result)).To(Equal(context.DeadlineExceeded))
+			clientTestRelease <- struct{}{}
+			// The late completion is ignored
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+			Expect(result).To(Equal(""))
+		})
+		It("should return an error after the client is closed", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			Expect(client.Close()).To(BeNil())
+			Expect(client.Invoke(context.Background(), "add", nil, 1, 2)).NotTo(BeNil())
+			Expect(client.Send("add", 1, 2)).NotTo(BeNil())
+		})
+	})
+
+	Context("Stream", func() {
+		It("should receive the typed items of the stream until it is completed", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var ages []int
+			for result := range client.Stream(context.Background(), "count", 3) {
+				Expect(result.Err).To(BeNil())
+				var person clientTestPerson
+				Expect(result.Unmarshal(&person)).To(BeNil())
+				ages = append(ages, person.Age)
+			}
+			Expect(ages).To(Equal([]int{1, 2, 3}))
+		})
+		It("should return the error of the server as last item", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var results []StreamResult
+			for result := range client.Stream(context.Background(), "missing") {
+				results = append(results, result)
+			}
+			Expect(results).To(HaveLen(1))
+			Expect(results[0].Err).NotTo(BeNil())
+		})
+		It("should cancel the stream on the server when the context is canceled", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			results := client.Stream(ctx, "ticks")
+			for i := 0; i < 3; i++ {
+				Expect((<-results).Item).To(Equal(float64(i)))
+			}
+			cancel()
+			Eventually(clientTestTicksStopped).Should(Receive())
+			Eventually(results).Should(BeClosed())
+			// The connection is still usable
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+		})
+	})
+
+	Context("When a channel is passed as argument", func() {
+		It("should stream the items of the channel to the server with Invoke", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			numbers := make(chan int)
+			go func() {
+				defer close(numbers)
+				for i := 1; i <= 4; i++ {
+					numbers <- i
+				}
+			}()
+			var sum int
+			Expect(client.Invoke(context.Background(), "sum", &sum, numbers, 2)).To(BeNil())
+			Expect(sum).To(Equal(20))
+		})
+		It("should stream the items of the channel to the server with Send", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			words := make(chan string, 2)
+			words <- "hello"
+			words <- "world"
+			close(words)
+			Expect(client.Send("upload", words)).To(BeNil())
+			Eventually(clientTestUploaded).Should(Receive(Equal([]string{"hello", "world"})))
+		})
+	})
+
+	Context("On", func() {
+		It("should call the handler with the arguments sent by the server", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			received := make(chan string, 1)
+			Expect(client.On("receive", func(message string, length int) {
+				Expect(length).To(Equal(len(message)))
+				received <- message
+			})).To(BeNil())
+			Expect(client.Send("echo", "hello")).To(BeNil())
+			select {
+			case message := <-received:
+				Expect(message).To(Equal("hello"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+		})
+		It("should refuse handlers which are no funcs", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(client.On("receive", "nofunc")).NotTo(BeNil())
+		})
+	})
+
+	Context("When the connection is lost and automatic reconnect is enabled", func() {
+		It("should reconnect and raise the reconnect events", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			reconnecting := make(chan error, 1)
+			reconnected := make(chan string, 1)
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false),
+				WithAutomaticReconnect(0),
+				WithReconnecting(func(err error) { reconnecting <- err }),
+				WithReconnected(func(connectionID string) { reconnected <- connectionID }))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			oldID := client.ConnectionID()
+			// Simulate a network failure
+			_ = client.currentConnection().transport.Close()
+			Eventually(reconnecting).Should(Receive(HaveOccurred()))
+			var newID string
+			Eventually(reconnected).Should(Receive(&newID))
+			Expect(newID).NotTo(Equal(oldID))
+			Expect(client.ConnectionID()).To(Equal(newID))
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+		})
+	})
+
+	Context("When the connection is lost and the RetryPolicy gives up", func() {
+		It("should close the client", func() {
+			server, _ := NewServer(context.Background(), SimpleHubFactory(&clientTestHub{}), Logger(log.NewNopLogger(), false))
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub")
+			testServer := httptest.NewServer(router)
+			defer testServer.Close()
+			retries := make(chan int, 10)
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false),
+				WithRetryPolicy(retryPolicyFunc(func(retryCount int, elapsed time.Duration) (time.Duration, bool) {
+					retries <- retryCount
+					return time.Millisecond, retryCount < 2
+				})))
+			Expect(err).To(BeNil())
+			// The server does not accept new connections
+			Expect(server.Shutdown(context.Background())).To(BeNil())
+			Eventually(retries).Should(Receive(Equal(2)))
+			Eventually(func() error { return client.Send("add", 1, 2) }).Should(HaveOccurred())
+			Expect(client.Invoke(context.Background(), "add", nil, 1, 2)).NotTo(BeNil())
+		})
+	})
+
+	Context("When the connection is lost and automatic reconnect is not enabled", func() {
+		It("should close the client", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			_ = client.currentConnection().transport.Close()
+			Eventually(client.done).Should(BeClosed())
+			Expect(client.Send("add", 1, 2)).NotTo(BeNil())
+		})
+		It("should raise the closed event with the error", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			closed := make(chan error, 2)
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false),
+				WithClosed(func(err error) { closed <- err }))
+			Expect(err).To(BeNil())
+			client.OnClosed(func(err error) { closed <- err })
+			_ = client.currentConnection().transport.Close()
+			Eventually(closed).Should(Receive(HaveOccurred()))
+			Eventually(closed).Should(Receive(HaveOccurred()))
+			// Handlers registered after closing are called at once
+			client.OnClosed(func(err error) { closed <- err })
+			Expect(closed).To(Receive(HaveOccurred()))
+		})
+	})
+
+	Context("When the client is closed", func() {
+		It("should raise the closed event without error", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			closed := make(chan error, 1)
+			client.OnClosed(func(err error) {
+				// Close must not block in the handler
+				_ = client.Close()
+				closed <- err
+			})
+			Expect(client.Close()).To(BeNil())
+			Expect(closed).To(Receive(BeNil()))
+		})
+	})
+})
+
+type retryPolicyFunc func(retryCount int, elapsed time.Duration) (time.Duration, bool)
+
+func (r retryPolicyFunc) NextRetryDelay(retryCount int, elapsed time.Duration) (time.Duration, bool) {
+	return r(retryCount, elapsed)
+}
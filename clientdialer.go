@@ -0,0 +1,159 @@
+package signalr
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// httpTransport returns the http.Transport the client options configure. The first call clones http.DefaultTransport
+// and uses it for negotiation, unless another http.Client was set
+func (c *Client) httpTransport() *http.Transport {
+	if c.transport == nil {
+		c.transport = http.DefaultTransport.(*http.Transport).Clone()
+		if c.httpClient == http.DefaultClient {
+			c.httpClient = &http.Client{Transport: c.transport}
+		}
+	}
+	return c.transport
+}
+
+// webSocketTransport returns the http.Transport whose proxy, dialer and TLS config are used for WebSockets
+func (c *Client) webSocketTransport() *http.Transport {
+	if c.transport != nil {
+		return c.transport
+	}
+	if transport, ok := c.httpClient.Transport.(*http.Transport); ok {
+		return transport
+	}
+	return http.DefaultTransport.(*http.Transport)
+}
+
+// dialWebSocketConn opens the connection for the WebSocket at location, over the Unix domain socket if one is set.
+// Otherwise it connects like the http.Transport of the client, through its proxy and with its TLS config
+func (c *Client) dialWebSocketConn(location *url.URL) (net.Conn, error) {
+	if c.unixSocket != "" {
+		return net.Dial("unix", c.unixSocket)
+	}
+	transport := c.webSocketTransport()
+	dial := transport.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	secure := location.Scheme == "wss"
+	address := hostPort(location, secure)
+	var proxyURL *url.URL
+	if transport.Proxy != nil {
+		// Proxy funcs like http.ProxyFromEnvironment choose by the http(s) scheme
+		requestURL := *location
+		requestURL.Scheme = "http"
+		if secure {
+			requestURL.Scheme = "https"
+		}
+		var err error
+		if proxyURL, err = transport.Proxy(&http.Request{URL: &requestURL}); err != nil {
+			return nil, err
+		}
+	}
+	var conn net.Conn
+	var err error
+	if proxyURL != nil {
+		conn, err = dialProxy(dial, proxyURL, address, transport.TLSClientConfig)
+	} else {
+		conn, err = dial(context.Background(), "tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !secure {
+		return conn, nil
+	}
+	return tlsHandshake(conn, transport.TLSClientConfig, location.Hostname())
+}
+
+// dialProxy opens a tunnel to address through the http(s) proxy with a CONNECT request
+func dialProxy(dial func(ctx context.Context, network, address string) (net.Conn, error), proxyURL *url.URL,
+	address string, tlsConfig *tls.Config) (net.Conn, error) {
+	if proxyURL.Scheme != "http" && proxyURL.Scheme != "https" {
+		return nil, fmt.Errorf("proxy scheme %q not supported for WebSockets", proxyURL.Scheme)
+	}
+	conn, err := dial(context.Background(), "tcp", hostPort(proxyURL, proxyURL.Scheme == "https"))
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL.Scheme == "https" {
+		if conn, err = tlsHandshake(conn, tlsConfig, proxyURL.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+	connect := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		connect.Header.Set("Proxy-Authorization",
+			"Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if err = connect.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, connect)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy CONNECT failed with status %v", resp.Status)
+	}
+	return &bufferedConn{Conn: conn, reader: reader}, nil
+}
+
+// tlsHandshake starts TLS over conn. Without ServerName in config, the certificate is verified for host
+func tlsHandshake(conn net.Conn, config *tls.Config, host string) (net.Conn, error) {
+	config = config.Clone()
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// hostPort returns the host of u with the default port of the scheme if u has no port
+func hostPort(u *url.URL, secure bool) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if secure {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// bufferedConn reads the data the reader buffered before the rest of the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error) {
+	return b.reader.Read(p)
+}
@@ -0,0 +1,75 @@
+package signalr
+
+import "sync"
+
+// clientEvents holds the handlers for the lifecycle events of a Client
+type clientEvents struct {
+	mx             sync.Mutex
+	closed         bool
+	closeErr       error
+	onClosed       []func(err error)
+	onReconnecting []func(err error)
+	onReconnected  []func(connectionID string)
+}
+
+// OnClosed registers a handler which is called when the Client is closed for good, either by Close or because
+// the connection was lost and could not be reconnected. err is nil when the Client was closed by Close.
+// If the Client is already closed, handler is called at once
+func (c *Client) OnClosed(handler func(err error)) {
+	c.events.mx.Lock()
+	if c.events.closed {
+		err := c.events.closeErr
+		c.events.mx.Unlock()
+		handler(err)
+		return
+	}
+	c.events.onClosed = append(c.events.onClosed, handler)
+	c.events.mx.Unlock()
+}
+
+// OnReconnecting registers a handler which is called with the reason when the Client starts reconnecting
+func (c *Client) OnReconnecting(handler func(err error)) {
+	c.events.mx.Lock()
+	defer c.events.mx.Unlock()
+	c.events.onReconnecting = append(c.events.onReconnecting, handler)
+}
+
+// OnReconnected registers a handler which is called with the new connection id when the Client has reconnected.
+// Groups are bound to connections, so the handler is the place to join the groups of the Client again
+func (c *Client) OnReconnected(handler func(connectionID string)) {
+	c.events.mx.Lock()
+	defer c.events.mx.Unlock()
+	c.events.onReconnected = append(c.events.onReconnected, handler)
+}
+
+func (e *clientEvents) raiseClosed(err error) {
+	if err == errClientClosed {
+		err = nil
+	}
+	e.mx.Lock()
+	e.closed, e.closeErr = true, err
+	handlers := e.onClosed
+	e.onClosed = nil
+	e.mx.Unlock()
+	for _, handler := range handlers {
+		handler(err)
+	}
+}
+
+func (e *clientEvents) raiseReconnecting(err error) {
+	e.mx.Lock()
+	handlers := append([]func(err error){}, e.onReconnecting...)
+	e.mx.Unlock()
+	for _, handler := range handlers {
+		handler(err)
+	}
+}
+
+func (e *clientEvents) raiseReconnected(connectionID string) {
+	e.mx.Lock()
+	handlers := append([]func(connectionID string){}, e.onReconnected...)
+	e.mx.Unlock()
+	for _, handler := range handlers {
+		handler(connectionID)
+	}
+}
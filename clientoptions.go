@@ -0,0 +1,297 @@
+package signalr
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+)
+
+// WithHTTPClient sets the http.Client used by the Client to negotiate.
+// If its Transport is a http.Transport, WebSockets use its proxy, dialer and TLS config, too
+func WithHTTPClient(httpClient *http.Client) func(*Client) error {
+	return func(c *Client) error {
+		if httpClient == nil {
+			return errors.New("httpClient is nil")
+		}
+		c.httpClient = httpClient
+		return nil
+	}
+}
+
+// WithKeepAliveInterval sets the interval after which the Client sends a ping message to keep the connection
+// alive, if it has not sent any other message in the meantime. Default is 15 seconds
+func WithKeepAliveInterval(interval time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.New("KeepAliveInterval must be positive")
+		}
+		c.keepAliveInterval = interval
+		return nil
+	}
+}
+
+// WithWebSocketReadTimeout closes the WebSocket connection when nothing, not even a pong, is received during timeout
+func WithWebSocketReadTimeout(timeout time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return errors.New("WebSocketReadTimeout must be positive")
+		}
+		c.webSocket.readTimeout = timeout
+		return nil
+	}
+}
+
+// WithWebSocketWriteTimeout fails writes to the WebSocket connection which do not finish during timeout
+func WithWebSocketWriteTimeout(timeout time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if timeout <= 0 {
+			return errors.New("WebSocketWriteTimeout must be positive")
+		}
+		c.webSocket.writeTimeout = timeout
+		return nil
+	}
+}
+
+// WithWebSocketPingInterval sends WebSocket pings in interval. Combined with WithWebSocketReadTimeout,
+// a server which does not answer them is detected even if the hub sends no messages
+func WithWebSocketPingInterval(interval time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if interval <= 0 {
+			return errors.New("WebSocketPingInterval must be positive")
+		}
+		c.webSocket.pingInterval = interval
+		return nil
+	}
+}
+
+// WithUnixSocket connects to the http(s) url of the hub over the Unix domain socket at path instead of TCP,
+// e.g. to a server running http.Serve with a unix listener. No proxy is used
+func WithUnixSocket(path string) func(*Client) error {
+	return func(c *Client) error {
+		if path == "" {
+			return errors.New("unix socket path is empty")
+		}
+		c.unixSocket = path
+		transport := c.httpTransport()
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", path)
+		}
+		return nil
+	}
+}
+
+// WithTransport sets the http.Transport for negotiation and WebSockets. WebSockets use its Proxy, DialContext
+// and TLSClientConfig. By default, the Client uses the settings of http.DefaultTransport, which honors the
+// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables
+func WithTransport(transport *http.Transport) func(*Client) error {
+	return func(c *Client) error {
+		if transport == nil {
+			return errors.New("WithTransport needs a transport")
+		}
+		c.transport = transport
+		c.httpClient = &http.Client{Transport: transport}
+		return nil
+	}
+}
+
+// WithDialContext sets the function which opens the network connections for negotiation and WebSockets,
+// e.g. the DialContext of a net.Dialer with a timeout or a local address
+func WithDialContext(dial func(ctx context.Context, network, address string) (net.Conn, error)) func(*Client) error {
+	return func(c *Client) error {
+		if dial == nil {
+			return errors.New("WithDialContext needs a dial func")
+		}
+		c.httpTransport().DialContext = dial
+		return nil
+	}
+}
+
+// WithHTTPHeaders adds headers, e.g. Authorization or Cookie, to the negotiate requests and the WebSocket upgrades
+func WithHTTPHeaders(header http.Header) func(*Client) error {
+	return func(c *Client) error {
+		if c.header == nil {
+			c.header = make(http.Header)
+		}
+		for key, values := range header {
+			for _, value := range values {
+				c.header.Add(key, value)
+			}
+		}
+		return nil
+	}
+}
+
+// WithTLSConfig sets the TLS config for https and wss connections, e.g. with the Certificates for mutual TLS
+// and the RootCAs of the server
+func WithTLSConfig(config *tls.Config) func(*Client) error {
+	return func(c *Client) error {
+		if config == nil {
+			return errors.New("WithTLSConfig needs a config")
+		}
+		c.httpTransport().TLSClientConfig = config
+		return nil
+	}
+}
+
+// WithSkipNegotiation connects directly over WebSockets without negotiate request, e.g. to servers
+// which only expose WebSockets. The url of the hub may have a ws(s) or http(s) scheme.
+// The connection has no ConnectionID and can not use stateful reconnect
+func WithSkipNegotiation() func(*Client) error {
+	return func(c *Client) error {
+		c.skipNegotiation = true
+		return nil
+	}
+}
+
+// WithMaxNegotiateRedirects sets how often the Client follows redirects of negotiate responses to other urls,
+// e.g. of the Azure SignalR Service. Default is 100. 0 does not allow redirects
+func WithMaxNegotiateRedirects(maxRedirects int) func(*Client) error {
+	return func(c *Client) error {
+		if maxRedirects < 0 {
+			return errors.New("MaxNegotiateRedirects must not be negative")
+		}
+		c.maxNegotiateRedirects = maxRedirects
+		return nil
+	}
+}
+
+// WithJSONEncoder sets the JSONEncoder used by the Client to serialize hub messages. Default is encoding/json
+func WithJSONEncoder(encoder JSONEncoder) func(*Client) error {
+	return func(c *Client) error {
+		if encoder == nil {
+			return errors.New("WithJSONEncoder needs an encoder")
+		}
+		c.jsonEncoder = encoder
+		return nil
+	}
+}
+
+// WithStrictProtocol lets the Client reject messages from the server which violate the SignalR protocol,
+// see StrictProtocol. The Client closes the connection on such a message
+func WithStrictProtocol() func(*Client) error {
+	return func(c *Client) error {
+		c.strictProtocol = true
+		return nil
+	}
+}
+
+// WithLogger sets the logger used by the Client to log info events.
+// If debug is true, debug log event are generated, too
+func WithLogger(logger StructuredLogger, debug bool) func(*Client) error {
+	return func(c *Client) error {
+		c.logger = logger
+		c.debug = debug
+		return nil
+	}
+}
+
+// WithLogLevelValues sets the values of the "level" key which the Client passes to its logger instead of the LogLevels,
+// e.g. WithLogLevelValues(level.DebugValue(), level.InfoValue()) for level.NewFilter of github.com/go-kit/kit/log/level
+func WithLogLevelValues(debug, info interface{}) func(*Client) error {
+	return func(c *Client) error {
+		if debug == nil || info == nil {
+			return errors.New("log level values must not be nil")
+		}
+		c.logLevelValues = &logLevelValues{debug: debug, info: info}
+		return nil
+	}
+}
+
+// WithSubsystemLogLevel sets the level of the log events of a subsystem, e.g. LogSubsystemJSON.
+// With LogLevelDebug, debug log events of the subsystem are generated even if the WithLogger option disabled them
+func WithSubsystemLogLevel(subsystem string, level LogLevel) func(*Client) error {
+	return func(c *Client) error {
+		c.logLevels[subsystem] = level
+		return nil
+	}
+}
+
+// WithAutomaticReconnect lets the Client reconnect when the connection was lost and the server allows it.
+// The Client tries to reconnect once for each delay, waiting the delay before the attempt.
+// Without delays, the Client waits 0, 2, 10 and 30 seconds
+func WithAutomaticReconnect(delays ...time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if len(delays) == 0 {
+			delays = defaultRetryDelays
+		}
+		for _, delay := range delays {
+			if delay < 0 {
+				return errors.New("reconnect delays must not be negative")
+			}
+		}
+		c.retryPolicy = delayRetryPolicy(delays)
+		return nil
+	}
+}
+
+// WithRetryPolicy lets the Client reconnect as long as the RetryPolicy allows it
+// when the connection was lost and the server allows it
+func WithRetryPolicy(policy RetryPolicy) func(*Client) error {
+	return func(c *Client) error {
+		if policy == nil {
+			return errors.New("RetryPolicy is nil")
+		}
+		c.retryPolicy = policy
+		return nil
+	}
+}
+
+// WithStatefulReconnect lets the Client negotiate stateful reconnect. If the server supports it and the connection
+// fails, the Client tries to resume the connection during reconnectTimeout. When it resumes, the messages which were
+// not acknowledged are sent again and the connection id stays the same
+func WithStatefulReconnect(reconnectTimeout time.Duration) func(*Client) error {
+	return func(c *Client) error {
+		if reconnectTimeout <= 0 {
+			return errors.New("reconnectTimeout must be positive")
+		}
+		c.statefulReconnectTimeout = reconnectTimeout
+		return nil
+	}
+}
+
+// WithItemsToken lets the Client send the token with the first handshake, so the server restores the Items
+// of the connection the token was issued for, see PersistItems and Client.ItemsToken
+func WithItemsToken(token string) func(*Client) error {
+	return func(c *Client) error {
+		c.itemsToken = token
+		return nil
+	}
+}
+
+// WithReconnecting sets a handler which is called with the reason when the Client starts reconnecting, see OnReconnecting
+func WithReconnecting(handler func(err error)) func(*Client) error {
+	return func(c *Client) error {
+		if handler == nil {
+			return errors.New("reconnecting handler is nil")
+		}
+		c.OnReconnecting(handler)
+		return nil
+	}
+}
+
+// WithReconnected sets a handler which is called with the new connection id when the Client has reconnected, see OnReconnected
+func WithReconnected(handler func(connectionID string)) func(*Client) error {
+	return func(c *Client) error {
+		if handler == nil {
+			return errors.New("reconnected handler is nil")
+		}
+		c.OnReconnected(handler)
+		return nil
+	}
+}
+
+// WithClosed sets a handler which is called when the Client is closed for good, see OnClosed
+func WithClosed(handler func(err error)) func(*Client) error {
+	return func(c *Client) error {
+		if handler == nil {
+			return errors.New("closed handler is nil")
+		}
+		c.OnClosed(handler)
+		return nil
+	}
+}
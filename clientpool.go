@@ -0,0 +1,341 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ClientPool is a pool of Clients connected to the same hub, e.g. for services which invoke a remote hub at high
+// rates. Invoke, Send and Stream use the pooled Clients round-robin, so each call uses an established connection.
+// When all Clients are busy, the pool dials another Client up to its maximum size. The health check replaces
+// Clients which were closed or failed the check of PoolHealthCheck, so the pool keeps at least its minimum size
+type ClientPool struct {
+	address             string
+	clientOptions       []func(*Client) error
+	minSize, maxSize    int
+	healthCheckInterval time.Duration
+	healthCheck         func(ctx context.Context, client *Client) error
+	mx                  sync.Mutex
+	clients             []*pooledClient
+	// dialing is the count of Clients dialed in the background
+	dialing int
+	next    uint64
+	closed  bool
+	done    chan struct{}
+}
+
+type pooledClient struct {
+	client *Client
+	// inFlight is the count of calls which use the client
+	inFlight int64
+}
+
+const (
+	defaultPoolMinSize             = 1
+	defaultPoolMaxSize             = 8
+	defaultPoolHealthCheckInterval = 10 * time.Second
+)
+
+var errClientPoolClosed = errors.New("ClientPool closed")
+
+// NewClientPool dials the minimum size of Clients to the hub at address, see Dial, and starts the health check
+func NewClientPool(address string, options ...func(*ClientPool) error) (*ClientPool, error) {
+	p := &ClientPool{
+		address:             address,
+		minSize:             defaultPoolMinSize,
+		maxSize:             defaultPoolMaxSize,
+		healthCheckInterval: defaultPoolHealthCheckInterval,
+		done:                make(chan struct{}),
+	}
+	for _, option := range options {
+		if option != nil {
+			if err := option(p); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := 0; i < p.minSize; i++ {
+		client, err := p.dial()
+		if err != nil {
+			_ = p.Close()
+			return nil, err
+		}
+		p.clients = append(p.clients, &pooledClient{client: client})
+	}
+	go p.healthLoop()
+	return p, nil
+}
+
+// PoolSize sets the minimum and maximum count of Clients in the pool. Default are 1 and 8
+func PoolSize(minSize, maxSize int) func(*ClientPool) error {
+	return func(p *ClientPool) error {
+		if minSize < 1 || maxSize < minSize {
+			return errors.New("PoolSize needs 1 <= minSize <= maxSize")
+		}
+		p.minSize, p.maxSize = minSize, maxSize
+		return nil
+	}
+}
+
+// PoolHealthCheck sets the interval of the health check and an optional check, e.g. the invocation of a hub method.
+// Clients for which check returns an error are closed and replaced. Default is an interval of 10 seconds without check
+func PoolHealthCheck(interval time.Duration, check func(ctx context.Context, client *Client) error) func(*ClientPool) error {
+	return func(p *ClientPool) error {
+		if interval <= 0 {
+			return errors.New("health check interval must be positive")
+		}
+		p.healthCheckInterval, p.healthCheck = interval, check
+		return nil
+	}
+}
+
+// PoolClientOptions sets the options the pooled Clients are dialed with
+func PoolClientOptions(options ...func(*Client) error) func(*ClientPool) error {
+	return func(p *ClientPool) error {
+		p.clientOptions = options
+		return nil
+	}
+}
+
+// Invoke invokes the method on one of the pooled Clients, see Client.Invoke
+func (p *ClientPool) Invoke(ctx context.Context, method string, result interface{}, arguments ...interface{}) error {
+	pc, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	defer pc.release()
+	return pc.client.Invoke(ctx, method, result, arguments...)
+}
+
+// Send invokes the method on one of the pooled Clients without waiting for a result, see Client.Send
+func (p *ClientPool) Send(method string, arguments ...interface{}) error {
+	pc, err := p.acquire()
+	if err != nil {
+		return err
+	}
+	defer pc.release()
+	return pc.client.Send(method, arguments...)
+}
+
+// Stream invokes the streaming method on one of the pooled Clients, see Client.Stream
+func (p *ClientPool) Stream(ctx context.Context, method string, arguments ...interface{}) <-chan StreamResult {
+	pc, err := p.acquire()
+	if err != nil {
+		results := make(chan StreamResult, 1)
+		results <- StreamResult{Err: err}
+		close(results)
+		return results
+	}
+	results := pc.client.Stream(ctx, method, arguments...)
+	forwarded := make(chan StreamResult)
+	go func() {
+		defer close(forwarded)
+		defer pc.release()
+		for result := range results {
+			forwarded <- result
+		}
+	}()
+	return forwarded
+}
+
+// Client returns the next pooled Client for calls not covered by the ClientPool.
+// The pool does not know when these calls end, so they do not count as busy
+func (p *ClientPool) Client() (*Client, error) {
+	pc, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+	pc.release()
+	return pc.client, nil
+}
+
+// Size returns the count of Clients in the pool
+func (p *ClientPool) Size() int {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return len(p.clients)
+}
+
+// Close closes all Clients of the pool and stops the health check
+func (p *ClientPool) Close() error {
+	p.mx.Lock()
+	if p.closed {
+		p.mx.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	clients := p.clients
+	p.clients = nil
+	p.mx.Unlock()
+	for _, pc := range clients {
+		_ = pc.client.Close()
+	}
+	return nil
+}
+
+func (pc *pooledClient) release() {
+	atomic.AddInt64(&pc.inFlight, -1)
+}
+
+func (pc *pooledClient) isClosed() bool {
+	select {
+	case <-pc.client.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// acquire returns the next open Client and counts the call as in flight.
+// If all Clients are busy, another Client is dialed in the background. If no Client is open, it dials one
+func (p *ClientPool) acquire() (*pooledClient, error) {
+	p.mx.Lock()
+	if p.closed {
+		p.mx.Unlock()
+		return nil, errClientPoolClosed
+	}
+	p.removeClosed()
+	var chosen *pooledClient
+	busy := true
+	for i := 0; i < len(p.clients); i++ {
+		pc := p.clients[(p.next+uint64(i))%uint64(len(p.clients))]
+		if chosen == nil {
+			chosen = pc
+		}
+		if atomic.LoadInt64(&pc.inFlight) == 0 {
+			chosen, busy = pc, false
+			break
+		}
+	}
+	p.next++
+	if chosen != nil {
+		atomic.AddInt64(&chosen.inFlight, 1)
+		if busy && len(p.clients)+p.dialing < p.maxSize {
+			p.dialing++
+			go p.grow()
+		}
+		p.mx.Unlock()
+		return chosen, nil
+	}
+	p.mx.Unlock()
+	client, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	pc := &pooledClient{client: client, inFlight: 1}
+	if !p.add(pc) {
+		return nil, errClientPoolClosed
+	}
+	return pc, nil
+}
+
+func (p *ClientPool) dial() (*Client, error) {
+	return Dial(p.address, p.clientOptions...)
+}
+
+// grow dials a Client in the background
+func (p *ClientPool) grow() {
+	client, err := p.dial()
+	p.mx.Lock()
+	p.dialing--
+	closed := p.closed
+	if err == nil && !closed {
+		p.clients = append(p.clients, &pooledClient{client: client})
+	}
+	p.mx.Unlock()
+	if err == nil && closed {
+		_ = client.Close()
+	}
+}
+
+// add adds pc to the pool. If the pool is closed, pc is closed
+func (p *ClientPool) add(pc *pooledClient) bool {
+	p.mx.Lock()
+	closed := p.closed
+	if !closed {
+		p.clients = append(p.clients, pc)
+	}
+	p.mx.Unlock()
+	if closed {
+		_ = pc.client.Close()
+	}
+	return !closed
+}
+
+// removeClosed removes the closed Clients. It must be called with p.mx locked
+func (p *ClientPool) removeClosed() {
+	open := p.clients[:0]
+	for _, pc := range p.clients {
+		if !pc.isClosed() {
+			open = append(open, pc)
+		}
+	}
+	for i := len(open); i < len(p.clients); i++ {
+		p.clients[i] = nil
+	}
+	p.clients = open
+}
+
+func (p *ClientPool) healthLoop() {
+	ticker := time.NewTicker(p.healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+			p.checkHealth()
+		}
+	}
+}
+
+// checkHealth removes the closed and unhealthy Clients and dials Clients up to the minimum size
+func (p *ClientPool) checkHealth() {
+	p.mx.Lock()
+	p.removeClosed()
+	clients := append([]*pooledClient(nil), p.clients...)
+	p.mx.Unlock()
+	unhealthy := make(map[*pooledClient]bool)
+	for _, pc := range clients {
+		if p.healthCheck == nil {
+			break
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), p.healthCheckInterval)
+		err := p.healthCheck(ctx, pc.client)
+		cancel()
+		if err != nil {
+			unhealthy[pc] = true
+		}
+	}
+	p.mx.Lock()
+	if p.closed {
+		p.mx.Unlock()
+		return
+	}
+	kept := make([]*pooledClient, 0, len(p.clients))
+	var removed []*pooledClient
+	for _, pc := range p.clients {
+		if unhealthy[pc] {
+			removed = append(removed, pc)
+		} else {
+			kept = append(kept, pc)
+		}
+	}
+	p.clients = kept
+	missing := p.minSize - len(kept) - p.dialing
+	p.mx.Unlock()
+	for _, pc := range removed {
+		_ = pc.client.Close()
+	}
+	for i := 0; i < missing; i++ {
+		client, err := p.dial()
+		if err != nil {
+			return
+		}
+		p.add(&pooledClient{client: client})
+	}
+}
@@ -0,0 +1,170 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+	"time"
+)
+
+var _ = Describe("ClientPool", func() {
+
+	newTestClientPool := func(address string, options ...func(*ClientPool) error) *ClientPool {
+		pool, err := NewClientPool(address, append([]func(*ClientPool) error{
+			PoolClientOptions(WithLogger(log.NewNopLogger(), false))}, options...)...)
+		Expect(err).To(BeNil())
+		return pool
+	}
+
+	Context("When the pool is created", func() {
+		It("should dial the minimum size of clients", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			pool := newTestClientPool(testServer.URL+"/hub", PoolSize(3, 5))
+			defer pool.Close()
+			Expect(pool.Size()).To(Equal(3))
+		})
+		It("should return the error of Dial", func() {
+			_, err := NewClientPool("http://127.0.0.1:1/hub", PoolClientOptions(WithLogger(log.NewNopLogger(), false)))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When idle clients are used", func() {
+		It("should use them round-robin", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			pool := newTestClientPool(testServer.URL+"/hub", PoolSize(2, 2))
+			defer pool.Close()
+			first, err := pool.Client()
+			Expect(err).To(BeNil())
+			second, err := pool.Client()
+			Expect(err).To(BeNil())
+			third, err := pool.Client()
+			Expect(err).To(BeNil())
+			Expect(second).NotTo(BeIdenticalTo(first))
+			Expect(third).To(BeIdenticalTo(first))
+		})
+	})
+
+	Context("When methods are invoked concurrently", func() {
+		It("should return all results", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			pool := newTestClientPool(testServer.URL+"/hub", PoolSize(2, 4))
+			defer pool.Close()
+			var wg sync.WaitGroup
+			for i := 0; i < 50; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer GinkgoRecover()
+					defer wg.Done()
+					var sum int
+					Expect(pool.Invoke(context.Background(), "add", &sum, i, 1)).To(BeNil())
+					Expect(sum).To(Equal(i + 1))
+				}(i)
+			}
+			wg.Wait()
+			Expect(pool.Size()).To(BeNumerically("<=", 4))
+		})
+		It("should stream the items", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			pool := newTestClientPool(testServer.URL + "/hub")
+			defer pool.Close()
+			count := 0
+			for result := range pool.Stream(context.Background(), "count", 3) {
+				Expect(result.Err).To(BeNil())
+				count++
+			}
+			Expect(count).To(Equal(3))
+		})
+	})
+
+	Context("When all clients are busy", func() {
+		It("should dial another client up to the maximum size", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			pool := newTestClientPool(testServer.URL+"/hub", PoolSize(1, 2))
+			defer pool.Close()
+			results := make(chan string, 3)
+			for i := 0; i < 3; i++ {
+				go func() {
+					var result string
+					_ = pool.Invoke(context.Background(), "wait", &result)
+					results <- result
+				}()
+				time.Sleep(50 * time.Millisecond)
+			}
+			Eventually(pool.Size).Should(Equal(2))
+			Consistently(pool.Size, 100*time.Millisecond).Should(Equal(2))
+			for i := 0; i < 3; i++ {
+				clientTestRelease <- struct{}{}
+				Eventually(results).Should(Receive(Equal("released")))
+			}
+		})
+	})
+
+	Context("When a pooled client is closed", func() {
+		It("should replace it", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			pool := newTestClientPool(testServer.URL+"/hub", PoolSize(1, 1), PoolHealthCheck(50*time.Millisecond, nil))
+			defer pool.Close()
+			client, err := pool.Client()
+			Expect(err).To(BeNil())
+			Expect(client.Close()).To(BeNil())
+			Eventually(func() bool {
+				replaced, err := pool.Client()
+				return err == nil && replaced != client
+			}).Should(BeTrue())
+			var sum int
+			Expect(pool.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+		})
+	})
+
+	Context("When a pooled client fails the health check", func() {
+		It("should close and replace it", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			var mx sync.Mutex
+			var unhealthy *Client
+			pool := newTestClientPool(testServer.URL+"/hub", PoolSize(1, 1),
+				PoolHealthCheck(50*time.Millisecond, func(ctx context.Context, client *Client) error {
+					mx.Lock()
+					defer mx.Unlock()
+					if client == unhealthy {
+						return errors.New("unhealthy")
+					}
+					return nil
+				}))
+			defer pool.Close()
+			client, err := pool.Client()
+			Expect(err).To(BeNil())
+			mx.Lock()
+			unhealthy = client
+			mx.Unlock()
+			Eventually(func() bool {
+				replaced, err := pool.Client()
+				return err == nil && replaced != client
+			}).Should(BeTrue())
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).NotTo(BeNil())
+		})
+	})
+
+	Context("When the pool is closed", func() {
+		It("should return an error", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			pool := newTestClientPool(testServer.URL + "/hub")
+			Expect(pool.Close()).To(BeNil())
+			Expect(pool.Send("add", 1, 2)).NotTo(BeNil())
+			Expect(pool.Size()).To(Equal(0))
+		})
+	})
+})
@@ -1,16 +1,34 @@
 package signalr
 
-//ClientProxy allows the hub to send messages to one or more of its clients
+// ClientProxy allows the hub to send messages to one or more of its clients.
+// Send returns a channel which receives nil when the message was sent to all clients, otherwise the first error
 type ClientProxy interface {
-	Send(target string, args ...interface{})
+	Send(target string, args ...interface{}) <-chan error
 }
 
 type allClientProxy struct {
 	lifetimeManager HubLifetimeManager
 }
 
-func (a *allClientProxy) Send(target string, args ...interface{}) {
-	a.lifetimeManager.InvokeAll(target, args)
+func (a *allClientProxy) Send(target string, args ...interface{}) <-chan error {
+	return a.lifetimeManager.InvokeAll(target, args)
+}
+
+type allExceptClientProxy struct {
+	excludedConnectionIDs []string
+	lifetimeManager       HubLifetimeManager
+}
+
+func (a *allExceptClientProxy) Send(target string, args ...interface{}) <-chan error {
+	return a.lifetimeManager.InvokeAllExcept(a.excludedConnectionIDs, target, args)
+}
+
+// SingleClientProxy is the ClientProxy of one client connection.
+// Abort closes the connection without allowing the client to reconnect, e.g. after the user was banned.
+// reason is sent to the client as error of the close message
+type SingleClientProxy interface {
+	ClientProxy
+	Abort(reason string) <-chan error
 }
 
 type singleClientProxy struct {
@@ -18,8 +36,38 @@ type singleClientProxy struct {
 	lifetimeManager HubLifetimeManager
 }
 
-func (a *singleClientProxy) Send(target string, args ...interface{}) {
-	a.lifetimeManager.InvokeClient(a.connectionID, target, args)
+func (a *singleClientProxy) Send(target string, args ...interface{}) <-chan error {
+	return a.lifetimeManager.InvokeClient(a.connectionID, target, args)
+}
+
+func (a *singleClientProxy) Abort(reason string) <-chan error {
+	return a.lifetimeManager.Abort(a.connectionID, reason)
+}
+
+type multipleClientProxy struct {
+	connectionIDs   []string
+	lifetimeManager HubLifetimeManager
+}
+
+func (m *multipleClientProxy) Send(target string, args ...interface{}) <-chan error {
+	results := make([]<-chan error, 0, len(m.connectionIDs))
+	for _, connectionID := range m.connectionIDs {
+		results = append(results, m.lifetimeManager.InvokeClient(connectionID, target, args))
+	}
+	return mergeSendResults(results)
+}
+
+type userClientProxy struct {
+	userIDs         []string
+	lifetimeManager HubLifetimeManager
+}
+
+func (u *userClientProxy) Send(target string, args ...interface{}) <-chan error {
+	results := make([]<-chan error, 0, len(u.userIDs))
+	for _, userID := range u.userIDs {
+		results = append(results, u.lifetimeManager.InvokeUser(userID, target, args))
+	}
+	return mergeSendResults(results)
 }
 
 type groupClientProxy struct {
@@ -27,6 +75,6 @@ type groupClientProxy struct {
 	lifetimeManager HubLifetimeManager
 }
 
-func (g *groupClientProxy) Send(target string, args ...interface{}) {
-	g.lifetimeManager.InvokeGroup(g.groupName, target, args)
+func (g *groupClientProxy) Send(target string, args ...interface{}) <-chan error {
+	return g.lifetimeManager.InvokeGroup(g.groupName, target, args)
 }
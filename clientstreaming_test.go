@@ -47,8 +47,7 @@ func (c *clientStreamHub) UploadPanic(upload <-chan string) {
 
 func (c *clientStreamHub) UploadInt(upload <-chan int) {
 	clientStreamingInvocationQueue <- "UploadInt()"
-	for {
-		<-upload
+	for range upload {
 	}
 }
 
@@ -103,6 +102,14 @@ func (c *clientStreamHub) UploadArray(u <-chan []int) {
 	clientStreamingInvocationQueue <- "UploadArray finished"
 }
 
+func (c *clientStreamHub) UploadSum(u <-chan int) int {
+	sum := 0
+	for i := range u {
+		sum += i
+	}
+	return sum
+}
+
 func (c *clientStreamHub) UploadError(u <-chan error) {
 	clientStreamingInvocationQueue <- "UploadError start"
 	for range u {
@@ -337,6 +344,28 @@ var _ = Describe("ClientStreaming", func() {
 		})
 	})
 
+	Describe("Stream client with result", func() {
+		Context("When a func returning a value is invoked by the client and the stream is completed", func() {
+			It("should return the value in the completion of the invocation", func() {
+				conn := connect(&clientStreamHub{})
+				conn.ClientSend(`{"type":1,"invocationId":"sum","target":"uploadsum","streamids":["s"]}`)
+				conn.ClientSend(`{"type":2,"invocationId":"s","item":1}`)
+				conn.ClientSend(`{"type":2,"invocationId":"s","item":2}`)
+				conn.ClientSend(`{"type":2,"invocationId":"s","item":3}`)
+				conn.ClientSend(`{"type":3,"invocationId":"s"}`)
+				select {
+				case message := <-conn.received:
+					Expect(message).To(BeAssignableToTypeOf(completionMessage{}))
+					Expect(message.(completionMessage).InvocationID).To(Equal("sum"))
+					Expect(message.(completionMessage).Result).To(Equal(6.0))
+					Expect(message.(completionMessage).Error).To(Equal(""))
+				case <-time.After(1000 * time.Millisecond):
+					Fail("timed out")
+				}
+			})
+		})
+	})
+
 	Describe("Client sending invalid streamitems", func() {
 		Context("When an invalid streamitem message with missing id and item is sent", func() {
 			It("should end the connection with an error", func() {
@@ -352,6 +381,8 @@ var _ = Describe("ClientStreaming", func() {
 				case <-time.After(1000 * time.Millisecond):
 					Fail("timed out")
 				}
+				// The connection is closed, so the hub method receives no more stream items
+				Expect(<-clientStreamingInvocationQueue).To(Equal("Finished"))
 			})
 		})
 		Context("When an invalid streamitem message with missing item is received", func() {
@@ -368,6 +399,8 @@ var _ = Describe("ClientStreaming", func() {
 				case <-time.After(1000 * time.Millisecond):
 					Fail("timed out")
 				}
+				// The connection is closed, so the hub method receives no more stream items
+				Expect(<-clientStreamingInvocationQueue).To(Equal("Finished"))
 			})
 		})
 		Context("When an invalid streamitem message with wrong itemtype is received", func() {
@@ -384,6 +417,8 @@ var _ = Describe("ClientStreaming", func() {
 				case <-time.After(1000 * time.Millisecond):
 					Fail("timed out")
 				}
+				// The connection is closed, so the hub method receives no more stream items
+				Expect(<-clientStreamingInvocationQueue).To(Equal("Finished"))
 			})
 		})
 		Context("When an invalid streamitem message with invalid invocation id is sent", func() {
@@ -400,6 +435,8 @@ var _ = Describe("ClientStreaming", func() {
 				case <-time.After(1000 * time.Millisecond):
 					Fail("timed out")
 				}
+				// The connection is closed, so the hub method receives no more stream items
+				Expect(<-clientStreamingInvocationQueue).To(Equal("Finished"))
 			})
 		})
 	})
@@ -419,6 +456,8 @@ var _ = Describe("ClientStreaming", func() {
 				case <-time.After(1000 * time.Millisecond):
 					Fail("timed out")
 				}
+				// The connection is closed, so the hub method receives no more stream items
+				Expect(<-clientStreamingInvocationQueue).To(Equal("Finished"))
 			})
 		})
 
@@ -436,6 +475,8 @@ var _ = Describe("ClientStreaming", func() {
 				case <-time.After(1000 * time.Millisecond):
 					Fail("timed out")
 				}
+				// The connection is closed, so the hub method receives no more stream items
+				Expect(<-clientStreamingInvocationQueue).To(Equal("Finished"))
 			})
 		})
 
@@ -461,6 +502,8 @@ var _ = Describe("ClientStreaming", func() {
 				case <-time.After(100 * time.Millisecond):
 					Fail("timed out")
 				}
+				// The connection is closed, so the hub method receives no more stream items
+				Expect(<-clientStreamingInvocationQueue).To(Equal("UploadArray finished"))
 			})
 		})
 
@@ -481,6 +524,8 @@ var _ = Describe("ClientStreaming", func() {
 				case <-time.After(100 * time.Millisecond):
 					Fail("timed out")
 				}
+				// The connection is closed, so the hub method receives no more stream items
+				Expect(<-clientStreamingInvocationQueue).To(Equal("Finished"))
 			})
 		})
 
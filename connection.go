@@ -1,6 +1,9 @@
 package signalr
 
-import "io"
+import (
+	"io"
+	"reflect"
+)
 
 // Connection describes a connection between signalR client and Server
 type Connection interface {
@@ -8,3 +11,66 @@ type Connection interface {
 	io.Writer
 	ConnectionID() string
 }
+
+// binaryConnection is implemented by connections which have to know if they transfer binary messages,
+// e.g. because text and binary messages use different frames.
+// setBinary returns an error if the connection can not transfer binary messages
+type binaryConnection interface {
+	setBinary() error
+}
+
+// FrameType is the type of the frames a connection sends its messages in
+type FrameType int
+
+// Frame types
+const (
+	TextFrame FrameType = iota
+	BinaryFrame
+)
+
+// frameTypeConnection is implemented by connections which send their messages in text or binary frames
+type frameTypeConnection interface {
+	setFrameType(frameType FrameType)
+}
+
+// unreadConnection is implemented by stream connections which might return the messages following the
+// handshake in the same Read as the handshake. unread returns them to the connection
+type unreadConnection interface {
+	unread(data []byte)
+}
+
+// transportCloser is implemented by the http transports, which are closed by their handlers
+type transportCloser interface {
+	close()
+}
+
+// closeTransport closes conn, if it can be closed, so a blocked Read returns
+func closeTransport(conn Connection) {
+	switch conn := conn.(type) {
+	case io.Closer:
+		_ = conn.Close()
+	case transportCloser:
+		conn.close()
+	}
+}
+
+// transportName returns the name of the transport of conn, as used by the negotiation
+func transportName(conn Connection) string {
+	switch conn := conn.(type) {
+	case *webSocketConnection, *webSocketStream:
+		return "WebSockets"
+	case *serverSSEConnection:
+		return "ServerSentEvents"
+	case *serverLongPollingConnection:
+		return "LongPolling"
+	case *netConnection:
+		return conn.conn.LocalAddr().Network()
+	case *statefulConnection:
+		conn.mx.Lock()
+		transport := conn.transport
+		conn.mx.Unlock()
+		return transportName(transport)
+	default:
+		return reflect.TypeOf(conn).String()
+	}
+}
@@ -0,0 +1,87 @@
+package signalr
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSOptions configures Cross-Origin Resource Sharing for the negotiate endpoint and the http transports.
+// AllowedOrigins are the origins of browser clients which may connect, e.g. "https://example.com". "*" allows all origins,
+// but can not be combined with AllowCredentials.
+// AllowCredentials allows browser clients to send cookies and the Authorization header.
+// AllowedHeaders are allowed in addition to the headers SignalR clients send.
+// MaxAge is the time browsers may cache the result of a preflight request. 0 means browser default
+type CORSOptions struct {
+	AllowedOrigins   []string
+	AllowCredentials bool
+	AllowedHeaders   []string
+	MaxAge           time.Duration
+}
+
+// corsHeaders are the headers sent by SignalR clients
+var corsHeaders = []string{"Authorization", "Content-Type", "X-Requested-With", "X-SignalR-User-Agent"}
+
+func (c *CORSOptions) allows(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *CORSOptions) allowsAll() bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// cors wraps handler with the CORS handling of the server. Preflight requests are answered without calling handler.
+// Browsers do not apply CORS to WebSockets, so WebSocket upgrades from origins which are not allowed are rejected
+func (s *Server) cors(handler http.HandlerFunc) http.HandlerFunc {
+	if s.corsOptions == nil {
+		return handler
+	}
+	options := s.corsOptions
+	return func(w http.ResponseWriter, req *http.Request) {
+		origin := req.Header.Get("Origin")
+		if origin == "" {
+			// Not a cross origin request of a browser
+			handler(w, req)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := options.allows(origin)
+		if allowed {
+			if options.allowsAll() && !options.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if options.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+		}
+		if req.Method == http.MethodOptions && req.Header.Get("Access-Control-Request-Method") != "" {
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(append(corsHeaders, options.AllowedHeaders...), ", "))
+				if options.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(options.MaxAge/time.Second)))
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if !allowed && strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler(w, req)
+	}
+}
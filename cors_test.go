@@ -0,0 +1,102 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+func newCORSTestServer(options CORSOptions) *httptest.Server {
+	server, err := NewServer(context.Background(), SimpleHubFactory(&authHub{}), Logger(log.NewNopLogger(), false),
+		UseCORS(options))
+	Expect(err).To(BeNil())
+	router := http.NewServeMux()
+	server.MapHTTP(router, "/hub")
+	return httptest.NewServer(router)
+}
+
+func corsRequest(method, url, origin string) *http.Response {
+	req, err := http.NewRequest(method, url, nil)
+	Expect(err).To(BeNil())
+	req.Header.Set("Origin", origin)
+	if method == http.MethodOptions {
+		req.Header.Set("Access-Control-Request-Method", "POST")
+		req.Header.Set("Access-Control-Request-Headers", "x-signalr-user-agent")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	Expect(err).To(BeNil())
+	_ = resp.Body.Close()
+	return resp
+}
+
+var _ = Describe("UseCORS option", func() {
+
+	Context("When a preflight request of an allowed origin is sent to negotiate", func() {
+		It("should allow the origin, the methods and the headers", func() {
+			testServer := newCORSTestServer(CORSOptions{
+				AllowedOrigins:   []string{"https://app.example.com"},
+				AllowCredentials: true,
+				AllowedHeaders:   []string{"X-Tenant"},
+				MaxAge:           10 * time.Minute,
+			})
+			defer testServer.Close()
+			resp := corsRequest(http.MethodOptions, testServer.URL+"/hub/negotiate", "https://app.example.com")
+			Expect(resp.StatusCode).To(Equal(http.StatusNoContent))
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("https://app.example.com"))
+			Expect(resp.Header.Get("Access-Control-Allow-Credentials")).To(Equal("true"))
+			Expect(resp.Header.Get("Access-Control-Allow-Methods")).To(ContainSubstring("POST"))
+			Expect(resp.Header.Get("Access-Control-Allow-Headers")).To(ContainSubstring("X-SignalR-User-Agent"))
+			Expect(resp.Header.Get("Access-Control-Allow-Headers")).To(ContainSubstring("X-Tenant"))
+			Expect(resp.Header.Get("Access-Control-Max-Age")).To(Equal("600"))
+			Expect(resp.Header.Get("Vary")).To(Equal("Origin"))
+		})
+	})
+
+	Context("When negotiate is called from an allowed origin", func() {
+		It("should negotiate and return the CORS headers", func() {
+			testServer := newCORSTestServer(CORSOptions{AllowedOrigins: []string{"*"}})
+			defer testServer.Close()
+			resp := corsRequest(http.MethodPost, testServer.URL+"/hub/negotiate", "https://other.example.com")
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal("*"))
+			Expect(resp.Header.Get("Access-Control-Allow-Credentials")).To(Equal(""))
+		})
+	})
+
+	Context("When a preflight request of an origin which is not allowed is sent", func() {
+		It("should not allow the origin", func() {
+			testServer := newCORSTestServer(CORSOptions{AllowedOrigins: []string{"https://app.example.com"}})
+			defer testServer.Close()
+			resp := corsRequest(http.MethodOptions, testServer.URL+"/hub/negotiate", "https://evil.example.com")
+			Expect(resp.Header.Get("Access-Control-Allow-Origin")).To(Equal(""))
+			Expect(resp.Header.Get("Access-Control-Allow-Methods")).To(Equal(""))
+		})
+	})
+
+	Context("When a WebSocket is opened from an origin", func() {
+		It("should accept allowed origins and reject the others", func() {
+			testServer := newCORSTestServer(CORSOptions{AllowedOrigins: []string{"https://app.example.com"}})
+			defer testServer.Close()
+			wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http") + "/hub"
+			ws, err := websocket.Dial(wsURL, "", "https://app.example.com")
+			Expect(err).To(BeNil())
+			_ = ws.Close()
+			_, err = websocket.Dial(wsURL, "", "https://evil.example.com")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When credentials are allowed for all origins", func() {
+		It("should return an error", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&authHub{}), Logger(log.NewNopLogger(), false),
+				UseCORS(CORSOptions{AllowedOrigins: []string{"*"}, AllowCredentials: true}))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
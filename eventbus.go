@@ -0,0 +1,101 @@
+package signalr
+
+import (
+	"sync"
+	"time"
+)
+
+// ServerEventType is the type of a ServerEvent
+type ServerEventType string
+
+// Types of ServerEvents
+const (
+	ConnectionStarted   ServerEventType = "ConnectionStarted"
+	ConnectionEnded     ServerEventType = "ConnectionEnded"
+	InvocationStarted   ServerEventType = "InvocationStarted"
+	InvocationCompleted ServerEventType = "InvocationCompleted"
+	MessageSent         ServerEventType = "MessageSent"
+)
+
+// ServerEvent describes something that happened on a connection of the server
+type ServerEvent struct {
+	Type         ServerEventType
+	Time         time.Time
+	ConnectionID string
+	// UserID is the user id of the connection, see UserIDFromContext
+	UserID string
+	// Method and InvocationID are set for InvocationStarted and InvocationCompleted events
+	Method       string
+	InvocationID string
+	// Duration is the time the hub method took, set for InvocationCompleted events
+	Duration time.Duration
+	// Err is the reason of ConnectionEnded events, or the error of the hub method for InvocationCompleted events
+	Err error
+}
+
+// eventBus passes ServerEvents to the subscribed handlers
+type eventBus struct {
+	mx       sync.RWMutex
+	handlers map[uint64]eventHandler
+	lastID   uint64
+}
+
+type eventHandler struct {
+	handler func(event ServerEvent)
+	types   map[ServerEventType]bool
+}
+
+// SubscribeEvents subscribes handler to the ServerEvents of the given types, or to all events if no types are given.
+// handler is called synchronously on the goroutine of the connection, so it must not block. It must be safe for
+// concurrent use, because the events of different connections are raised concurrently.
+// The returned func unsubscribes the handler
+func (s *Server) SubscribeEvents(handler func(event ServerEvent), types ...ServerEventType) (unsubscribe func()) {
+	return s.events.subscribe(handler, types)
+}
+
+func (b *eventBus) subscribe(handler func(event ServerEvent), types []ServerEventType) func() {
+	h := eventHandler{handler: handler}
+	if len(types) > 0 {
+		h.types = make(map[ServerEventType]bool)
+		for _, eventType := range types {
+			h.types[eventType] = true
+		}
+	}
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if b.handlers == nil {
+		b.handlers = make(map[uint64]eventHandler)
+	}
+	b.lastID++
+	id := b.lastID
+	b.handlers[id] = h
+	return func() {
+		b.mx.Lock()
+		defer b.mx.Unlock()
+		delete(b.handlers, id)
+	}
+}
+
+// active tells if any handler is subscribed, so events need not be built when nobody handles them
+func (b *eventBus) active() bool {
+	b.mx.RLock()
+	defer b.mx.RUnlock()
+	return len(b.handlers) > 0
+}
+
+func (b *eventBus) publish(event ServerEvent) {
+	b.mx.RLock()
+	handlers := make([]func(event ServerEvent), 0, len(b.handlers))
+	for _, h := range b.handlers {
+		if h.types == nil || h.types[event.Type] {
+			handlers = append(handlers, h.handler)
+		}
+	}
+	b.mx.RUnlock()
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,86 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+type eventBusHub struct {
+	Hub
+}
+
+func (e *eventBusHub) Echo(value string) string {
+	return value
+}
+
+func (e *eventBusHub) Fail() error {
+	return errors.New("failed")
+}
+
+var _ = Describe("SubscribeEvents", func() {
+
+	Context("When a client connects, invokes methods and disconnects", func() {
+		It("should publish the events of the connection", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&eventBusHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			events := make(chan ServerEvent, 20)
+			server.SubscribeEvents(func(event ServerEvent) { events <- event },
+				ConnectionStarted, ConnectionEnded, InvocationStarted, InvocationCompleted)
+			conn := newTestingConnection()
+			go server.Run(conn)
+			var event ServerEvent
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(ConnectionStarted))
+			Expect(event.ConnectionID).To(Equal(conn.ConnectionID()))
+			Expect(event.Time).NotTo(BeZero())
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"echo","arguments":["hi"]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal("hi"))
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(InvocationStarted))
+			Expect(event.Method).To(Equal("echo"))
+			Expect(event.InvocationID).To(Equal("1"))
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(InvocationCompleted))
+			Expect(event.Err).To(BeNil())
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"fail"}`)
+			Expect(receiveCompletion(conn).Error).To(Equal("failed"))
+			Eventually(events).Should(Receive(&event))
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(InvocationCompleted))
+			Expect(event.Err).To(MatchError("failed"))
+			conn.ClientSend(`{"type":7}`)
+			Eventually(events).Should(Receive(&event))
+			Expect(event.Type).To(Equal(ConnectionEnded))
+			Expect(event.ConnectionID).To(Equal(conn.ConnectionID()))
+		})
+	})
+
+	Context("When a handler is unsubscribed", func() {
+		It("should not receive events anymore", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&eventBusHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			sent := make(chan ServerEvent, 20)
+			unsubscribe := server.SubscribeEvents(func(event ServerEvent) { sent <- event }, MessageSent)
+			conn := newTestingConnection()
+			go server.Run(conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"echo","arguments":["hi"]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal("hi"))
+			var event ServerEvent
+			Eventually(sent).Should(Receive(&event))
+			Expect(event.Type).To(Equal(MessageSent))
+			unsubscribe()
+			// Drain the events of the handshake and the completion
+			time.Sleep(100 * time.Millisecond)
+			for len(sent) > 0 {
+				<-sent
+			}
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"echo","arguments":["hi"]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal("hi"))
+			Consistently(sent, 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+})
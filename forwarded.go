@@ -0,0 +1,69 @@
+package signalr
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// forwarded wraps handler so it gets the request as the client sent it to the reverse proxy, if the server trusts
+// the X-Forwarded-* headers: X-Forwarded-For sets the RemoteAddr, X-Forwarded-Host the Host and X-Forwarded-Proto
+// the scheme of the URL
+func (s *Server) forwarded(handler http.HandlerFunc) http.HandlerFunc {
+	if !s.trustForwardedHeaders {
+		return handler
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		handler(w, forwardedRequest(req))
+	}
+}
+
+func forwardedRequest(req *http.Request) *http.Request {
+	forwarded := req.WithContext(req.Context())
+	forwardedURL := *req.URL
+	forwarded.URL = &forwardedURL
+	if clientIP := firstForwardedValue(req.Header.Get("X-Forwarded-For")); clientIP != "" {
+		// The port of the client is not forwarded
+		forwarded.RemoteAddr = net.JoinHostPort(clientIP, "0")
+	}
+	if host := firstForwardedValue(req.Header.Get("X-Forwarded-Host")); host != "" {
+		forwarded.Host = host
+	}
+	if proto := firstForwardedValue(req.Header.Get("X-Forwarded-Proto")); proto != "" {
+		forwarded.URL.Scheme = strings.ToLower(proto)
+	}
+	return forwarded
+}
+
+// firstForwardedValue returns the value added by the first proxy of a comma separated header value
+func firstForwardedValue(value string) string {
+	if i := strings.IndexByte(value, ','); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(value)
+}
+
+// requestScheme returns the scheme the client used for req
+func requestScheme(req *http.Request) string {
+	if req.URL.Scheme != "" {
+		return req.URL.Scheme
+	}
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// redirectURL returns the PublicURL of the server if the client sent the negotiate request to another host
+func (s *Server) redirectURL(req *http.Request) (string, bool) {
+	if s.publicURL == nil {
+		return "", false
+	}
+	requestHost := (&url.URL{Scheme: requestScheme(req), Host: req.Host})
+	if strings.EqualFold(hostPort(requestHost, requestHost.Scheme == "https"),
+		hostPort(s.publicURL, s.publicURL.Scheme == "https")) {
+		return "", false
+	}
+	return s.publicURL.String(), true
+}
@@ -0,0 +1,49 @@
+package signalr
+
+import "bufio"
+
+// frameScanner collects the data received from a connection and splits it into the frames of a protocol.
+// The data of a partial frame is kept until the frame is complete, however the frame is split over the reads
+type frameScanner struct {
+	split bufio.SplitFunc
+	data  []byte
+	start int
+}
+
+func newFrameScanner(split bufio.SplitFunc) *frameScanner {
+	return &frameScanner{split: split}
+}
+
+// write appends p to the buffered data. Frames returned by next are invalid afterwards
+func (s *frameScanner) write(p []byte) {
+	if s.start > 0 && s.start >= len(s.data)/2 {
+		// Reuse the space of the frames already returned
+		s.data = s.data[:copy(s.data, s.data[s.start:])]
+		s.start = 0
+	}
+	s.data = append(s.data, p...)
+}
+
+// next returns the next complete frame and the number of bytes it took in the data, including its separator
+// or length prefix. If the buffered data does not contain a complete frame, ok is false
+func (s *frameScanner) next() (frame []byte, size int, ok bool, err error) {
+	if s.start == len(s.data) {
+		return nil, 0, false, nil
+	}
+	advance, frame, err := s.split(s.data[s.start:], false)
+	if err != nil || advance == 0 {
+		return nil, 0, false, err
+	}
+	s.start += advance
+	return frame, advance, true, nil
+}
+
+// buffered is the number of bytes of the partial frame
+func (s *frameScanner) buffered() int {
+	return len(s.data) - s.start
+}
+
+// reset drops the buffered data
+func (s *frameScanner) reset() {
+	s.data, s.start = s.data[:0], 0
+}
@@ -0,0 +1,120 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"testing/iotest"
+)
+
+// chunkReader returns the data in reads of size bytes
+type chunkReader struct {
+	data []byte
+	size int
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	if len(c.data) == 0 {
+		return 0, io.EOF
+	}
+	n := c.size
+	if n > len(p) {
+		n = len(p)
+	}
+	if n > len(c.data) {
+		n = len(c.data)
+	}
+	copy(p, c.data[:n])
+	c.data = c.data[n:]
+	return n, nil
+}
+
+func messagePackInvocations(count int, argument string) []byte {
+	protocol := newMessagePackProtocol()
+	var data bytes.Buffer
+	for i := 0; i < count; i++ {
+		Expect(protocol.WriteMessage(invocationMessage{Type: 1, InvocationID: fmt.Sprint(i), Target: "target",
+			Arguments: []interface{}{argument}}, &data)).To(BeNil())
+	}
+	return data.Bytes()
+}
+
+var _ = Describe("frameScanner", func() {
+
+	Context("When frames are split across writes", func() {
+		It("should return them when they are complete", func() {
+			scanner := newFrameScanner(newJSONTestProtocol().splitFrame)
+			scanner.write([]byte(`{"a":`))
+			_, _, ok, err := scanner.next()
+			Expect(ok).To(BeFalse())
+			Expect(err).To(BeNil())
+			scanner.write([]byte("1}\x1e{\"b\""))
+			frame, size, ok, _ := scanner.next()
+			Expect(ok).To(BeTrue())
+			Expect(string(frame)).To(Equal(`{"a":1}`))
+			Expect(size).To(Equal(8))
+			_, _, ok, _ = scanner.next()
+			Expect(ok).To(BeFalse())
+			Expect(scanner.buffered()).To(Equal(4))
+			scanner.write([]byte(":2}\x1e"))
+			frame, _, ok, _ = scanner.next()
+			Expect(ok).To(BeTrue())
+			Expect(string(frame)).To(Equal(`{"b":2}`))
+			Expect(scanner.buffered()).To(Equal(0))
+		})
+	})
+
+	Context("When the length prefix is invalid", func() {
+		It("should return an error", func() {
+			scanner := newFrameScanner(newMessagePackProtocol().splitFrame)
+			scanner.write([]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+			_, _, _, err := scanner.next()
+			Expect(err).To(Equal(errInvalidLengthPrefix))
+		})
+	})
+
+	Context("When a hubConnection receives MessagePack messages split at any read boundary", func() {
+		for _, size := range []int{1, 3, 7, 100, readBufferSize} {
+			size := size
+			It(fmt.Sprintf("should parse all of them with reads of %v bytes", size), func() {
+				// Messages larger than the read buffer span many reads
+				data := messagePackInvocations(5, string(bytes.Repeat([]byte("x"), readBufferSize+10)))
+				conn := &readerConnection{discardConnection: discardConnection{"c"}, reader: &chunkReader{data: data, size: size}}
+				hubConn := newHubConnection(context.Background(), conn, newMessagePackProtocol(), 0, log.NewNopLogger(), log.NewNopLogger())
+				for i := 0; i < 5; i++ {
+					message, err := hubConn.Receive()
+					Expect(err).To(BeNil())
+					invocation := message.(invocationMessage)
+					Expect(invocation.InvocationID).To(Equal(fmt.Sprint(i)))
+					var argument string
+					Expect(newMessagePackProtocol().UnmarshalArgument(invocation.Arguments[0], &argument)).To(BeNil())
+					Expect(argument).To(HaveLen(readBufferSize + 10))
+				}
+			})
+		}
+		It("should parse many messages received in one read", func() {
+			data := messagePackInvocations(5, "x")
+			conn := &readerConnection{discardConnection: discardConnection{"c"}, reader: bytes.NewReader(data)}
+			hubConn := newHubConnection(context.Background(), conn, newMessagePackProtocol(), 0, log.NewNopLogger(), log.NewNopLogger())
+			for i := 0; i < 5; i++ {
+				message, err := hubConn.Receive()
+				Expect(err).To(BeNil())
+				Expect(message.(invocationMessage).InvocationID).To(Equal(fmt.Sprint(i)))
+			}
+		})
+	})
+
+	Context("When a message exceeds the maximum size", func() {
+		It("should fail before the message is complete", func() {
+			data := messagePackInvocations(1, string(bytes.Repeat([]byte("x"), 1000)))
+			conn := &readerConnection{discardConnection: discardConnection{"c"}, reader: iotest.OneByteReader(bytes.NewReader(data))}
+			hubConn := newHubConnection(context.Background(), conn, newMessagePackProtocol(), 100, log.NewNopLogger(), log.NewNopLogger())
+			_, err := hubConn.Receive()
+			Expect(err).To(MatchError(ContainSubstring("MaximumReceiveMessageSize")))
+		})
+	})
+})
@@ -0,0 +1,19 @@
+//go:build gofuzz
+// +build gofuzz
+
+package signalr
+
+// Fuzz is the entry point for go-fuzz. It parses data as frame of both hub protocols, with and without strict mode
+func Fuzz(data []byte) int {
+	result := 0
+	for _, strict := range []bool{false, true} {
+		for _, protocol := range []HubProtocol{&JSONHubProtocol{}, &MessagePackHubProtocol{}} {
+			protocol.setDebugLogger(&discardLogger{})
+			protocol.setStrict(strict)
+			if _, err := protocol.parseFrame(data); err == nil {
+				result = 1
+			}
+		}
+	}
+	return result
+}
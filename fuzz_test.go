@@ -0,0 +1,53 @@
+//go:build go1.18
+// +build go1.18
+
+package signalr
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzReadMessage reads all messages in data with protocol, with and without strict mode. The seeds are the frames
+// of the seedMessages
+func fuzzReadMessage(f *testing.F, newProtocol func() HubProtocol) {
+	seeds, err := writeSeedMessages(newProtocol())
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	// Several messages in one buffer
+	f.Add(bytes.Join(seeds, nil))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, strict := range []bool{false, true} {
+			protocol := newProtocol()
+			protocol.setStrict(strict)
+			buf := bytes.NewBuffer(append([]byte(nil), data...))
+			for buf.Len() > 0 {
+				length := buf.Len()
+				_, complete, err := protocol.ReadMessage(buf)
+				if err != nil || !complete || buf.Len() == length {
+					break
+				}
+			}
+		}
+	})
+}
+
+func FuzzReadMessageJSON(f *testing.F) {
+	fuzzReadMessage(f, func() HubProtocol {
+		protocol := &JSONHubProtocol{}
+		protocol.setDebugLogger(&discardLogger{})
+		return protocol
+	})
+}
+
+func FuzzReadMessageMessagePack(f *testing.F) {
+	fuzzReadMessage(f, func() HubProtocol {
+		protocol := &MessagePackHubProtocol{}
+		protocol.setDebugLogger(&discardLogger{})
+		return protocol
+	})
+}
@@ -1,19 +1,92 @@
 package signalr
 
+import (
+	"sync"
+	"time"
+)
+
 // GroupManager manages the client groups of the hub
+// AddToGroup() adds a connection to a group until it is removed or disconnected
+// AddToGroupFor() adds a connection to a group for the duration ttl. Adding it again renews the membership
+// RemoveFromGroup() removes a connection from a group
 type GroupManager interface {
 	AddToGroup(groupName string, connectionID string)
+	AddToGroupFor(groupName string, connectionID string, ttl time.Duration)
 	RemoveFromGroup(groupName string, connectionID string)
 }
 
 type defaultGroupManager struct {
 	lifetimeManager HubLifetimeManager
+	mx              sync.Mutex
+	// expirations holds the timers of the memberships with ttl by connection id and group name
+	expirations map[string]map[string]*time.Timer
 }
 
 func (d *defaultGroupManager) AddToGroup(groupName string, connectionID string) {
+	d.mx.Lock()
+	d.stopExpiration(groupName, connectionID)
+	d.mx.Unlock()
+	d.lifetimeManager.AddToGroup(groupName, connectionID)
+}
+
+func (d *defaultGroupManager) AddToGroupFor(groupName string, connectionID string, ttl time.Duration) {
+	d.mx.Lock()
+	d.stopExpiration(groupName, connectionID)
+	if d.expirations == nil {
+		d.expirations = make(map[string]map[string]*time.Timer)
+	}
+	timers, ok := d.expirations[connectionID]
+	if !ok {
+		timers = make(map[string]*time.Timer)
+		d.expirations[connectionID] = timers
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(ttl, func() {
+		d.mx.Lock()
+		// The membership might have been renewed or removed in the meantime
+		expired := d.expirations[connectionID][groupName] == timer
+		if expired {
+			d.stopExpiration(groupName, connectionID)
+		}
+		d.mx.Unlock()
+		if expired {
+			d.lifetimeManager.RemoveFromGroup(groupName, connectionID)
+		}
+	})
+	timers[groupName] = timer
+	d.mx.Unlock()
 	d.lifetimeManager.AddToGroup(groupName, connectionID)
 }
 
 func (d *defaultGroupManager) RemoveFromGroup(groupName string, connectionID string) {
+	d.mx.Lock()
+	d.stopExpiration(groupName, connectionID)
+	d.mx.Unlock()
 	d.lifetimeManager.RemoveFromGroup(groupName, connectionID)
 }
+
+// onDisconnected stops the expirations of the memberships of the connection.
+// The lifetime manager already removed the connection from all its groups
+func (d *defaultGroupManager) onDisconnected(connectionID string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	for _, timer := range d.expirations[connectionID] {
+		timer.Stop()
+	}
+	delete(d.expirations, connectionID)
+}
+
+// stopExpiration must be called with d.mx locked
+func (d *defaultGroupManager) stopExpiration(groupName string, connectionID string) {
+	timers, ok := d.expirations[connectionID]
+	if !ok {
+		return
+	}
+	if timer, ok := timers[groupName]; ok {
+		timer.Stop()
+		delete(timers, groupName)
+		if len(timers) == 0 {
+			delete(d.expirations, connectionID)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package signalr
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// GroupStore stores the group memberships of the connections of a hub.
+// The default is an in-memory store of each server. A store shared by all servers of a backplane, e.g. the
+// RedisGroupStore or the SQLGroupStore, lets each server find the members of a group on all servers,
+// also after the server restarted. A server only invokes the members which are connected to itself,
+// the backplane passes the invocation to the other servers.
+// Memberships are removed when their connection ends.
+// Stores which implement ConnectionGroups(connectionID string) ([]string, error) let the AdminHandler list the groups
+// of the connections
+type GroupStore interface {
+	// AddToGroup adds the connection to the group. Adding it again is no error
+	AddToGroup(groupName string, connectionID string) error
+	// RemoveFromGroup removes the connection from the group
+	RemoveFromGroup(groupName string, connectionID string) error
+	// GroupMembers returns the ids of the connections in the group
+	GroupMembers(groupName string) ([]string, error)
+	// RemoveConnection removes the connection from all groups
+	RemoveConnection(connectionID string) error
+}
+
+// memoryGroupStore is the GroupStore of servers without UseGroupStore option
+type memoryGroupStore struct {
+	mx sync.RWMutex
+	// groups holds the connection ids by group name, connections the group names by connection id
+	groups      map[string]map[string]struct{}
+	connections map[string]map[string]struct{}
+}
+
+func newMemoryGroupStore() *memoryGroupStore {
+	return &memoryGroupStore{
+		groups:      make(map[string]map[string]struct{}),
+		connections: make(map[string]map[string]struct{}),
+	}
+}
+
+func (m *memoryGroupStore) AddToGroup(groupName string, connectionID string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	addToSet(m.groups, groupName, connectionID)
+	addToSet(m.connections, connectionID, groupName)
+	return nil
+}
+
+func (m *memoryGroupStore) RemoveFromGroup(groupName string, connectionID string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	removeFromSet(m.groups, groupName, connectionID)
+	removeFromSet(m.connections, connectionID, groupName)
+	return nil
+}
+
+func (m *memoryGroupStore) GroupMembers(groupName string) ([]string, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	members := make([]string, 0, len(m.groups[groupName]))
+	for connectionID := range m.groups[groupName] {
+		members = append(members, connectionID)
+	}
+	return members, nil
+}
+
+func (m *memoryGroupStore) RemoveConnection(connectionID string) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	for groupName := range m.connections[connectionID] {
+		removeFromSet(m.groups, groupName, connectionID)
+	}
+	delete(m.connections, connectionID)
+	return nil
+}
+
+// ConnectionGroups returns the names of the groups of the connection
+func (m *memoryGroupStore) ConnectionGroups(connectionID string) ([]string, error) {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	groupNames := make([]string, 0, len(m.connections[connectionID]))
+	for groupName := range m.connections[connectionID] {
+		groupNames = append(groupNames, groupName)
+	}
+	return groupNames, nil
+}
+
+// groupSizes returns the count of the members by group name
+func (m *memoryGroupStore) groupSizes() map[string]int {
+	m.mx.RLock()
+	defer m.mx.RUnlock()
+	sizes := make(map[string]int, len(m.groups))
+	for groupName, members := range m.groups {
+		sizes[groupName] = len(members)
+	}
+	return sizes
+}
+
+func addToSet(sets map[string]map[string]struct{}, key string, value string) {
+	set, ok := sets[key]
+	if !ok {
+		set = make(map[string]struct{})
+		sets[key] = set
+	}
+	set[value] = struct{}{}
+}
+
+func removeFromSet(sets map[string]map[string]struct{}, key string, value string) {
+	if set, ok := sets[key]; ok {
+		delete(set, value)
+		if len(set) == 0 {
+			delete(sets, key)
+		}
+	}
+}
+
+// RedisGroupStore is a GroupStore using Redis sets. The members of each group and the groups of each connection
+// are stored in a set with the key prefix followed by "group:" and the group name, or "connection:" and the connection id
+type RedisGroupStore struct {
+	address string
+	prefix  string
+	// ioTimeout limits dialing and each request to the Redis server
+	ioTimeout time.Duration
+	mx        sync.Mutex
+	// conn is nil until the first request and after a broken request
+	conn   net.Conn
+	reader *bufio.Reader
+	closed bool
+}
+
+var errRedisGroupStoreClosed = errors.New("RedisGroupStore closed")
+
+// NewRedisGroupStore creates a RedisGroupStore which connects to the Redis server at address (host:port)
+// and stores the sets with the key prefix
+func NewRedisGroupStore(address string, prefix string) (*RedisGroupStore, error) {
+	r := &RedisGroupStore{address: address, prefix: prefix, ioTimeout: redisIOTimeout}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// AddToGroup adds the connection to the set of the group and the group to the set of the connection
+func (r *RedisGroupStore) AddToGroup(groupName string, connectionID string) error {
+	_, err := r.do(
+		[][]byte{[]byte("SADD"), r.groupKey(groupName), []byte(connectionID)},
+		[][]byte{[]byte("SADD"), r.connectionKey(connectionID), []byte(groupName)})
+	return err
+}
+
+// RemoveFromGroup removes the connection from the set of the group and the group from the set of the connection
+func (r *RedisGroupStore) RemoveFromGroup(groupName string, connectionID string) error {
+	_, err := r.do(
+		[][]byte{[]byte("SREM"), r.groupKey(groupName), []byte(connectionID)},
+		[][]byte{[]byte("SREM"), r.connectionKey(connectionID), []byte(groupName)})
+	return err
+}
+
+// GroupMembers returns the members of the set of the group
+func (r *RedisGroupStore) GroupMembers(groupName string) ([]string, error) {
+	replies, err := r.do([][]byte{[]byte("SMEMBERS"), r.groupKey(groupName)})
+	if err != nil {
+		return nil, err
+	}
+	return redisStrings(replies[0])
+}
+
+// ConnectionGroups returns the members of the set of the connection
+func (r *RedisGroupStore) ConnectionGroups(connectionID string) ([]string, error) {
+	replies, err := r.do([][]byte{[]byte("SMEMBERS"), r.connectionKey(connectionID)})
+	if err != nil {
+		return nil, err
+	}
+	return redisStrings(replies[0])
+}
+
+// RemoveConnection removes the connection from the sets of its groups and deletes the set of the connection
+func (r *RedisGroupStore) RemoveConnection(connectionID string) error {
+	groupNames, err := r.ConnectionGroups(connectionID)
+	if err != nil {
+		return err
+	}
+	commands := make([][][]byte, 0, len(groupNames)+1)
+	for _, groupName := range groupNames {
+		commands = append(commands, [][]byte{[]byte("SREM"), r.groupKey(groupName), []byte(connectionID)})
+	}
+	commands = append(commands, [][]byte{[]byte("DEL"), r.connectionKey(connectionID)})
+	_, err = r.do(commands...)
+	return err
+}
+
+// Close closes the connection to the Redis server
+func (r *RedisGroupStore) Close() error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.closed = true
+	if r.conn != nil {
+		return r.conn.Close()
+	}
+	return nil
+}
+
+func (r *RedisGroupStore) groupKey(groupName string) []byte {
+	return []byte(r.prefix + "group:" + groupName)
+}
+
+func (r *RedisGroupStore) connectionKey(connectionID string) []byte {
+	return []byte(r.prefix + "connection:" + connectionID)
+}
+
+// connect must be called with r.mx locked
+func (r *RedisGroupStore) connect() error {
+	conn, err := net.DialTimeout("tcp", r.address, r.ioTimeout)
+	if err != nil {
+		return err
+	}
+	r.conn, r.reader = conn, bufio.NewReader(conn)
+	return nil
+}
+
+// do sends the commands in one pipeline and returns their replies. If a command failed, it returns its error
+func (r *RedisGroupStore) do(commands ...[][]byte) ([]interface{}, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.closed {
+		return nil, errRedisGroupStoreClosed
+	}
+	if r.conn == nil {
+		if err := r.connect(); err != nil {
+			return nil, err
+		}
+	}
+	replies := make([]interface{}, len(commands))
+	err := r.conn.SetDeadline(time.Now().Add(r.ioTimeout))
+	for i := 0; err == nil && i < len(commands); i++ {
+		err = writeRESPCommand(r.conn, commands[i]...)
+	}
+	var commandErr error
+	for i := 0; err == nil && i < len(commands); i++ {
+		replies[i], err = readRESP(r.reader)
+		var redisErr redisError
+		if errors.As(err, &redisErr) {
+			// Read the replies of the other commands
+			if commandErr == nil {
+				commandErr = err
+			}
+			err = nil
+		}
+	}
+	if err != nil {
+		// The connection is broken or timed out, connect again on the next request
+		_ = r.conn.Close()
+		r.conn, r.reader = nil, nil
+		return nil, err
+	}
+	return replies, commandErr
+}
+
+func redisStrings(reply interface{}) ([]string, error) {
+	array, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid Redis reply %v", reply)
+	}
+	values := make([]string, 0, len(array))
+	for _, value := range array {
+		data, ok := value.([]byte)
+		if !ok {
+			return nil, fmt.Errorf("invalid Redis reply %v", reply)
+		}
+		values = append(values, string(data))
+	}
+	return values, nil
+}
+
+// SQLPlaceholders is the style of the query parameters of a database driver
+type SQLPlaceholders int
+
+// Styles of query parameters
+const (
+	// SQLQuestionMarks are the ? parameters of e.g. MySQL and SQLite
+	SQLQuestionMarks SQLPlaceholders = iota
+	// SQLDollarNumbers are the $1, $2... parameters of PostgreSQL
+	SQLDollarNumbers
+)
+
+// SQLGroupStore is a GroupStore using a table of a SQL database. The table needs the text columns
+// group_name and connection_id, e.g.
+//
+//	CREATE TABLE signalr_groups (group_name VARCHAR(255) NOT NULL, connection_id VARCHAR(255) NOT NULL,
+//		PRIMARY KEY (group_name, connection_id))
+type SQLGroupStore struct {
+	db *sql.DB
+	// The statements for the table
+	deleteMembership string
+	insertMembership string
+	selectMembers    string
+	selectGroups     string
+	deleteConnection string
+}
+
+var sqlTableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// NewSQLGroupStore creates a SQLGroupStore which stores the memberships in the table of db.
+// placeholders is the style of the query parameters of the driver of db
+func NewSQLGroupStore(db *sql.DB, table string, placeholders SQLPlaceholders) (*SQLGroupStore, error) {
+	if db == nil {
+		return nil, errors.New("SQLGroupStore needs a db")
+	}
+	if !sqlTableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid SQL table name %q", table)
+	}
+	p := func(i int) string {
+		if placeholders == SQLDollarNumbers {
+			return fmt.Sprintf("$%d", i)
+		}
+		return "?"
+	}
+	return &SQLGroupStore{
+		db:               db,
+		deleteMembership: fmt.Sprintf("DELETE FROM %s WHERE group_name = %s AND connection_id = %s", table, p(1), p(2)),
+		insertMembership: fmt.Sprintf("INSERT INTO %s (group_name, connection_id) VALUES (%s, %s)", table, p(1), p(2)),
+		selectMembers:    fmt.Sprintf("SELECT DISTINCT connection_id FROM %s WHERE group_name = %s", table, p(1)),
+		selectGroups:     fmt.Sprintf("SELECT DISTINCT group_name FROM %s WHERE connection_id = %s", table, p(1)),
+		deleteConnection: fmt.Sprintf("DELETE FROM %s WHERE connection_id = %s", table, p(1)),
+	}, nil
+}
+
+// AddToGroup replaces the row of the membership in a transaction
+func (s *SQLGroupStore) AddToGroup(groupName string, connectionID string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec(s.deleteMembership, groupName, connectionID); err == nil {
+		_, err = tx.Exec(s.insertMembership, groupName, connectionID)
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// RemoveFromGroup deletes the row of the membership
+func (s *SQLGroupStore) RemoveFromGroup(groupName string, connectionID string) error {
+	_, err := s.db.Exec(s.deleteMembership, groupName, connectionID)
+	return err
+}
+
+// GroupMembers selects the connection ids of the rows of the group
+func (s *SQLGroupStore) GroupMembers(groupName string) ([]string, error) {
+	return s.selectStrings(s.selectMembers, groupName)
+}
+
+// ConnectionGroups selects the group names of the rows of the connection
+func (s *SQLGroupStore) ConnectionGroups(connectionID string) ([]string, error) {
+	return s.selectStrings(s.selectGroups, connectionID)
+}
+
+func (s *SQLGroupStore) selectStrings(query string, arg string) ([]string, error) {
+	rows, err := s.db.Query(query, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	values := make([]string, 0)
+	for rows.Next() {
+		var value string
+		if err = rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// RemoveConnection deletes the rows of the connection
+func (s *SQLGroupStore) RemoveConnection(connectionID string) error {
+	_, err := s.db.Exec(s.deleteConnection, connectionID)
+	return err
+}
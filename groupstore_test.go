@@ -0,0 +1,288 @@
+package signalr
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// fakeSQLTable is the table of the fakeSQLDriver. It executes the statements of the SQLGroupStore
+type fakeSQLTable struct {
+	mx      sync.Mutex
+	rows    [][2]string
+	queries []string
+}
+
+type fakeSQLDriver struct {
+	mx     sync.Mutex
+	tables map[string]*fakeSQLTable
+}
+
+var fakeSQL = &fakeSQLDriver{tables: make(map[string]*fakeSQLTable)}
+
+func init() {
+	sql.Register("signalrfake", fakeSQL)
+}
+
+// openFakeSQL opens a database with an empty table
+func openFakeSQL(name string) (*sql.DB, *fakeSQLTable) {
+	table := &fakeSQLTable{}
+	fakeSQL.mx.Lock()
+	fakeSQL.tables[name] = table
+	fakeSQL.mx.Unlock()
+	db, err := sql.Open("signalrfake", name)
+	Expect(err).To(BeNil())
+	return db, table
+}
+
+func (f *fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return &fakeSQLConn{table: f.tables[name]}, nil
+}
+
+type fakeSQLConn struct {
+	table *fakeSQLTable
+}
+
+func (c *fakeSQLConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeSQLStmt{table: c.table, query: query}, nil
+}
+
+func (c *fakeSQLConn) Close() error {
+	return nil
+}
+
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	return c, nil
+}
+
+func (c *fakeSQLConn) Commit() error {
+	return nil
+}
+
+func (c *fakeSQLConn) Rollback() error {
+	return nil
+}
+
+type fakeSQLStmt struct {
+	table *fakeSQLTable
+	query string
+}
+
+func (s *fakeSQLStmt) Close() error {
+	return nil
+}
+
+func (s *fakeSQLStmt) NumInput() int {
+	return -1
+}
+
+func (s *fakeSQLStmt) Exec(args []driver.Value) (driver.Result, error) {
+	t := s.table
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.queries = append(t.queries, s.query)
+	switch {
+	case strings.HasPrefix(s.query, "INSERT"):
+		t.rows = append(t.rows, [2]string{args[0].(string), args[1].(string)})
+	case strings.Contains(s.query, "group_name ="):
+		t.delete(func(row [2]string) bool { return row[0] == args[0].(string) && row[1] == args[1].(string) })
+	case strings.HasPrefix(s.query, "DELETE"):
+		t.delete(func(row [2]string) bool { return row[1] == args[0].(string) })
+	default:
+		return nil, fmt.Errorf("unexpected statement %v", s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeSQLStmt) Query(args []driver.Value) (driver.Rows, error) {
+	t := s.table
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.queries = append(t.queries, s.query)
+	rows := &fakeSQLRows{}
+	for _, row := range t.rows {
+		switch {
+		case strings.Contains(s.query, "WHERE connection_id") && row[1] == args[0].(string):
+			rows.values = append(rows.values, row[0])
+		case strings.Contains(s.query, "WHERE group_name") && row[0] == args[0].(string):
+			rows.values = append(rows.values, row[1])
+		}
+	}
+	return rows, nil
+}
+
+// delete must be called with t.mx locked
+func (t *fakeSQLTable) delete(match func(row [2]string) bool) {
+	rows := t.rows[:0]
+	for _, row := range t.rows {
+		if !match(row) {
+			rows = append(rows, row)
+		}
+	}
+	t.rows = rows
+}
+
+func (t *fakeSQLTable) executed() []string {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	return append([]string{}, t.queries...)
+}
+
+type fakeSQLRows struct {
+	values []string
+}
+
+func (r *fakeSQLRows) Columns() []string {
+	return []string{"connection_id"}
+}
+
+func (r *fakeSQLRows) Close() error {
+	return nil
+}
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	dest[0], r.values = r.values[0], r.values[1:]
+	return nil
+}
+
+func expectGroupMembers(store GroupStore, groupName string, connectionIDs ...string) {
+	members, err := store.GroupMembers(groupName)
+	Expect(err).To(BeNil())
+	sort.Strings(members)
+	Expect(members).To(Equal(append([]string{}, connectionIDs...)))
+}
+
+// testGroupStore checks the behavior all GroupStores share
+func testGroupStore(store GroupStore) {
+	Expect(store.AddToGroup("room", "1")).To(BeNil())
+	Expect(store.AddToGroup("room", "1")).To(BeNil())
+	Expect(store.AddToGroup("room", "2")).To(BeNil())
+	Expect(store.AddToGroup("other", "1")).To(BeNil())
+	expectGroupMembers(store, "room", "1", "2")
+	expectGroupMembers(store, "other", "1")
+	Expect(store.RemoveFromGroup("room", "2")).To(BeNil())
+	expectGroupMembers(store, "room", "1")
+	groupNames, err := store.(connectionGroupLister).ConnectionGroups("1")
+	Expect(err).To(BeNil())
+	sort.Strings(groupNames)
+	Expect(groupNames).To(Equal([]string{"other", "room"}))
+	Expect(store.RemoveConnection("1")).To(BeNil())
+	expectGroupMembers(store, "room")
+	expectGroupMembers(store, "other")
+}
+
+var _ = Describe("GroupStore", func() {
+
+	Context("When memberships are changed in the memory store", func() {
+		It("should return the members of the groups", func() {
+			testGroupStore(newMemoryGroupStore())
+		})
+	})
+
+	Context("When memberships are changed in the RedisGroupStore", func() {
+		It("should return the members of the groups", func() {
+			redis := newFakeRedis()
+			defer redis.Close()
+			store, err := NewRedisGroupStore(redis.listener.Addr().String(), "chat:")
+			Expect(err).To(BeNil())
+			defer store.Close()
+			testGroupStore(store)
+		})
+		It("should use keys with the prefix", func() {
+			redis := newFakeRedis()
+			defer redis.Close()
+			store, err := NewRedisGroupStore(redis.listener.Addr().String(), "chat:")
+			Expect(err).To(BeNil())
+			defer store.Close()
+			Expect(store.AddToGroup("room", "1")).To(BeNil())
+			redis.mx.Lock()
+			defer redis.mx.Unlock()
+			Expect(redis.sets).To(HaveKey("chat:group:room"))
+			Expect(redis.sets).To(HaveKey("chat:connection:1"))
+		})
+	})
+
+	Context("When the RedisGroupStore is closed", func() {
+		It("should return an error", func() {
+			redis := newFakeRedis()
+			defer redis.Close()
+			store, err := NewRedisGroupStore(redis.listener.Addr().String(), "")
+			Expect(err).To(BeNil())
+			Expect(store.Close()).To(BeNil())
+			Expect(store.AddToGroup("room", "1")).NotTo(BeNil())
+		})
+	})
+
+	Context("When memberships are changed in the SQLGroupStore", func() {
+		It("should return the members of the groups", func() {
+			db, _ := openFakeSQL("members")
+			defer db.Close()
+			store, err := NewSQLGroupStore(db, "signalr_groups", SQLQuestionMarks)
+			Expect(err).To(BeNil())
+			testGroupStore(store)
+		})
+		It("should use the placeholders of the driver", func() {
+			db, table := openFakeSQL("placeholders")
+			defer db.Close()
+			store, err := NewSQLGroupStore(db, "public.signalr_groups", SQLDollarNumbers)
+			Expect(err).To(BeNil())
+			Expect(store.AddToGroup("room", "1")).To(BeNil())
+			Expect(table.executed()).To(Equal([]string{
+				"DELETE FROM public.signalr_groups WHERE group_name = $1 AND connection_id = $2",
+				"INSERT INTO public.signalr_groups (group_name, connection_id) VALUES ($1, $2)",
+			}))
+		})
+	})
+
+	Context("When the SQL table name is invalid", func() {
+		It("should return an error", func() {
+			db, _ := openFakeSQL("invalid")
+			defer db.Close()
+			_, err := NewSQLGroupStore(db, "groups; DROP TABLE users", SQLQuestionMarks)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When a server uses a GroupStore", func() {
+		It("should store the memberships of its connections until they end", func() {
+			store := newMemoryGroupStore()
+			server, err := NewServer(context.Background(), SimpleHubFactory(&chatHub{}), UseGroupStore(store),
+				Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := runAddHubConnection(server)
+			server.Groups().AddToGroup("room", conn.ConnectionID())
+			expectGroupMembers(store, "room", conn.ConnectionID())
+			server.HubClients().Group("room").Send("clientFunc")
+			expectClientFunc(conn)
+			conn.ClientSend(`{"type":7}`)
+			Eventually(func() int {
+				members, _ := store.GroupMembers("room")
+				return len(members)
+			}).Should(Equal(0))
+		})
+		It("should only invoke the members connected to itself", func() {
+			store := newMemoryGroupStore()
+			Expect(store.AddToGroup("room", "elsewhere")).To(BeNil())
+			server, err := NewServer(context.Background(), SimpleHubFactory(&chatHub{}), UseGroupStore(store),
+				Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := runAddHubConnection(server)
+			server.Groups().AddToGroup("room", conn.ConnectionID())
+			Expect(<-server.HubClients().Group("room").Send("clientFunc")).To(BeNil())
+			expectClientFunc(conn)
+		})
+	})
+})
@@ -0,0 +1,41 @@
+package signalr
+
+import (
+	"sync"
+	"time"
+)
+
+// startHeartbeats calls the OnHeartbeat handler of the server every KeepAliveInterval until the context of the
+// loop is done. The first call is one interval after the start. A handler which takes longer than the interval
+// delays the next call, calls are never made in parallel
+func (sl *serverLoop) startHeartbeats() *sync.WaitGroup {
+	var waitgroup sync.WaitGroup
+	handler := sl.server.heartbeat
+	if handler == nil {
+		return &waitgroup
+	}
+	connection := ConnectionContext{Context: sl.ctx, ConnectionID: sl.hubConn.GetConnectionID()}
+	waitgroup.Add(1)
+	go func() {
+		defer waitgroup.Done()
+		ticker := time.NewTicker(sl.server.keepAliveInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-sl.ctx.Done():
+				return
+			}
+			// A connection ending at the same time as the tick gets no more heartbeats
+			select {
+			case <-sl.ctx.Done():
+				return
+			default:
+			}
+			sl.recoverHubPanic("OnHeartbeat", func() {
+				handler(connection)
+			})
+		}
+	}()
+	return &waitgroup
+}
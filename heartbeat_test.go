@@ -0,0 +1,116 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+	"time"
+)
+
+type heartbeatHub struct {
+	Hub
+}
+
+var heartbeatHubDisconnected = make(chan string, 10)
+
+func (h *heartbeatHub) Add(a, b int) int {
+	return a + b
+}
+
+func (h *heartbeatHub) OnDisconnected(connectionID string, _ error) {
+	heartbeatHubDisconnected <- connectionID
+}
+
+// heartbeatRecorder records the heartbeats and the disconnects in their order
+type heartbeatRecorder struct {
+	mx     sync.Mutex
+	events []string
+}
+
+func (r *heartbeatRecorder) record(event string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *heartbeatRecorder) recorded() []string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+func connectHeartbeatHub(handler func(connection ConnectionContext)) *testingConnection {
+	server, err := NewServer(context.Background(), SimpleHubFactory(&heartbeatHub{}), Logger(log.NewNopLogger(), false),
+		KeepAliveInterval(50*time.Millisecond), OnHeartbeat(handler))
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	go server.Run(conn)
+	return conn
+}
+
+var _ = Describe("OnHeartbeat", func() {
+
+	Context("When a connection is connected", func() {
+		It("should call the handler every KeepAliveInterval, also when the server sends other messages", func() {
+			recorder := &heartbeatRecorder{}
+			heartbeats := make(chan ConnectionContext, 100)
+			conn := connectHeartbeatHub(func(connection ConnectionContext) {
+				recorder.record("heartbeat")
+				heartbeats <- connection
+			})
+			// Keep the connection busy, so no pings are needed
+			for i := 0; i < 15; i++ {
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"add","arguments":[1,2]}`)
+				Expect(receiveCompletion(conn).Result).To(Equal(3.0))
+				time.Sleep(10 * time.Millisecond)
+			}
+			Expect(len(recorder.recorded())).To(BeNumerically(">=", 2))
+			var connection ConnectionContext
+			Expect(heartbeats).To(Receive(&connection))
+			Expect(connection.ConnectionID).To(Equal(conn.ConnectionID()))
+			Expect(connection.Context).NotTo(BeNil())
+		})
+	})
+
+	Context("When a connection ends", func() {
+		It("should not call the handler after OnDisconnected", func() {
+			recorder := &heartbeatRecorder{}
+			conn := connectHeartbeatHub(func(connection ConnectionContext) {
+				recorder.record("heartbeat")
+				// A slow handler, which is still running when the connection ends
+				time.Sleep(30 * time.Millisecond)
+			})
+			Eventually(recorder.recorded).ShouldNot(BeEmpty())
+			conn.ClientSend(`{"type":7}`)
+			Eventually(heartbeatHubDisconnected).Should(Receive(Equal(conn.ConnectionID())))
+			recorder.record("disconnected")
+			Consistently(func() string {
+				events := recorder.recorded()
+				return events[len(events)-1]
+			}, 200*time.Millisecond).Should(Equal("disconnected"))
+		})
+	})
+
+	Context("When the handler panics", func() {
+		It("should keep the connection and call the handler again", func() {
+			heartbeats := make(chan struct{}, 100)
+			conn := connectHeartbeatHub(func(connection ConnectionContext) {
+				heartbeats <- struct{}{}
+				panic("heartbeat failed")
+			})
+			Eventually(heartbeats).Should(Receive())
+			Eventually(heartbeats).Should(Receive())
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"add","arguments":[1,2]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal(3.0))
+		})
+	})
+
+	Context("When the handler is nil", func() {
+		It("should not create the server", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&heartbeatHub{}), OnHeartbeat(nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,524 @@
+package signalr
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/websocket"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MapHub used to register a SignalR Hub with the specified ServeMux
+func MapHub(mux *http.ServeMux, path string, hubProto HubInterface) *Server {
+	server, _ := NewServer(context.Background(), SimpleHubFactory(hubProto))
+	server.MapHTTP(mux, path)
+	return server
+}
+
+// MapHTTP registers the negotiate endpoint and the transports of the server with the specified ServeMux.
+// The path is served with and without trailing slash. Behind a reverse proxy which forwards a path prefix,
+// map the path with the prefix or strip it with http.StripPrefix
+func (s *Server) MapHTTP(mux *http.ServeMux, path string) {
+	httpMux := newHTTPMux(s)
+	negotiate := s.forwarded(s.cors(httpMux.negotiate))
+	handle := s.forwarded(s.cors(httpMux.handle))
+	path = strings.TrimRight(path, "/")
+	if path != "" && !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	mux.HandleFunc(fmt.Sprintf("%s/negotiate", path), negotiate)
+	if path != "" {
+		mux.HandleFunc(path, handle)
+	}
+	mux.HandleFunc(path+"/", func(w http.ResponseWriter, req *http.Request) {
+		switch strings.TrimPrefix(req.URL.Path, path) {
+		case "/":
+			handle(w, req)
+		case "/negotiate/":
+			negotiate(w, req)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+}
+
+// Handler returns a http.Handler for the negotiate endpoint and the transports of the server, to mount the hub
+// on routers other than http.ServeMux. Requests with a path ending with /negotiate are negotiate requests,
+// all other requests are transport requests. The router has to pass requests with any method for the path of
+// the hub and its subpath /negotiate to the same Handler, e.g. for a hub at /chat
+//
+//	chi:   r.Handle("/chat", h); r.Handle("/chat/negotiate", h)
+//	gin:   r.Any("/chat", gin.WrapH(h)); r.Any("/chat/negotiate", gin.WrapH(h))
+//	echo:  e.Any("/chat", echo.WrapHandler(h)); e.Any("/chat/negotiate", echo.WrapHandler(h))
+//	fiber: app.All("/chat", adaptor.HTTPHandler(h)); app.All("/chat/negotiate", adaptor.HTTPHandler(h))
+//
+// fasthttp based routers like fiber can not hijack connections, so their clients can not use WebSockets
+func (s *Server) Handler() http.Handler {
+	httpMux := newHTTPMux(s)
+	negotiate := s.forwarded(s.cors(httpMux.negotiate))
+	handle := s.forwarded(s.cors(httpMux.handle))
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if path := strings.TrimRight(req.URL.Path, "/"); path == "negotiate" || strings.HasSuffix(path, "/negotiate") {
+			negotiate(w, req)
+		} else {
+			handle(w, req)
+		}
+	})
+}
+
+type httpMux struct {
+	mx            sync.RWMutex
+	connectionMap map[string]Connection
+	// tokens maps the connection tokens of negotiate version 1 to their connection ids, ids the other way round
+	tokens map[string]string
+	ids    map[string]string
+	// stateful contains the connection tokens negotiated with stateful reconnect
+	stateful map[string]struct{}
+	server   *Server
+	// negotiateTimeout is the time after which a negotiated connection id without transport expires
+	negotiateTimeout time.Duration
+}
+
+// defaultNegotiateTimeout is the time a client has to connect after it negotiated its connection id
+const defaultNegotiateTimeout = 30 * time.Second
+
+func newHTTPMux(server *Server) *httpMux {
+	return &httpMux{
+		connectionMap:    make(map[string]Connection),
+		tokens:           make(map[string]string),
+		ids:              make(map[string]string),
+		stateful:         make(map[string]struct{}),
+		server:           server,
+		negotiateTimeout: defaultNegotiateTimeout,
+	}
+}
+
+func (h *httpMux) handle(w http.ResponseWriter, req *http.Request) {
+	req, ok := h.server.authenticateRequest(w, req)
+	if !ok {
+		return
+	}
+	switch req.Method {
+	case "POST":
+		h.handlePost(w, req)
+	case "GET":
+		h.handleGet(w, req)
+	case "DELETE":
+		h.handleDelete(w, req)
+	case "CONNECT":
+		h.handleConnect(w, req)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *httpMux) handleGet(w http.ResponseWriter, req *http.Request) {
+	if h.server.isShuttingDown() {
+		// No new transports
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if h.server.Draining() && req.URL.Query().Get("id") == "" {
+		// WebSockets without negotiate would be new connections
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	switch {
+	case strings.EqualFold(req.Header.Get("Upgrade"), "websocket"):
+		if h.server.webSocketCompression != nil && offersPermessageDeflate(req.Header) {
+			// golang.org/x/net/websocket does not support extensions
+			if wsConn, err := acceptWebSocket(w, req, h.server.webSocketCompression,
+				int64(h.server.maximumReceiveMessageSize)); err == nil {
+				h.runWebSocketStream(req, wsConn)
+			}
+			return
+		}
+		options := h.server.webSocketOptions()
+		if options.readTimeout > 0 {
+			w = &readDeadlineResponseWriter{ResponseWriter: w, readTimeout: options.readTimeout}
+		}
+		websocket.Handler(func(ws *websocket.Conn) {
+			h.runWebSocket(req, func(connectionID string) Connection {
+				return newWebSocketConnection(ws, connectionID, options)
+			})
+		}).ServeHTTP(w, req)
+	case strings.Contains(req.Header.Get("Accept"), "text/event-stream"):
+		h.handleServerSentEvent(w, req)
+	default:
+		h.handleLongPolling(w, req)
+	}
+}
+
+// handleConnect handles WebSocket requests over HTTP/2. WebTransport over HTTP/3 is not supported,
+// it needs a QUIC implementation which the standard library does not have
+func (h *httpMux) handleConnect(w http.ResponseWriter, req *http.Request) {
+	if !isHTTP2WebSocket(req) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if h.server.isShuttingDown() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if wsConn, err := acceptHTTP2WebSocket(w, req, h.server.webSocketCompression,
+		int64(h.server.maximumReceiveMessageSize)); err == nil {
+		h.runWebSocketStream(req, wsConn)
+	}
+}
+
+// runWebSocketStream runs the server on a webSocketStream
+func (h *httpMux) runWebSocketStream(req *http.Request, wsConn *webSocketStream) {
+	defer func() { _ = wsConn.Close() }()
+	h.runWebSocket(req, func(connectionID string) Connection {
+		wsConn.connectionID = connectionID
+		return wsConn
+	})
+}
+
+// runWebSocket runs the server on the WebSocket connection created by newConn
+func (h *httpMux) runWebSocket(req *http.Request, newConn func(connectionID string) Connection) {
+	key := req.URL.Query().Get("id")
+	if len(key) == 0 {
+		// Support websocket connection without negotiate
+		var err error
+		if key, err = h.reserveConnectionID(); err != nil {
+			info, _ := h.server.prefixLogger()
+			_ = info.Log(evt, "reserveConnectionID", "error", err, react, "do not connect")
+			return
+		}
+	}
+	wsConn := newConn(h.connectionIDOf(key))
+	if stateful, ok := h.statefulConnection(key); ok {
+		// The client resumes after its transport failed. Keep the transport open until it is replaced
+		<-stateful.resume(wsConn)
+		return
+	}
+	conn := wsConn
+	if h.isStateful(key) {
+		info, _ := h.server.prefixLogger()
+		conn = newStatefulConnection(wsConn.ConnectionID(), wsConn, h.server.statefulReconnectTimeout, nil, info)
+	}
+	if !h.claimNegotiated(key, conn) {
+		// Unknown id or the id is already used by another transport
+		return
+	}
+	defer h.removeConnection(key)
+	h.server.run(h.server.connectionContext(req), conn)
+	if stateful, ok := conn.(*statefulConnection); ok {
+		_ = stateful.Close()
+	}
+}
+
+func (h *httpMux) handleServerSentEvent(w http.ResponseWriter, req *http.Request) {
+	key := req.URL.Query().Get("id")
+	sseConn, err := newServerSSEConnection(w, h.connectionIDOf(key))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !h.claimNegotiated(key, sseConn) {
+		// SSE requires a prior negotiate, because the client sends over POST with this id
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	defer h.removeConnection(key)
+	sseConn.open()
+	go func() {
+		// Stop the server loop when the client goes away
+		<-req.Context().Done()
+		sseConn.close()
+	}()
+	h.server.run(h.server.connectionContext(req), sseConn)
+	sseConn.close()
+}
+
+func (h *httpMux) handleLongPolling(w http.ResponseWriter, req *http.Request) {
+	key := req.URL.Query().Get("id")
+	h.mx.Lock()
+	conn, ok := h.connectionMap[key]
+	if !ok {
+		h.mx.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if conn == nil {
+		// First poll: start the connection and tell the client that it is connected
+		connectionID := key
+		if id, ok := h.tokens[key]; ok {
+			connectionID = id
+		}
+		lpConn := newServerLongPollingConnection(connectionID, h.server.longPolling, func() { h.removeConnection(key) })
+		h.connectionMap[key] = lpConn
+		h.mx.Unlock()
+		ctx := h.server.connectionContext(req)
+		go func() {
+			h.server.run(ctx, lpConn)
+			lpConn.close()
+		}()
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	h.mx.Unlock()
+	if lpConn, ok := conn.(*serverLongPollingConnection); ok {
+		lpConn.poll(w, req)
+	} else {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func (h *httpMux) handleDelete(w http.ResponseWriter, req *http.Request) {
+	connectionID := req.URL.Query().Get("id")
+	h.mx.RLock()
+	conn, ok := h.connectionMap[connectionID]
+	h.mx.RUnlock()
+	if lpConn, isLP := conn.(*serverLongPollingConnection); ok && isLP {
+		lpConn.release()
+		w.WriteHeader(http.StatusAccepted)
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (h *httpMux) handlePost(w http.ResponseWriter, req *http.Request) {
+	connectionID := req.URL.Query().Get("id")
+	h.mx.RLock()
+	conn, ok := h.connectionMap[connectionID]
+	h.mx.RUnlock()
+	if !ok || conn == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if c, ok := conn.(postConsumer); ok {
+		if err := c.consume(req.Body); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	} else {
+		// The transport of this connection does not receive over POST
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+// reserveConnectionID generates a new connection id which is not used by any other connection
+// and reserves it for a transport
+func (h *httpMux) reserveConnectionID() (string, error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	connectionID, err := h.unusedID(h.server.connectionIDGenerator, "")
+	if err != nil {
+		return "", err
+	}
+	h.connectionMap[connectionID] = nil
+	return connectionID, nil
+}
+
+// maxConnectionIDAttempts limits the ids generated for a connection, so a ConnectionIDGenerator which only returns
+// ids in use does not block all negotiations
+const maxConnectionIDAttempts = 10
+
+// unusedID generates ids until it gets one which is unused and not equal to other. h.mx must be locked
+func (h *httpMux) unusedID(generate func() string, other string) (string, error) {
+	for i := 0; i < maxConnectionIDAttempts; i++ {
+		if id := generate(); h.isUnused(id) && id != other {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("no unused connection id after %v attempts", maxConnectionIDAttempts)
+}
+
+// reserveConnectionToken generates a new connection id and a secret connection token for negotiate version 1.
+// The token is used by the transports to address the connection, the id is visible to other clients
+func (h *httpMux) reserveConnectionToken() (connectionID string, connectionToken string, err error) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if connectionID, err = h.unusedID(h.server.connectionIDGenerator, ""); err != nil {
+		return "", "", err
+	}
+	// The token must not be guessable, so it is always crypto random
+	if connectionToken, err = h.unusedID(getConnectionID, connectionID); err != nil {
+		return "", "", err
+	}
+	h.connectionMap[connectionToken] = nil
+	h.tokens[connectionToken] = connectionID
+	h.ids[connectionID] = connectionToken
+	return connectionID, connectionToken, nil
+}
+
+// isUnused tells if id is neither used as connection id nor as connection token. h.mx must be locked
+func (h *httpMux) isUnused(id string) bool {
+	_, isKey := h.connectionMap[id]
+	_, isID := h.ids[id]
+	return !isKey && !isID
+}
+
+// connectionIDOf returns the connection id for the key used by the transports, which is either the connection id or
+// the connection token
+func (h *httpMux) connectionIDOf(key string) string {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	if connectionID, ok := h.tokens[key]; ok {
+		return connectionID
+	}
+	return key
+}
+
+// isStateful tells if the connection with key was negotiated with stateful reconnect
+func (h *httpMux) isStateful(key string) bool {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	_, ok := h.stateful[key]
+	return ok
+}
+
+// statefulConnection returns the running stateful connection with key
+func (h *httpMux) statefulConnection(key string) (*statefulConnection, bool) {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	stateful, ok := h.connectionMap[key].(*statefulConnection)
+	return stateful, ok
+}
+
+// claimNegotiated assigns conn to connectionID if connectionID was negotiated and still has no transport
+func (h *httpMux) claimNegotiated(connectionID string, conn Connection) bool {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	if claimed, ok := h.connectionMap[connectionID]; !ok || claimed != nil {
+		return false
+	}
+	h.connectionMap[connectionID] = conn
+	return true
+}
+
+func (h *httpMux) removeConnection(key string) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.removeKey(key)
+}
+
+// removeKey removes the connection with key and its token. h.mx must be locked
+func (h *httpMux) removeKey(key string) {
+	delete(h.connectionMap, key)
+	delete(h.stateful, key)
+	if connectionID, ok := h.tokens[key]; ok {
+		delete(h.tokens, key)
+		delete(h.ids, connectionID)
+	}
+}
+
+func (h *httpMux) negotiate(w http.ResponseWriter, req *http.Request) {
+	if req.Method != "POST" {
+		w.WriteHeader(400)
+	} else if h.server.isShuttingDown() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else if h.server.Draining() {
+		h.negotiateDraining(w)
+	} else if redirectURL, ok := h.server.redirectURL(req); ok {
+		// The client has to negotiate again with the public url
+		_ = json.NewEncoder(w).Encode(negotiateResponse{URL: redirectURL})
+	} else if _, ok := h.server.authenticateRequest(w, req); ok {
+		// Reserve the id for the transport which is connected later
+		var connectionID, connectionToken string
+		var err error
+		negotiateVersion, _ := strconv.Atoi(req.URL.Query().Get("negotiateVersion"))
+		if negotiateVersion >= 1 {
+			negotiateVersion = 1
+			connectionID, connectionToken, err = h.reserveConnectionToken()
+		} else {
+			negotiateVersion = 0
+			connectionID, err = h.reserveConnectionID()
+		}
+		if err != nil {
+			info, _ := h.server.prefixLogger()
+			_ = info.Log(evt, "negotiate", "error", err, react, "send 500")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		key := connectionID
+		if connectionToken != "" {
+			key = connectionToken
+		}
+		// Stateful reconnect needs the secret token to resume the connection
+		useStatefulReconnect := connectionToken != "" && h.server.statefulReconnectTimeout > 0 &&
+			req.URL.Query().Get("useStatefulReconnect") == "true"
+		if useStatefulReconnect {
+			h.mx.Lock()
+			h.stateful[key] = struct{}{}
+			h.mx.Unlock()
+		}
+		time.AfterFunc(h.negotiateTimeout, func() {
+			// The client did not connect
+			h.mx.Lock()
+			defer h.mx.Unlock()
+			if conn, ok := h.connectionMap[key]; ok && conn == nil {
+				h.removeKey(key)
+			}
+		})
+		response := negotiateResponse{
+			NegotiateVersion:     negotiateVersion,
+			ConnectionID:         connectionID,
+			ConnectionToken:      connectionToken,
+			UseStatefulReconnect: useStatefulReconnect,
+			AvailableTransports:  h.availableTransports(),
+		}
+		_ = json.NewEncoder(w).Encode(response) // Can't imagine an error when encoding
+	}
+}
+
+// negotiateDraining redirects the client to the DrainRedirectURL or tells it that the server is unavailable
+func (h *httpMux) negotiateDraining(w http.ResponseWriter) {
+	if h.server.drainRedirectURL != "" {
+		_ = json.NewEncoder(w).Encode(negotiateResponse{URL: h.server.drainRedirectURL})
+		return
+	}
+	w.WriteHeader(http.StatusServiceUnavailable)
+	_ = json.NewEncoder(w).Encode(negotiateResponse{Error: errServerDraining.Error()})
+}
+
+// availableTransports returns the transports with the transfer formats of the server protocols
+func (h *httpMux) availableTransports() []availableTransport {
+	formats := h.server.transferFormats()
+	transports := []availableTransport{{Transport: "WebSockets", TransferFormats: formats}}
+	if formats[0] == "Text" {
+		// SSE can not transfer binary data
+		transports = append(transports, availableTransport{Transport: "ServerSentEvents", TransferFormats: []string{"Text"}})
+	}
+	return append(transports, availableTransport{Transport: "LongPolling", TransferFormats: formats})
+}
+
+// postConsumer is implemented by connections which receive client messages over http POST
+type postConsumer interface {
+	consume(body io.Reader) error
+}
+
+func getConnectionID() string {
+	bytes := make([]byte, 16)
+	// rand.Read only fails when the systems random number generator fails. Rare case, ignore
+	_, _ = rand.Read(bytes)
+	return base64.StdEncoding.EncodeToString(bytes)
+}
+
+type availableTransport struct {
+	Transport       string   `json:"transport"`
+	TransferFormats []string `json:"transferFormats"`
+}
+
+type negotiateResponse struct {
+	NegotiateVersion     int                  `json:"negotiateVersion"`
+	ConnectionID         string               `json:"connectionId"`
+	ConnectionToken      string               `json:"connectionToken,omitempty"`
+	UseStatefulReconnect bool                 `json:"useStatefulReconnect,omitempty"`
+	AvailableTransports  []availableTransport `json:"availableTransports,omitempty"`
+	// URL redirects the client to another url of the hub, AccessToken is the bearer token for it
+	URL         string `json:"url,omitempty"`
+	AccessToken string `json:"accessToken,omitempty"`
+	// Error tells the client why the negotiation failed
+	Error string `json:"error,omitempty"`
+}
@@ -0,0 +1,248 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+)
+
+func negotiateHTTPMux(h *httpMux) string {
+	recorder := httptest.NewRecorder()
+	h.negotiate(recorder, httptest.NewRequest("POST", "/hub/negotiate", nil))
+	response := negotiateResponse{}
+	Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(BeNil())
+	return response.ConnectionID
+}
+
+func negotiateV1HTTPMux(h *httpMux) negotiateResponse {
+	recorder := httptest.NewRecorder()
+	h.negotiate(recorder, httptest.NewRequest("POST", "/hub/negotiate?negotiateVersion=1", nil))
+	response := negotiateResponse{}
+	Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(BeNil())
+	return response
+}
+
+func httpMuxConnectionCount(h *httpMux) int {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	return len(h.connectionMap)
+}
+
+var _ = Describe("httpMux", func() {
+
+	Context("When a negotiated connection id is not used", func() {
+		It("should expire", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			h := newHTTPMux(server)
+			h.negotiateTimeout = 50 * time.Millisecond
+			negotiateHTTPMux(h)
+			Expect(httpMuxConnectionCount(h)).To(Equal(1))
+			Eventually(func() int { return httpMuxConnectionCount(h) }, time.Second).Should(Equal(0))
+		})
+	})
+
+	Context("When a negotiated connection id is claimed twice", func() {
+		It("should only be assigned to the first transport", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			h := newHTTPMux(server)
+			connectionID := negotiateHTTPMux(h)
+			Expect(h.claimNegotiated(connectionID, &discardConnection{connectionID})).To(BeTrue())
+			Expect(h.claimNegotiated(connectionID, &discardConnection{connectionID})).To(BeFalse())
+			Expect(h.claimNegotiated("unknown", &discardConnection{"unknown"})).To(BeFalse())
+		})
+	})
+
+	Context("When a websocket connection ends", func() {
+		It("should remove its connection id", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			h := newHTTPMux(server)
+			testServer := httptest.NewServer(http.HandlerFunc(h.handle))
+			defer testServer.Close()
+			connectionID := negotiateHTTPMux(h)
+			wsURL := fmt.Sprintf("ws%v?id=%v", testServer.URL[len("http"):], url.QueryEscape(connectionID))
+			ws, err := websocket.Dial(wsURL, "", testServer.URL)
+			Expect(err).To(BeNil())
+			_, err = ws.Write(append([]byte(`{"protocol": "json","version": 1}`), 30))
+			Expect(err).To(BeNil())
+			Eventually(func() bool {
+				h.mx.RLock()
+				defer h.mx.RUnlock()
+				return h.connectionMap[connectionID] != nil
+			}, time.Second).Should(BeTrue())
+			Expect(ws.Close()).To(BeNil())
+			Eventually(func() int { return httpMuxConnectionCount(h) }, time.Second).Should(Equal(0))
+		})
+	})
+
+	Context("When a ConnectionIDGenerator is set", func() {
+		It("should use it and skip ids which are already in use", func() {
+			ids := []string{"node1-1", "node1-1", "node1-2"}
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false),
+				ConnectionIDGenerator(func() string {
+					id := ids[0]
+					ids = ids[1:]
+					return id
+				}))
+			Expect(err).To(BeNil())
+			h := newHTTPMux(server)
+			Expect(negotiateHTTPMux(h)).To(Equal("node1-1"))
+			Expect(negotiateHTTPMux(h)).To(Equal("node1-2"))
+		})
+		It("should fail the negotiate if it only returns ids which are in use", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false),
+				ConnectionIDGenerator(func() string { return "node1-1" }))
+			Expect(err).To(BeNil())
+			h := newHTTPMux(server)
+			Expect(negotiateHTTPMux(h)).To(Equal("node1-1"))
+			for _, target := range []string{"/hub/negotiate", "/hub/negotiate?negotiateVersion=1"} {
+				recorder := httptest.NewRecorder()
+				h.negotiate(recorder, httptest.NewRequest("POST", target, nil))
+				Expect(recorder.Code).To(Equal(http.StatusInternalServerError))
+			}
+			Expect(httpMuxConnectionCount(h)).To(Equal(1))
+		})
+	})
+
+	Context("When negotiateVersion 1 is requested", func() {
+		It("should return a connection token which addresses the connection", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			h := newHTTPMux(server)
+			response := negotiateV1HTTPMux(h)
+			Expect(response.NegotiateVersion).To(Equal(1))
+			Expect(response.ConnectionToken).NotTo(BeEmpty())
+			Expect(response.ConnectionToken).NotTo(Equal(response.ConnectionID))
+			Expect(h.connectionIDOf(response.ConnectionToken)).To(Equal(response.ConnectionID))
+			// The public id must not address the connection
+			Expect(h.claimNegotiated(response.ConnectionID, &discardConnection{response.ConnectionID})).To(BeFalse())
+			Expect(h.claimNegotiated(response.ConnectionToken, &discardConnection{response.ConnectionID})).To(BeTrue())
+			h.removeConnection(response.ConnectionToken)
+			Expect(httpMuxConnectionCount(h)).To(Equal(0))
+			Expect(h.tokens).To(BeEmpty())
+			Expect(h.ids).To(BeEmpty())
+		})
+		It("should expire the token if it is not used", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			h := newHTTPMux(server)
+			h.negotiateTimeout = 50 * time.Millisecond
+			negotiateV1HTTPMux(h)
+			Eventually(func() int { return httpMuxConnectionCount(h) }, time.Second).Should(Equal(0))
+			h.mx.RLock()
+			defer h.mx.RUnlock()
+			Expect(h.tokens).To(BeEmpty())
+		})
+	})
+
+	Context("When negotiateVersion is missing", func() {
+		It("should answer with version 0 without token", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			h := newHTTPMux(server)
+			recorder := httptest.NewRecorder()
+			h.negotiate(recorder, httptest.NewRequest("POST", "/hub/negotiate", nil))
+			Expect(recorder.Body.String()).NotTo(ContainSubstring("connectionToken"))
+		})
+	})
+})
+
+var _ = Describe("MapHTTP", func() {
+
+	Context("When the path has a trailing slash", func() {
+		It("should serve the hub with and without trailing slash", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub/")
+			for _, c := range []struct {
+				path   string
+				status int
+			}{
+				{"/hub/negotiate", http.StatusOK},
+				{"/hub/negotiate/", http.StatusOK},
+				{"/hub/other", http.StatusNotFound},
+			} {
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, httptest.NewRequest("POST", c.path, nil))
+				Expect(recorder.Code).To(Equal(c.status), c.path)
+			}
+		})
+	})
+
+	Context("When a PublicURL is set", func() {
+		It("should redirect negotiate requests which were sent to another host", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), Logger(log.NewNopLogger(), false),
+				PublicURL("https://example.com/api/hub"), TrustForwardedHeaders())
+			Expect(err).To(BeNil())
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub")
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest("POST", "http://internal:8080/hub/negotiate", nil))
+			response := negotiateResponse{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(BeNil())
+			Expect(response.URL).To(Equal("https://example.com/api/hub"))
+			Expect(response.ConnectionID).To(BeEmpty())
+			recorder = httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "http://internal:8080/hub/negotiate", nil)
+			req.Header.Set("X-Forwarded-Host", "example.com")
+			req.Header.Set("X-Forwarded-Proto", "https")
+			router.ServeHTTP(recorder, req)
+			response = negotiateResponse{}
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &response)).To(BeNil())
+			Expect(response.URL).To(BeEmpty())
+			Expect(response.ConnectionID).NotTo(BeEmpty())
+		})
+	})
+
+	Context("When the forwarded headers are trusted", func() {
+		It("should take the remote address, host and scheme from them", func() {
+			req := httptest.NewRequest("GET", "http://internal:8080/hub", nil)
+			req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+			req.Header.Set("X-Forwarded-Host", "example.com")
+			req.Header.Set("X-Forwarded-Proto", "HTTPS")
+			forwarded := forwardedRequest(req)
+			Expect(forwarded.RemoteAddr).To(Equal("203.0.113.7:0"))
+			Expect(forwarded.Host).To(Equal("example.com"))
+			Expect(requestScheme(forwarded)).To(Equal("https"))
+			Expect(req.Host).To(Equal("internal:8080"))
+		})
+	})
+})
+
+var _ = Describe("Handler", func() {
+
+	Context("When the Handler is mounted on another router", func() {
+		It("should serve negotiate and the transports", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&clientTestHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			handler := server.Handler()
+			// A router which matches exact paths, like the routes of chi, gin or echo
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				switch req.URL.Path {
+				case "/api/chat", "/api/chat/negotiate":
+					handler.ServeHTTP(w, req)
+				default:
+					http.NotFound(w, req)
+				}
+			}))
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/api/chat", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+		})
+	})
+})
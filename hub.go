@@ -1,10 +1,12 @@
 package signalr
 
+import "context"
+
 // HubInterface is a hubs interface
 type HubInterface interface {
 	Initialize(hubContext HubContext)
 	OnConnected(connectionID string)
-	OnDisconnected(connectionID string)
+	OnDisconnected(connectionID string, err error)
 }
 
 // Hub is a base class for hubs
@@ -28,12 +30,25 @@ func (h *Hub) Groups() GroupManager {
 }
 
 // Items returns the items for this connection
-func (h *Hub) Items() map[string]interface{} {
+func (h *Hub) Items() *Items {
 	return h.context.Items()
 }
 
+// Context returns the context of this connection.
+// It holds e.g. the Claims and the http.Request of the connection, see RequestFromContext
+func (h *Hub) Context() context.Context {
+	return h.context.Context()
+}
+
+// Abort closes this connection and does not allow the client to reconnect.
+// reason is sent to the client as error of the close message
+func (h *Hub) Abort(reason string) {
+	h.context.Abort(reason)
+}
+
 // OnConnected is called when the hub is connected
 func (h *Hub) OnConnected(string) {}
 
-//OnDisconnected is called when the hub is disconnected
-func (h *Hub) OnDisconnected(string) {}
+// OnDisconnected is called when the hub is disconnected.
+// err is the reason for the disconnect, or nil if the connection was closed normally
+func (h *Hub) OnDisconnected(string, error) {}
@@ -0,0 +1,178 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var lifetimeHubQueue = make(chan string, 10)
+
+type lifetimeHub struct {
+	Hub
+}
+
+func (l *lifetimeHub) OnConnected(connectionID string) {
+	lifetimeHubQueue <- "OnConnected()"
+}
+
+func (l *lifetimeHub) OnDisconnected(connectionID string, err error) {
+	lifetimeHubQueue <- fmt.Sprintf("OnDisconnected(%v)", err)
+}
+
+var _ = Describe("Hub", func() {
+
+	Context("When the client connects and closes the connection", func() {
+		It("should call OnConnected and OnDisconnected without error", func() {
+			conn := connect(&lifetimeHub{})
+			Expect(<-lifetimeHubQueue).To(Equal("OnConnected()"))
+			conn.ClientSend(`{"type":7}`)
+			select {
+			case r := <-lifetimeHubQueue:
+				Expect(r).To(Equal("OnDisconnected(<nil>)"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+		})
+	})
+
+	Context("When the client closes the connection with an error", func() {
+		It("should call OnDisconnected with the error of the client", func() {
+			conn := connect(&lifetimeHub{})
+			Expect(<-lifetimeHubQueue).To(Equal("OnConnected()"))
+			conn.ClientSend(`{"type":7,"error":"bye"}`)
+			select {
+			case r := <-lifetimeHubQueue:
+				Expect(r).To(Equal("OnDisconnected(client closed the connection with error: bye)"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+		})
+	})
+
+	Context("When the connection ends with an error", func() {
+		It("should call OnDisconnected with the error", func() {
+			conn := connect(&lifetimeHub{})
+			Expect(<-lifetimeHubQueue).To(Equal("OnConnected()"))
+			conn.ClientSend(`{"type":99}`)
+			select {
+			case r := <-lifetimeHubQueue:
+				Expect(r).To(HavePrefix("OnDisconnected("))
+				Expect(r).NotTo(Equal("OnDisconnected(<nil>)"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+		})
+	})
+
+	Context("When the client sends a message the server can not handle", func() {
+		It("should call OnDisconnected with the protocol error", func() {
+			conn := connect(&lifetimeHub{})
+			Expect(<-lifetimeHubQueue).To(Equal("OnConnected()"))
+			conn.ClientSend(`{"type":3,"invocationId":"unknown"}`)
+			conn.ClientSend(`{"type":6}`)
+			select {
+			case r := <-lifetimeHubQueue:
+				Expect(r).To(ContainSubstring("received completion with unknown id unknown"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+		})
+	})
+
+	Context("When OnConnected panics", func() {
+		It("should keep the connection", func() {
+			conn := connect(&panicLifetimeHub{})
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"ping"}`)
+			select {
+			case message := <-conn.received:
+				Expect(message).To(BeAssignableToTypeOf(completionMessage{}))
+				Expect(message.(completionMessage).Result).To(Equal("pong"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+		})
+	})
+})
+
+type panicLifetimeHub struct {
+	Hub
+}
+
+func (p *panicLifetimeHub) OnConnected(string) {
+	panic("OnConnected")
+}
+
+func (p *panicLifetimeHub) Ping() string {
+	return "pong"
+}
+
+type abortHub struct {
+	Hub
+}
+
+var abortHubDisconnected = make(chan error, 2)
+
+func (a *abortHub) Ban() {
+	a.Abort("banned")
+}
+
+func (a *abortHub) Kick(connectionID string) {
+	<-a.Clients().Client(connectionID).Abort("kicked")
+}
+
+func (a *abortHub) OnDisconnected(_ string, err error) {
+	abortHubDisconnected <- err
+}
+
+var _ = Describe("Abort", func() {
+
+	Context("When a hub aborts its connection", func() {
+		It("should close the connection without allowing reconnect", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&abortHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			ended := make(chan struct{})
+			go func() {
+				server.Run(conn)
+				close(ended)
+			}()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"ban"}`)
+			closeMsg := receiveClose(conn)
+			Expect(closeMsg.Error).To(Equal("banned"))
+			Expect(closeMsg.AllowReconnect).To(BeFalse())
+			Eventually(ended).Should(BeClosed())
+			Expect(<-abortHubDisconnected).To(Equal(errConnectionAborted))
+		})
+	})
+
+	Context("When a hub aborts another connection", func() {
+		It("should close the other connection", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&abortHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			victim := newTestingConnection()
+			go server.Run(victim)
+			caller := newTestingConnection()
+			go server.Run(caller)
+			// Wait until the victim is connected
+			Eventually(func() error {
+				return <-server.defaultHubClients.Client(victim.ConnectionID()).Send("ping")
+			}).Should(BeNil())
+			caller.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"1","target":"kick","arguments":["%v"]}`, victim.ConnectionID()))
+			Expect(receiveClose(victim).Error).To(Equal("kicked"))
+			Expect(receiveCompletion(caller).Error).To(BeEmpty())
+			Expect(<-abortHubDisconnected).To(Equal(errConnectionAborted))
+		})
+	})
+
+	Context("When an unknown connection is aborted", func() {
+		It("should return an error", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&abortHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			Expect(<-server.defaultHubClients.Client("unknown").Abort("")).NotTo(BeNil())
+		})
+	})
+})
@@ -3,13 +3,33 @@ package signalr
 // HubClients gives the hub access to various client groups
 // All() gets a ClientProxy that can be used to invoke methods on all clients connected to the hub
 // Caller() gets a ClientProxy that can be used to invoke methods of the current calling client
-// Client() gets a ClientProxy that can be used to invoke methods on the specified client connection
+// Others() gets a ClientProxy that can be used to invoke methods on all clients except the current calling client
+// Client() gets a SingleClientProxy that can be used to invoke methods on or abort the specified client connection
+// Clients() gets a ClientProxy that can be used to invoke methods on the specified client connections
 // Group() gets a ClientProxy that can be used to invoke methods on all connections in the specified group
+// User() gets a ClientProxy that can be used to invoke methods on all connections of the specified user
+// Users() gets a ClientProxy that can be used to invoke methods on all connections of the specified users
 type HubClients interface {
 	All() ClientProxy
 	Caller() ClientProxy
-	Client(connectionID string) ClientProxy
+	Others() ClientProxy
+	Client(connectionID string) SingleClientProxy
+	Clients(connectionIDs []string) ClientProxy
 	Group(groupName string) ClientProxy
+	User(userID string) ClientProxy
+	Users(userIDs []string) ClientProxy
+}
+
+// ServerHubClients gives code outside of hub methods, e.g. http handlers or background jobs,
+// access to the clients connected to the hub. It is HubClients without Caller() and Others(),
+// which only make sense inside a hub method
+type ServerHubClients interface {
+	All() ClientProxy
+	Client(connectionID string) SingleClientProxy
+	Clients(connectionIDs []string) ClientProxy
+	Group(groupName string) ClientProxy
+	User(userID string) ClientProxy
+	Users(userIDs []string) ClientProxy
 }
 
 type defaultHubClients struct {
@@ -21,14 +41,26 @@ func (c *defaultHubClients) All() ClientProxy {
 	return &c.allCache
 }
 
-func (c *defaultHubClients) Client(connectionID string) ClientProxy {
+func (c *defaultHubClients) Client(connectionID string) SingleClientProxy {
 	return &singleClientProxy{connectionID: connectionID, lifetimeManager: c.lifetimeManager}
 }
 
+func (c *defaultHubClients) Clients(connectionIDs []string) ClientProxy {
+	return &multipleClientProxy{connectionIDs: connectionIDs, lifetimeManager: c.lifetimeManager}
+}
+
 func (c *defaultHubClients) Group(groupName string) ClientProxy {
 	return &groupClientProxy{groupName: groupName, lifetimeManager: c.lifetimeManager}
 }
 
+func (c *defaultHubClients) User(userID string) ClientProxy {
+	return &userClientProxy{userIDs: []string{userID}, lifetimeManager: c.lifetimeManager}
+}
+
+func (c *defaultHubClients) Users(userIDs []string) ClientProxy {
+	return &userClientProxy{userIDs: userIDs, lifetimeManager: c.lifetimeManager}
+}
+
 type callerHubClients struct {
 	defaultHubClients *defaultHubClients
 	connectionID      string
@@ -42,10 +74,29 @@ func (c *callerHubClients) Caller() ClientProxy {
 	return c.defaultHubClients.Client(c.connectionID)
 }
 
-func (c *callerHubClients) Client(connectionID string) ClientProxy {
+func (c *callerHubClients) Others() ClientProxy {
+	return &allExceptClientProxy{
+		excludedConnectionIDs: []string{c.connectionID},
+		lifetimeManager:       c.defaultHubClients.lifetimeManager,
+	}
+}
+
+func (c *callerHubClients) Client(connectionID string) SingleClientProxy {
 	return c.defaultHubClients.Client(connectionID)
 }
 
+func (c *callerHubClients) Clients(connectionIDs []string) ClientProxy {
+	return c.defaultHubClients.Clients(connectionIDs)
+}
+
 func (c *callerHubClients) Group(groupName string) ClientProxy {
 	return c.defaultHubClients.Group(groupName)
 }
+
+func (c *callerHubClients) User(userID string) ClientProxy {
+	return c.defaultHubClients.User(userID)
+}
+
+func (c *callerHubClients) Users(userIDs []string) ClientProxy {
+	return c.defaultHubClients.Users(userIDs)
+}
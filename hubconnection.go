@@ -1,57 +1,212 @@
 package signalr
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"github.com/go-kit/kit/log"
 	"reflect"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+// hubConnection sends and receives hub messages over a Connection.
+// The send methods queue the message and return a channel which receives the result of the write
 type hubConnection interface {
 	Start()
 	IsConnected() bool
 	Close(error string)
+	// Abort closes the connection and does not allow the client to reconnect
+	Abort(error string)
+	// Closed is closed when the close message was written
+	Closed() <-chan struct{}
 	GetConnectionID() string
+	Context() context.Context
+	// Receive returns the next message received by Messages
 	Receive() (interface{}, error)
-	SendInvocation(target string, args ...interface{})
-	StreamItem(id string, item interface{})
-	Completion(id string, result interface{}, error string)
-	Ping()
-	Items() map[string]interface{}
-}
-
-func newHubConnection(connection Connection, protocol HubProtocol, info log.Logger, debug log.Logger) hubConnection {
-	info = log.WithPrefix(info, "ts", log.DefaultTimestampUTC,
-		"class", "HubConnection")
-	debug = log.WithPrefix(debug, "ts", log.DefaultTimestampUTC,
-		"class", "HubConnection",
+	// Messages starts the reader goroutine of the connection, which parses the received messages and passes them
+	// to the returned channel. After a receive error the channel is closed. The reader goroutine ends
+	// with the connection, or when the context of the connection is done
+	Messages() <-chan receiveResult
+	SendInvocation(target string, args ...interface{}) <-chan error
+	// SendPrepared sends a message which is shared by many connections
+	SendPrepared(message *preparedMessage) <-chan error
+	Invoke(id string, target string, args []interface{}, streamIds []string) <-chan error
+	// StreamInvoke invokes a streaming method. The items are sent with the id
+	StreamInvoke(id string, target string, args []interface{}, streamIds []string) <-chan error
+	CancelInvocation(id string) <-chan error
+	StreamItem(id string, item interface{}) <-chan error
+	// StreamItems sends the items of the stream with the id in one write to the connection
+	StreamItems(id string, items []interface{}) <-chan error
+	Completion(id string, result interface{}, error string) <-chan error
+	// CompletionError sends the completion with err. The code and data of a HubError in err are sent, too
+	CompletionError(id string, err error) <-chan error
+	Ping() <-chan error
+	LastWriteStamp() time.Time
+	Items() *Items
+}
+
+// newHubConnection creates a hubConnection. ctx is the context of the connection.
+// If maximumReceiveMessageSize is not 0, Receive fails when the client sends a message larger than
+// maximumReceiveMessageSize bytes
+func newHubConnection(ctx context.Context, connection Connection, protocol HubProtocol, maximumReceiveMessageSize uint,
+	info StructuredLogger, debug StructuredLogger) hubConnection {
+	info = withPrefix(info, "ts", defaultTimestampUTC,
+		"class", LogSubsystemHubConnection)
+	debug = withPrefix(debug, "ts", defaultTimestampUTC,
+		"class", LogSubsystemHubConnection,
 		"conn", reflect.ValueOf(connection).Elem().Type(),
 		"protocol", reflect.ValueOf(protocol).Elem().Type())
-	return &defaultHubConnection{
-		Protocol:   protocol,
-		Connection: connection,
-		items:      make(map[string]interface{}),
-		info:       info,
-		dbg:        debug,
+	c := &defaultHubConnection{
+		ctx:                       ctx,
+		Protocol:                  protocol,
+		Connection:                connection,
+		maximumReceiveMessageSize: maximumReceiveMessageSize,
+		items:                     &Items{},
+		messageSent:               func() {},
+		outbound:                  newOutboundQueue(outboundQueueSize, SlowClientBlock),
+		closed:                    make(chan struct{}),
+		messages:                  make(chan receiveResult, 1),
+		info:                      info,
+		dbg:                       debug,
+	}
+	if stateful, ok := connection.(*statefulConnection); ok {
+		c.stateful = stateful
+		c.nextSequenceID = 1
+		c.maxSentBytes = defaultStatefulReconnectBufferSize
+		stateful.setOnResume(func(transport Connection) {
+			// Let the writeLoop replay all unacknowledged messages before it writes new ones
+			c.enqueue(writeRequest{resume: transport})
+		})
 	}
+	go c.writeLoop()
+	return c
+}
+
+// outboundQueueSize is the default number of messages which can be queued for a connection before senders are blocked
+const outboundQueueSize = 64
+
+var errHubConnectionClosed = errors.New("hub connection closed")
+
+type writeRequest struct {
+	message interface{}
+	// closing marks the close message, the last message written to the connection
+	closing bool
+	// resume is the new transport of a stateful connection
+	resume Connection
+	result chan error
+	once   *sync.Once
+}
+
+// answer delivers the result of the request. Only the first answer is delivered
+func (w writeRequest) answer(err error) {
+	w.once.Do(func() {
+		w.result <- err
+	})
 }
 
 type defaultHubConnection struct {
-	Protocol   HubProtocol
-	Connected  int32
-	Connection Connection
-	items      map[string]interface{}
-	info       log.Logger
-	dbg        log.Logger
+	// first field for 64 bit alignment of atomic access
+	lastWriteStamp int64
+	ctx            context.Context
+	Protocol       HubProtocol
+	Connected      int32
+	Connection     Connection
+	// maximumReceiveMessageSize is the maximum size of a message in bytes. 0 means unlimited
+	maximumReceiveMessageSize uint
+	items                     *Items
+	outbound                  *outboundQueue
+	closed                    chan struct{}
+	info                      StructuredLogger
+	dbg                       StructuredLogger
+	// messageSent is called after each message written. It must be set before the first message is sent
+	messageSent func()
+	// recorder records the received and sent data, if the server records frames
+	recorder *frameRecorder
+	// stateful is not nil for stateful reconnect connections, which use sequence ids and acks
+	stateful *statefulConnection
+	// reader buffers the data of the connection for streaming protocols. It is only used by receive
+	reader *bufio.Reader
+	// scanner keeps the received data of partial frames between the calls of receive
+	scanner *frameScanner
+	// messages is the channel of the reader goroutine
+	messages   chan receiveResult
+	readerOnce sync.Once
+	// sent contains the messages sent but not yet acknowledged. It is only used by stateful connections
+	sentMx    sync.Mutex
+	sent      []sentMessage
+	sentBytes int
+	// maxSentBytes limits the size of the unacknowledged messages. The connection is closed when it is exceeded
+	maxSentBytes   int
+	lastSentID     int64
+	lastReceivedID int64
+	nextSequenceID int64
 }
 
-func (c *defaultHubConnection) Items() map[string]interface{} {
+// sentMessage is a message buffered for replay after a stateful reconnect
+type sentMessage struct {
+	sequenceID int64
+	data       []byte
+}
+
+// defaultStatefulReconnectBufferSize is the default size of the unacknowledged messages in bytes, like in ASP.NET Core
+const defaultStatefulReconnectBufferSize = 100000
+
+var errReplayBufferFull = errors.New("stateful reconnect buffer full, messages are not acknowledged")
+
+// statefulAckInterval is the interval in which stateful connections acknowledge received messages
+var statefulAckInterval = time.Second
+
+func (c *defaultHubConnection) Items() *Items {
 	return c.items
 }
 
 func (c *defaultHubConnection) Start() {
-	atomic.CompareAndSwapInt32(&c.Connected, 0, 1)
+	if atomic.CompareAndSwapInt32(&c.Connected, 0, 1) && c.stateful != nil {
+		go c.ackLoop()
+	}
+}
+
+// ackLoop acknowledges the received messages of a stateful connection
+func (c *defaultHubConnection) ackLoop() {
+	ticker := time.NewTicker(statefulAckInterval)
+	defer ticker.Stop()
+	var lastAckedID int64
+	for {
+		select {
+		case <-ticker.C:
+			if receivedID := atomic.LoadInt64(&c.lastReceivedID); receivedID > lastAckedID {
+				c.writeMessage(ackMessage{Type: 8, SequenceID: receivedID})
+				lastAckedID = receivedID
+			}
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// acknowledge removes all messages up to sequenceID from the replay buffer
+func (c *defaultHubConnection) acknowledge(sequenceID int64) {
+	c.sentMx.Lock()
+	defer c.sentMx.Unlock()
+	i := 0
+	for i < len(c.sent) && c.sent[i].sequenceID <= sequenceID {
+		c.sentBytes -= len(c.sent[i].data)
+		i++
+	}
+	c.sent = c.sent[i:]
+}
+
+// isSequenced tells if message is counted by stateful reconnect
+func isSequenced(message interface{}) bool {
+	switch message.(type) {
+	case hubMessage, closeMessage, ackMessage, sequenceMessage:
+		return false
+	default:
+		return true
+	}
 }
 
 func (c *defaultHubConnection) IsConnected() bool {
@@ -59,78 +214,435 @@ func (c *defaultHubConnection) IsConnected() bool {
 }
 
 func (c *defaultHubConnection) Close(error string) {
+	c.close(error, true)
+}
+
+func (c *defaultHubConnection) Abort(error string) {
+	c.close(error, false)
+}
+
+func (c *defaultHubConnection) close(error string, allowReconnect bool) {
 	atomic.StoreInt32(&c.Connected, 0)
 
 	var closeMessage = closeMessage{
 		Type:           7,
 		Error:          error,
-		AllowReconnect: true,
+		AllowReconnect: allowReconnect,
 	}
-	c.writeMessage(closeMessage)
+	// Wait until the close message is written, so the caller can safely close the underlying connection
+	<-c.enqueue(writeRequest{message: closeMessage, closing: true})
+}
+
+func (c *defaultHubConnection) Closed() <-chan struct{} {
+	return c.closed
+}
+
+func (c *defaultHubConnection) Context() context.Context {
+	return c.ctx
 }
 
 func (c *defaultHubConnection) GetConnectionID() string {
 	return c.Connection.ConnectionID()
 }
 
-func (c *defaultHubConnection) SendInvocation(target string, args ...interface{}) {
+func (c *defaultHubConnection) SendInvocation(target string, args ...interface{}) <-chan error {
+	headers, args := splitHeaders(args)
+	if args == nil {
+		// Clients expect an array, even if there are no arguments
+		args = make([]interface{}, 0)
+	}
 	var invocationMessage = sendOnlyHubInvocationMessage{
 		Type:      1,
+		Headers:   headers,
 		Target:    target,
 		Arguments: args,
 	}
-	c.writeMessage(invocationMessage)
+	return c.writeMessage(invocationMessage)
 }
 
-func (c *defaultHubConnection) Ping() {
+func (c *defaultHubConnection) SendPrepared(message *preparedMessage) <-chan error {
+	return c.writeMessage(message)
+}
+
+func (c *defaultHubConnection) Invoke(id string, target string, args []interface{}, streamIds []string) <-chan error {
+	headers, args := splitHeaders(args)
+	if args == nil {
+		args = make([]interface{}, 0)
+	}
+	var invocationMessage = invocationMessage{
+		Type:         1,
+		Headers:      headers,
+		InvocationID: id,
+		Target:       target,
+		Arguments:    args,
+		StreamIds:    streamIds,
+	}
+	return c.writeMessage(invocationMessage)
+}
+
+func (c *defaultHubConnection) StreamInvoke(id string, target string, args []interface{}, streamIds []string) <-chan error {
+	headers, args := splitHeaders(args)
+	if args == nil {
+		args = make([]interface{}, 0)
+	}
+	var invocationMessage = invocationMessage{
+		Type:         4,
+		Headers:      headers,
+		InvocationID: id,
+		Target:       target,
+		Arguments:    args,
+		StreamIds:    streamIds,
+	}
+	return c.writeMessage(invocationMessage)
+}
+
+func (c *defaultHubConnection) CancelInvocation(id string) <-chan error {
+	var cancelInvocationMessage = cancelInvocationMessage{
+		Type:         5,
+		InvocationID: id,
+	}
+	return c.writeMessage(cancelInvocationMessage)
+}
+
+func (c *defaultHubConnection) Ping() <-chan error {
 	var pingMessage = hubMessage{
 		Type: 6,
 	}
-	c.writeMessage(pingMessage)
+	return c.writeMessage(pingMessage)
+}
+
+// LastWriteStamp is the time when the last message was written to the connection
+func (c *defaultHubConnection) LastWriteStamp() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&c.lastWriteStamp))
+}
+
+// queuedMessages returns the number of messages waiting for the writeLoop
+func (c *defaultHubConnection) queuedMessages() int {
+	return c.outbound.queued()
+}
+
+type receiveResult struct {
+	message interface{}
+	err     error
 }
 
 func (c *defaultHubConnection) Receive() (interface{}, error) {
-	var buf bytes.Buffer
-	var data = make([]byte, 1<<12) // 4K
-	var n int
+	select {
+	case result, ok := <-c.Messages():
+		if !ok {
+			return nil, errHubConnectionClosed
+		}
+		return result.message, result.err
+	case <-c.ctx.Done():
+		return nil, c.ctx.Err()
+	}
+}
+
+func (c *defaultHubConnection) Messages() <-chan receiveResult {
+	c.readerOnce.Do(func() {
+		go c.readLoop()
+	})
+	return c.messages
+}
+
+// readLoop is the reader goroutine. It is the only one reading from the connection
+func (c *defaultHubConnection) readLoop() {
+	defer close(c.messages)
 	for {
-		if message, complete, err := c.Protocol.ReadMessage(&buf); !complete {
-			// Partial message, need more data
-			// ReadMessage read data out of the buf, so its gone there: refill
-			buf.Write(data[:n])
-			if n, err = c.Connection.Read(data); err == nil {
-				buf.Write(data[:n])
-			} else {
-				return nil, err
+		message, err := c.readMessage()
+		select {
+		case c.messages <- receiveResult{message, err}:
+			if err != nil {
+				return
 			}
-		} else {
+		case <-c.ctx.Done():
+			return
+		case <-c.closed:
+			// The close message was written, nobody waits for more messages
+			return
+		}
+	}
+}
+
+// readMessage reads the next message which is passed to the hub. Stateful connections handle acks and sequence
+// messages here and drop replayed messages
+func (c *defaultHubConnection) readMessage() (interface{}, error) {
+	for {
+		message, err := c.receive()
+		if err != nil || c.stateful == nil {
 			return message, err
 		}
+		switch message := message.(type) {
+		case ackMessage:
+			c.acknowledge(message.SequenceID)
+		case sequenceMessage:
+			if lastReceivedID := atomic.LoadInt64(&c.lastReceivedID); message.SequenceID > lastReceivedID+1 {
+				return nil, fmt.Errorf("sequence id %v skips messages after %v", message.SequenceID, lastReceivedID)
+			}
+			c.nextSequenceID = message.SequenceID
+		default:
+			if !isSequenced(message) {
+				return message, nil
+			}
+			sequenceID := c.nextSequenceID
+			c.nextSequenceID++
+			if sequenceID <= atomic.LoadInt64(&c.lastReceivedID) {
+				// Replayed message which was already received
+				continue
+			}
+			atomic.StoreInt64(&c.lastReceivedID, sequenceID)
+			return message, nil
+		}
+	}
+}
+
+func (c *defaultHubConnection) receive() (interface{}, error) {
+	// Stateful connections need to drop partial messages of a failed transport, so they can not use the reader
+	if protocol, ok := c.Protocol.(streamingHubProtocol); ok && c.stateful == nil {
+		if c.reader == nil {
+			c.reader = bufio.NewReaderSize(recordingReader{c}, readBufferSize)
+		}
+		message, err := protocol.readMessageFrom(c.reader, c.maximumReceiveMessageSize)
+		if errors.Is(err, errMessageTooLarge) {
+			return nil, c.maximumReceiveMessageSizeError()
+		}
+		return message, err
+	}
+	if c.scanner == nil {
+		c.scanner = newFrameScanner(c.Protocol.splitFrame)
+	}
+	readBuffer := getReadBuffer()
+	defer putReadBuffer(readBuffer)
+	data := *readBuffer
+	for {
+		frame, size, ok, err := c.scanner.next()
+		switch {
+		case err != nil:
+			return nil, err
+		case ok:
+			if c.exceedsMaximumReceiveMessageSize(size) {
+				return nil, c.maximumReceiveMessageSizeError()
+			}
+			return c.Protocol.parseFrame(frame)
+		case c.exceedsMaximumReceiveMessageSize(c.scanner.buffered()):
+			// Do not buffer more of a message which is too large anyway
+			return nil, c.maximumReceiveMessageSizeError()
+		}
+		var generation uint64
+		if c.stateful != nil {
+			generation = c.stateful.currentGeneration()
+		}
+		n, err := c.Connection.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		c.recorder.record(c.GetConnectionID(), FrameIn, data[:n])
+		if c.stateful != nil && c.stateful.currentGeneration() != generation {
+			// Data of a partial message from the failed transport is never completed
+			c.scanner.reset()
+		}
+		c.scanner.write(data[:n])
+	}
+}
+
+// recordingReader reads from the Connection and records what has been read
+type recordingReader struct {
+	c *defaultHubConnection
+}
+
+func (r recordingReader) Read(p []byte) (int, error) {
+	n, err := r.c.Connection.Read(p)
+	if n > 0 {
+		r.c.recorder.record(r.c.GetConnectionID(), FrameIn, p[:n])
 	}
+	return n, err
 }
 
-func (c *defaultHubConnection) Completion(id string, result interface{}, error string) {
+func (c *defaultHubConnection) exceedsMaximumReceiveMessageSize(size int) bool {
+	return c.maximumReceiveMessageSize > 0 && uint(size) > c.maximumReceiveMessageSize
+}
+
+func (c *defaultHubConnection) maximumReceiveMessageSizeError() error {
+	return fmt.Errorf("message size exceeds the MaximumReceiveMessageSize of %v bytes", c.maximumReceiveMessageSize)
+}
+
+func (c *defaultHubConnection) Completion(id string, result interface{}, error string) <-chan error {
 	var completionMessage = completionMessage{
 		Type:         3,
 		InvocationID: id,
 		Result:       result,
 		Error:        error,
 	}
-	c.writeMessage(completionMessage)
+	return c.writeMessage(completionMessage)
+}
+
+func (c *defaultHubConnection) CompletionError(id string, err error) <-chan error {
+	return c.writeMessage(errorCompletion(id, err))
 }
 
-func (c *defaultHubConnection) StreamItem(id string, item interface{}) {
+func (c *defaultHubConnection) StreamItem(id string, item interface{}) <-chan error {
 	var streamItemMessage = streamItemMessage{
 		Type:         2,
 		InvocationID: id,
 		Item:         item,
 	}
-	c.writeMessage(streamItemMessage)
+	return c.writeMessage(streamItemMessage)
+}
+
+// streamItemBatch are stream items written at once
+type streamItemBatch []streamItemMessage
+
+func (c *defaultHubConnection) StreamItems(id string, items []interface{}) <-chan error {
+	batch := make(streamItemBatch, len(items))
+	for i, item := range items {
+		batch[i] = streamItemMessage{Type: 2, InvocationID: id, Item: item}
+	}
+	return c.writeMessage(batch)
 }
 
-func (c *defaultHubConnection) writeMessage(message interface{}) {
-	if err := c.Protocol.WriteMessage(message, c.Connection); err != nil {
-		_ = c.info.Log(evt, "send invocation", "error",
-			fmt.Sprintf("cannot send message %v over connection %v: %v", message, c.GetConnectionID(), err))
+func isBatch(message interface{}) bool {
+	_, ok := message.(streamItemBatch)
+	return ok
+}
+
+// writeBatch serializes the messages of batch and writes them at once
+func (c *defaultHubConnection) writeBatch(batch streamItemBatch) error {
+	var buf bytes.Buffer
+	for _, message := range batch {
+		data, err := c.serialize(message)
+		if err != nil {
+			return err
+		}
+		if c.stateful != nil {
+			// Each message has its own sequence id
+			if err := c.bufferSent(data); err != nil {
+				return err
+			}
+		}
+		buf.Write(data)
+	}
+	_, err := c.writer().Write(buf.Bytes())
+	return err
+}
+
+// writeMessage queues the message for the writeLoop. The returned channel receives the result of the write
+func (c *defaultHubConnection) writeMessage(message interface{}) <-chan error {
+	return c.enqueue(writeRequest{message: message})
+}
+
+func (c *defaultHubConnection) enqueue(request writeRequest) <-chan error {
+	request.result = make(chan error, 1)
+	request.once = &sync.Once{}
+	if err := c.outbound.push(request, c.closed); err != nil {
+		_ = c.info.Log(evt, "send", "error", err, react, "disconnect")
+		// The close message must not wait for the sender
+		go c.Abort(err.Error())
+	}
+	return request.result
+}
+
+// writeSequenced writes message and buffers it for replay
+func (c *defaultHubConnection) writeSequenced(message interface{}) error {
+	data, err := c.serialize(message)
+	if err != nil {
+		return err
+	}
+	if err := c.bufferSent(data); err != nil {
+		return err
+	}
+	_, err = c.writer().Write(data)
+	return err
+}
+
+// bufferSent buffers the data of a message for replay. If the buffer would exceed maxSentBytes, the message is not
+// buffered and the connection is aborted, because the other side does not acknowledge the messages
+func (c *defaultHubConnection) bufferSent(data []byte) error {
+	c.sentMx.Lock()
+	if c.sentBytes+len(data) > c.maxSentBytes {
+		c.sentMx.Unlock()
+		_ = c.info.Log(evt, "send", "error", errReplayBufferFull, "size", c.sentBytes, react, "disconnect")
+		// The close message is written by the writeLoop, which is the caller
+		go c.Abort(errReplayBufferFull.Error())
+		return errReplayBufferFull
+	}
+	c.lastSentID++
+	c.sent = append(c.sent, sentMessage{sequenceID: c.lastSentID, data: data})
+	c.sentBytes += len(data)
+	c.sentMx.Unlock()
+	return nil
+}
+
+// serialize returns message written by the protocol of the connection
+func (c *defaultHubConnection) serialize(message interface{}) ([]byte, error) {
+	if prepared, ok := message.(*preparedMessage); ok {
+		return prepared.serialize(c.Protocol)
+	}
+	var buf bytes.Buffer
+	err := c.Protocol.WriteMessage(message, &buf)
+	return buf.Bytes(), err
+}
+
+// replay sends the sequence id of the first unacknowledged message and all unacknowledged messages over the
+// new transport of a stateful connection
+func (c *defaultHubConnection) replay(transport Connection) error {
+	c.sentMx.Lock()
+	sent := make([]sentMessage, len(c.sent))
+	copy(sent, c.sent)
+	firstID := c.lastSentID + 1
+	if len(sent) > 0 {
+		firstID = sent[0].sequenceID
+	}
+	c.sentMx.Unlock()
+	c.stateful.setWriteTransport(transport)
+	if err := c.Protocol.WriteMessage(sequenceMessage{Type: 9, SequenceID: firstID}, c.writer()); err != nil {
+		return err
+	}
+	for _, message := range sent {
+		if _, err := c.writer().Write(message.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writer is the connection, which records all writes if the server records frames
+func (c *defaultHubConnection) writer() Connection {
+	return c.recorder.writer(c.Connection)
+}
+
+// writeLoop is the only writer to the connection, so frames of concurrently sent messages are never interleaved
+func (c *defaultHubConnection) writeLoop() {
+	for {
+		request := c.outbound.pop()
+		var err error
+		switch {
+		case request.resume != nil:
+			err = c.replay(request.resume)
+		case isBatch(request.message):
+			err = c.writeBatch(request.message.(streamItemBatch))
+		case c.stateful != nil && isSequenced(request.message):
+			err = c.writeSequenced(request.message)
+		case isPrepared(request.message):
+			var data []byte
+			if data, err = c.serialize(request.message); err == nil {
+				_, err = c.writer().Write(data)
+			}
+		default:
+			err = c.Protocol.WriteMessage(request.message, c.writer())
+		}
+		if err != nil {
+			_ = c.info.Log(evt, "send invocation", "error",
+				fmt.Sprintf("cannot send message %v over connection %v: %v", request.message, c.GetConnectionID(), err))
+		} else {
+			atomic.StoreInt64(&c.lastWriteStamp, time.Now().UnixNano())
+			c.messageSent()
+		}
+		request.answer(err)
+		if request.closing {
+			close(c.closed)
+			// Messages queued after the close message are not sent
+			c.outbound.close()
+			return
+		}
 	}
 }
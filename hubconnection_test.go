@@ -0,0 +1,120 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// concurrencyDetectingConnection counts the writes and detects concurrent writes
+type concurrencyDetectingConnection struct {
+	discardConnection
+	writing    int32
+	concurrent int32
+	writes     int32
+}
+
+func (c *concurrencyDetectingConnection) Write(p []byte) (n int, err error) {
+	if !atomic.CompareAndSwapInt32(&c.writing, 0, 1) {
+		atomic.StoreInt32(&c.concurrent, 1)
+		return len(p), nil
+	}
+	time.Sleep(time.Millisecond)
+	atomic.AddInt32(&c.writes, 1)
+	atomic.StoreInt32(&c.writing, 0)
+	return len(p), nil
+}
+
+var _ = Describe("HubConnection", func() {
+
+	Context("When messages are sent concurrently", func() {
+		It("should write them one after another", func() {
+			conn := &concurrencyDetectingConnection{discardConnection: discardConnection{"c"}}
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			hubConn := newHubConnection(context.Background(), conn, protocol, 0, log.NewNopLogger(), log.NewNopLogger())
+			hubConn.Start()
+			wg := sync.WaitGroup{}
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					hubConn.SendInvocation("clientFunc", i)
+					hubConn.StreamItem("1", i)
+					wg.Done()
+				}(i)
+			}
+			wg.Wait()
+			hubConn.Close("")
+			Expect(atomic.LoadInt32(&conn.concurrent)).To(Equal(int32(0)))
+			// 40 messages and the close message
+			Expect(atomic.LoadInt32(&conn.writes)).To(Equal(int32(41)))
+		})
+	})
+
+	Context("When the connection is closed", func() {
+		It("should not write messages sent afterwards and return an error", func() {
+			conn := &concurrencyDetectingConnection{discardConnection: discardConnection{"c"}}
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			hubConn := newHubConnection(context.Background(), conn, protocol, 0, log.NewNopLogger(), log.NewNopLogger())
+			hubConn.Start()
+			hubConn.Close("")
+			Expect(<-hubConn.SendInvocation("clientFunc")).To(Equal(errHubConnectionClosed))
+			hubConn.Close("")
+			<-time.After(50 * time.Millisecond)
+			Expect(atomic.LoadInt32(&conn.writes)).To(Equal(int32(1)))
+		})
+	})
+
+	Context("When the ping loop starts before the connection is started", func() {
+		It("should ping after the connection is started", func() {
+			conn := &concurrencyDetectingConnection{discardConnection: discardConnection{"c"}}
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			hubConn := newHubConnection(context.Background(), conn, protocol, 0, log.NewNopLogger(), log.NewNopLogger())
+			done := make(chan struct{})
+			pings := startPingClientLoop(hubConn, 10*time.Millisecond, done)
+			<-time.After(20 * time.Millisecond)
+			hubConn.Start()
+			<-time.After(50 * time.Millisecond)
+			close(done)
+			pings.Wait()
+			Expect(atomic.LoadInt32(&conn.writes)).To(BeNumerically(">", 0))
+		})
+	})
+
+	Context("When the reader goroutine fails to read", func() {
+		It("should pass the error and close the channel", func() {
+			data := bytes.NewBufferString(`{"type":6}` + "\u001e")
+			conn := &readerConnection{discardConnection: discardConnection{"c"}, reader: data}
+			hubConn := newHubConnection(context.Background(), conn, newJSONTestProtocol(), 0, log.NewNopLogger(), log.NewNopLogger())
+			messages := hubConn.Messages()
+			Expect((<-messages).message).To(BeAssignableToTypeOf(hubMessage{}))
+			Expect((<-messages).err).To(Equal(io.EOF))
+			Eventually(messages).Should(BeClosed())
+			_, err := hubConn.Receive()
+			Expect(err).To(Equal(errHubConnectionClosed))
+		})
+	})
+
+	Context("When the context of the connection is done", func() {
+		It("should end Receive while the reader goroutine waits for data", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			hubConn := newHubConnection(ctx, &discardConnection{"c"}, newJSONTestProtocol(), 0, log.NewNopLogger(), log.NewNopLogger())
+			received := make(chan error, 1)
+			go func() {
+				_, err := hubConn.Receive()
+				received <- err
+			}()
+			Consistently(received, 50*time.Millisecond).ShouldNot(Receive())
+			cancel()
+			Eventually(received).Should(Receive(Equal(context.Canceled)))
+		})
+	})
+})
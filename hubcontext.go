@@ -1,19 +1,34 @@
 package signalr
 
+import "context"
+
+// ConnectionContext describes the connection a hub instance is created for
+type ConnectionContext struct {
+	// Context is the context of the connection. It holds the Claims and the user id of the connection
+	Context      context.Context
+	ConnectionID string
+}
+
 // HubContext is a context abstraction for a hub
 // Clients() gets a HubClients that can be used to invoke methods on clients connected to the hub
 // Groups() gets a GroupManager that can be used to add and remove connections to named groups
-// Items() holds key/value pairs scoped to the hubs connection
+// Items() holds concurrency safe key/value pairs scoped to the hubs connection
+// Context() gets the context of the hubs connection
+// Abort() closes the hubs connection without allowing the client to reconnect
 type HubContext interface {
 	Clients() HubClients
 	Groups() GroupManager
-	Items() map[string]interface{}
+	Items() *Items
+	Context() context.Context
+	Abort(reason string)
 }
 
 type connectionHubContext struct {
 	clients HubClients
 	groups  GroupManager
-	items   map[string]interface{}
+	items   *Items
+	ctx     context.Context
+	conn    hubConnection
 }
 
 func (c *connectionHubContext) Clients() HubClients {
@@ -24,6 +39,14 @@ func (c *connectionHubContext) Groups() GroupManager {
 	return c.groups
 }
 
-func (c *connectionHubContext) Items() map[string]interface{} {
+func (c *connectionHubContext) Items() *Items {
 	return c.items
 }
+
+func (c *connectionHubContext) Context() context.Context {
+	return c.ctx
+}
+
+func (c *connectionHubContext) Abort(reason string) {
+	c.conn.Abort(reason)
+}
@@ -1,6 +1,7 @@
 package signalr
 
 import (
+	"context"
 	"fmt"
 	"github.com/go-kit/kit/log"
 	. "github.com/onsi/ginkgo"
@@ -30,6 +31,25 @@ func (c *contextHub) CallCaller() {
 	hubContextInvocationQueue <- "CallCaller()"
 }
 
+func (c *contextHub) CallOthers() {
+	c.Clients().Others().Send("clientFunc")
+	hubContextInvocationQueue <- "CallOthers()"
+}
+
+func (c *contextHub) CallClients(connectionIDs []string) {
+	c.Clients().Clients(connectionIDs).Send("clientFunc")
+	hubContextInvocationQueue <- "CallClients()"
+}
+
+func (c *contextHub) SendResult(connectionID string) string {
+	err := <-c.Clients().Client(connectionID).Send("clientFunc")
+	hubContextInvocationQueue <- "SendResult()"
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
 func (c *contextHub) CallClient(connectionID string) {
 	c.Clients().Client(connectionID).Send("clientFunc")
 	hubContextInvocationQueue <- "CallClient()"
@@ -52,19 +72,25 @@ func (c *contextHub) CallGroup() {
 }
 
 func (c *contextHub) AddItem(key string, value interface{}) {
-	c.Items()[key] = value
+	c.Items().Set(key, value)
 	hubContextInvocationQueue <- "AddItem()"
 }
 
 func (c *contextHub) GetItem(key string) interface{} {
 	hubContextInvocationQueue <- "GetItem()"
-	return c.Items()[key]
+	value, _ := c.Items().Get(key)
+	return value
+}
+
+func (c *contextHub) DeleteItem(key string) {
+	c.Items().Delete(key)
+	hubContextInvocationQueue <- "DeleteItem()"
 }
 
 var hubContextInvocationQueue = make(chan string, 10)
 
 func connectMany() []*testingConnection {
-	server, err := NewServer(SimpleHubFactory(&contextHub{}),
+	server, err := NewServer(context.Background(), SimpleHubFactory(&contextHub{}),
 		Logger(log.NewLogfmtLogger(os.Stderr), false))
 	if err != nil {
 		Fail(err.Error())
@@ -154,7 +180,90 @@ var _ = Describe("HubContext", func() {
 		})
 	})
 
+	Context("Clients().Others()", func() {
+		It("should invoke all clients except the caller", func() {
+			conns := connectMany()
+			conns[0].ClientSend(`{"type":1,"invocationId": "123","target":"callothers"}`)
+			callCount := make(chan int, 1)
+			callCount <- 0
+			done := make(chan bool)
+			go func(conns []*testingConnection) {
+				msg := <-conns[0].received
+				if _, ok := msg.(invocationMessage); ok {
+					Fail(fmt.Sprintf("caller received %v", msg))
+				}
+			}(conns)
+			go func(conns []*testingConnection, callCount chan int, done chan bool) {
+				msg := <-conns[1].received
+				expectInvocation(msg, callCount, done, 2)
+			}(conns, callCount, done)
+			go func(conns []*testingConnection, callCount chan int, done chan bool) {
+				msg := <-conns[2].received
+				expectInvocation(msg, callCount, done, 2)
+			}(conns, callCount, done)
+			Expect(<-hubContextInvocationQueue).To(Equal("CallOthers()"))
+			select {
+			case <-done:
+				break
+			case <-time.After(3000 * time.Millisecond):
+				Fail("timed out")
+			}
+		})
+	})
+
+	Context("Clients().Clients()", func() {
+		It("should invoke only the clients which were addressed", func() {
+			conns := connectMany()
+			conns[0].ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "123","target":"callclients","arguments":[["%v","%v"]]}`,
+				conns[0].ConnectionID(), conns[2].ConnectionID()))
+			callCount := make(chan int, 1)
+			callCount <- 0
+			done := make(chan bool)
+			go func(conns []*testingConnection, callCount chan int, done chan bool) {
+				msg := <-conns[0].received
+				if _, ok := msg.(completionMessage); ok {
+					msg = <-conns[0].received
+				}
+				expectInvocation(msg, callCount, done, 2)
+			}(conns, callCount, done)
+			go func(conns []*testingConnection) {
+				msg := <-conns[1].received
+				if _, ok := msg.(invocationMessage); ok {
+					Fail(fmt.Sprintf("wrong client received %v", msg))
+				}
+			}(conns)
+			go func(conns []*testingConnection, callCount chan int, done chan bool) {
+				msg := <-conns[2].received
+				expectInvocation(msg, callCount, done, 2)
+			}(conns, callCount, done)
+			Expect(<-hubContextInvocationQueue).To(Equal("CallClients()"))
+			select {
+			case <-done:
+				break
+			case <-time.After(3000 * time.Millisecond):
+				Fail("timed out")
+			}
+		})
+	})
+
 	Context("Clients().Client()", func() {
+		It("should return the result of the send", func() {
+			conns := connectMany()
+			conns[0].ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "123","target":"sendresult","arguments":["%v"]}`, conns[1].ConnectionID()))
+			Expect(<-hubContextInvocationQueue).To(Equal("SendResult()"))
+			Expect(<-conns[1].received).To(BeAssignableToTypeOf(invocationMessage{}))
+			msg := <-conns[0].received
+			Expect(msg).To(BeAssignableToTypeOf(completionMessage{}))
+			Expect(msg.(completionMessage).Result).To(Equal(""))
+		})
+		It("should return an error when the client is unknown", func() {
+			conns := connectMany()
+			conns[2].ClientSend(`{"type":1,"invocationId": "124","target":"sendresult","arguments":["unknown"]}`)
+			Expect(<-hubContextInvocationQueue).To(Equal("SendResult()"))
+			msg := <-conns[2].received
+			Expect(msg).To(BeAssignableToTypeOf(completionMessage{}))
+			Expect(msg.(completionMessage).Result).To(ContainSubstring("unknown connection"))
+		})
 		It("should invoke only the client which was addressed", func() {
 			conns := connectMany()
 			conns[0].ClientSend(fmt.Sprintf(`{"type":1,"invocationId": "123","target":"callclient","arguments":["%v"]}`, conns[2].ConnectionID()))
@@ -304,6 +413,20 @@ var _ = Describe("HubContext", func() {
 			Expect(msg).To(BeAssignableToTypeOf(completionMessage{}))
 			Expect(msg.(completionMessage).Result).To(BeNil())
 		})
+		It("should not hold deleted Items", func() {
+			conn := connect(&contextHub{})
+			conn.ClientSend(`{"type":1,"invocationId": "123","target":"additem","arguments":["first",1]}`)
+			Expect(<-hubContextInvocationQueue).To(Equal("AddItem()"))
+			<-conn.received
+			conn.ClientSend(`{"type":1,"invocationId": "123","target":"deleteitem","arguments":["first"]}`)
+			Expect(<-hubContextInvocationQueue).To(Equal("DeleteItem()"))
+			<-conn.received
+			conn.ClientSend(`{"type":1,"invocationId": "123","target":"getitem","arguments":["first"]}`)
+			Expect(<-hubContextInvocationQueue).To(Equal("GetItem()"))
+			msg := <-conn.received
+			Expect(msg).To(BeAssignableToTypeOf(completionMessage{}))
+			Expect(msg.(completionMessage).Result).To(BeNil())
+		})
 	})
 })
 
@@ -318,3 +441,49 @@ func expectInvocation(msg interface{}, callCount chan int, done chan bool, doneC
 		callCount <- d
 	}
 }
+
+type outsideHub struct {
+	Hub
+}
+
+var outsideHubConnected = make(chan string, 2)
+
+func (o *outsideHub) OnConnected(connectionID string) {
+	outsideHubConnected <- connectionID
+}
+
+var _ = Describe("Server.HubClients()", func() {
+	var server *Server
+	var conns []*testingConnection
+
+	BeforeEach(func() {
+		var err error
+		server, err = NewServer(context.Background(), SimpleHubFactory(&outsideHub{}),
+			Logger(log.NewLogfmtLogger(os.Stderr), false))
+		Expect(err).To(BeNil())
+		conns = make([]*testingConnection, 2)
+		for i := range conns {
+			conns[i] = newTestingConnection()
+			go server.Run(conns[i])
+			<-outsideHubConnected
+		}
+	})
+
+	Context("When All() is invoked outside of a hub method", func() {
+		It("should invoke all clients", func() {
+			Expect(<-server.HubClients().All().Send("clientFunc")).To(BeNil())
+			for _, conn := range conns {
+				Eventually(conn.received).Should(Receive(BeAssignableToTypeOf(invocationMessage{})))
+			}
+		})
+	})
+
+	Context("When a group is built and invoked outside of a hub method", func() {
+		It("should invoke only the members of the group", func() {
+			server.Groups().AddToGroup("outside", conns[0].ConnectionID())
+			Expect(<-server.HubClients().Group("outside").Send("clientFunc")).To(BeNil())
+			Eventually(conns[0].received).Should(Receive(BeAssignableToTypeOf(invocationMessage{})))
+			Consistently(conns[1].received, 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+})
@@ -0,0 +1,36 @@
+package signalr
+
+import "errors"
+
+// HubError is an error with a code and data, which are sent with the error of the completion.
+// Hub methods and HubFilters return it to let the clients distinguish, e.g., validation failures from server faults
+//
+//	return nil, &signalr.HubError{Message: "invalid name", Code: "validation", Data: map[string]string{"name": "empty"}}
+//
+// Wrapped HubErrors are found, too. The message of the completion is then the message of the wrapping error.
+// The client returns the errors of completions as *HubError, with Code and Data if the server sent them.
+// Data is decoded like the results of invocations without a result type, e.g. objects to map[string]interface{}
+type HubError struct {
+	Message string
+	Code    string
+	Data    interface{}
+}
+
+func (e *HubError) Error() string {
+	return e.Message
+}
+
+// errorCompletion returns the completion of the invocation with the id for err
+func errorCompletion(id string, err error) completionMessage {
+	completion := completionMessage{Type: 3, InvocationID: id, Error: err.Error()}
+	var hubErr *HubError
+	if errors.As(err, &hubErr) {
+		completion.ErrorCode, completion.ErrorData = hubErr.Code, hubErr.Data
+	}
+	return completion
+}
+
+// completionError returns the error of a completion with an error
+func completionError(completion completionMessage) error {
+	return &HubError{Message: completion.Error, Code: completion.ErrorCode, Data: completion.ErrorData}
+}
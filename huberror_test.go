@@ -0,0 +1,124 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+type hubErrorHub struct {
+	Hub
+}
+
+func (h *hubErrorHub) Validate(name string) (string, error) {
+	if name == "" {
+		return "", &HubError{Message: "invalid name", Code: "validation", Data: map[string]interface{}{"field": "name"}}
+	}
+	return name, nil
+}
+
+func (h *hubErrorHub) Wrapped() error {
+	return fmt.Errorf("store failed: %w", &HubError{Message: "timeout", Code: "unavailable"})
+}
+
+func (h *hubErrorHub) Fault() error {
+	return errors.New("fault")
+}
+
+func connectHubErrorHub() *testingConnection {
+	server, err := NewServer(context.Background(), SimpleHubFactory(&hubErrorHub{}), Logger(log.NewNopLogger(), false))
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	go server.Run(conn)
+	return conn
+}
+
+var _ = Describe("HubError", func() {
+
+	Context("When a hub method returns a HubError", func() {
+		It("should send its code and data with the completion", func() {
+			conn := connectHubErrorHub()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"validate","arguments":[""]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(Equal("invalid name"))
+			Expect(completion.ErrorCode).To(Equal("validation"))
+			Expect(completion.ErrorData).To(Equal(map[string]interface{}{"field": "name"}))
+		})
+		It("should send the message of the wrapping error", func() {
+			conn := connectHubErrorHub()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"wrapped","arguments":[]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(Equal("store failed: timeout"))
+			Expect(completion.ErrorCode).To(Equal("unavailable"))
+			Expect(completion.ErrorData).To(BeNil())
+		})
+	})
+
+	Context("When a hub method returns another error", func() {
+		It("should send the error without code and data", func() {
+			conn := connectHubErrorHub()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"fault","arguments":[]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(Equal("fault"))
+			Expect(completion.ErrorCode).To(BeEmpty())
+			Expect(completion.ErrorData).To(BeNil())
+		})
+	})
+
+	Context("When completions with error code and data are written and parsed", func() {
+		It("should keep them", func() {
+			for _, protocol := range []HubProtocol{&JSONHubProtocol{}, newMessagePackProtocol()} {
+				protocol.setDebugLogger(log.NewNopLogger())
+				for _, message := range []completionMessage{
+					{Type: 3, InvocationID: "1", Error: "failed", ErrorCode: "validation",
+						ErrorData: map[string]interface{}{"field": "name"}},
+					{Type: 3, InvocationID: "1", Error: "failed", ErrorCode: "validation"},
+					{Type: 3, InvocationID: "1", Error: "failed"},
+				} {
+					var buf bytes.Buffer
+					Expect(protocol.WriteMessage(message, &buf)).To(BeNil())
+					_, frame, err := protocol.splitFrame(buf.Bytes(), true)
+					Expect(err).To(BeNil())
+					protocol.setStrict(true)
+					parsed, err := protocol.parseFrame(frame)
+					protocol.setStrict(false)
+					Expect(err).To(BeNil())
+					Expect(parsed).To(Equal(message), "%T", protocol)
+				}
+			}
+		})
+		It("should reject error codes without error in strict mode", func() {
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			protocol.setStrict(true)
+			_, err := protocol.parseFrame([]byte(`{"type":3,"invocationId":"1","errorCode":"validation"}`))
+			Expect(isProtocolError(err)).To(BeTrue())
+		})
+	})
+
+	Context("When a client invokes a hub method which returns a HubError", func() {
+		It("should return a HubError with code and data", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&hubErrorHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub")
+			testServer := httptest.NewServer(router)
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var hubErr *HubError
+			Expect(errors.As(client.Invoke(context.Background(), "validate", nil, ""), &hubErr)).To(BeTrue())
+			Expect(*hubErr).To(Equal(HubError{Message: "invalid name", Code: "validation",
+				Data: map[string]interface{}{"field": "name"}}))
+			Expect(errors.As(client.Invoke(context.Background(), "fault", nil), &hubErr)).To(BeTrue())
+			Expect(*hubErr).To(Equal(HubError{Message: "fault"}))
+		})
+	})
+})
@@ -0,0 +1,72 @@
+package signalr
+
+import (
+	"context"
+	"reflect"
+)
+
+// HubInvocationContext describes the invocation of a hub method for a HubFilter
+type HubInvocationContext struct {
+	// Context is the context of the connection, or of the stream for stream invocations
+	Context      context.Context
+	Hub          HubInterface
+	ConnectionID string
+	MethodName   string
+	// Arguments are the arguments the hub method is called with
+	Arguments []interface{}
+	// Headers are the MessageHeaders of the invocation message
+	Headers MessageHeaders
+}
+
+// HubFilter wraps the invocations of hub methods, e.g. for authorization, logging, rate limiting or metrics.
+// InvokeMethod calls next to invoke the hub method, or the next filter.
+// next returns the values returned by the hub method, without a trailing error, and the error returned by the hub method.
+// InvokeMethod might change the results or return an error without calling next to reject the invocation.
+// The error is sent to the client as error of the completion
+type HubFilter interface {
+	InvokeMethod(invocation *HubInvocationContext, next func() ([]interface{}, error)) ([]interface{}, error)
+}
+
+// HubFilterFunc is an adapter to use a func as HubFilter
+type HubFilterFunc func(invocation *HubInvocationContext, next func() ([]interface{}, error)) ([]interface{}, error)
+
+// InvokeMethod calls f(invocation, next)
+func (f HubFilterFunc) InvokeMethod(invocation *HubInvocationContext,
+	next func() ([]interface{}, error)) ([]interface{}, error) {
+	return f(invocation, next)
+}
+
+// invokeFiltered calls the hub method through the filters. The first filter is the outermost.
+// It returns the results of the method in the form method.Call returns them
+func invokeFiltered(filters []HubFilter, invocation *HubInvocationContext, method reflect.Value,
+	in []reflect.Value) ([]reflect.Value, error) {
+	next := func() ([]interface{}, error) {
+		result, err := splitResultError(method.Call(in))
+		values := make([]interface{}, len(result))
+		for i, rv := range result {
+			values[i] = rv.Interface()
+		}
+		return values, err
+	}
+	for i := len(filters) - 1; i >= 0; i-- {
+		filter, inner := filters[i], next
+		next = func() ([]interface{}, error) {
+			return filter.InvokeMethod(invocation, inner)
+		}
+	}
+	values, err := next()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]reflect.Value, len(values))
+	for i, value := range values {
+		if value != nil {
+			result[i] = reflect.ValueOf(value)
+		} else if i < method.Type().NumOut() {
+			result[i] = reflect.Zero(method.Type().Out(i))
+		} else {
+			result[i] = reflect.Zero(reflect.TypeOf((*interface{})(nil)).Elem())
+		}
+	}
+	return result, nil
+}
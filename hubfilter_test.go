@@ -0,0 +1,103 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+	"time"
+)
+
+type filterHub struct {
+	Hub
+}
+
+func (f *filterHub) Add(a, b int) int {
+	return a + b
+}
+
+func (f *filterHub) Forbidden() int {
+	filterHubQueue <- "Forbidden()"
+	return 1
+}
+
+var filterHubQueue = make(chan string, 10)
+
+func connectFilterHub(filters ...HubFilter) *testingConnection {
+	options := []Option{SimpleHubFactory(&filterHub{}), Logger(log.NewNopLogger(), false)}
+	for _, filter := range filters {
+		options = append(options, UseHubFilter(filter))
+	}
+	server, err := NewServer(context.Background(), options...)
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	go server.Run(conn)
+	return conn
+}
+
+func receiveCompletion(conn *testingConnection) completionMessage {
+	for {
+		select {
+		case message := <-conn.received:
+			if completion, ok := message.(completionMessage); ok {
+				return completion
+			}
+		case <-time.After(time.Second):
+			Fail("timed out")
+			return completionMessage{}
+		}
+	}
+}
+
+var _ = Describe("HubFilter", func() {
+
+	Context("When filters are used", func() {
+		It("should call them in the order they were added around the hub method", func() {
+			calls := make(chan string, 4)
+			logFilter := func(name string) HubFilter {
+				return HubFilterFunc(func(invocation *HubInvocationContext, next func() ([]interface{}, error)) ([]interface{}, error) {
+					calls <- name + " " + invocation.MethodName
+					return next()
+				})
+			}
+			conn := connectFilterHub(logFilter("first"), logFilter("second"))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"add","arguments":[1,2]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Result).To(Equal(3.0))
+			Expect(<-calls).To(Equal("first add"))
+			Expect(<-calls).To(Equal("second add"))
+		})
+	})
+
+	Context("When a filter changes the result", func() {
+		It("should return the changed result", func() {
+			conn := connectFilterHub(HubFilterFunc(func(invocation *HubInvocationContext, next func() ([]interface{}, error)) ([]interface{}, error) {
+				result, err := next()
+				return []interface{}{result[0].(int) * 10}, err
+			}))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"add","arguments":[1,2]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal(30.0))
+		})
+	})
+
+	Context("When a filter rejects the invocation", func() {
+		It("should not call the hub method and send the error", func() {
+			conn := connectFilterHub(HubFilterFunc(func(invocation *HubInvocationContext, next func() ([]interface{}, error)) ([]interface{}, error) {
+				if strings.EqualFold(invocation.MethodName, "forbidden") {
+					return nil, errors.New("forbidden")
+				}
+				return next()
+			}))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"forbidden"}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(Equal("forbidden"))
+			select {
+			case <-filterHubQueue:
+				Fail("hub method called")
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+})
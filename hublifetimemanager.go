@@ -1,30 +1,62 @@
 package signalr
 
 import (
+	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 )
 
 // HubLifetimeManager is a lifetime manager abstraction for hub instances
 // OnConnected() is called when a connection is started
 // OnDisconnected() is called when a connection is finished
 // InvokeAll() sends an invocation message to all hub connections
+// InvokeAllExcept() sends an invocation message to all hub connections except the excluded connections
 // InvokeClient() sends an invocation message to a specified hub connection
 // InvokeGroup() sends an invocation message to a specified group of hub connections
+// InvokeUser() sends an invocation message to all hub connections of a specified user
+// The Invoke methods return a channel which receives nil when the message was written to all connections,
+// otherwise the first error
 // AddToGroup() adds a connection to the specified group
 // RemoveFromGroup() removes a connection from the specified group
+// Abort() closes the specified connection without allowing the client to reconnect
 type HubLifetimeManager interface {
 	OnConnected(conn hubConnection)
 	OnDisconnected(conn hubConnection)
-	InvokeAll(target string, args []interface{})
-	InvokeClient(connectionID string, target string, args []interface{})
-	InvokeGroup(groupName string, target string, args []interface{})
+	InvokeAll(target string, args []interface{}) <-chan error
+	InvokeAllExcept(excludedConnectionIDs []string, target string, args []interface{}) <-chan error
+	InvokeClient(connectionID string, target string, args []interface{}) <-chan error
+	InvokeGroup(groupName string, target string, args []interface{}) <-chan error
+	InvokeUser(userID string, target string, args []interface{}) <-chan error
 	AddToGroup(groupName, connectionID string)
 	RemoveFromGroup(groupName, connectionID string)
+	Abort(connectionID string, reason string) <-chan error
 }
 
+var errConnectionAborted = errors.New("connection aborted")
+
 type defaultHubLifetimeManager struct {
 	clients sync.Map
-	groups  sync.Map
+	// store is the GroupStore of the server. Without store, the memberships are stored in memory
+	store     GroupStore
+	storeOnce sync.Once
+	info      StructuredLogger
+}
+
+func (d *defaultHubLifetimeManager) groupStore() GroupStore {
+	d.storeOnce.Do(func() {
+		if d.store == nil {
+			d.store = newMemoryGroupStore()
+		}
+	})
+	return d.store
+}
+
+func (d *defaultHubLifetimeManager) logGroupStoreError(event string, err error, groupName string, connectionID string) {
+	if err != nil && d.info != nil {
+		_ = d.info.Log(evt, event, "error", err, "group", groupName, "connection", connectionID,
+			react, "membership not stored")
+	}
 }
 
 func (d *defaultHubLifetimeManager) OnConnected(conn hubConnection) {
@@ -32,39 +64,169 @@ func (d *defaultHubLifetimeManager) OnConnected(conn hubConnection) {
 }
 
 func (d *defaultHubLifetimeManager) OnDisconnected(conn hubConnection) {
-	d.clients.Delete(conn.GetConnectionID())
+	connectionID := conn.GetConnectionID()
+	d.clients.Delete(connectionID)
+	// A closed connection must not stay in its groups
+	d.logGroupStoreError("remove connection from groups", d.groupStore().RemoveConnection(connectionID), "", connectionID)
+}
+
+func (d *defaultHubLifetimeManager) InvokeAll(target string, args []interface{}) <-chan error {
+	conns := make([]hubConnection, 0)
+	d.clients.Range(func(key, value interface{}) bool {
+		conns = append(conns, value.(hubConnection))
+		return true
+	})
+	return fanOut(conns, target, args)
 }
 
-func (d *defaultHubLifetimeManager) InvokeAll(target string, args []interface{}) {
+func (d *defaultHubLifetimeManager) InvokeAllExcept(excludedConnectionIDs []string, target string, args []interface{}) <-chan error {
+	conns := make([]hubConnection, 0)
 	d.clients.Range(func(key, value interface{}) bool {
-		value.(hubConnection).SendInvocation(target, args)
+		for _, excluded := range excludedConnectionIDs {
+			if key.(string) == excluded {
+				return true
+			}
+		}
+		conns = append(conns, value.(hubConnection))
 		return true
 	})
+	return fanOut(conns, target, args)
 }
 
-func (d *defaultHubLifetimeManager) InvokeClient(connectionID string, target string, args []interface{}) {
+func (d *defaultHubLifetimeManager) InvokeClient(connectionID string, target string, args []interface{}) <-chan error {
 	if client, ok := d.clients.Load(connectionID); ok {
-		client.(hubConnection).SendInvocation(target, args)
+		return client.(hubConnection).SendInvocation(target, args...)
+	}
+	return sendResult(fmt.Errorf("unknown connection %v", connectionID))
+}
+
+func (d *defaultHubLifetimeManager) InvokeGroup(groupName string, target string, args []interface{}) <-chan error {
+	members, err := d.groupMembers(groupName)
+	if err != nil {
+		return sendResult(err)
 	}
+	return fanOut(members, target, args)
+}
+
+func (d *defaultHubLifetimeManager) InvokeUser(userID string, target string, args []interface{}) <-chan error {
+	conns := make([]hubConnection, 0)
+	d.clients.Range(func(key, value interface{}) bool {
+		conn := value.(hubConnection)
+		if UserIDFromContext(conn.Context()) == userID {
+			conns = append(conns, conn)
+		}
+		return true
+	})
+	return fanOut(conns, target, args)
 }
 
-func (d *defaultHubLifetimeManager) InvokeGroup(groupName string, target string, args []interface{}) {
-	if groups, ok := d.groups.Load(groupName); ok {
-		for _, v := range groups.(map[string]hubConnection) {
-			v.SendInvocation(target, args)
+// groupMembers returns the members of the group which are connected to this server
+func (d *defaultHubLifetimeManager) groupMembers(groupName string) ([]hubConnection, error) {
+	connectionIDs, err := d.groupStore().GroupMembers(groupName)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]hubConnection, 0, len(connectionIDs))
+	for _, connectionID := range connectionIDs {
+		if client, ok := d.clients.Load(connectionID); ok {
+			members = append(members, client.(hubConnection))
 		}
 	}
+	return members, nil
 }
 
 func (d *defaultHubLifetimeManager) AddToGroup(groupName string, connectionID string) {
-	if client, ok := d.clients.Load(connectionID); ok {
-		groups, _ := d.groups.LoadOrStore(groupName, make(map[string]hubConnection))
-		groups.(map[string]hubConnection)[connectionID] = client.(hubConnection)
+	if _, ok := d.clients.Load(connectionID); ok {
+		d.logGroupStoreError("add to group", d.groupStore().AddToGroup(groupName, connectionID), groupName, connectionID)
 	}
 }
 
 func (d *defaultHubLifetimeManager) RemoveFromGroup(groupName string, connectionID string) {
-	if groups, ok := d.groups.Load(groupName); ok {
-		delete(groups.(map[string]hubConnection), connectionID)
+	d.logGroupStoreError("remove from group", d.groupStore().RemoveFromGroup(groupName, connectionID), groupName, connectionID)
+}
+
+func (d *defaultHubLifetimeManager) Abort(connectionID string, reason string) <-chan error {
+	if client, ok := d.clients.Load(connectionID); ok {
+		result := make(chan error, 1)
+		go func() {
+			client.(hubConnection).Abort(reason)
+			result <- nil
+		}()
+		return result
+	}
+	return sendResult(fmt.Errorf("unknown connection %v", connectionID))
+}
+
+// Broadcasts to more than fanOutBatchSize connections are queued by up to fanOutWorkers goroutines
+const (
+	fanOutBatchSize = 64
+	fanOutWorkers   = 16
+)
+
+// fanOut queues the invocation for all conns. The invocation is serialized once per protocol. Large broadcasts are queued by a bounded number of workers,
+// so a connection with a full outbound queue does not delay the other connections.
+// fanOut returns when the invocation is queued for all conns, so the order of consecutive broadcasts is kept
+func fanOut(conns []hubConnection, target string, args []interface{}) <-chan error {
+	if len(conns) == 1 {
+		return conns[0].SendInvocation(target, args...)
 	}
+	headers, args := splitHeaders(args)
+	if args == nil {
+		// Clients expect an array, even if there are no arguments
+		args = make([]interface{}, 0)
+	}
+	message := newPreparedMessage(sendOnlyHubInvocationMessage{Type: 1, Headers: headers, Target: target, Arguments: args})
+	results := make([]<-chan error, len(conns))
+	workers := (len(conns) + fanOutBatchSize - 1) / fanOutBatchSize
+	if workers > fanOutWorkers {
+		workers = fanOutWorkers
+	}
+	if workers <= 1 {
+		for i, conn := range conns {
+			results[i] = conn.SendPrepared(message)
+		}
+		return mergeSendResults(results)
+	}
+	next := int64(-1)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(conns) {
+					return
+				}
+				results[i] = conns[i].SendPrepared(message)
+			}
+		}()
+	}
+	wg.Wait()
+	return mergeSendResults(results)
+}
+
+// sendResult returns a channel which receives err
+func sendResult(err error) <-chan error {
+	result := make(chan error, 1)
+	result <- err
+	return result
+}
+
+// mergeSendResults returns a channel which receives nil when all sends succeeded, otherwise the first error
+func mergeSendResults(results []<-chan error) <-chan error {
+	if len(results) == 1 {
+		return results[0]
+	}
+	merged := make(chan error, 1)
+	go func() {
+		var firstErr error
+		for _, result := range results {
+			if err := <-result; err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		merged <- firstErr
+	}()
+	return merged
 }
@@ -0,0 +1,170 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type discardConnection struct {
+	connectionID string
+}
+
+func (d *discardConnection) Read([]byte) (n int, err error) {
+	select {}
+}
+
+func (d *discardConnection) Write(p []byte) (n int, err error) {
+	return len(p), nil
+}
+
+func (d *discardConnection) ConnectionID() string {
+	return d.connectionID
+}
+
+func newDiscardHubConnection(connectionID string) hubConnection {
+	protocol := &JSONHubProtocol{}
+	protocol.setDebugLogger(log.NewNopLogger())
+	return newHubConnection(context.Background(), &discardConnection{connectionID}, protocol, 0, log.NewNopLogger(), log.NewNopLogger())
+}
+
+func groupMembersOf(manager *defaultHubLifetimeManager, groupName string) []hubConnection {
+	members, err := manager.groupMembers(groupName)
+	Expect(err).To(BeNil())
+	return members
+}
+
+var _ = Describe("HubLifetimeManager", func() {
+
+	Context("When groups are changed and invoked concurrently", func() {
+		It("should not corrupt the group membership", func() {
+			manager := &defaultHubLifetimeManager{}
+			for i := 0; i < 20; i++ {
+				manager.OnConnected(newDiscardHubConnection(fmt.Sprint(i)))
+			}
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(connectionID string) {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						manager.AddToGroup("room", connectionID)
+						manager.InvokeGroup("room", "clientFunc", []interface{}{j})
+						manager.AddToGroup("other", connectionID)
+						manager.RemoveFromGroup("other", connectionID)
+					}
+				}(fmt.Sprint(i))
+			}
+			wg.Wait()
+			Expect(len(groupMembersOf(manager, "room"))).To(Equal(20))
+			Expect(len(groupMembersOf(manager, "other"))).To(Equal(0))
+		})
+	})
+
+	Context("When a connection in groups is disconnected", func() {
+		It("should remove it from all groups", func() {
+			manager := &defaultHubLifetimeManager{}
+			conns := []hubConnection{newDiscardHubConnection("1"), newDiscardHubConnection("2")}
+			for _, conn := range conns {
+				manager.OnConnected(conn)
+				manager.AddToGroup("room", conn.GetConnectionID())
+			}
+			manager.AddToGroup("other", "1")
+			manager.OnDisconnected(conns[0])
+			Expect(len(groupMembersOf(manager, "room"))).To(Equal(1))
+			Expect(groupMembersOf(manager, "room")[0].GetConnectionID()).To(Equal("2"))
+			Expect(len(groupMembersOf(manager, "other"))).To(Equal(0))
+		})
+	})
+
+	Context("When an unknown connection is added to a group", func() {
+		It("should ignore it", func() {
+			manager := &defaultHubLifetimeManager{}
+			manager.AddToGroup("room", "unknown")
+			Expect(len(groupMembersOf(manager, "room"))).To(Equal(0))
+		})
+	})
+})
+
+var _ = Describe("GroupManager", func() {
+
+	var manager *defaultHubLifetimeManager
+	var groups *defaultGroupManager
+
+	BeforeEach(func() {
+		manager = &defaultHubLifetimeManager{}
+		manager.OnConnected(newDiscardHubConnection("1"))
+		groups = &defaultGroupManager{lifetimeManager: manager}
+	})
+
+	Context("When a connection is added to a group with ttl", func() {
+		It("should be removed when the ttl expired", func() {
+			groups.AddToGroupFor("room", "1", 50*time.Millisecond)
+			Expect(len(groupMembersOf(manager, "room"))).To(Equal(1))
+			Eventually(func() int { return len(groupMembersOf(manager, "room")) }).Should(Equal(0))
+		})
+		It("should stay in the group when it was renewed", func() {
+			groups.AddToGroupFor("room", "1", 100*time.Millisecond)
+			time.Sleep(60 * time.Millisecond)
+			groups.AddToGroupFor("room", "1", 100*time.Millisecond)
+			time.Sleep(60 * time.Millisecond)
+			Expect(len(groupMembersOf(manager, "room"))).To(Equal(1))
+			Eventually(func() int { return len(groupMembersOf(manager, "room")) }).Should(Equal(0))
+		})
+		It("should stay in the group when it was added without ttl", func() {
+			groups.AddToGroupFor("room", "1", 50*time.Millisecond)
+			groups.AddToGroup("room", "1")
+			Consistently(func() int { return len(groupMembersOf(manager, "room")) }, 150*time.Millisecond).Should(Equal(1))
+		})
+	})
+
+	Context("When a connection with ttl memberships is disconnected", func() {
+		It("should stop the expirations", func() {
+			groups.AddToGroupFor("room", "1", time.Hour)
+			groups.AddToGroupFor("other", "1", time.Hour)
+			groups.onDisconnected("1")
+			groups.mx.Lock()
+			defer groups.mx.Unlock()
+			Expect(groups.expirations).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Broadcast fan-out", func() {
+
+	Context("When a broadcast reaches a connection with a full outbound queue", func() {
+		It("should deliver the message to the other connections", func() {
+			manager := &defaultHubLifetimeManager{}
+			conns := make([]*concurrencyDetectingConnection, 3*fanOutBatchSize)
+			for i := range conns {
+				conns[i] = &concurrencyDetectingConnection{discardConnection: discardConnection{fmt.Sprint(i)}}
+				hubConn := newHubConnection(context.Background(), conns[i], newJSONTestProtocol(), 0, log.NewNopLogger(), log.NewNopLogger())
+				manager.OnConnected(hubConn)
+			}
+			slow := &blockingConnection{discardConnection: discardConnection{"slow"}, release: make(chan struct{})}
+			slowConn := newHubConnection(context.Background(), slow, newJSONTestProtocol(), 0, log.NewNopLogger(), log.NewNopLogger())
+			slowConn.(*defaultHubConnection).outbound.configure(1, SlowClientBlock)
+			// One message is written, one is queued
+			slowConn.SendInvocation("a")
+			slowConn.SendInvocation("b")
+			manager.OnConnected(slowConn)
+			done := make(chan error, 1)
+			go func() { done <- <-manager.InvokeAll("broadcast", nil) }()
+			Eventually(func() int {
+				written := 0
+				for _, conn := range conns {
+					written += int(atomic.LoadInt32(&conn.writes))
+				}
+				return written
+			}).Should(Equal(len(conns)))
+			Expect(done).NotTo(Receive())
+			close(slow.release)
+			Eventually(done).Should(Receive(BeNil()))
+		})
+	})
+})
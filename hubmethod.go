@@ -0,0 +1,125 @@
+package signalr
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// hubMethod describes how a hub method is invoked. It is built once for each hub type, so invocations
+// need no reflection to find the method and to decide how its arguments are bound
+type hubMethod struct {
+	// index is the index of the method in the method set of the hub type. It is -1 for mapped methods
+	index  int
+	mapped *mappedMethod
+	// numIn is the number of parameters of the method, without receiver
+	numIn    int
+	params   []methodParameter
+	required int
+	maximum  int
+}
+
+type parameterKind int
+
+const (
+	contextParameter parameterKind = iota
+	streamParameter
+	argumentParameter
+	// variadicParameter binds the remaining arguments
+	variadicParameter
+)
+
+// methodParameter is the plan to bind a parameter of a hub method
+type methodParameter struct {
+	kind parameterKind
+	// t is the type of the argument, the element type for variadic parameters
+	t         reflect.Type
+	converter ArgumentConverter
+	number    bool
+}
+
+// unlimitedArguments is the maximum argument count of variadic methods
+const unlimitedArguments = math.MaxInt32
+
+// newHubMethod plans the invocation of a method of methodType, without receiver.
+// The context and the channels for client streaming are no arguments. Trailing pointer parameters and
+// the variadic parameter are optional
+func newHubMethod(methodType reflect.Type, index int, mapped *mappedMethod,
+	converters map[reflect.Type]ArgumentConverter) *hubMethod {
+	method := &hubMethod{index: index, mapped: mapped, numIn: methodType.NumIn(), params: make([]methodParameter, methodType.NumIn())}
+	for i := 0; i < methodType.NumIn(); i++ {
+		t := methodType.In(i)
+		param := &method.params[i]
+		switch {
+		case i == 0 && t == contextType:
+			param.kind = contextParameter
+		case t.Kind() == reflect.Chan && t.ChanDir() != reflect.SendDir:
+			param.kind = streamParameter
+		case methodType.IsVariadic() && i == methodType.NumIn()-1:
+			param.kind = variadicParameter
+			t = t.Elem()
+			method.maximum = unlimitedArguments
+		default:
+			param.kind = argumentParameter
+			method.maximum++
+			if t.Kind() != reflect.Ptr {
+				method.required = method.maximum
+			}
+		}
+		param.t = t
+		param.converter = converters[t]
+		param.number = param.converter == nil && isCoercedNumber(t)
+	}
+	return method
+}
+
+// methodCache holds the hubMethods of each hub type by lower case name
+type methodCache struct {
+	mx    sync.RWMutex
+	types map[reflect.Type]map[string]*hubMethod
+}
+
+// hubMethods returns the methods clients can invoke on hub: its own methods which are not excluded and the mapped methods
+func (s *Server) hubMethods(hub HubInterface) map[string]*hubMethod {
+	hubType := reflect.TypeOf(hub)
+	s.methods.mx.RLock()
+	methods, ok := s.methods.types[hubType]
+	s.methods.mx.RUnlock()
+	if ok {
+		return methods
+	}
+	hubValue := reflect.ValueOf(hub)
+	methods = make(map[string]*hubMethod)
+	for i := 0; i < hubType.NumMethod(); i++ {
+		name := strings.ToLower(hubType.Method(i).Name)
+		if !s.excludedMethods[name] {
+			methods[name] = newHubMethod(hubValue.Method(i).Type(), i, nil, s.argumentConverters)
+		}
+	}
+	for name, mapped := range s.mappedMethods {
+		if hubType.AssignableTo(mapped.method.Type().In(0)) {
+			methods[name] = newHubMethod(mapped.bound, -1, mapped, s.argumentConverters)
+		}
+	}
+	s.methods.mx.Lock()
+	defer s.methods.mx.Unlock()
+	if s.methods.types == nil {
+		s.methods.types = make(map[reflect.Type]map[string]*hubMethod)
+	}
+	s.methods.types[hubType] = methods
+	return methods
+}
+
+// getMethod returns the method of hub which is invoked by name. Mapped methods take precedence over
+// the methods of the hub, excluded methods of the hub are not found
+func (s *Server) getMethod(hub HubInterface, name string) (reflect.Value, *hubMethod, bool) {
+	method, ok := s.hubMethods(hub)[strings.ToLower(name)]
+	if !ok {
+		return reflect.Value{}, nil, false
+	}
+	if method.mapped != nil {
+		return method.mapped.bind(hub), method, true
+	}
+	return reflect.ValueOf(hub).Method(method.index), method, true
+}
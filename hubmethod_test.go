@@ -0,0 +1,47 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"reflect"
+)
+
+var _ = Describe("hubMethod", func() {
+
+	Context("When the server is created", func() {
+		It("should plan the methods of the hub type", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&mappedMethodHub{}),
+				MapMethod("post", (*mappedMethodHub).SendMessage), ExcludeMethods("helper"),
+				Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			methods := server.methods.types[reflect.TypeOf(&mappedMethodHub{})]
+			Expect(methods).To(HaveKey("sendmessage"))
+			Expect(methods).To(HaveKey("post"))
+			Expect(methods).NotTo(HaveKey("helper"))
+			Expect(methods["post"].mapped).NotTo(BeNil())
+			Expect(methods["sum"].params).To(HaveLen(1))
+			Expect(methods["sum"].params[0].kind).To(Equal(variadicParameter))
+			Expect(methods["sum"].params[0].t).To(Equal(reflect.TypeOf(0)))
+			Expect(methods["sum"].maximum).To(Equal(unlimitedArguments))
+		})
+	})
+
+	Context("When methods of different hub instances are looked up", func() {
+		It("should reuse the plan of the hub type", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&invocationHub{}),
+				Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			_, first, ok := server.getMethod(&invocationHub{}, "Greet")
+			Expect(ok).To(BeTrue())
+			_, second, ok := server.getMethod(&invocationHub{}, "greet")
+			Expect(ok).To(BeTrue())
+			Expect(second).To(BeIdenticalTo(first))
+			Expect(first.required).To(Equal(1))
+			Expect(first.maximum).To(Equal(3))
+			_, _, ok = server.getMethod(&invocationHub{}, "unknown")
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
@@ -1,7 +1,9 @@
 package signalr
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"io"
 )
 
@@ -15,43 +17,69 @@ type HubProtocol interface {
 	WriteMessage(message interface{}, writer io.Writer) error
 	UnmarshalArgument(argument interface{}, value interface{}) error
 	setDebugLogger(dbg StructuredLogger)
+	// setStrict lets the protocol reject messages which violate the protocol, see StrictProtocol
+	setStrict(strict bool)
+	// splitFrame finds the first frame in data, see bufio.SplitFunc. The token is the frame without its
+	// separator or length prefix
+	splitFrame(data []byte, atEOF bool) (advance int, token []byte, err error)
+	// parseFrame parses the message in a frame returned by splitFrame
+	parseFrame(frame []byte) (interface{}, error)
+	// unmarshalNumber unmarshals a numeric argument without losing precision. It returns nil for null
+	unmarshalNumber(argument interface{}) (interface{}, error)
 }
 
+// streamingHubProtocol is a HubProtocol which parses messages directly from the stream of the connection,
+// without collecting the data of each message in a separate buffer first
+type streamingHubProtocol interface {
+	HubProtocol
+	readMessageFrom(reader *bufio.Reader, maximumSize uint) (interface{}, error)
+}
+
+var errMessageTooLarge = errors.New("message too large")
+
 // Protocol
 type hubMessage struct {
 	Type int `json:"type"`
 }
 
 type invocationMessage struct {
-	Type         int
-	Target       string
-	InvocationID string
-	Arguments    []interface{}
-	StreamIds    []string
+	Type         int            `json:"type"`
+	Headers      MessageHeaders `json:"headers,omitempty"`
+	Target       string         `json:"target"`
+	InvocationID string         `json:"invocationId,omitempty"`
+	Arguments    []interface{}  `json:"arguments"`
+	StreamIds    []string       `json:"streamIds,omitempty"`
 }
 
 type sendOnlyHubInvocationMessage struct {
-	Type      int           `json:"type"`
-	Target    string        `json:"target"`
-	Arguments []interface{} `json:"arguments"`
+	Type      int            `json:"type"`
+	Headers   MessageHeaders `json:"headers,omitempty"`
+	Target    string         `json:"target"`
+	Arguments []interface{}  `json:"arguments"`
 }
 
 type completionMessage struct {
-	Type         int         `json:"type"`
-	InvocationID string      `json:"invocationId"`
-	Result       interface{} `json:"result,omitempty"`
-	Error        string      `json:"error,omitempty"`
+	Type         int            `json:"type"`
+	Headers      MessageHeaders `json:"headers,omitempty"`
+	InvocationID string         `json:"invocationId"`
+	Result       interface{}    `json:"result,omitempty"`
+	Error        string         `json:"error,omitempty"`
+	// ErrorCode and ErrorData are the Code and Data of a HubError
+	ErrorCode string      `json:"errorCode,omitempty"`
+	ErrorData interface{} `json:"errorData,omitempty"`
 }
 
 type streamItemMessage struct {
-	Type         int         `json:"type"`
-	InvocationID string      `json:"invocationId"`
-	Item         interface{} `json:"item"`
+	Type         int            `json:"type"`
+	Headers      MessageHeaders `json:"headers,omitempty"`
+	InvocationID string         `json:"invocationId"`
+	Item         interface{}    `json:"item"`
 }
 
 type cancelInvocationMessage struct {
-	Type         int    `json:"type"`
-	InvocationID string `json:"invocationId"`
+	Type         int            `json:"type"`
+	Headers      MessageHeaders `json:"headers,omitempty"`
+	InvocationID string         `json:"invocationId"`
 }
 
 type closeMessage struct {
@@ -60,7 +88,27 @@ type closeMessage struct {
 	AllowReconnect bool   `json:"allowReconnect"`
 }
 
+// ackMessage acknowledges all messages up to SequenceID for stateful reconnect
+type ackMessage struct {
+	Type       int   `json:"type"`
+	SequenceID int64 `json:"sequenceId"`
+}
+
+// sequenceMessage tells the sequence id of the next message after a stateful reconnect
+type sequenceMessage struct {
+	Type       int   `json:"type"`
+	SequenceID int64 `json:"sequenceId"`
+}
+
 type handshakeRequest struct {
-	Protocol string `json:"Protocol"`
+	Protocol string `json:"protocol"`
 	Version  int    `json:"version"`
+	// ItemsToken restores the Items of a previous connection, see PersistItems
+	ItemsToken string `json:"itemsToken,omitempty"`
+	// AcceptItemsTokens tells that the client handles ItemsTokenTarget. Other clients get no items tokens
+	AcceptItemsTokens bool `json:"acceptItemsTokens,omitempty"`
+}
+
+type handshakeResponse struct {
+	Error string `json:"error,omitempty"`
 }
@@ -1,6 +1,8 @@
 package signalr
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -33,6 +35,51 @@ func (i *invocationHub) SimpleString(value1 string, value2 string) string {
 	return strings.ToLower(value1 + value2)
 }
 
+func (i *invocationHub) SimpleError(fail bool) error {
+	invocationQueue <- fmt.Sprintf("SimpleError(%v)", fail)
+	if fail {
+		return errors.New("failed")
+	}
+	return nil
+}
+
+func (i *invocationHub) ValueOrError(value int) (int, error) {
+	invocationQueue <- fmt.Sprintf("ValueOrError(%v)", value)
+	if value < 0 {
+		return 0, errors.New("negative value")
+	}
+	return value * 2, nil
+}
+
+func (i *invocationHub) Greet(name string, greeting *string, times *int) string {
+	invocationQueue <- fmt.Sprintf("Greet(%v)", name)
+	text := "Hello"
+	if greeting != nil {
+		text = *greeting
+	}
+	count := 1
+	if times != nil {
+		count = *times
+	}
+	return strings.Repeat(text+" "+name+"!", count)
+}
+
+func (i *invocationHub) Log(level string, parts ...string) string {
+	invocationQueue <- fmt.Sprintf("Log(%v)", level)
+	return level + ": " + strings.Join(parts, " ")
+}
+
+func (i *invocationHub) ContextInt(ctx context.Context, value int) int {
+	invocationQueue <- fmt.Sprintf("ContextInt(%v)", value)
+	return value + 1
+}
+
+func (i *invocationHub) WaitForDisconnect(ctx context.Context) {
+	invocationQueue <- "WaitForDisconnect()"
+	<-ctx.Done()
+	invocationQueue <- "disconnected"
+}
+
 func (i *invocationHub) Async() chan bool {
 	r := make(chan bool)
 	go func() {
@@ -190,6 +237,83 @@ var _ = Describe("Invocation", func() {
 		})
 	})
 
+	Describe("Invocation of a method returning an error", func() {
+		Context("When the method returns nil", func() {
+			It("should return a completion without result and error", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "e1","target":"simpleerror","arguments":[false]}`)
+				Expect(<-invocationQueue).To(Equal("SimpleError(false)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("e1"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(Equal(""))
+			})
+		})
+		Context("When the method returns an error", func() {
+			It("should return a completion with the error", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "e2","target":"simpleerror","arguments":[true]}`)
+				Expect(<-invocationQueue).To(Equal("SimpleError(true)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("e2"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(Equal("failed"))
+			})
+		})
+	})
+
+	Describe("Invocation of a method returning a value and an error", func() {
+		Context("When the method returns no error", func() {
+			It("should return a completion with the value as result", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "ve1","target":"valueorerror","arguments":[21]}`)
+				Expect(<-invocationQueue).To(Equal("ValueOrError(21)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("ve1"))
+				Expect(recv.Result).To(Equal(42.0))
+				Expect(recv.Error).To(Equal(""))
+			})
+		})
+		Context("When the method returns an error", func() {
+			It("should return a completion with the error and no result", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "ve2","target":"valueorerror","arguments":[-1]}`)
+				Expect(<-invocationQueue).To(Equal("ValueOrError(-1)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("ve2"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(Equal("negative value"))
+			})
+		})
+	})
+
+	Describe("Invocation of a method with context", func() {
+		Context("When invoked by the client", func() {
+			It("should pass the client arguments after the context and return the result", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "ctx","target":"contextint","arguments":[1]}`)
+				Expect(<-invocationQueue).To(Equal("ContextInt(1)"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("ctx"))
+				Expect(recv.Result).To(Equal(2.0))
+			})
+		})
+		Context("When the connection is closed while the method is running", func() {
+			It("should cancel the context", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "wait","target":"waitfordisconnect"}`)
+				Expect(<-invocationQueue).To(Equal("WaitForDisconnect()"))
+				conn.ClientSend(`{"type":7}`)
+				select {
+				case r := <-invocationQueue:
+					Expect(r).To(Equal("disconnected"))
+				case <-time.After(time.Second):
+					Fail("timed out")
+				}
+			})
+		})
+	})
+
 	Describe("Async invocation", func() {
 		Context("When invoked by the client", func() {
 			It("should be invoked on the server and return true asynchronously", func() {
@@ -232,6 +356,60 @@ var _ = Describe("Invocation", func() {
 				Expect(recv.Result).To(BeNil())
 				Expect(recv.Error).NotTo(Equal(""))
 			})
+			It("should send the panic value without the stack", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "???","target":"panic"}`)
+				Expect(<-invocationQueue).To(Equal("Panic()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.Error).To(ContainSubstring("Don't panic!"))
+				Expect(recv.Error).NotTo(ContainSubstring("goroutine"))
+			})
+		})
+	})
+
+	Describe("Invocation with optional arguments", func() {
+		Context("When the client omits trailing pointer arguments", func() {
+			It("should pass nil for them", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "o1","target":"greet","arguments":["Bob"]}`)
+				Expect(<-invocationQueue).To(Equal("Greet(Bob)"))
+				Expect(receiveCompletion(conn).Result).To(Equal("Hello Bob!"))
+				conn.ClientSend(`{"type":1,"invocationId": "o2","target":"greet","arguments":["Bob", "Hi", 2]}`)
+				Expect(<-invocationQueue).To(Equal("Greet(Bob)"))
+				Expect(receiveCompletion(conn).Result).To(Equal("Hi Bob!Hi Bob!"))
+			})
+		})
+		Context("When the client sends too few or too many arguments", func() {
+			It("should return an error with the expected and provided argument count", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "o3","target":"greet","arguments":[]}`)
+				Expect(receiveCompletion(conn).Error).To(Equal("method greet expects 1 to 3 arguments, but 0 were provided"))
+				conn.ClientSend(`{"type":1,"invocationId": "o4","target":"simplestring","arguments":["a", "b", "c"]}`)
+				Expect(receiveCompletion(conn).Error).To(Equal("method simplestring expects 2 arguments, but 3 were provided"))
+			})
+		})
+	})
+
+	Describe("Invocation of a variadic method", func() {
+		Context("When the client sends any number of trailing arguments", func() {
+			It("should pass them as variadic parameter", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "v1","target":"log","arguments":["info", "a", "b", "c"]}`)
+				Expect(<-invocationQueue).To(Equal("Log(info)"))
+				Expect(receiveCompletion(conn).Result).To(Equal("info: a b c"))
+				conn.ClientSend(`{"type":1,"invocationId": "v2","target":"log","arguments":["debug"]}`)
+				Expect(<-invocationQueue).To(Equal("Log(debug)"))
+				Expect(receiveCompletion(conn).Result).To(Equal("debug: "))
+			})
+		})
+		Context("When a variadic argument has the wrong type", func() {
+			It("should return an error", func() {
+				conn := connect(&invocationHub{})
+				conn.ClientSend(`{"type":1,"invocationId": "v3","target":"log","arguments":["info", "a", 1]}`)
+				Expect(receiveCompletion(conn).Error).NotTo(BeEmpty())
+				conn.ClientSend(`{"type":1,"invocationId": "v4","target":"log","arguments":[]}`)
+				Expect(receiveCompletion(conn).Error).To(Equal("method log expects at least 1 arguments, but 0 were provided"))
+			})
 		})
 	})
 
@@ -0,0 +1,49 @@
+package signalr
+
+import "sync"
+
+// invocationLimiter runs at most maxParallel invocations of one connection at once.
+// Further invocations wait and are run in the order they were queued. maxParallel 0 means no limit
+type invocationLimiter struct {
+	mx          sync.Mutex
+	maxParallel uint
+	running     uint
+	waiting     []func()
+}
+
+func newInvocationLimiter(maxParallel uint) *invocationLimiter {
+	return &invocationLimiter{maxParallel: maxParallel}
+}
+
+// run runs invocation in its own goroutine as soon as less than maxParallel invocations are running
+func (l *invocationLimiter) run(invocation func()) {
+	if l.maxParallel == 0 {
+		go invocation()
+		return
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.running < l.maxParallel {
+		l.running++
+		go l.work(invocation)
+	} else {
+		l.waiting = append(l.waiting, invocation)
+	}
+}
+
+// work runs invocation and then the waiting invocations until none is left
+func (l *invocationLimiter) work(invocation func()) {
+	for invocation != nil {
+		invocation()
+		l.mx.Lock()
+		if len(l.waiting) > 0 {
+			invocation = l.waiting[0]
+			l.waiting[0] = nil
+			l.waiting = l.waiting[1:]
+		} else {
+			invocation = nil
+			l.running--
+		}
+		l.mx.Unlock()
+	}
+}
@@ -0,0 +1,85 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+type parallelHub struct {
+	Hub
+}
+
+var parallelHubStarted = make(chan string, 10)
+var parallelHubRelease = make(chan struct{}, 10)
+
+func (p *parallelHub) Wait(name string) {
+	parallelHubStarted <- name
+	<-parallelHubRelease
+}
+
+func connectParallel(options ...Option) *testingConnection {
+	server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&parallelHub{}),
+		Logger(log.NewNopLogger(), false)}, options...)...)
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	go server.Run(conn)
+	return conn
+}
+
+func sendWaits(conn *testingConnection, count int) {
+	for i := 0; i < count; i++ {
+		conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"%v","target":"wait","arguments":["%v"]}`, i, i))
+	}
+}
+
+var _ = Describe("MaximumParallelInvocationsPerClient", func() {
+
+	AfterEach(func() {
+		for len(parallelHubStarted) > 0 {
+			<-parallelHubStarted
+		}
+	})
+
+	Context("When the default is used", func() {
+		It("should run the invocations of a client one after another in the order they were received", func() {
+			conn := connectParallel()
+			sendWaits(conn, 3)
+			for i := 0; i < 3; i++ {
+				Eventually(parallelHubStarted).Should(Receive(Equal(fmt.Sprint(i))))
+				Consistently(parallelHubStarted, 100*time.Millisecond).ShouldNot(Receive())
+				parallelHubRelease <- struct{}{}
+			}
+		})
+	})
+
+	Context("When more invocations are allowed", func() {
+		It("should run as many invocations in parallel", func() {
+			conn := connectParallel(MaximumParallelInvocationsPerClient(2))
+			sendWaits(conn, 3)
+			Eventually(parallelHubStarted).Should(Receive())
+			Eventually(parallelHubStarted).Should(Receive())
+			Consistently(parallelHubStarted, 100*time.Millisecond).ShouldNot(Receive())
+			parallelHubRelease <- struct{}{}
+			Eventually(parallelHubStarted).Should(Receive())
+			parallelHubRelease <- struct{}{}
+			parallelHubRelease <- struct{}{}
+		})
+	})
+
+	Context("When there is no limit", func() {
+		It("should run all invocations in parallel", func() {
+			conn := connectParallel(MaximumParallelInvocationsPerClient(0))
+			sendWaits(conn, 3)
+			for i := 0; i < 3; i++ {
+				Eventually(parallelHubStarted).Should(Receive())
+			}
+			for i := 0; i < 3; i++ {
+				parallelHubRelease <- struct{}{}
+			}
+		})
+	})
+})
@@ -0,0 +1,137 @@
+package signalr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// InvocationTimeoutErrorCode is the Code of the HubError which is sent when a hub method invocation times out
+const InvocationTimeoutErrorCode = "InvocationTimeout"
+
+// methodInvocationTimeout returns the timeout of the hub method, or 0 if it has none
+func (s *Server) methodInvocationTimeout(method string) time.Duration {
+	if timeout, ok := s.methodInvocationTimeouts[strings.ToLower(method)]; ok {
+		return timeout
+	}
+	return s.invocationTimeout
+}
+
+// invocationDeadline cancels the context of a hub method invocation which has not completed during its timeout
+// and sends a completion with error to the caller.
+// It passes only the first completion of the invocation on to the hubConnection, so after a timeout the completion
+// of the hub method is dropped
+type invocationDeadline struct {
+	hubConnection
+	invocation invocationMessage
+	timeout    time.Duration
+	cancel     context.CancelFunc
+	info       StructuredLogger
+	timer      *time.Timer
+	state      int32
+	expired    chan struct{}
+}
+
+// states of an invocationDeadline
+const (
+	deadlineRunning int32 = iota
+	deadlineCompleted
+	deadlineExpired
+)
+
+// newInvocationDeadline returns the context for the invocation, which is canceled when the invocation times out,
+// and the deadline, which has to be used as hubConnection of the invocation
+func newInvocationDeadline(ctx context.Context, conn hubConnection, invocation invocationMessage, timeout time.Duration,
+	info StructuredLogger) (context.Context, *invocationDeadline) {
+	ctx, cancel := context.WithCancel(ctx)
+	return ctx, &invocationDeadline{
+		hubConnection: conn,
+		invocation:    invocation,
+		timeout:       timeout,
+		cancel:        cancel,
+		info:          info,
+		expired:       make(chan struct{}),
+	}
+}
+
+// start starts the timeout. It is called when the hub method starts running
+func (d *invocationDeadline) start() {
+	d.timer = time.AfterFunc(d.timeout, d.expire)
+}
+
+// complete stops the timeout. It returns false if the invocation has already completed or timed out
+func (d *invocationDeadline) complete() bool {
+	if !atomic.CompareAndSwapInt32(&d.state, deadlineRunning, deadlineCompleted) {
+		return false
+	}
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	return true
+}
+
+// returned is called when the hub method has returned. The timeout ends with the start of a stream and
+// with invocations without completion. Other invocations end with their completion
+func (d *invocationDeadline) returned(streaming bool) {
+	switch {
+	case streaming:
+		// The stream ends the context when it ends
+		d.complete()
+	case d.invocation.InvocationID == "":
+		if d.complete() {
+			d.cancel()
+		}
+	}
+}
+
+func (d *invocationDeadline) expire() {
+	if !atomic.CompareAndSwapInt32(&d.state, deadlineRunning, deadlineExpired) {
+		return
+	}
+	close(d.expired)
+	d.cancel()
+	_ = d.info.Log(evt, "invocation timeout", "name", d.invocation.Target, "timeout", d.timeout,
+		react, "cancel invocation and send completion with error")
+	if d.invocation.InvocationID != "" {
+		d.hubConnection.CompletionError(d.invocation.InvocationID, &HubError{
+			Message: fmt.Sprintf("invocation of %v timed out after %v", d.invocation.Target, d.timeout),
+			Code:    InvocationTimeoutErrorCode,
+		})
+	}
+}
+
+// timedOut tells if the invocation has timed out
+func (d *invocationDeadline) timedOut() bool {
+	return atomic.LoadInt32(&d.state) == deadlineExpired
+}
+
+func (d *invocationDeadline) Completion(id string, result interface{}, error string) <-chan error {
+	if id != d.invocation.InvocationID {
+		return d.hubConnection.Completion(id, result, error)
+	}
+	if d.complete(); d.timedOut() {
+		return sendResult(nil)
+	}
+	defer d.cancel()
+	return d.hubConnection.Completion(id, result, error)
+}
+
+func (d *invocationDeadline) CompletionError(id string, err error) <-chan error {
+	if id != d.invocation.InvocationID {
+		return d.hubConnection.CompletionError(id, err)
+	}
+	if d.complete(); d.timedOut() {
+		return sendResult(nil)
+	}
+	defer d.cancel()
+	return d.hubConnection.CompletionError(id, err)
+}
+
+func (d *invocationDeadline) StreamItem(id string, item interface{}) <-chan error {
+	if id == d.invocation.InvocationID && d.timedOut() {
+		return sendResult(nil)
+	}
+	return d.hubConnection.StreamItem(id, item)
+}
@@ -0,0 +1,138 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+type timeoutHub struct {
+	Hub
+}
+
+var timeoutHubCanceled = make(chan error, 10)
+var timeoutHubRelease = make(chan struct{})
+
+func (t *timeoutHub) Block(ctx context.Context) {
+	<-ctx.Done()
+	timeoutHubCanceled <- ctx.Err()
+}
+
+func (t *timeoutHub) Stuck() int {
+	<-timeoutHubRelease
+	return 1
+}
+
+func (t *timeoutHub) Slow() int {
+	time.Sleep(200 * time.Millisecond)
+	return 2
+}
+
+func (t *timeoutHub) Ticks() <-chan int {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 1; i <= 3; i++ {
+			time.Sleep(50 * time.Millisecond)
+			ch <- i
+		}
+	}()
+	return ch
+}
+
+func (t *timeoutHub) Add(a, b int) int {
+	return a + b
+}
+
+func connectTimeoutHub(options ...Option) *testingConnection {
+	options = append(options, SimpleHubFactory(&timeoutHub{}), Logger(log.NewNopLogger(), false))
+	server, err := NewServer(context.Background(), options...)
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	go server.Run(conn)
+	return conn
+}
+
+var _ = Describe("InvocationTimeout", func() {
+
+	Context("When a hub method runs longer than the InvocationTimeout", func() {
+		It("should cancel its context and send a completion with error", func() {
+			conn := connectTimeoutHub(InvocationTimeout(100 * time.Millisecond))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"block","arguments":[]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.InvocationID).To(Equal("1"))
+			Expect(completion.Error).To(Equal("invocation of block timed out after 100ms"))
+			Expect(completion.ErrorCode).To(Equal(InvocationTimeoutErrorCode))
+			Eventually(timeoutHubCanceled).Should(Receive(Equal(context.Canceled)))
+		})
+		It("should run the next invocations and drop the result of the hub method", func() {
+			conn := connectTimeoutHub(InvocationTimeout(100 * time.Millisecond))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"stuck","arguments":[]}`)
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"add","arguments":[1,2]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.InvocationID).To(Equal("1"))
+			Expect(completion.ErrorCode).To(Equal(InvocationTimeoutErrorCode))
+			completion = receiveCompletion(conn)
+			Expect(completion.InvocationID).To(Equal("2"))
+			Expect(completion.Result).To(Equal(3.0))
+			timeoutHubRelease <- struct{}{}
+			Consistently(conn.received, 200*time.Millisecond).ShouldNot(Receive(BeAssignableToTypeOf(completionMessage{})))
+		})
+	})
+
+	Context("When a hub method completes during the InvocationTimeout", func() {
+		It("should send its result", func() {
+			conn := connectTimeoutHub(InvocationTimeout(time.Second))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"add","arguments":[1,2]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(BeEmpty())
+			Expect(completion.Result).To(Equal(3.0))
+		})
+	})
+
+	Context("When a stream is started during the InvocationTimeout", func() {
+		It("should run until it ends", func() {
+			conn := connectTimeoutHub(InvocationTimeout(80 * time.Millisecond))
+			conn.ClientSend(`{"type":4,"invocationId":"1","target":"ticks","arguments":[]}`)
+			var items []interface{}
+			for len(items) < 3 {
+				select {
+				case message := <-conn.received:
+					switch message := message.(type) {
+					case streamItemMessage:
+						items = append(items, message.Item)
+					case completionMessage:
+						Fail("completion before the end of the stream: " + message.Error)
+					}
+				case <-time.After(time.Second):
+					Fail("timed out")
+				}
+			}
+			Expect(items).To(Equal([]interface{}{1.0, 2.0, 3.0}))
+			Expect(receiveCompletion(conn).Error).To(BeEmpty())
+		})
+	})
+
+	Context("When a MethodInvocationTimeout is set", func() {
+		It("should override the InvocationTimeout", func() {
+			conn := connectTimeoutHub(InvocationTimeout(50*time.Millisecond), MethodInvocationTimeout("Slow", 0))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"slow","arguments":[]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal(2.0))
+			conn = connectTimeoutHub(MethodInvocationTimeout("slow", 50*time.Millisecond))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"slow","arguments":[]}`)
+			Expect(receiveCompletion(conn).ErrorCode).To(Equal(InvocationTimeoutErrorCode))
+		})
+	})
+
+	Context("When the timeout is negative", func() {
+		It("should not create the server", func() {
+			for _, option := range []Option{InvocationTimeout(-1), MethodInvocationTimeout("add", -1)} {
+				_, err := NewServer(context.Background(), SimpleHubFactory(&timeoutHub{}), option,
+					Logger(log.NewNopLogger(), false))
+				Expect(err).NotTo(BeNil())
+			}
+		})
+	})
+})
@@ -0,0 +1,45 @@
+package signalr
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Items is a concurrency safe key/value store holding the state of a hubs connection.
+// A hub is created for each invocation, so state which should outlive the invocation has to be stored here
+type Items struct {
+	// version counts the changes, so the items token is only renewed after changes, see PersistItems
+	version uint64
+	m       sync.Map
+}
+
+// Get returns the value stored for key. ok is false if no value is stored for key
+func (i *Items) Get(key string) (value interface{}, ok bool) {
+	return i.m.Load(key)
+}
+
+// Set stores value for key
+func (i *Items) Set(key string, value interface{}) {
+	i.m.Store(key, value)
+	atomic.AddUint64(&i.version, 1)
+}
+
+// Delete removes the value stored for key
+func (i *Items) Delete(key string) {
+	i.m.Delete(key)
+	atomic.AddUint64(&i.version, 1)
+}
+
+func (i *Items) currentVersion() uint64 {
+	return atomic.LoadUint64(&i.version)
+}
+
+// snapshot returns a copy of all items
+func (i *Items) snapshot() map[string]interface{} {
+	items := make(map[string]interface{})
+	i.m.Range(func(key, value interface{}) bool {
+		items[key.(string)] = value
+		return true
+	})
+	return items
+}
@@ -0,0 +1,126 @@
+package signalr
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ItemsTokenTarget is the client method the server invokes with the items token of the connection, see PersistItems.
+// The Client handles it itself and sends the last token with the handshake when it connects again
+const ItemsTokenTarget = "signalr.itemsToken"
+
+// itemsTokenizer encrypts the Items of connections into tokens and restores them
+type itemsTokenizer struct {
+	aead   cipher.AEAD
+	maxAge time.Duration
+}
+
+// itemsTokenContent is the encrypted content of an items token
+type itemsTokenContent struct {
+	Issued int64                  `json:"issued"`
+	Items  map[string]interface{} `json:"items"`
+}
+
+func newItemsTokenizer(key []byte, maxAge time.Duration) (*itemsTokenizer, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &itemsTokenizer{aead: aead, maxAge: maxAge}, nil
+}
+
+// token encrypts the items. They must be JSON serializable. The token is bound to the userID, it can only be
+// restored by connections of the same user
+func (t *itemsTokenizer) token(userID string, items map[string]interface{}) (string, error) {
+	plaintext, err := json.Marshal(itemsTokenContent{Issued: time.Now().Unix(), Items: items})
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, t.aead.NonceSize(), t.aead.NonceSize()+len(plaintext)+t.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(t.aead.Seal(nonce, nonce, plaintext, []byte(userID))), nil
+}
+
+var errInvalidItemsToken = errors.New("invalid items token")
+
+// items decrypts the token. It fails if the token was not created with the same key for the same user
+// or is older than maxAge
+func (t *itemsTokenizer) items(userID string, token string) (map[string]interface{}, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) < t.aead.NonceSize() {
+		return nil, errInvalidItemsToken
+	}
+	nonce, ciphertext := data[:t.aead.NonceSize()], data[t.aead.NonceSize():]
+	plaintext, err := t.aead.Open(nil, nonce, ciphertext, []byte(userID))
+	if err != nil {
+		return nil, errInvalidItemsToken
+	}
+	var content itemsTokenContent
+	if err := json.Unmarshal(plaintext, &content); err != nil {
+		return nil, errInvalidItemsToken
+	}
+	if t.maxAge > 0 {
+		if age := time.Since(time.Unix(content.Issued, 0)); age > t.maxAge {
+			return nil, fmt.Errorf("items token expired %v ago", age-t.maxAge)
+		}
+	}
+	return content.Items, nil
+}
+
+// restoreItems sets the items of the token in the handshake request in the Items of the new connection
+func (sl *serverLoop) restoreItems(request handshakeRequest) {
+	tokenizer := sl.server.itemsTokenizer
+	if tokenizer == nil {
+		return
+	}
+	// Clients which do not handle ItemsTokenTarget must not get tokens
+	sl.itemsTokens = request.AcceptItemsTokens || request.ItemsToken != ""
+	if request.ItemsToken == "" {
+		return
+	}
+	items, err := tokenizer.items(UserIDFromContext(sl.ctx), request.ItemsToken)
+	if err != nil {
+		_ = sl.info.Log(evt, "restoreItems", "error", err, react, "connect without items")
+		return
+	}
+	for key, value := range items {
+		sl.hubConn.Items().Set(key, value)
+	}
+	sl.itemsVersion = sl.hubConn.Items().currentVersion()
+}
+
+// sendItemsToken sends the token of the Items to the client if they changed since the last token
+func (sl *serverLoop) sendItemsToken() {
+	tokenizer := sl.server.itemsTokenizer
+	if tokenizer == nil || !sl.itemsTokens {
+		return
+	}
+	sl.itemsMx.Lock()
+	defer sl.itemsMx.Unlock()
+	items := sl.hubConn.Items()
+	version := items.currentVersion()
+	if version == sl.itemsVersion {
+		return
+	}
+	// Items which can not be serialized are not tried again before they change
+	sl.itemsVersion = version
+	token, err := tokenizer.token(UserIDFromContext(sl.ctx), items.snapshot())
+	if err != nil {
+		_ = sl.info.Log(evt, "sendItemsToken", "error", err, react, "do not send token")
+		return
+	}
+	sl.hubConn.SendInvocation(ItemsTokenTarget, token)
+}
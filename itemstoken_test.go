@@ -0,0 +1,233 @@
+package signalr
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+type itemsTokenHub struct {
+	Hub
+}
+
+func (i *itemsTokenHub) OnConnected(string) {
+	if _, ok := i.Items().Get("connected"); !ok {
+		i.Items().Set("connected", true)
+	}
+}
+
+func (i *itemsTokenHub) SetSession(name string, count int) {
+	i.Items().Set("name", name)
+	i.Items().Set("count", count)
+}
+
+func (i *itemsTokenHub) Session() (string, float64) {
+	name, _ := i.Items().Get("name")
+	count, _ := i.Items().Get("count")
+	if name == nil {
+		return "", 0
+	}
+	// Restored numbers are float64
+	if c, ok := count.(int); ok {
+		return name.(string), float64(c)
+	}
+	return name.(string), count.(float64)
+}
+
+var itemsTokenKey = []byte("0123456789abcdef")
+
+func startItemsTokenServer(options ...Option) *httptest.Server {
+	server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&itemsTokenHub{}),
+		Logger(log.NewNopLogger(), false)}, options...)...)
+	Expect(err).To(BeNil())
+	router := http.NewServeMux()
+	server.MapHTTP(router, "/hub")
+	return httptest.NewServer(router)
+}
+
+func expectSession(client *Client, name string, count float64) {
+	var session []interface{}
+	Expect(client.Invoke(context.Background(), "session", &session)).To(BeNil())
+	Expect(session).To(Equal([]interface{}{name, count}))
+}
+
+var _ = Describe("PersistItems", func() {
+
+	Context("When the items of a connection change", func() {
+		It("should send a token which restores them in another connection", func() {
+			testServer := startItemsTokenServer(PersistItems(itemsTokenKey, time.Hour))
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			// The token of the items set by OnConnected
+			Eventually(client.ItemsToken).ShouldNot(BeEmpty())
+			connectedToken := client.ItemsToken()
+			Expect(client.Invoke(context.Background(), "setSession", nil, "alice", 3)).To(BeNil())
+			token := client.ItemsToken()
+			Expect(token).NotTo(Equal(connectedToken))
+			// Unchanged items send no new token
+			expectSession(client, "alice", 3)
+			Expect(client.ItemsToken()).To(Equal(token))
+			other, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false), WithItemsToken(token))
+			Expect(err).To(BeNil())
+			defer other.Close()
+			Expect(other.ConnectionID()).NotTo(Equal(client.ConnectionID()))
+			expectSession(other, "alice", 3)
+		})
+		It("should restore them when the client reconnects", func() {
+			testServer := startItemsTokenServer(PersistItems(itemsTokenKey, 0))
+			defer testServer.Close()
+			reconnected := make(chan string, 1)
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false), WithAutomaticReconnect(0),
+				WithReconnected(func(connectionID string) { reconnected <- connectionID }))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(client.Invoke(context.Background(), "setSession", nil, "bob", 1)).To(BeNil())
+			_ = client.currentConnection().transport.Close()
+			Eventually(reconnected).Should(Receive())
+			expectSession(client, "bob", 1)
+		})
+	})
+
+	Context("When the server does not persist items", func() {
+		It("should send no token and ignore the tokens of clients", func() {
+			testServer := startItemsTokenServer()
+			defer testServer.Close()
+			tokenizer, err := newItemsTokenizer(itemsTokenKey, 0)
+			Expect(err).To(BeNil())
+			token, err := tokenizer.token("", map[string]interface{}{"name": "alice", "count": 3})
+			Expect(err).To(BeNil())
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false), WithItemsToken(token))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(client.Invoke(context.Background(), "setSession", nil, "bob", 1)).To(BeNil())
+			Expect(client.ItemsToken()).To(Equal(token))
+			other, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false), WithItemsToken(token))
+			Expect(err).To(BeNil())
+			defer other.Close()
+			expectSession(other, "", 0)
+		})
+	})
+
+	Context("When the token was issued for another user", func() {
+		It("should connect without the items", func() {
+			testServer := startItemsTokenServer(PersistItems(itemsTokenKey, 0),
+				Authenticate(func(req *http.Request) (Claims, error) {
+					return Claims{"sub": BearerToken(req)}, nil
+				}))
+			defer testServer.Close()
+			alice, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false),
+				WithHTTPHeaders(http.Header{"Authorization": {"Bearer alice"}}))
+			Expect(err).To(BeNil())
+			defer alice.Close()
+			Expect(alice.Invoke(context.Background(), "setSession", nil, "alice", 3)).To(BeNil())
+			token := alice.ItemsToken()
+			for user, session := range map[string][]interface{}{"alice": {"alice", 3.0}, "bob": {"", 0.0}} {
+				client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false), WithItemsToken(token),
+					WithHTTPHeaders(http.Header{"Authorization": {"Bearer " + user}}))
+				Expect(err).To(BeNil())
+				expectSession(client, session[0].(string), session[1].(float64))
+				client.Close()
+			}
+		})
+	})
+
+	Context("When a client does not accept items tokens", func() {
+		It("should send it no tokens", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&itemsTokenHub{}), Logger(log.NewNopLogger(), false),
+				PersistItems(itemsTokenKey, 0))
+			Expect(err).To(BeNil())
+			for _, accept := range []bool{false, true} {
+				conn := newTestingConnectionBeforeHandshake()
+				if accept {
+					conn.ClientSend(`{"protocol":"json","version":1,"acceptItemsTokens":true}`)
+				} else {
+					conn.ClientSend(`{"protocol":"json","version":1}`)
+				}
+				conn.SetConnected(true)
+				go server.Run(conn)
+				conn.ClientSend(`{"type":1,"invocationId":"1","target":"setSession","arguments":["alice",3]}`)
+				var targets []string
+				for {
+					message := <-conn.received
+					if invocation, ok := message.(invocationMessage); ok {
+						targets = append(targets, invocation.Target)
+					}
+					if _, ok := message.(completionMessage); ok {
+						break
+					}
+				}
+				if accept {
+					// The tokens of OnConnected and of setSession
+					Expect(targets).To(Equal([]string{ItemsTokenTarget, ItemsTokenTarget}))
+				} else {
+					Expect(targets).To(BeEmpty())
+				}
+			}
+		})
+	})
+
+	Context("When the token is invalid", func() {
+		It("should connect without the items", func() {
+			testServer := startItemsTokenServer(PersistItems(itemsTokenKey, time.Hour))
+			defer testServer.Close()
+			otherKey, err := newItemsTokenizer([]byte("fedcba9876543210"), 0)
+			Expect(err).To(BeNil())
+			token, err := otherKey.token("", map[string]interface{}{"name": "alice", "count": 3})
+			Expect(err).To(BeNil())
+			for _, token := range []string{token, "invalid", token[:10]} {
+				client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false), WithItemsToken(token))
+				Expect(err).To(BeNil())
+				expectSession(client, "", 0)
+				client.Close()
+			}
+		})
+	})
+
+	Context("When tokens are created", func() {
+		It("should restore the items only with the same key for the same user and before maxAge", func() {
+			tokenizer, err := newItemsTokenizer(itemsTokenKey, time.Minute)
+			Expect(err).To(BeNil())
+			token, err := tokenizer.token("alice", map[string]interface{}{"name": "alice", "tags": []string{"a"}})
+			Expect(err).To(BeNil())
+			items, err := tokenizer.items("alice", token)
+			Expect(err).To(BeNil())
+			Expect(items).To(Equal(map[string]interface{}{"name": "alice", "tags": []interface{}{"a"}}))
+			// Tokens of other users are rejected
+			for _, userID := range []string{"bob", ""} {
+				_, err = tokenizer.items(userID, token)
+				Expect(err).To(Equal(errInvalidItemsToken))
+			}
+			// Tampered tokens are rejected
+			data, _ := base64.RawURLEncoding.DecodeString(token)
+			data[len(data)-1] ^= 1
+			_, err = tokenizer.items("alice", base64.RawURLEncoding.EncodeToString(data))
+			Expect(err).To(Equal(errInvalidItemsToken))
+			// Expired tokens are rejected
+			plaintext, _ := json.Marshal(itemsTokenContent{Issued: time.Now().Add(-time.Hour).Unix(),
+				Items: map[string]interface{}{"name": "alice"}})
+			nonce := make([]byte, tokenizer.aead.NonceSize())
+			expired := base64.RawURLEncoding.EncodeToString(tokenizer.aead.Seal(nonce, nonce, plaintext, nil))
+			_, err = tokenizer.items("", expired)
+			Expect(err).To(MatchError(ContainSubstring("expired")))
+			_, err = tokenizer.token("", map[string]interface{}{"func": func() {}})
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When the options are invalid", func() {
+		It("should not create the server", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&itemsTokenHub{}), PersistItems([]byte("short"), 0))
+			Expect(err).NotTo(BeNil())
+			_, err = NewServer(context.Background(), SimpleHubFactory(&itemsTokenHub{}), PersistItems(itemsTokenKey, -time.Second))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
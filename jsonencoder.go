@@ -0,0 +1,33 @@
+package signalr
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// JSONEncoder serializes the messages of the JSON hub protocol.
+// The APIs of jsoniter (e.g. jsoniter.ConfigCompatibleWithStandardLibrary) and sonic (e.g. sonic.ConfigStd)
+// implement JSONEncoder and can be used without adapter. The encoder must support json.RawMessage and the json struct tags
+type JSONEncoder interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// stdJSONEncoder is the JSONEncoder using encoding/json
+type stdJSONEncoder struct {
+	// useNumber unmarshals numbers into interface{} values as json.Number instead of float64
+	useNumber bool
+}
+
+func (stdJSONEncoder) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (e stdJSONEncoder) Unmarshal(data []byte, v interface{}) error {
+	if !e.useNumber {
+		return json.Unmarshal(data, v)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,78 @@
+package signalr
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+)
+
+// countingJSONEncoder is a JSONEncoder using encoding/json which counts its calls
+type countingJSONEncoder struct {
+	mx         sync.Mutex
+	marshals   int
+	unmarshals int
+}
+
+func (c *countingJSONEncoder) Marshal(v interface{}) ([]byte, error) {
+	c.mx.Lock()
+	c.marshals++
+	c.mx.Unlock()
+	return json.Marshal(v)
+}
+
+func (c *countingJSONEncoder) Unmarshal(data []byte, v interface{}) error {
+	c.mx.Lock()
+	c.unmarshals++
+	c.mx.Unlock()
+	return json.Unmarshal(data, v)
+}
+
+func (c *countingJSONEncoder) counts() (int, int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.marshals, c.unmarshals
+}
+
+var _ = Describe("JSONEncoder", func() {
+
+	Context("When the server uses the JSONEncoding option", func() {
+		It("should serialize the hub messages with the encoder", func() {
+			encoder := &countingJSONEncoder{}
+			conn := connectConverterHub(JSONEncoding(encoder))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"optional","arguments":[null]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(BeEmpty())
+			Expect(completion.Result).To(Equal(true))
+			marshals, unmarshals := encoder.counts()
+			Expect(marshals).To(BeNumerically(">", 0))
+			// hubMessage, invocation and argument
+			Expect(unmarshals).To(BeNumerically(">=", 3))
+		})
+	})
+
+	Context("When the JSONHubProtocol has an encoder", func() {
+		It("should read and write messages with it", func() {
+			encoder := &countingJSONEncoder{}
+			protocol := &JSONHubProtocol{encoder: encoder}
+			protocol.setDebugLogger(log.NewNopLogger())
+			buf := &bytes.Buffer{}
+			Expect(protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "1", Result: 1}, buf)).To(BeNil())
+			message, complete, err := protocol.ReadMessage(buf)
+			Expect(err).To(BeNil())
+			Expect(complete).To(BeTrue())
+			Expect(message.(completionMessage).InvocationID).To(Equal("1"))
+			marshals, unmarshals := encoder.counts()
+			Expect(marshals).To(Equal(1))
+			Expect(unmarshals).To(Equal(2))
+		})
+	})
+
+	Context("When the JSONEncoding option gets no encoder", func() {
+		It("should return an error", func() {
+			Expect(JSONEncoding(nil)(&Server{})).NotTo(BeNil())
+		})
+	})
+})
@@ -1,22 +1,29 @@
 package signalr
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/go-kit/kit/log"
 	"io"
 )
 
 // JSONHubProtocol is the JSON based SignalR protocol
 type JSONHubProtocol struct {
-	dbg log.Logger
+	dbg StructuredLogger
+	// encoder is nil for encoding/json
+	encoder JSONEncoder
+	// strict rejects messages which violate the protocol, see StrictProtocol
+	strict bool
+	// debug is false if dbg drops the debug events of the protocol
+	debug bool
 }
 
 // Protocol specific message for correct unmarshaling of Arguments
 type jsonInvocationMessage struct {
 	Type         int               `json:"type"`
+	Headers      MessageHeaders    `json:"headers"`
 	Target       string            `json:"target"`
 	InvocationID string            `json:"invocationId"`
 	Arguments    []json.RawMessage `json:"arguments"`
@@ -34,12 +41,28 @@ func (j *jsonError) Error() string {
 
 // UnmarshalArgument unmarshals a json.RawMessage depending of the specified value type into value
 func (j *JSONHubProtocol) UnmarshalArgument(argument interface{}, value interface{}) error {
-	if err := json.Unmarshal(argument.(json.RawMessage), value); err != nil {
+	if err := j.json().Unmarshal(argument.(json.RawMessage), value); err != nil {
 		return &jsonError{string(argument.(json.RawMessage)), err}
 	}
 	return nil
 }
 
+// unmarshalNumber unmarshals argument as json.Number
+func (j *JSONHubProtocol) unmarshalNumber(argument interface{}) (interface{}, error) {
+	raw := argument.(json.RawMessage)
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, &jsonError{string(raw), err}
+	}
+	if _, ok := value.(string); ok {
+		// Strings are no numbers, even if unmarshaling json.Number accepts them
+		return nil, fmt.Errorf("%s is not a number", raw)
+	}
+	return value, nil
+}
+
 // ReadMessage reads a JSON message from buf and returns the message if the buf contained one completely.
 // If buf does not contain the whole message, it returns a nil message and complete false
 func (j *JSONHubProtocol) ReadMessage(buf *bytes.Buffer) (m interface{}, complete bool, err error) {
@@ -51,17 +74,69 @@ func (j *JSONHubProtocol) ReadMessage(buf *bytes.Buffer) (m interface{}, complet
 		// from bytes.Buffer.ReadBytes() which is always io.EOF or nil
 	}
 
+	m, err = j.parseMessage(data)
+	return m, true, err
+}
+
+// splitFrame splits data at the record separator
+func (j *JSONHubProtocol) splitFrame(data []byte, _ bool) (int, []byte, error) {
+	if i := bytes.IndexByte(data, 30); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	return 0, nil, nil
+}
+
+func (j *JSONHubProtocol) parseFrame(frame []byte) (interface{}, error) {
+	return j.parseMessage(frame)
+}
+
+// readMessageFrom reads the next message from reader. The message is read directly from the buffer of reader,
+// so messages larger than the buffer are only copied once. If maximumSize is not 0, reading stops with
+// errMessageTooLarge as soon as the message exceeds maximumSize bytes, including the record separator
+func (j *JSONHubProtocol) readMessageFrom(reader *bufio.Reader, maximumSize uint) (interface{}, error) {
+	var data []byte
+	for {
+		chunk, err := reader.ReadSlice(30)
+		if maximumSize > 0 && uint(len(data)+len(chunk)) > maximumSize {
+			return nil, errMessageTooLarge
+		}
+		// chunk is only valid until the next read
+		data = append(data, chunk...)
+		switch {
+		case err == nil:
+			return j.parseMessage(data[:len(data)-1])
+		case !errors.Is(err, bufio.ErrBufferFull):
+			return nil, err
+		}
+	}
+}
+
+// parseMessage parses the JSON of one message without the record separator.
+// In strict mode, it also validates the message
+func (j *JSONHubProtocol) parseMessage(data []byte) (interface{}, error) {
+	message, err := j.parseFields(data)
+	if err != nil || !j.strict {
+		return message, err
+	}
+	if err = validateMessage(message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// parseFields parses the JSON of one message into the message type of its type field
+func (j *JSONHubProtocol) parseFields(data []byte) (interface{}, error) {
 	message := hubMessage{}
-	err = json.Unmarshal(data, &message)
+	err := j.json().Unmarshal(data, &message)
 	_ = j.dbg.Log(evt, "read", msg, string(data))
 	if err != nil {
-		return nil, true, &jsonError{string(data), err}
+		return nil, &jsonError{string(data), err}
 	}
 
 	switch message.Type {
 	case 1, 4:
 		jsonInvocation := jsonInvocationMessage{}
-		if err = json.Unmarshal(data, &jsonInvocation); err != nil {
+		if err = j.json().Unmarshal(data, &jsonInvocation); err != nil {
 			err = &jsonError{string(data), err}
 		}
 		arguments := make([]interface{}, len(jsonInvocation.Arguments))
@@ -70,38 +145,51 @@ func (j *JSONHubProtocol) ReadMessage(buf *bytes.Buffer) (m interface{}, complet
 		}
 		invocation := invocationMessage{
 			Type:         jsonInvocation.Type,
+			Headers:      jsonInvocation.Headers,
 			Target:       jsonInvocation.Target,
 			InvocationID: jsonInvocation.InvocationID,
 			Arguments:    arguments,
 			StreamIds:    jsonInvocation.StreamIds,
 		}
-		return invocation, true, err
+		return invocation, err
 	case 2:
 		streamItem := streamItemMessage{}
-		if err = json.Unmarshal(data, &streamItem); err != nil {
+		if err = j.json().Unmarshal(data, &streamItem); err != nil {
 			err = &jsonError{string(data), err}
 		}
-		return streamItem, true, err
+		return streamItem, err
 	case 3:
 		completion := completionMessage{}
-		if err = json.Unmarshal(data, &completion); err != nil {
+		if err = j.json().Unmarshal(data, &completion); err != nil {
 			err = &jsonError{string(data), err}
 		}
-		return completion, true, err
+		return completion, err
 	case 5:
 		invocation := cancelInvocationMessage{}
-		if err = json.Unmarshal(data, &invocation); err != nil {
+		if err = j.json().Unmarshal(data, &invocation); err != nil {
 			err = &jsonError{string(data), err}
 		}
-		return invocation, true, err
+		return invocation, err
 	case 7:
 		cm := closeMessage{}
-		if err = json.Unmarshal(data, &cm); err != nil {
+		if err = j.json().Unmarshal(data, &cm); err != nil {
 			err = &jsonError{string(data), err}
 		}
-		return cm, true, err
+		return cm, err
+	case 8:
+		ack := ackMessage{}
+		if err = j.json().Unmarshal(data, &ack); err != nil {
+			err = &jsonError{string(data), err}
+		}
+		return ack, err
+	case 9:
+		sequence := sequenceMessage{}
+		if err = j.json().Unmarshal(data, &sequence); err != nil {
+			err = &jsonError{string(data), err}
+		}
+		return sequence, err
 	default:
-		return message, true, nil
+		return message, nil
 	}
 }
 
@@ -119,24 +207,36 @@ func parseTextMessageFormat(buf *bytes.Buffer) ([]byte, error) {
 // WriteMessage writes a message as JSON to the specified writer
 func (j *JSONHubProtocol) WriteMessage(message interface{}, writer io.Writer) error {
 
-	// TODO: Reduce the amount of copies
-
-	// We're copying because we want to write complete messages to the underlying Writer
-	buf := bytes.Buffer{}
-
-	if err := json.NewEncoder(&buf).Encode(message); err != nil {
+	// The message is appended to a pooled slice, so it is written at once to the underlying Writer
+	frame := getFrameBuffer()
+	defer putFrameBuffer(frame)
+	data, err := j.appendMessage((*frame)[:0], message)
+	if err != nil {
 		return err
 	}
-	_ = j.dbg.Log(evt, "write", msg, string(buf.Bytes()))
-
-	if err := buf.WriteByte(30); err != nil {
-		return err
+	// Like json.Encoder, terminate the message with a newline
+	data = append(data, '\n')
+	if j.debug {
+		_ = j.dbg.Log(evt, "write", msg, string(data))
 	}
-
-	_, err := writer.Write(buf.Bytes())
+	data = append(data, 30)
+	*frame = data
+	_, err = writer.Write(data)
 	return err
 }
 
+func (j *JSONHubProtocol) json() JSONEncoder {
+	if j.encoder == nil {
+		return stdJSONEncoder{}
+	}
+	return j.encoder
+}
+
 func (j *JSONHubProtocol) setDebugLogger(dbg StructuredLogger) {
-	j.dbg = log.WithPrefix(dbg, "ts", log.DefaultTimestampUTC, "protocol", "JSON")
+	j.dbg = withPrefix(dbg, "ts", defaultTimestampUTC, "protocol", LogSubsystemJSON)
+	j.debug = logsLevel(dbg, LogLevelDebug, LogSubsystemJSON)
+}
+
+func (j *JSONHubProtocol) setStrict(strict bool) {
+	j.strict = strict
 }
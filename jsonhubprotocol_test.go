@@ -0,0 +1,70 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"strings"
+	"testing/iotest"
+)
+
+// readerConnection is a Connection which reads from a reader and discards what is written
+type readerConnection struct {
+	discardConnection
+	reader io.Reader
+}
+
+func (r *readerConnection) Read(p []byte) (int, error) {
+	return r.reader.Read(p)
+}
+
+func newJSONTestProtocol() *JSONHubProtocol {
+	protocol := &JSONHubProtocol{}
+	protocol.setDebugLogger(log.NewNopLogger())
+	return protocol
+}
+
+var _ = Describe("JSONHubProtocol", func() {
+
+	Context("When messages are read from a stream", func() {
+		It("should read messages larger than the buffer and the messages following them", func() {
+			large := fmt.Sprintf(`{"type":1,"target":"large","arguments":["%v"]}`, strings.Repeat("x", 100))
+			reader := bufio.NewReaderSize(strings.NewReader(large+"\x1e"+`{"type":6}`+"\x1e"), 16)
+			protocol := newJSONTestProtocol()
+			message, err := protocol.readMessageFrom(reader, 0)
+			Expect(err).To(BeNil())
+			Expect(message.(invocationMessage).Target).To(Equal("large"))
+			message, err = protocol.readMessageFrom(reader, 0)
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(hubMessage{Type: 6}))
+			_, err = protocol.readMessageFrom(reader, 0)
+			Expect(err).To(Equal(io.EOF))
+		})
+		It("should stop reading a message which exceeds the maximum size", func() {
+			reader := bufio.NewReaderSize(strings.NewReader(`{"type":1,"target":"large","arguments":[]}`), 16)
+			_, err := newJSONTestProtocol().readMessageFrom(reader, 20)
+			Expect(err).To(Equal(errMessageTooLarge))
+		})
+	})
+
+	Context("When a hubConnection receives messages split over many reads", func() {
+		It("should parse all of them", func() {
+			var data bytes.Buffer
+			for i := 0; i < 5; i++ {
+				data.WriteString(fmt.Sprintf(`{"type":1,"invocationId":"%v","target":"add2","arguments":[%v]}`+"\x1e", i, i))
+			}
+			conn := &readerConnection{discardConnection: discardConnection{"c"}, reader: iotest.OneByteReader(&data)}
+			hubConn := newHubConnection(context.Background(), conn, newJSONTestProtocol(), 0, log.NewNopLogger(), log.NewNopLogger())
+			for i := 0; i < 5; i++ {
+				message, err := hubConn.Receive()
+				Expect(err).To(BeNil())
+				Expect(message.(invocationMessage).InvocationID).To(Equal(fmt.Sprint(i)))
+			}
+		})
+	})
+})
@@ -0,0 +1,233 @@
+package signalr
+
+import (
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// appendMessage appends the JSON of message to b. With encoding/json, the messages which are sent most often are
+// appended without reflection and intermediate values, with the same output encoding/json has.
+// All other messages, and all messages of protocols with a JSONEncoder option, are marshaled by the JSONEncoder
+func (j *JSONHubProtocol) appendMessage(b []byte, message interface{}) ([]byte, error) {
+	if j.encoder != nil {
+		return j.appendMarshaled(b, message)
+	}
+	var err error
+	switch message := message.(type) {
+	case sendOnlyHubInvocationMessage:
+		b = strconv.AppendInt(append(b, `{"type":`...), int64(message.Type), 10)
+		if b, err = j.appendHeaders(b, message.Headers); err != nil {
+			return nil, err
+		}
+		b = appendJSONString(append(b, `,"target":`...), message.Target)
+		if b, err = j.appendValues(append(b, `,"arguments":`...), message.Arguments); err != nil {
+			return nil, err
+		}
+		return append(b, '}'), nil
+	case invocationMessage:
+		b = strconv.AppendInt(append(b, `{"type":`...), int64(message.Type), 10)
+		if b, err = j.appendHeaders(b, message.Headers); err != nil {
+			return nil, err
+		}
+		b = appendJSONString(append(b, `,"target":`...), message.Target)
+		if message.InvocationID != "" {
+			b = appendJSONString(append(b, `,"invocationId":`...), message.InvocationID)
+		}
+		if b, err = j.appendValues(append(b, `,"arguments":`...), message.Arguments); err != nil {
+			return nil, err
+		}
+		if len(message.StreamIds) > 0 {
+			b = append(b, `,"streamIds":[`...)
+			for i, streamID := range message.StreamIds {
+				if i > 0 {
+					b = append(b, ',')
+				}
+				b = appendJSONString(b, streamID)
+			}
+			b = append(b, ']')
+		}
+		return append(b, '}'), nil
+	case streamItemMessage:
+		b = strconv.AppendInt(append(b, `{"type":`...), int64(message.Type), 10)
+		if b, err = j.appendHeaders(b, message.Headers); err != nil {
+			return nil, err
+		}
+		b = appendJSONString(append(b, `,"invocationId":`...), message.InvocationID)
+		if b, err = j.appendValue(append(b, `,"item":`...), message.Item); err != nil {
+			return nil, err
+		}
+		return append(b, '}'), nil
+	case completionMessage:
+		b = strconv.AppendInt(append(b, `{"type":`...), int64(message.Type), 10)
+		if b, err = j.appendHeaders(b, message.Headers); err != nil {
+			return nil, err
+		}
+		b = appendJSONString(append(b, `,"invocationId":`...), message.InvocationID)
+		if message.Result != nil {
+			if b, err = j.appendValue(append(b, `,"result":`...), message.Result); err != nil {
+				return nil, err
+			}
+		}
+		if message.Error != "" {
+			b = appendJSONString(append(b, `,"error":`...), message.Error)
+		}
+		if message.ErrorCode != "" {
+			b = appendJSONString(append(b, `,"errorCode":`...), message.ErrorCode)
+		}
+		if message.ErrorData != nil {
+			if b, err = j.appendValue(append(b, `,"errorData":`...), message.ErrorData); err != nil {
+				return nil, err
+			}
+		}
+		return append(b, '}'), nil
+	case hubMessage:
+		return append(strconv.AppendInt(append(b, `{"type":`...), int64(message.Type), 10), '}'), nil
+	}
+	return j.appendMarshaled(b, message)
+}
+
+func (j *JSONHubProtocol) appendMarshaled(b []byte, value interface{}) ([]byte, error) {
+	data, err := j.json().Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, data...), nil
+}
+
+// appendHeaders appends the headers field, if there are headers
+func (j *JSONHubProtocol) appendHeaders(b []byte, headers MessageHeaders) ([]byte, error) {
+	if len(headers) == 0 {
+		return b, nil
+	}
+	return j.appendValue(append(b, `,"headers":`...), map[string]string(headers))
+}
+
+// appendValues appends values as JSON array. nil is appended as null, like encoding/json does
+func (j *JSONHubProtocol) appendValues(b []byte, values []interface{}) ([]byte, error) {
+	if values == nil {
+		return append(b, "null"...), nil
+	}
+	b = append(b, '[')
+	var err error
+	for i, value := range values {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		if b, err = j.appendValue(b, value); err != nil {
+			return nil, err
+		}
+	}
+	return append(b, ']'), nil
+}
+
+// appendValue appends strings, numbers, bools and nil directly and marshals all other values
+func (j *JSONHubProtocol) appendValue(b []byte, value interface{}) ([]byte, error) {
+	switch value := value.(type) {
+	case nil:
+		return append(b, "null"...), nil
+	case string:
+		return appendJSONString(b, value), nil
+	case bool:
+		return strconv.AppendBool(b, value), nil
+	case int:
+		return strconv.AppendInt(b, int64(value), 10), nil
+	case int8:
+		return strconv.AppendInt(b, int64(value), 10), nil
+	case int16:
+		return strconv.AppendInt(b, int64(value), 10), nil
+	case int32:
+		return strconv.AppendInt(b, int64(value), 10), nil
+	case int64:
+		return strconv.AppendInt(b, value, 10), nil
+	case uint:
+		return strconv.AppendUint(b, uint64(value), 10), nil
+	case uint8:
+		return strconv.AppendUint(b, uint64(value), 10), nil
+	case uint16:
+		return strconv.AppendUint(b, uint64(value), 10), nil
+	case uint32:
+		return strconv.AppendUint(b, uint64(value), 10), nil
+	case uint64:
+		return strconv.AppendUint(b, value, 10), nil
+	case float64:
+		if !math.IsInf(value, 0) && !math.IsNaN(value) {
+			return appendJSONFloat(b, value, 64), nil
+		}
+	case float32:
+		if !math.IsInf(float64(value), 0) && !math.IsNaN(float64(value)) {
+			return appendJSONFloat(b, float64(value), 32), nil
+		}
+	}
+	// Infinity and NaN are marshaled, too, so encoding/json returns its error for them
+	return j.appendMarshaled(b, value)
+}
+
+// appendJSONFloat appends f in the format of encoding/json, which uses exponents only for very small and large values
+func appendJSONFloat(b []byte, f float64, bits int) []byte {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// clean up e-09 to e-9
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b
+}
+
+const jsonHex = "0123456789abcdef"
+
+// appendJSONString appends s as JSON string with the escaping of encoding/json, which escapes HTML characters
+// and replaces invalid UTF-8 with U+FFFD
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= ' ' && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', jsonHex[c>>4], jsonHex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			b = append(append(b, s[start:i]...), "\ufffd"...)
+		case r == '\u2028' || r == '\u2029':
+			b = append(append(b, s[start:i]...), '\\', 'u', '2', '0', '2', jsonHex[r&0xF])
+		default:
+			i += size
+			continue
+		}
+		i += size
+		start = i
+	}
+	return append(append(b, s[start:]...), '"')
+}
@@ -0,0 +1,118 @@
+package signalr
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+type jsonWriterPerson struct {
+	Name string `json:"name"`
+	Age  int    `json:"age"`
+}
+
+var _ = Describe("JSON writer", func() {
+
+	Context("When messages are written", func() {
+		It("should write the same JSON as encoding/json", func() {
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			arguments := []interface{}{
+				nil, true, false, "", "plain", "quote\" backslash\\ slash/", "<html> & </html>", "\n\r\t\b\f\x01\x1f\x7f",
+				"ünïcödé 😀", "line\u2028paragraph\u2029", 0, -1, math.MaxInt64,
+				int8(-8), int16(16), int32(-32), int64(math.MinInt64), uint(1), uint8(8), uint16(16), uint32(32),
+				uint64(math.MaxUint64), 0.0, 1.5, -123.456, 1e-7, 1e20, 1e21, 123456789.123, math.SmallestNonzeroFloat64,
+				math.MaxFloat64, float32(1.1), float32(1e-7), float32(3e38), jsonWriterPerson{Name: "Bob", Age: 42},
+				map[string]interface{}{"b": 1, "a": []int{1, 2}}, []string{"a"}, json.RawMessage(` { "a" : 1 } `),
+				[]byte("bytes"),
+			}
+			for _, message := range []interface{}{
+				sendOnlyHubInvocationMessage{Type: 1, Target: "receive", Arguments: arguments},
+				sendOnlyHubInvocationMessage{Type: 1, Target: "<none>", Arguments: nil},
+				sendOnlyHubInvocationMessage{Type: 1, Headers: MessageHeaders{"b": "2", "a": "<1>"}, Target: "x",
+					Arguments: []interface{}{}},
+				invocationMessage{Type: 1, Target: "add", InvocationID: "1", Arguments: []interface{}{1, 2}},
+				invocationMessage{Type: 4, Headers: MessageHeaders{"a": "1"}, Target: "count", InvocationID: "2",
+					Arguments: arguments, StreamIds: []string{"s1", "s\"2"}},
+				invocationMessage{Type: 1, Target: "send"},
+				streamItemMessage{Type: 2, InvocationID: "1", Item: jsonWriterPerson{Name: "Alice"}},
+				streamItemMessage{Type: 2, InvocationID: "1"},
+				completionMessage{Type: 3, InvocationID: "1"},
+				completionMessage{Type: 3, InvocationID: "1", Result: 3},
+				completionMessage{Type: 3, InvocationID: "1", Result: ""},
+				completionMessage{Type: 3, InvocationID: "1", Error: "failed", ErrorCode: "validation",
+					ErrorData: map[string]string{"field": "name"}},
+				hubMessage{Type: 6},
+				closeMessage{Type: 7, Error: "closed", AllowReconnect: true},
+				ackMessage{Type: 8, SequenceID: 3},
+			} {
+				expected, err := json.Marshal(message)
+				Expect(err).To(BeNil())
+				var buf bytes.Buffer
+				Expect(protocol.WriteMessage(message, &buf)).To(BeNil())
+				Expect(buf.String()).To(Equal(string(expected)+"\n\x1e"), "%#v", message)
+			}
+		})
+		It("should replace invalid UTF-8", func() {
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			var buf bytes.Buffer
+			Expect(protocol.WriteMessage(sendOnlyHubInvocationMessage{Type: 1, Target: "receive",
+				Arguments: []interface{}{"invalid \xff utf8 \xe2\x82"}}, &buf)).To(BeNil())
+			var message sendOnlyHubInvocationMessage
+			Expect(json.Unmarshal(bytes.TrimSuffix(buf.Bytes(), []byte("\n\x1e")), &message)).To(BeNil())
+			Expect(message.Arguments).To(Equal([]interface{}{"invalid \ufffd utf8 \ufffd\ufffd"}))
+		})
+		It("should return the errors of encoding/json", func() {
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			for _, argument := range []interface{}{math.NaN(), math.Inf(1), float32(math.Inf(-1)), make(chan int)} {
+				var buf bytes.Buffer
+				err := protocol.WriteMessage(sendOnlyHubInvocationMessage{Type: 1, Target: "receive",
+					Arguments: []interface{}{argument}}, &buf)
+				Expect(err).NotTo(BeNil(), "%v", argument)
+				Expect(buf.Len()).To(Equal(0))
+			}
+		})
+	})
+
+	Context("When a simple invocation is written without debug logging", func() {
+		It("should not allocate", func() {
+			if raceEnabled {
+				Skip("sync.Pool drops pooled values with the race detector")
+			}
+			protocol := &JSONHubProtocol{}
+			_, dbg := buildInfoDebugLogger(log.NewNopLogger(), false, nil, nil)
+			protocol.setDebugLogger(dbg)
+			var message interface{} = sendOnlyHubInvocationMessage{Type: 1, Target: "receive",
+				Arguments: []interface{}{"message", 42, true, 1.5}}
+			Expect(testing.AllocsPerRun(100, func() {
+				_ = protocol.WriteMessage(message, ioutil.Discard)
+			})).To(BeZero())
+		})
+	})
+
+	Context("When debug logging is enabled", func() {
+		It("should log the written messages", func() {
+			var logged bytes.Buffer
+			protocol := &JSONHubProtocol{}
+			_, dbg := buildInfoDebugLogger(newLogfmtLogger(&logged), true, nil, nil)
+			protocol.setDebugLogger(dbg)
+			Expect(protocol.WriteMessage(hubMessage{Type: 6}, ioutil.Discard)).To(BeNil())
+			Expect(logged.String()).To(ContainSubstring(`{\"type\":6}`))
+		})
+		It("should not log if the JSON subsystem has a higher level", func() {
+			var logged bytes.Buffer
+			protocol := &JSONHubProtocol{}
+			_, dbg := buildInfoDebugLogger(newLogfmtLogger(&logged), true, map[string]LogLevel{LogSubsystemJSON: LogLevelInfo}, nil)
+			protocol.setDebugLogger(dbg)
+			Expect(protocol.WriteMessage(hubMessage{Type: 6}, ioutil.Discard)).To(BeNil())
+			Expect(logged.Len()).To(BeZero())
+		})
+	})
+})
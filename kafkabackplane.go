@@ -0,0 +1,64 @@
+package signalr
+
+import (
+	"errors"
+	"hash/fnv"
+)
+
+// KafkaProducer writes messages to the partitions of a Kafka topic, e.g. a KafkaClient or an adapter of the producer
+// of a Kafka client library
+type KafkaProducer interface {
+	// Produce writes the message with key and value to the partition. It returns when the message is acknowledged
+	Produce(partition int32, key []byte, value []byte) error
+}
+
+// KafkaConsumer reads all partitions of a Kafka topic, e.g. a KafkaClient or an adapter of the consumer of a Kafka client library.
+// Each server needs its own consumer, because each server has to receive all messages.
+// If the consumer continues at its committed offsets after a restart of the server,
+// messages published while the server was down are not lost, see the offsets file of NewKafkaClient
+type KafkaConsumer interface {
+	// Consume starts reading the topic and calls handler with the value of each message.
+	// Messages of the same partition must be passed in order
+	Consume(handler func(value []byte)) error
+}
+
+// KafkaBackplane is a PartitionedBackplane using a Kafka topic. Messages are distributed over the partitions
+// of the topic by the hash of their key, so all messages for a group, user or connection are delivered in order
+type KafkaBackplane struct {
+	producer   KafkaProducer
+	consumer   KafkaConsumer
+	partitions int32
+}
+
+// NewKafkaBackplane creates a KafkaBackplane which produces with producer on a topic with the count of partitions
+// and consumes the topic with consumer
+func NewKafkaBackplane(producer KafkaProducer, consumer KafkaConsumer, partitions int32) (*KafkaBackplane, error) {
+	if producer == nil || consumer == nil {
+		return nil, errors.New("KafkaBackplane needs a producer and a consumer")
+	}
+	if partitions < 1 {
+		return nil, errors.New("KafkaBackplane needs at least one partition")
+	}
+	return &KafkaBackplane{producer: producer, consumer: consumer, partitions: partitions}, nil
+}
+
+// Publish publishes the message with the empty key
+func (k *KafkaBackplane) Publish(message []byte) error {
+	return k.PublishKey("", message)
+}
+
+// PublishKey publishes the message to the partition of the key
+func (k *KafkaBackplane) PublishKey(key string, message []byte) error {
+	return k.producer.Produce(k.partition(key), []byte(key), message)
+}
+
+// Subscribe consumes the topic. handler is called with every message published on the topic
+func (k *KafkaBackplane) Subscribe(handler func(message []byte)) error {
+	return k.consumer.Consume(handler)
+}
+
+func (k *KafkaBackplane) partition(key string) int32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int32(h.Sum32() % uint32(k.partitions))
+}
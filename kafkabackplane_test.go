@@ -0,0 +1,114 @@
+package signalr
+
+import (
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+)
+
+type kafkaRecord struct {
+	partition int32
+	key       string
+	value     []byte
+}
+
+// fakeKafka is a topic which passes each produced message to all consumers
+type fakeKafka struct {
+	mx       sync.Mutex
+	records  []kafkaRecord
+	handlers []func(value []byte)
+}
+
+func (f *fakeKafka) Produce(partition int32, key []byte, value []byte) error {
+	f.mx.Lock()
+	f.records = append(f.records, kafkaRecord{partition: partition, key: string(key), value: value})
+	handlers := make([]func(value []byte), len(f.handlers))
+	copy(handlers, f.handlers)
+	f.mx.Unlock()
+	for _, handler := range handlers {
+		handler(value)
+	}
+	return nil
+}
+
+func (f *fakeKafka) Consume(handler func(value []byte)) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	f.handlers = append(f.handlers, handler)
+	return nil
+}
+
+func (f *fakeKafka) produced() []kafkaRecord {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	return append([]kafkaRecord{}, f.records...)
+}
+
+var _ = Describe("KafkaBackplane", func() {
+
+	Context("When messages are published with keys", func() {
+		It("should produce messages with the same key to the same partition", func() {
+			kafka := &fakeKafka{}
+			backplane, err := NewKafkaBackplane(kafka, kafka, 8)
+			Expect(err).To(BeNil())
+			partitions := make(map[int32]bool)
+			for i := 0; i < 20; i++ {
+				Expect(backplane.PublishKey(fmt.Sprintf("group%v", i), []byte("a"))).To(BeNil())
+				Expect(backplane.PublishKey("room", []byte("b"))).To(BeNil())
+			}
+			roomPartition := backplane.partition("room")
+			for _, record := range kafka.produced() {
+				Expect(record.partition).To(BeNumerically("<", 8))
+				if record.key == "room" {
+					Expect(record.partition).To(Equal(roomPartition))
+				} else {
+					partitions[record.partition] = true
+				}
+			}
+			Expect(len(partitions)).To(BeNumerically(">", 1))
+		})
+	})
+
+	Context("When servers are connected over the KafkaBackplane", func() {
+		It("should invoke the clients of all servers", func() {
+			kafka := &fakeKafka{}
+			backplane, err := NewKafkaBackplane(kafka, kafka, 4)
+			Expect(err).To(BeNil())
+			connA, connB := connectBackplaneServers(backplane)
+			connA.ClientSend(`{"type":1,"target":"callall"}`)
+			expectClientFunc(connA)
+			expectClientFunc(connB)
+		})
+		It("should key the messages for a group with the group name", func() {
+			kafka := &fakeKafka{}
+			backplane, err := NewKafkaBackplane(kafka, kafka, 4)
+			Expect(err).To(BeNil())
+			connA, connB := connectBackplaneServers(backplane)
+			connA.ClientSend(fmt.Sprintf(`{"type":1,"target":"joinandcallgroup","arguments":["%v"]}`, connB.ConnectionID()))
+			expectClientFunc(connB)
+			records := kafka.produced()
+			Expect(records).To(HaveLen(2))
+			for _, record := range records {
+				Expect(record.key).To(Equal("remote"))
+				Expect(record.partition).To(Equal(backplane.partition("remote")))
+			}
+		})
+	})
+
+	Context("When a client message has no connection id", func() {
+		It("should be keyed with the target", func() {
+			for _, kind := range []string{backplaneInvokeClient, backplaneAbort} {
+				Expect(backplaneMessage{Kind: kind, Target: "clientfunc"}.partitionKey()).To(Equal("clientfunc"))
+			}
+		})
+	})
+
+	Context("When the topic has no partitions", func() {
+		It("should return an error", func() {
+			kafka := &fakeKafka{}
+			_, err := NewKafkaBackplane(kafka, kafka, 0)
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,775 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// KafkaClient is a minimal client of the Kafka protocol for one topic. It is the KafkaProducer and KafkaConsumer
+// of a KafkaBackplane, e.g.
+//
+//	client, err := NewKafkaClient("kafka:9092", "signalr", "/var/lib/signalr/offsets.json")
+//	backplane, err := NewKafkaBackplane(client, client, client.Partitions())
+//
+// Messages are produced when all in-sync replicas acknowledged them. Each call of Consume reads all partitions
+// of the topic without consumer group. If the KafkaClient has an offsets file, the offsets of the consumed messages
+// are saved in it after the handler returned, and a KafkaClient created again with the file, e.g. after a restart of
+// the server, continues after them. So messages published while the server was down are received as long as Kafka
+// retains them. Without offsets file, Consume starts at the end of the partitions.
+// Compressed messages are only supported with gzip
+type KafkaClient struct {
+	address     string
+	topic       string
+	offsetsFile string
+	// ioTimeout limits dialing and each request to the Kafka brokers, except the wait of Fetch for messages
+	ioTimeout time.Duration
+	// fetchMaxWait is the time the broker waits for messages before it answers a Fetch request
+	fetchMaxWait time.Duration
+	mx           sync.Mutex
+	partitions   int32
+	brokers      map[int32]string
+	leaders      map[int32]int32
+	// producers are the connections used by Produce, by broker address
+	producers map[string]*kafkaConn
+	// conns are all open connections, closed by Close
+	conns     map[*kafkaConn]bool
+	offsetsMx sync.Mutex
+	// offsets are the offsets of the next messages to consume by partition
+	offsets map[int32]int64
+	closed  bool
+}
+
+const kafkaReconnectInterval = time.Second
+const kafkaIOTimeout = 5 * time.Second
+const kafkaFetchMaxWait = 500 * time.Millisecond
+const kafkaFetchMaxBytes = 1 << 20
+
+// Kafka protocol api keys
+const (
+	kafkaProduce     int16 = 0
+	kafkaFetch       int16 = 1
+	kafkaListOffsets int16 = 2
+	kafkaMetadata    int16 = 3
+)
+
+// Timestamps of ListOffsets requests
+const (
+	kafkaLatestOffset   int64 = -1
+	kafkaEarliestOffset int64 = -2
+)
+
+var errKafkaClientClosed = errors.New("KafkaClient closed")
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// NewKafkaClient creates a KafkaClient which connects to the Kafka broker at address (host:port) to find the brokers
+// leading the partitions of the topic. If offsetsFile is not empty, the consumed offsets are saved in it
+func NewKafkaClient(address string, topic string, offsetsFile string) (*KafkaClient, error) {
+	if topic == "" {
+		return nil, errors.New("KafkaClient needs a topic")
+	}
+	k := &KafkaClient{
+		address:      address,
+		topic:        topic,
+		offsetsFile:  offsetsFile,
+		ioTimeout:    kafkaIOTimeout,
+		fetchMaxWait: kafkaFetchMaxWait,
+		producers:    make(map[string]*kafkaConn),
+		conns:        make(map[*kafkaConn]bool),
+		offsets:      make(map[int32]int64),
+	}
+	if err := k.loadOffsets(); err != nil {
+		return nil, err
+	}
+	if err := k.refreshMetadata(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Partitions returns the count of partitions of the topic
+func (k *KafkaClient) Partitions() int32 {
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	return k.partitions
+}
+
+// Produce writes the message to the partition. If the leader of the partition changed, it is retried once
+func (k *KafkaClient) Produce(partition int32, key []byte, value []byte) error {
+	err := k.produce(partition, key, value)
+	if code, ok := err.(kafkaError); ok && code.leaderChanged() {
+		if err = k.refreshMetadata(); err == nil {
+			err = k.produce(partition, key, value)
+		}
+	}
+	return err
+}
+
+func (k *KafkaClient) produce(partition int32, key []byte, value []byte) error {
+	conn, err := k.producer(partition)
+	if err != nil {
+		return err
+	}
+	var w kafkaWriter
+	w.nullString("") // transactional_id
+	w.int16(-1)      // acks from all in-sync replicas
+	w.int32(int32(k.ioTimeout / time.Millisecond))
+	w.int32(1)
+	w.string(k.topic)
+	w.int32(1)
+	w.int32(partition)
+	w.bytes(encodeKafkaRecordBatch(key, value, time.Now().UnixNano()/int64(time.Millisecond)))
+	response, err := conn.roundTrip(kafkaProduce, 3, w.buf.Bytes(), 2*k.ioTimeout)
+	if err != nil {
+		k.closeConn(conn)
+		return err
+	}
+	r := kafkaReader{data: response}
+	for topics := r.int32(); topics > 0; topics-- {
+		_ = r.string()
+		for partitions := r.int32(); partitions > 0; partitions-- {
+			index, code := r.int32(), r.int16()
+			r.skip(16) // base_offset, log_append_time_ms
+			if index == partition && code != 0 {
+				return kafkaError(code)
+			}
+		}
+	}
+	return r.err
+}
+
+// Consume reads all partitions of the topic, starting at the saved offsets or the end of the partitions.
+// handler is called with the messages of each partition in order, but concurrently for different partitions.
+// Consume returns when the start offsets are known
+func (k *KafkaClient) Consume(handler func(value []byte)) error {
+	partitions := k.Partitions()
+	start := make([]int64, partitions)
+	for partition := int32(0); partition < partitions; partition++ {
+		k.offsetsMx.Lock()
+		offset, ok := k.offsets[partition]
+		k.offsetsMx.Unlock()
+		if !ok {
+			var err error
+			if offset, err = k.startOffset(partition, kafkaLatestOffset); err != nil {
+				return err
+			}
+		}
+		start[partition] = offset
+	}
+	for partition := int32(0); partition < partitions; partition++ {
+		go k.consume(partition, start[partition], handler)
+	}
+	return nil
+}
+
+// Close closes all connections to the Kafka brokers and stops consuming
+func (k *KafkaClient) Close() error {
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	k.closed = true
+	for conn := range k.conns {
+		_ = conn.conn.Close()
+	}
+	k.conns = make(map[*kafkaConn]bool)
+	k.producers = make(map[string]*kafkaConn)
+	return nil
+}
+
+// consume fetches the messages of the partition until the KafkaClient is closed. A negative offset is out of range
+// and is reset to the earliest offset of the partition
+func (k *KafkaClient) consume(partition int32, offset int64, handler func(value []byte)) {
+	var conn *kafkaConn
+	for {
+		var err error
+		if conn == nil {
+			conn, err = k.dialLeader(partition)
+		}
+		if err == nil && offset < 0 {
+			offset, err = k.listOffset(conn, partition, kafkaEarliestOffset)
+		}
+		var messages []kafkaMessage
+		if err == nil {
+			messages, err = k.fetch(conn, partition, offset)
+		}
+		if err == errKafkaClientClosed {
+			return
+		}
+		if err != nil {
+			if err == kafkaErrOffsetOutOfRange {
+				offset = -1
+			}
+			if conn != nil {
+				k.closeConn(conn)
+				conn = nil
+			}
+			if k.isClosed() {
+				return
+			}
+			time.Sleep(kafkaReconnectInterval)
+			_ = k.refreshMetadata()
+			continue
+		}
+		for _, message := range messages {
+			// Batches can start before the fetched offset
+			if message.offset < offset {
+				continue
+			}
+			handler(message.value)
+			offset = message.offset + 1
+		}
+		if len(messages) > 0 {
+			k.saveOffset(partition, offset)
+		}
+	}
+}
+
+func (k *KafkaClient) fetch(conn *kafkaConn, partition int32, offset int64) ([]kafkaMessage, error) {
+	var w kafkaWriter
+	w.int32(-1) // replica_id
+	w.int32(int32(k.fetchMaxWait / time.Millisecond))
+	w.int32(1) // min_bytes
+	w.int32(kafkaFetchMaxBytes)
+	w.int8(0) // read uncommitted
+	w.int32(1)
+	w.string(k.topic)
+	w.int32(1)
+	w.int32(partition)
+	w.int64(offset)
+	w.int32(kafkaFetchMaxBytes)
+	response, err := conn.roundTrip(kafkaFetch, 4, w.buf.Bytes(), k.fetchMaxWait+k.ioTimeout)
+	if err != nil {
+		if k.isClosed() {
+			return nil, errKafkaClientClosed
+		}
+		return nil, err
+	}
+	r := kafkaReader{data: response}
+	r.skip(4) // throttle_time_ms
+	var messages []kafkaMessage
+	for topics := r.int32(); topics > 0; topics-- {
+		_ = r.string()
+		for partitions := r.int32(); partitions > 0; partitions-- {
+			index, code := r.int32(), r.int16()
+			r.skip(16) // high_watermark, last_stable_offset
+			for aborted := r.int32(); aborted > 0; aborted-- {
+				r.skip(16)
+			}
+			records := r.bytes()
+			if index != partition || r.err != nil {
+				continue
+			}
+			if code != 0 {
+				return nil, kafkaError(code)
+			}
+			if messages, err = decodeKafkaRecordBatches(records); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return messages, r.err
+}
+
+// startOffset returns the offset of the partition at the timestamp
+func (k *KafkaClient) startOffset(partition int32, timestamp int64) (int64, error) {
+	conn, err := k.dialLeader(partition)
+	if err != nil {
+		return 0, err
+	}
+	defer k.closeConn(conn)
+	return k.listOffset(conn, partition, timestamp)
+}
+
+func (k *KafkaClient) listOffset(conn *kafkaConn, partition int32, timestamp int64) (int64, error) {
+	var w kafkaWriter
+	w.int32(-1) // replica_id
+	w.int32(1)
+	w.string(k.topic)
+	w.int32(1)
+	w.int32(partition)
+	w.int64(timestamp)
+	response, err := conn.roundTrip(kafkaListOffsets, 1, w.buf.Bytes(), k.ioTimeout)
+	if err != nil {
+		return 0, err
+	}
+	r := kafkaReader{data: response}
+	for topics := r.int32(); topics > 0; topics-- {
+		_ = r.string()
+		for partitions := r.int32(); partitions > 0; partitions-- {
+			index, code := r.int32(), r.int16()
+			r.skip(8) // timestamp
+			offset := r.int64()
+			if index == partition && r.err == nil {
+				if code != 0 {
+					return 0, kafkaError(code)
+				}
+				return offset, nil
+			}
+		}
+	}
+	if r.err != nil {
+		return 0, r.err
+	}
+	return 0, fmt.Errorf("no offset for partition %v of Kafka topic %v", partition, k.topic)
+}
+
+// refreshMetadata gets the brokers and the leaders of the partitions of the topic
+func (k *KafkaClient) refreshMetadata() error {
+	conn, err := k.dial(k.address)
+	if err != nil {
+		return err
+	}
+	defer k.closeConn(conn)
+	var w kafkaWriter
+	w.int32(1)
+	w.string(k.topic)
+	response, err := conn.roundTrip(kafkaMetadata, 1, w.buf.Bytes(), k.ioTimeout)
+	if err != nil {
+		return err
+	}
+	r := kafkaReader{data: response}
+	brokers := make(map[int32]string)
+	for count := r.int32(); count > 0; count-- {
+		nodeID, host, port := r.int32(), r.string(), r.int32()
+		_ = r.string() // rack
+		brokers[nodeID] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	r.skip(4) // controller_id
+	leaders := make(map[int32]int32)
+	found := false
+	for topics := r.int32(); topics > 0; topics-- {
+		code, name := r.int16(), r.string()
+		r.skip(1) // is_internal
+		for partitions := r.int32(); partitions > 0; partitions-- {
+			r.skip(2) // error_code
+			index, leader := r.int32(), r.int32()
+			r.skip(4 * int(r.int32())) // replica_nodes
+			r.skip(4 * int(r.int32())) // isr_nodes
+			if name == k.topic {
+				leaders[index] = leader
+			}
+		}
+		if name == k.topic {
+			if code != 0 {
+				return kafkaError(code)
+			}
+			found = true
+		}
+	}
+	if r.err != nil {
+		return r.err
+	}
+	if !found || len(leaders) == 0 {
+		return fmt.Errorf("Kafka topic %v not found", k.topic)
+	}
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	k.brokers, k.leaders, k.partitions = brokers, leaders, int32(len(leaders))
+	return nil
+}
+
+// leaderAddress returns the address of the broker leading the partition
+func (k *KafkaClient) leaderAddress(partition int32) (string, error) {
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	leader, ok := k.leaders[partition]
+	if !ok {
+		return "", fmt.Errorf("partition %v of Kafka topic %v not found", partition, k.topic)
+	}
+	address, ok := k.brokers[leader]
+	if !ok {
+		return "", fmt.Errorf("leader of partition %v of Kafka topic %v not available", partition, k.topic)
+	}
+	return address, nil
+}
+
+// producer returns the connection to the leader of the partition used by Produce
+func (k *KafkaClient) producer(partition int32) (*kafkaConn, error) {
+	address, err := k.leaderAddress(partition)
+	if err != nil {
+		return nil, err
+	}
+	k.mx.Lock()
+	conn, ok := k.producers[address]
+	k.mx.Unlock()
+	if ok {
+		return conn, nil
+	}
+	if conn, err = k.dial(address); err != nil {
+		return nil, err
+	}
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	// Concurrent calls might have dialed, too
+	if existing, ok := k.producers[address]; ok {
+		delete(k.conns, conn)
+		_ = conn.conn.Close()
+		return existing, nil
+	}
+	conn.address = address
+	k.producers[address] = conn
+	return conn, nil
+}
+
+func (k *KafkaClient) dialLeader(partition int32) (*kafkaConn, error) {
+	address, err := k.leaderAddress(partition)
+	if err != nil {
+		return nil, err
+	}
+	return k.dial(address)
+}
+
+func (k *KafkaClient) dial(address string) (*kafkaConn, error) {
+	if k.isClosed() {
+		return nil, errKafkaClientClosed
+	}
+	conn, err := net.DialTimeout("tcp", address, k.ioTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &kafkaConn{conn: conn, reader: bufio.NewReader(conn)}
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	if k.closed {
+		_ = conn.Close()
+		return nil, errKafkaClientClosed
+	}
+	k.conns[c] = true
+	return c, nil
+}
+
+func (k *KafkaClient) closeConn(conn *kafkaConn) {
+	k.mx.Lock()
+	delete(k.conns, conn)
+	if k.producers[conn.address] == conn {
+		delete(k.producers, conn.address)
+	}
+	k.mx.Unlock()
+	_ = conn.conn.Close()
+}
+
+func (k *KafkaClient) isClosed() bool {
+	k.mx.Lock()
+	defer k.mx.Unlock()
+	return k.closed
+}
+
+func (k *KafkaClient) loadOffsets() error {
+	if k.offsetsFile == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(k.offsetsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &k.offsets)
+}
+
+// saveOffset saves the offset of the next message of the partition. The file is replaced, so it is never half written.
+// If saving fails, the offset is saved with the next messages
+func (k *KafkaClient) saveOffset(partition int32, offset int64) {
+	k.offsetsMx.Lock()
+	defer k.offsetsMx.Unlock()
+	k.offsets[partition] = offset
+	if k.offsetsFile == "" {
+		return
+	}
+	data, err := json.Marshal(k.offsets)
+	if err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(k.offsetsFile+".tmp", data, 0600); err == nil {
+		_ = os.Rename(k.offsetsFile+".tmp", k.offsetsFile)
+	}
+}
+
+// kafkaConn is a connection to a Kafka broker. Requests are sent one by one
+type kafkaConn struct {
+	mx            sync.Mutex
+	conn          net.Conn
+	reader        *bufio.Reader
+	correlationID int32
+	// address is set for the connections used by Produce
+	address string
+}
+
+// roundTrip sends the request and returns the response body after the correlation id
+func (c *kafkaConn) roundTrip(apiKey int16, apiVersion int16, body []byte, timeout time.Duration) ([]byte, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.correlationID++
+	var w kafkaWriter
+	w.int32(0) // size, set below
+	w.int16(apiKey)
+	w.int16(apiVersion)
+	w.int32(c.correlationID)
+	w.string("signalr")
+	w.buf.Write(body)
+	request := w.buf.Bytes()
+	binary.BigEndian.PutUint32(request, uint32(len(request)-4))
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	if _, err := c.conn.Write(request); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(c.reader, header); err != nil {
+		return nil, err
+	}
+	size := int32(binary.BigEndian.Uint32(header))
+	if size < 4 {
+		return nil, fmt.Errorf("invalid Kafka response size %v", size)
+	}
+	response := make([]byte, size-4)
+	if _, err := io.ReadFull(c.reader, response); err != nil {
+		return nil, err
+	}
+	if correlationID := int32(binary.BigEndian.Uint32(header[4:])); correlationID != c.correlationID {
+		return nil, fmt.Errorf("Kafka response for request %v, expected %v", correlationID, c.correlationID)
+	}
+	return response, nil
+}
+
+// kafkaError is the error code of a Kafka response
+type kafkaError int16
+
+const kafkaErrOffsetOutOfRange kafkaError = 1
+
+func (k kafkaError) Error() string {
+	return fmt.Sprintf("Kafka error code %d", int16(k))
+}
+
+// leaderChanged tells if the request should be sent again after the metadata were refreshed:
+// UNKNOWN_TOPIC_OR_PARTITION, LEADER_NOT_AVAILABLE, NOT_LEADER_OR_FOLLOWER
+func (k kafkaError) leaderChanged() bool {
+	return k == 3 || k == 5 || k == 6
+}
+
+// kafkaMessage is a consumed message
+type kafkaMessage struct {
+	offset int64
+	value  []byte
+}
+
+// encodeKafkaRecordBatch encodes a record batch (magic 2) with one record. An empty key is sent as null key
+func encodeKafkaRecordBatch(key []byte, value []byte, timestamp int64) []byte {
+	var record kafkaWriter
+	record.int8(0)   // attributes
+	record.varint(0) // timestamp delta
+	record.varint(0) // offset delta
+	if len(key) == 0 {
+		record.varint(-1)
+	} else {
+		record.varint(int64(len(key)))
+		record.buf.Write(key)
+	}
+	record.varint(int64(len(value)))
+	record.buf.Write(value)
+	record.varint(0) // headers
+	var attributes kafkaWriter
+	attributes.int16(0)
+	attributes.int32(0) // last offset delta
+	attributes.int64(timestamp)
+	attributes.int64(timestamp)
+	attributes.int64(-1) // producer id
+	attributes.int16(-1) // producer epoch
+	attributes.int32(-1) // base sequence
+	attributes.int32(1)  // records
+	attributes.varint(int64(record.buf.Len()))
+	attributes.buf.Write(record.buf.Bytes())
+	var batch kafkaWriter
+	batch.int64(0) // base offset, assigned by the broker
+	batch.int32(int32(4 + 1 + 4 + attributes.buf.Len()))
+	batch.int32(-1) // partition leader epoch
+	batch.int8(2)   // magic
+	batch.int32(int32(crc32.Checksum(attributes.buf.Bytes(), castagnoli)))
+	batch.buf.Write(attributes.buf.Bytes())
+	return batch.buf.Bytes()
+}
+
+// decodeKafkaRecordBatches returns the messages of the record batches. The last batch may be incomplete,
+// because brokers cut the response at the max bytes of the Fetch request
+func decodeKafkaRecordBatches(data []byte) ([]kafkaMessage, error) {
+	var messages []kafkaMessage
+	for len(data) >= 12 {
+		baseOffset := int64(binary.BigEndian.Uint64(data))
+		length := int(int32(binary.BigEndian.Uint32(data[8:])))
+		if length < 49 || len(data) < 12+length {
+			break
+		}
+		batch := data[12 : 12+length]
+		data = data[12+length:]
+		if magic := batch[4]; magic != 2 {
+			return nil, fmt.Errorf("Kafka message format %v not supported", magic)
+		}
+		if crc32.Checksum(batch[9:], castagnoli) != binary.BigEndian.Uint32(batch[5:]) {
+			return nil, errors.New("Kafka record batch with invalid crc")
+		}
+		attributes := binary.BigEndian.Uint16(batch[9:])
+		// Control batches of transactions have no messages
+		if attributes&0x20 != 0 {
+			continue
+		}
+		count := int(int32(binary.BigEndian.Uint32(batch[45:])))
+		records := batch[49:]
+		switch attributes & 7 {
+		case 0:
+		case 1:
+			reader, err := gzip.NewReader(bytes.NewReader(records))
+			if err != nil {
+				return nil, err
+			}
+			if records, err = ioutil.ReadAll(reader); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("Kafka compression %v not supported", attributes&7)
+		}
+		r := kafkaReader{data: records}
+		for i := 0; i < count; i++ {
+			length := int(r.varint())
+			if length < 0 {
+				return nil, errors.New("invalid Kafka record")
+			}
+			record := kafkaReader{data: r.next(length)}
+			record.skip(1) // attributes
+			_ = record.varint()
+			offsetDelta := record.varint()
+			record.skip(int(record.varint())) // key
+			value := record.next(int(record.varint()))
+			if r.err != nil || record.err != nil {
+				return nil, errors.New("invalid Kafka record")
+			}
+			messages = append(messages, kafkaMessage{offset: baseOffset + offsetDelta, value: value})
+		}
+	}
+	return messages, nil
+}
+
+// kafkaWriter encodes the primitive types of the Kafka protocol
+type kafkaWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *kafkaWriter) int8(v int8) {
+	w.buf.WriteByte(byte(v))
+}
+
+func (w *kafkaWriter) int16(v int16) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *kafkaWriter) int32(v int32) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *kafkaWriter) int64(v int64) {
+	_ = binary.Write(&w.buf, binary.BigEndian, v)
+}
+
+func (w *kafkaWriter) varint(v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	w.buf.Write(b[:binary.PutVarint(b, v)])
+}
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.buf.WriteString(s)
+}
+
+// nullString writes the empty string as null
+func (w *kafkaWriter) nullString(s string) {
+	if s == "" {
+		w.int16(-1)
+		return
+	}
+	w.string(s)
+}
+
+func (w *kafkaWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.buf.Write(b)
+}
+
+// kafkaReader decodes the primitive types of the Kafka protocol. After the first error, it returns zero values
+type kafkaReader struct {
+	data []byte
+	err  error
+}
+
+// next returns the next n bytes. Negative n are the length of null values
+func (r *kafkaReader) next(n int) []byte {
+	if n < 0 || r.err != nil {
+		return nil
+	}
+	if len(r.data) < n {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *kafkaReader) skip(n int) {
+	_ = r.next(n)
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data)
+	if n <= 0 {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+	r.data = r.data[n:]
+	return v
+}
+
+// string reads a string or a null string as empty string
+func (r *kafkaReader) string() string {
+	return string(r.next(int(r.int16())))
+}
+
+// bytes reads bytes or null bytes as nil
+func (r *kafkaReader) bytes() []byte {
+	return r.next(int(r.int32()))
+}
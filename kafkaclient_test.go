@@ -0,0 +1,338 @@
+package signalr
+
+import (
+	"bufio"
+	"encoding/binary"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// fakeKafkaBroker is a single Kafka broker for one topic. It implements the requests Metadata v1, Produce v3,
+// ListOffsets v1 and Fetch v4
+type fakeKafkaBroker struct {
+	listener net.Listener
+	topic    string
+	mx       sync.Mutex
+	// batches are the produced record batches of each partition with the base offsets assigned by the broker
+	batches [][]fakeKafkaBatch
+	// ends are the offsets of the next messages of each partition
+	ends []int64
+}
+
+type fakeKafkaBatch struct {
+	baseOffset int64
+	count      int64
+	data       []byte
+}
+
+func newFakeKafkaBroker(topic string, partitions int) *fakeKafkaBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	f := &fakeKafkaBroker{listener: listener, topic: topic,
+		batches: make([][]fakeKafkaBatch, partitions), ends: make([]int64, partitions)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeKafkaBroker) Close() {
+	_ = f.listener.Close()
+}
+
+func (f *fakeKafkaBroker) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	for {
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(reader, size); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(size))
+		if _, err := io.ReadFull(reader, request); err != nil {
+			return
+		}
+		r := &kafkaReader{data: request}
+		apiKey, apiVersion, correlationID := r.int16(), r.int16(), r.int32()
+		_ = r.string() // client_id
+		var w kafkaWriter
+		w.int32(0)
+		w.int32(correlationID)
+		switch {
+		case apiKey == kafkaMetadata && apiVersion == 1:
+			f.metadata(r, &w)
+		case apiKey == kafkaProduce && apiVersion == 3:
+			f.produce(r, &w)
+		case apiKey == kafkaListOffsets && apiVersion == 1:
+			f.listOffsets(r, &w)
+		case apiKey == kafkaFetch && apiVersion == 4:
+			f.fetch(r, &w)
+		default:
+			return
+		}
+		if r.err != nil {
+			return
+		}
+		response := w.buf.Bytes()
+		binary.BigEndian.PutUint32(response, uint32(len(response)-4))
+		if _, err := conn.Write(response); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeKafkaBroker) metadata(r *kafkaReader, w *kafkaWriter) {
+	var topics []string
+	for count := r.int32(); count > 0; count-- {
+		topics = append(topics, r.string())
+	}
+	host, port, _ := net.SplitHostPort(f.listener.Addr().String())
+	portNumber, _ := strconv.Atoi(port)
+	w.int32(1)
+	w.int32(0)
+	w.string(host)
+	w.int32(int32(portNumber))
+	w.nullString("")
+	w.int32(0) // controller_id
+	w.int32(int32(len(topics)))
+	for _, topic := range topics {
+		if topic != f.topic {
+			w.int16(3) // UNKNOWN_TOPIC_OR_PARTITION
+			w.string(topic)
+			w.buf.WriteByte(0)
+			w.int32(0)
+			continue
+		}
+		w.int16(0)
+		w.string(topic)
+		w.buf.WriteByte(0)
+		w.int32(int32(len(f.ends)))
+		for partition := range f.ends {
+			w.int16(0)
+			w.int32(int32(partition))
+			w.int32(0) // leader
+			w.int32(1)
+			w.int32(0) // replicas
+			w.int32(1)
+			w.int32(0) // isr
+		}
+	}
+}
+
+func (f *fakeKafkaBroker) produce(r *kafkaReader, w *kafkaWriter) {
+	_ = r.string() // transactional_id
+	r.skip(6)      // acks, timeout_ms
+	r.skip(4)      // one topic
+	topic := r.string()
+	r.skip(4) // one partition
+	partition := r.int32()
+	batch := append([]byte(nil), r.bytes()...)
+	code, baseOffset := int16(0), int64(-1)
+	switch {
+	case topic != f.topic || partition < 0 || int(partition) >= len(f.ends):
+		code = 3
+	case len(batch) < 61 || batch[16] != 2 ||
+		crc32.Checksum(batch[21:], crc32.MakeTable(crc32.Castagnoli)) != binary.BigEndian.Uint32(batch[17:]):
+		code = 2 // CORRUPT_MESSAGE
+	default:
+		f.mx.Lock()
+		baseOffset = f.ends[partition]
+		count := int64(binary.BigEndian.Uint32(batch[57:]))
+		// The broker assigns the offsets. The crc does not cover the base offset
+		binary.BigEndian.PutUint64(batch, uint64(baseOffset))
+		f.batches[partition] = append(f.batches[partition], fakeKafkaBatch{baseOffset: baseOffset, count: count, data: batch})
+		f.ends[partition] += count
+		f.mx.Unlock()
+	}
+	w.int32(1)
+	w.string(topic)
+	w.int32(1)
+	w.int32(partition)
+	w.int16(code)
+	w.int64(baseOffset)
+	w.int64(-1) // log_append_time_ms
+	w.int32(0)  // throttle_time_ms
+}
+
+func (f *fakeKafkaBroker) listOffsets(r *kafkaReader, w *kafkaWriter) {
+	r.skip(4) // replica_id
+	r.skip(4) // one topic
+	topic := r.string()
+	r.skip(4) // one partition
+	partition, timestamp := r.int32(), r.int64()
+	offset := int64(0)
+	if timestamp == kafkaLatestOffset {
+		f.mx.Lock()
+		offset = f.ends[partition]
+		f.mx.Unlock()
+	}
+	w.int32(1)
+	w.string(topic)
+	w.int32(1)
+	w.int32(partition)
+	w.int16(0)
+	w.int64(-1)
+	w.int64(offset)
+}
+
+func (f *fakeKafkaBroker) fetch(r *kafkaReader, w *kafkaWriter) {
+	r.skip(4) // replica_id
+	maxWait := time.Duration(r.int32()) * time.Millisecond
+	r.skip(9) // min_bytes, max_bytes, isolation_level
+	r.skip(4) // one topic
+	topic := r.string()
+	r.skip(4) // one partition
+	partition, offset := r.int32(), r.int64()
+	deadline := time.Now().Add(maxWait)
+	f.mx.Lock()
+	for f.ends[partition] <= offset && time.Now().Before(deadline) {
+		f.mx.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		f.mx.Lock()
+	}
+	var records []byte
+	code := int16(0)
+	if offset > f.ends[partition] {
+		code = int16(kafkaErrOffsetOutOfRange)
+	}
+	for _, batch := range f.batches[partition] {
+		if batch.baseOffset+batch.count > offset {
+			records = append(records, batch.data...)
+		}
+	}
+	end := f.ends[partition]
+	f.mx.Unlock()
+	w.int32(0) // throttle_time_ms
+	w.int32(1)
+	w.string(topic)
+	w.int32(1)
+	w.int32(partition)
+	w.int16(code)
+	w.int64(end)
+	w.int64(end)
+	w.int32(-1) // aborted_transactions
+	w.bytes(records)
+}
+
+func receiveKafka(values <-chan []byte) string {
+	select {
+	case value := <-values:
+		return string(value)
+	case <-time.After(2 * time.Second):
+		return "timeout"
+	}
+}
+
+var _ = Describe("KafkaClient", func() {
+
+	Context("When the topic does not exist", func() {
+		It("should return an error", func() {
+			broker := newFakeKafkaBroker("signalr", 2)
+			defer broker.Close()
+			_, err := NewKafkaClient(broker.listener.Addr().String(), "other", "")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When servers are connected over a KafkaBackplane with a KafkaClient", func() {
+		It("should invoke the clients of all servers", func() {
+			broker := newFakeKafkaBroker("signalr", 4)
+			defer broker.Close()
+			client, err := NewKafkaClient(broker.listener.Addr().String(), "signalr", "")
+			Expect(err).To(BeNil())
+			defer func() { _ = client.Close() }()
+			Expect(client.Partitions()).To(Equal(int32(4)))
+			backplane, err := NewKafkaBackplane(client, client, client.Partitions())
+			Expect(err).To(BeNil())
+			connA, connB := connectBackplaneServers(backplane)
+			connA.ClientSend(`{"type":1,"target":"callall"}`)
+			expectClientFunc(connA)
+			expectClientFunc(connB)
+		})
+	})
+
+	Context("When messages are produced and consumed", func() {
+		It("should pass the messages of each partition in order", func() {
+			broker := newFakeKafkaBroker("signalr", 2)
+			defer broker.Close()
+			client, err := NewKafkaClient(broker.listener.Addr().String(), "signalr", "")
+			Expect(err).To(BeNil())
+			defer func() { _ = client.Close() }()
+			values := make(chan []byte, 10)
+			Expect(client.Consume(func(value []byte) { values <- value })).To(BeNil())
+			for _, value := range []string{"a", "b", "c"} {
+				Expect(client.Produce(1, []byte("key"), []byte(value))).To(BeNil())
+			}
+			for _, value := range []string{"a", "b", "c"} {
+				Expect(receiveKafka(values)).To(Equal(value))
+			}
+		})
+		It("should not pass messages produced before Consume without offsets file", func() {
+			broker := newFakeKafkaBroker("signalr", 1)
+			defer broker.Close()
+			client, err := NewKafkaClient(broker.listener.Addr().String(), "signalr", "")
+			Expect(err).To(BeNil())
+			defer func() { _ = client.Close() }()
+			Expect(client.Produce(0, nil, []byte("old"))).To(BeNil())
+			values := make(chan []byte, 10)
+			Expect(client.Consume(func(value []byte) { values <- value })).To(BeNil())
+			Expect(client.Produce(0, nil, []byte("new"))).To(BeNil())
+			Expect(receiveKafka(values)).To(Equal("new"))
+		})
+	})
+
+	Context("When a KafkaClient with offsets file is created again", func() {
+		It("should pass the messages produced while it was closed", func() {
+			broker := newFakeKafkaBroker("signalr", 2)
+			defer broker.Close()
+			dir, err := ioutil.TempDir("", "kafkaclient")
+			Expect(err).To(BeNil())
+			defer func() { _ = os.RemoveAll(dir) }()
+			offsetsFile := filepath.Join(dir, "offsets.json")
+			producer, err := NewKafkaClient(broker.listener.Addr().String(), "signalr", "")
+			Expect(err).To(BeNil())
+			defer func() { _ = producer.Close() }()
+			consumer, err := NewKafkaClient(broker.listener.Addr().String(), "signalr", offsetsFile)
+			Expect(err).To(BeNil())
+			values := make(chan []byte, 10)
+			Expect(consumer.Consume(func(value []byte) { values <- value })).To(BeNil())
+			Expect(producer.Produce(0, nil, []byte("before"))).To(BeNil())
+			Expect(receiveKafka(values)).To(Equal("before"))
+			Expect(consumer.Close()).To(BeNil())
+			Expect(producer.Produce(0, nil, []byte("while closed"))).To(BeNil())
+			Expect(producer.Produce(1, nil, []byte("other partition"))).To(BeNil())
+			consumer, err = NewKafkaClient(broker.listener.Addr().String(), "signalr", offsetsFile)
+			Expect(err).To(BeNil())
+			defer func() { _ = consumer.Close() }()
+			values = make(chan []byte, 10)
+			Expect(consumer.Consume(func(value []byte) { values <- value })).To(BeNil())
+			Expect(receiveKafka(values)).To(Equal("while closed"))
+			// Partition 1 had no saved offset, so it starts at the end
+			Consistently(values, 100*time.Millisecond).ShouldNot(Receive())
+		})
+	})
+
+	Context("When the last record batch of a Fetch response is incomplete", func() {
+		It("should return the messages of the complete batches", func() {
+			batch := encodeKafkaRecordBatch([]byte("key"), []byte("value"), 0)
+			messages, err := decodeKafkaRecordBatches(append(append([]byte(nil), batch...), batch[:30]...))
+			Expect(err).To(BeNil())
+			Expect(messages).To(Equal([]kafkaMessage{{offset: 0, value: []byte("value")}}))
+		})
+	})
+})
@@ -0,0 +1,208 @@
+// Package loadtest runs simulated clients against a SignalR hub and measures the latency and throughput
+// of their invocations, e.g. to validate performance-oriented changes of the server:
+//
+//	server, _ := signalr.NewServer(ctx, signalr.SimpleHubFactory(&chat{}))
+//	result, err := loadtest.RunServer(ctx, server, loadtest.Options{Clients: 100, Invocations: 1000,
+//		Method: "echo", Arguments: []interface{}{"hello"}, Transport: loadtest.InMemory})
+//	fmt.Println(result)
+package loadtest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/philippseith/signalr"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Transport is the way the simulated clients of RunServer are connected to the server
+type Transport int
+
+const (
+	// WebSockets connects the clients over WebSockets to a server listening on a localhost port
+	WebSockets Transport = iota
+	// InMemory connects the clients over WebSockets on in-memory pipes, without network.
+	// It measures the server without the latency of the network stack
+	InMemory
+)
+
+// Options configure a load test
+type Options struct {
+	// Clients is the number of simulated clients, which invoke the method concurrently. Default is 1
+	Clients int
+	// Invocations is the number of invocations of each client. Default is 100. It is ignored if Duration is set
+	Invocations int
+	// Duration lets the clients invoke the method until the duration is over
+	Duration time.Duration
+	// Method is the hub method to invoke
+	Method string
+	// Arguments are the arguments of the method
+	Arguments []interface{}
+	// Transport is the transport of RunServer. Run always connects over WebSockets
+	Transport Transport
+	// ClientOptions are passed to signalr.Dial for each client. The clients log nothing by default
+	ClientOptions []func(*signalr.Client) error
+}
+
+// Result is the result of a load test. The latencies are the durations of successful invocations
+type Result struct {
+	Clients     int
+	Invocations int
+	Errors      int
+	// FirstError is the error of the first failed invocation
+	FirstError error
+	Duration   time.Duration
+	// Throughput is the number of successful invocations per second
+	Throughput float64
+	Min        time.Duration
+	P50        time.Duration
+	P90        time.Duration
+	P99        time.Duration
+	Max        time.Duration
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%v clients, %v invocations, %v errors in %v: %.0f/s, latency min %v p50 %v p90 %v p99 %v max %v",
+		r.Clients, r.Invocations, r.Errors, r.Duration, r.Throughput, r.Min, r.P50, r.P90, r.P99, r.Max)
+}
+
+// Run connects the clients to the hub at address, e.g. http://localhost:5000/chat, and lets each of them invoke
+// the method and wait for its completion until its invocations are done, or the duration or ctx is over.
+// It returns an error if a client can not connect
+func Run(ctx context.Context, address string, options Options) (Result, error) {
+	if options.Method == "" {
+		return Result{}, errors.New("loadtest needs a method")
+	}
+	if options.Clients <= 0 {
+		options.Clients = 1
+	}
+	if options.Invocations <= 0 {
+		options.Invocations = 100
+	}
+	clientOptions := append([]func(*signalr.Client) error{signalr.WithLogger(discardLogger{}, false)},
+		options.ClientOptions...)
+	clients := make([]*signalr.Client, 0, options.Clients)
+	defer func() {
+		for _, client := range clients {
+			client.Close()
+		}
+	}()
+	for i := 0; i < options.Clients; i++ {
+		client, err := signalr.Dial(address, clientOptions...)
+		if err != nil {
+			return Result{}, fmt.Errorf("client %v can not connect: %w", i, err)
+		}
+		clients = append(clients, client)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if options.Duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, options.Duration)
+		defer cancel()
+	}
+	runs := make([]clientRun, len(clients))
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i, client := range clients {
+		wg.Add(1)
+		go func(run *clientRun, client *signalr.Client) {
+			defer wg.Done()
+			run.invoke(ctx, client, options)
+		}(&runs[i], client)
+	}
+	wg.Wait()
+	return newResult(runs, time.Since(start)), nil
+}
+
+// RunServer serves the hub of server with the transport of the options and runs the load test against it
+func RunServer(ctx context.Context, server *signalr.Server, options Options) (Result, error) {
+	mux := http.NewServeMux()
+	server.MapHTTP(mux, "/hub")
+	var listener net.Listener
+	address := "http://loadtest/hub"
+	switch options.Transport {
+	case InMemory:
+		pipes := newPipeListener()
+		listener = pipes
+		options.ClientOptions = append([]func(*signalr.Client) error{signalr.WithDialContext(pipes.dial)},
+			options.ClientOptions...)
+	default:
+		var err error
+		if listener, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+			return Result{}, err
+		}
+		address = fmt.Sprintf("http://%v/hub", listener.Addr())
+	}
+	httpServer := &http.Server{Handler: mux}
+	go func() { _ = httpServer.Serve(listener) }()
+	defer func() { _ = httpServer.Close() }()
+	return Run(ctx, address, options)
+}
+
+// clientRun are the invocations of one client
+type clientRun struct {
+	latencies  []time.Duration
+	errors     int
+	firstError error
+}
+
+func (r *clientRun) invoke(ctx context.Context, client *signalr.Client, options Options) {
+	for i := 0; options.Duration > 0 || i < options.Invocations; i++ {
+		if ctx.Err() != nil {
+			return
+		}
+		start := time.Now()
+		if err := client.Invoke(ctx, options.Method, nil, options.Arguments...); err != nil {
+			if ctx.Err() != nil {
+				// Invocations canceled at the end of the test are not counted
+				return
+			}
+			r.errors++
+			if r.firstError == nil {
+				r.firstError = err
+			}
+			continue
+		}
+		r.latencies = append(r.latencies, time.Since(start))
+	}
+}
+
+func newResult(runs []clientRun, duration time.Duration) Result {
+	result := Result{Clients: len(runs), Duration: duration}
+	var latencies []time.Duration
+	for _, run := range runs {
+		latencies = append(latencies, run.latencies...)
+		result.Errors += run.errors
+		if result.FirstError == nil {
+			result.FirstError = run.firstError
+		}
+	}
+	result.Invocations = len(latencies) + result.Errors
+	if len(latencies) == 0 {
+		return result
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.Throughput = float64(len(latencies)) / duration.Seconds()
+	result.Min, result.Max = latencies[0], latencies[len(latencies)-1]
+	result.P50, result.P90, result.P99 = percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99)
+	return result
+}
+
+// percentile returns the nearest-rank percentile p of the sorted latencies
+func percentile(latencies []time.Duration, p int) time.Duration {
+	rank := (len(latencies)*p + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return latencies[rank-1]
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Log(...interface{}) error {
+	return nil
+}
@@ -0,0 +1,12 @@
+package loadtest
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestLoadTest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "LoadTest Suite")
+}
@@ -0,0 +1,132 @@
+package loadtest
+
+import (
+	"context"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/philippseith/signalr"
+	"testing"
+	"time"
+)
+
+type loadTestHub struct {
+	signalr.Hub
+}
+
+func (l *loadTestHub) Echo(message string) string {
+	return message
+}
+
+func (l *loadTestHub) Fail() error {
+	return errors.New("failed")
+}
+
+func newLoadTestServer() *signalr.Server {
+	server, err := signalr.NewServer(context.Background(), signalr.SimpleHubFactory(&loadTestHub{}),
+		signalr.Logger(discardLogger{}, false), signalr.MaximumParallelInvocationsPerClient(0))
+	if err != nil {
+		panic(err)
+	}
+	return server
+}
+
+var _ = Describe("LoadTest", func() {
+
+	Context("When the clients invoke a hub method", func() {
+		It("should measure all invocations", func() {
+			for _, transport := range []Transport{InMemory, WebSockets} {
+				result, err := RunServer(context.Background(), newLoadTestServer(), Options{Clients: 5, Invocations: 20,
+					Method: "echo", Arguments: []interface{}{"hello"}, Transport: transport})
+				Expect(err).To(BeNil())
+				Expect(result.Clients).To(Equal(5))
+				Expect(result.Invocations).To(Equal(100))
+				Expect(result.Errors).To(BeZero())
+				Expect(result.Throughput).To(BeNumerically(">", 0))
+				Expect(result.Min).To(BeNumerically(">", 0))
+				Expect(result.Min).To(BeNumerically("<=", result.P50))
+				Expect(result.P50).To(BeNumerically("<=", result.P90))
+				Expect(result.P90).To(BeNumerically("<=", result.P99))
+				Expect(result.P99).To(BeNumerically("<=", result.Max))
+				Expect(result.String()).To(ContainSubstring("100 invocations, 0 errors"))
+			}
+		})
+		It("should invoke until the duration is over", func() {
+			result, err := RunServer(context.Background(), newLoadTestServer(), Options{Clients: 2,
+				Duration: 200 * time.Millisecond, Method: "echo", Arguments: []interface{}{"hello"}, Transport: InMemory})
+			Expect(err).To(BeNil())
+			Expect(result.Invocations).To(BeNumerically(">", 2))
+			Expect(result.Errors).To(BeZero())
+			Expect(result.Duration).To(BeNumerically(">=", 200*time.Millisecond))
+		})
+	})
+
+	Context("When the invocations fail", func() {
+		It("should count the errors", func() {
+			result, err := RunServer(context.Background(), newLoadTestServer(), Options{Clients: 2, Invocations: 3,
+				Method: "fail", Transport: InMemory})
+			Expect(err).To(BeNil())
+			Expect(result.Invocations).To(Equal(6))
+			Expect(result.Errors).To(Equal(6))
+			Expect(result.FirstError).To(MatchError("failed"))
+			Expect(result.Throughput).To(BeZero())
+		})
+	})
+
+	Context("When the options are invalid", func() {
+		It("should return an error", func() {
+			_, err := RunServer(context.Background(), newLoadTestServer(), Options{Transport: InMemory})
+			Expect(err).NotTo(BeNil())
+			_, err = Run(context.Background(), "http://127.0.0.1:1/hub", Options{Method: "echo"})
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When percentiles are computed", func() {
+		It("should return the nearest rank", func() {
+			latencies := make([]time.Duration, 100)
+			for i := range latencies {
+				latencies[i] = time.Duration(i + 1)
+			}
+			Expect(percentile(latencies, 50)).To(Equal(time.Duration(50)))
+			Expect(percentile(latencies, 99)).To(Equal(time.Duration(99)))
+			Expect(percentile(latencies[:1], 99)).To(Equal(time.Duration(1)))
+			Expect(percentile(latencies[:3], 50)).To(Equal(time.Duration(2)))
+		})
+	})
+})
+
+func benchmarkTransport(b *testing.B, transport Transport, clients int) {
+	invocations := b.N / clients
+	if invocations == 0 {
+		invocations = 1
+	}
+	b.ResetTimer()
+	result, err := RunServer(context.Background(), newLoadTestServer(), Options{Clients: clients,
+		Invocations: invocations, Method: "echo", Arguments: []interface{}{"hello"}, Transport: transport})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if result.Errors > 0 {
+		b.Fatal(result.FirstError)
+	}
+	b.ReportMetric(float64(result.P50.Microseconds()), "p50-µs")
+	b.ReportMetric(float64(result.P99.Microseconds()), "p99-µs")
+	b.ReportMetric(result.Throughput, "invocations/s")
+}
+
+func BenchmarkInMemory(b *testing.B) {
+	benchmarkTransport(b, InMemory, 1)
+}
+
+func BenchmarkInMemory10Clients(b *testing.B) {
+	benchmarkTransport(b, InMemory, 10)
+}
+
+func BenchmarkWebSockets(b *testing.B) {
+	benchmarkTransport(b, WebSockets, 1)
+}
+
+func BenchmarkWebSockets10Clients(b *testing.B) {
+	benchmarkTransport(b, WebSockets, 10)
+}
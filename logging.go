@@ -0,0 +1,390 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel is the level of a log event
+type LogLevel int
+
+// Log levels
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+)
+
+func (l LogLevel) String() string {
+	if l == LogLevelDebug {
+		return "debug"
+	}
+	return "info"
+}
+
+// Log subsystems. The subsystem of a log event is the value of its "class" or "protocol" key
+const (
+	LogSubsystemServer        = "Server"
+	LogSubsystemHubConnection = "HubConnection"
+	LogSubsystemClient        = "Client"
+	LogSubsystemBackplane     = "Backplane"
+	LogSubsystemJSON          = "JSON"
+	LogSubsystemMessagePack   = "MessagePack"
+)
+
+// logValuer is a log value which is evaluated when the event is logged
+type logValuer func() interface{}
+
+var defaultTimestampUTC logValuer = func() interface{} {
+	return time.Now().UTC().Format(time.RFC3339Nano)
+}
+
+// defaultCaller is the file and line of the Log call.
+// The depth is the valuer, bindValues, logContext.Log and the caller
+var defaultCaller logValuer = func() interface{} {
+	_, file, line, _ := runtime.Caller(3)
+	return file[strings.LastIndexByte(file, '/')+1:] + ":" + strconv.Itoa(line)
+}
+
+// logContext is a StructuredLogger which adds keyvals to each event. Nested logContexts are flattened,
+// so the call depth of Log is always the same
+type logContext struct {
+	logger    StructuredLogger
+	keyvals   []interface{}
+	hasValuer bool
+	// fields are added after keyvals. They can change after the logContext was created
+	fields *logFields
+}
+
+func (l *logContext) Log(keyvals ...interface{}) error {
+	if l.fields != nil {
+		keyvals = append(l.fields.get(), keyvals...)
+	}
+	kvs := append(l.keyvals, keyvals...)
+	if len(kvs)%2 != 0 {
+		kvs = append(kvs, nil)
+	}
+	if l.hasValuer {
+		// l.keyvals must not be changed
+		if len(keyvals) == 0 {
+			kvs = append([]interface{}{}, l.keyvals...)
+		}
+		bindValues(kvs[:len(l.keyvals)])
+	}
+	return l.logger.Log(kvs...)
+}
+
+func newLogContext(logger StructuredLogger) *logContext {
+	if l, ok := logger.(*logContext); ok {
+		return l
+	}
+	return &logContext{logger: logger}
+}
+
+// with returns a logger which appends keyvals to the keyvals of each event
+func with(logger StructuredLogger, keyvals ...interface{}) StructuredLogger {
+	if len(keyvals) == 0 {
+		return logger
+	}
+	l := newLogContext(logger)
+	kvs := append(l.keyvals, keyvals...)
+	if len(kvs)%2 != 0 {
+		kvs = append(kvs, nil)
+	}
+	return &logContext{
+		logger: l.logger,
+		// full slice expression, so appending in Log always copies
+		keyvals:   kvs[:len(kvs):len(kvs)],
+		hasValuer: l.hasValuer || containsValuer(keyvals),
+		fields:    l.fields,
+	}
+}
+
+// withPrefix returns a logger which prepends keyvals to the keyvals of each event
+func withPrefix(logger StructuredLogger, keyvals ...interface{}) StructuredLogger {
+	if len(keyvals) == 0 {
+		return logger
+	}
+	l := newLogContext(logger)
+	n := len(l.keyvals) + len(keyvals)
+	if len(keyvals)%2 != 0 {
+		n++
+	}
+	kvs := make([]interface{}, 0, n)
+	kvs = append(kvs, keyvals...)
+	if len(kvs)%2 != 0 {
+		kvs = append(kvs, nil)
+	}
+	kvs = append(kvs, l.keyvals...)
+	return &logContext{
+		logger:    l.logger,
+		keyvals:   kvs,
+		hasValuer: l.hasValuer || containsValuer(keyvals),
+		fields:    l.fields,
+	}
+}
+
+// withFields returns a logger which adds the current keyvals of fields to each event
+func withFields(logger StructuredLogger, fields *logFields) StructuredLogger {
+	l := newLogContext(logger)
+	return &logContext{
+		logger:    l.logger,
+		keyvals:   l.keyvals,
+		hasValuer: l.hasValuer,
+		fields:    fields,
+	}
+}
+
+// logFields are the keyvals added to all events of a connection
+type logFields struct {
+	mx      sync.RWMutex
+	keyvals []interface{}
+}
+
+func (f *logFields) add(keyvals ...interface{}) {
+	if len(keyvals)%2 != 0 {
+		keyvals = append(keyvals, nil)
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	kvs := append(f.keyvals[:len(f.keyvals):len(f.keyvals)], keyvals...)
+	f.keyvals = kvs[:len(kvs):len(kvs)]
+}
+
+// get returns the keyvals. They must not be changed, but appending to them always copies
+func (f *logFields) get() []interface{} {
+	f.mx.RLock()
+	defer f.mx.RUnlock()
+	return f.keyvals
+}
+
+type logFieldsKey struct{}
+
+// connectionLog is the logging state of a connection, which is passed with the context of the connection
+type connectionLog struct {
+	fields *logFields
+	info   StructuredLogger
+}
+
+// AddLogFields adds keyvals, e.g. a tenant or a request id, to all further log events of the connection of ctx.
+// Hubs get the context of the connection with Hub.Context() or a context.Context parameter, e.g. in OnConnected.
+// Log events of the connection contain the connection id, the user id, the transport and the hub protocol by default
+func AddLogFields(ctx context.Context, keyvals ...interface{}) {
+	if log, ok := ctx.Value(logFieldsKey{}).(*connectionLog); ok {
+		log.fields.add(keyvals...)
+	}
+}
+
+// LoggerFromContext returns the info logger of the connection of ctx, which adds the fields of the connection
+// to each event. If ctx is not the context of a connection, events are dropped
+func LoggerFromContext(ctx context.Context) StructuredLogger {
+	if log, ok := ctx.Value(logFieldsKey{}).(*connectionLog); ok {
+		return log.info
+	}
+	return discardLogger{}
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Log(...interface{}) error {
+	return nil
+}
+
+func containsValuer(keyvals []interface{}) bool {
+	for i := 1; i < len(keyvals); i += 2 {
+		if _, ok := keyvals[i].(logValuer); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func bindValues(keyvals []interface{}) {
+	for i := 1; i < len(keyvals); i += 2 {
+		if v, ok := keyvals[i].(logValuer); ok {
+			keyvals[i] = v()
+		}
+	}
+}
+
+// logLevelValues are the values of the "level" key which are passed to the logger instead of the LogLevels
+type logLevelValues struct {
+	debug, info interface{}
+}
+
+func (v *logLevelValues) replace(keyvals []interface{}) []interface{} {
+	replaced := append([]interface{}(nil), keyvals...)
+	for i := 0; i < len(replaced)-1; i += 2 {
+		if replaced[i] != "level" {
+			continue
+		}
+		switch replaced[i+1] {
+		case LogLevelDebug:
+			replaced[i+1] = v.debug
+		case LogLevelInfo:
+			replaced[i+1] = v.info
+		}
+	}
+	return replaced
+}
+
+// levelFilter drops events below the level of their subsystem
+type levelFilter struct {
+	next       StructuredLogger
+	level      LogLevel
+	subsystems map[string]LogLevel
+	values     *logLevelValues
+}
+
+func (f *levelFilter) Log(keyvals ...interface{}) error {
+	eventLevel, subsystem, hasSubsystem := LogLevelInfo, "", false
+	for i := 0; i < len(keyvals)-1; i += 2 {
+		switch keyvals[i] {
+		case "level":
+			if l, ok := keyvals[i+1].(LogLevel); ok {
+				eventLevel = l
+			}
+		case "class", "protocol":
+			// The first subsystem is the most specific, because loggers of subsystems are built with withPrefix
+			if !hasSubsystem {
+				subsystem, hasSubsystem = fmt.Sprint(keyvals[i+1]), true
+			}
+		}
+	}
+	allowed := f.level
+	if l, ok := f.subsystems[subsystem]; ok {
+		allowed = l
+	}
+	if eventLevel < allowed {
+		return nil
+	}
+	if f.values != nil {
+		keyvals = f.values.replace(keyvals)
+	}
+	return f.next.Log(keyvals...)
+}
+
+// logsLevel tells if logger might log events of level for the subsystem. Only the loggers built by
+// buildInfoDebugLogger are known to drop events
+func logsLevel(logger StructuredLogger, level LogLevel, subsystem string) bool {
+	if l, ok := logger.(*logContext); ok {
+		logger = l.logger
+	}
+	filter, ok := logger.(*levelFilter)
+	if !ok {
+		return true
+	}
+	allowed := filter.level
+	if l, ok := filter.subsystems[subsystem]; ok {
+		allowed = l
+	}
+	return level >= allowed
+}
+
+func buildInfoDebugLogger(logger StructuredLogger, debug bool, subsystems map[string]LogLevel, values *logLevelValues) (StructuredLogger, StructuredLogger) {
+	filter := &levelFilter{next: logger, level: LogLevelInfo, subsystems: subsystems, values: values}
+	if debug {
+		filter.level = LogLevelDebug
+	}
+	return withPrefix(filter, "level", LogLevelInfo), with(withPrefix(filter, "level", LogLevelDebug), "caller", defaultCaller)
+}
+
+// logfmtLogger writes each event as one line in logfmt format
+type logfmtLogger struct {
+	mx sync.Mutex
+	w  io.Writer
+}
+
+func newLogfmtLogger(w io.Writer) StructuredLogger {
+	return &logfmtLogger{w: w}
+}
+
+func (l *logfmtLogger) Log(keyvals ...interface{}) error {
+	var b bytes.Buffer
+	for i := 0; i < len(keyvals)-1; i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(logfmtValue(keyvals[i]))
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(keyvals[i+1]))
+	}
+	b.WriteByte('\n')
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	_, err := l.w.Write(b.Bytes())
+	return err
+}
+
+func logfmtValue(value interface{}) string {
+	var s string
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		s = v
+	case error:
+		s = v.Error()
+	default:
+		s = fmt.Sprint(v)
+	}
+	if s == "" || strings.ContainsAny(s, " =\"\\") || strings.IndexFunc(s, func(r rune) bool { return r < ' ' }) >= 0 {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// LeveledLogger is the interface of loggers with a method for each level and key value pairs,
+// e.g. *zap.SugaredLogger
+type LeveledLogger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+}
+
+// NewLeveledLoggerAdapter creates a StructuredLogger which logs to a LeveledLogger.
+// The message is the value of the "event" key
+func NewLeveledLoggerAdapter(logger LeveledLogger) StructuredLogger {
+	return &leveledLoggerAdapter{logger: logger}
+}
+
+type leveledLoggerAdapter struct {
+	logger LeveledLogger
+}
+
+func (l *leveledLoggerAdapter) Log(keyvals ...interface{}) error {
+	eventLevel, msg, kvs := splitLogEvent(keyvals)
+	if eventLevel == LogLevelDebug {
+		l.logger.Debugw(msg, kvs...)
+	} else {
+		l.logger.Infow(msg, kvs...)
+	}
+	return nil
+}
+
+// splitLogEvent returns the level and message of the event and the remaining keyvals
+func splitLogEvent(keyvals []interface{}) (LogLevel, string, []interface{}) {
+	eventLevel, msg := LogLevelInfo, ""
+	kvs := make([]interface{}, 0, len(keyvals))
+	for i := 0; i < len(keyvals)-1; i += 2 {
+		switch keyvals[i] {
+		case "level":
+			if l, ok := keyvals[i+1].(LogLevel); ok {
+				eventLevel = l
+				continue
+			}
+		case evt:
+			if msg == "" {
+				msg = fmt.Sprint(keyvals[i+1])
+				continue
+			}
+		}
+		kvs = append(kvs, fmt.Sprint(keyvals[i]), keyvals[i+1])
+	}
+	return eventLevel, msg, kvs
+}
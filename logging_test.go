@@ -0,0 +1,192 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log/level"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+	"sync"
+)
+
+type recordingLogger struct {
+	mx     sync.Mutex
+	events [][]interface{}
+}
+
+func (r *recordingLogger) Log(keyvals ...interface{}) error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.events = append(r.events, keyvals)
+	return nil
+}
+
+func (r *recordingLogger) count() int {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return len(r.events)
+}
+
+type recordingLeveledLogger struct {
+	debug, info []string
+}
+
+func (r *recordingLeveledLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	r.debug = append(r.debug, fmt.Sprint(msg, keysAndValues))
+}
+
+func (r *recordingLeveledLogger) Infow(msg string, keysAndValues ...interface{}) {
+	r.info = append(r.info, fmt.Sprint(msg, keysAndValues))
+}
+
+type logFieldsHub struct {
+	Hub
+}
+
+func (l *logFieldsHub) OnConnected(string) {
+	AddLogFields(l.Context(), "tenant", "acme")
+}
+
+func (l *logFieldsHub) Hello() {
+	_ = LoggerFromContext(l.Context()).Log(evt, "hello")
+}
+
+// event returns the first event containing the value
+func (r *recordingLogger) event(value interface{}) []interface{} {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, event := range r.events {
+		for _, v := range event {
+			if v == value {
+				return event
+			}
+		}
+	}
+	return nil
+}
+
+var _ = Describe("Logging", func() {
+
+	Context("When debug is disabled", func() {
+		It("should drop debug events, but not info events", func() {
+			logger := &recordingLogger{}
+			info, dbg := buildInfoDebugLogger(logger, false, nil, nil)
+			_ = dbg.Log(evt, "d")
+			_ = info.Log(evt, "i")
+			Expect(logger.events).To(HaveLen(1))
+			Expect(logger.events[0]).To(Equal([]interface{}{"level", LogLevelInfo, evt, "i"}))
+		})
+	})
+
+	Context("When debug is enabled for a subsystem", func() {
+		It("should only log the debug events of the subsystem", func() {
+			logger := &recordingLogger{}
+			_, dbg := buildInfoDebugLogger(logger, false, map[string]LogLevel{LogSubsystemJSON: LogLevelDebug}, nil)
+			_ = withPrefix(dbg, "protocol", LogSubsystemJSON).Log(evt, "json")
+			_ = withPrefix(dbg, "class", LogSubsystemHubConnection).Log(evt, "conn")
+			Expect(logger.events).To(HaveLen(1))
+			Expect(logger.events[0]).To(ContainElement("json"))
+		})
+		It("should use the most specific subsystem", func() {
+			logger := &recordingLogger{}
+			_, dbg := buildInfoDebugLogger(logger, true, map[string]LogLevel{LogSubsystemServer: LogLevelInfo}, nil)
+			serverDbg := withPrefix(dbg, "class", LogSubsystemServer)
+			_ = serverDbg.Log(evt, "server")
+			_ = withPrefix(serverDbg, "class", LogSubsystemHubConnection).Log(evt, "conn")
+			Expect(logger.events).To(HaveLen(1))
+			Expect(logger.events[0]).To(ContainElement("conn"))
+		})
+	})
+
+	Context("When a server is created with SubsystemLogLevel", func() {
+		It("should log the debug events of the subsystem", func() {
+			logger := &recordingLogger{}
+			server, err := NewServer(context.Background(), SimpleHubFactory(&filterHub{}), Logger(logger, false),
+				SubsystemLogLevel(LogSubsystemHubConnection, LogLevelDebug))
+			Expect(err).To(BeNil())
+			_ = withPrefix(server.dbg, "class", LogSubsystemHubConnection).Log(evt, "conn")
+			_ = withPrefix(server.dbg, "class", LogSubsystemServer).Log(evt, "server")
+			Expect(logger.count()).To(Equal(1))
+		})
+	})
+
+	Context("When a server is created with LogLevelValues", func() {
+		It("should pass the values to the logger, so a go-kit level filter can filter by them", func() {
+			logger := &recordingLogger{}
+			server, err := NewServer(context.Background(), SimpleHubFactory(&filterHub{}),
+				Logger(level.NewFilter(logger, level.AllowInfo()), true),
+				LogLevelValues(level.DebugValue(), level.InfoValue()))
+			Expect(err).To(BeNil())
+			_ = server.dbg.Log(evt, "dropped")
+			_ = server.info.Log(evt, "logged")
+			Expect(logger.events).To(HaveLen(1))
+			Expect(logger.events[0]).To(Equal([]interface{}{"level", level.InfoValue(), evt, "logged"}))
+		})
+		It("should not accept nil values", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&filterHub{}),
+				LogLevelValues(nil, level.InfoValue()))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When a logger has valuers", func() {
+		It("should evaluate them for each event and log the caller", func() {
+			logger := &recordingLogger{}
+			n := 0
+			counter := logValuer(func() interface{} { n++; return n })
+			_, dbg := buildInfoDebugLogger(logger, true, nil, nil)
+			l := withPrefix(dbg, "n", counter)
+			_ = l.Log(evt, "first")
+			_ = l.Log()
+			Expect(logger.events[0][1]).To(Equal(1))
+			Expect(logger.events[1][1]).To(Equal(2))
+			Expect(logger.events[0]).To(ContainElement(HavePrefix("logging_test.go:")))
+		})
+	})
+
+	Context("When a connection logs", func() {
+		It("should add the fields of the connection and the fields added by the hub", func() {
+			logger := &recordingLogger{}
+			server, err := NewServer(context.Background(), SimpleHubFactory(&logFieldsHub{}), Logger(logger, false))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"hello"}`)
+			Expect(receiveCompletion(conn).Error).To(BeEmpty())
+			event := logger.event("hello")
+			Expect(event).NotTo(BeNil())
+			for _, field := range []interface{}{"connection", conn.ConnectionID(), "transport", "*signalr.testingConnection",
+				"hubProtocol", "json", "tenant", "acme", "class", LogSubsystemServer} {
+				Expect(event).To(ContainElement(field))
+			}
+		})
+	})
+
+	Context("When LoggerFromContext gets a context without connection", func() {
+		It("should return a logger which drops the events", func() {
+			Expect(LoggerFromContext(context.Background()).Log(evt, "dropped")).To(BeNil())
+		})
+	})
+
+	Context("When events are written in logfmt", func() {
+		It("should quote values if needed", func() {
+			var b bytes.Buffer
+			_ = newLogfmtLogger(&b).Log("level", LogLevelDebug, evt, "a b", "error", nil, "v", 1)
+			Expect(b.String()).To(Equal("level=debug event=\"a b\" error=null v=1\n"))
+		})
+	})
+
+	Context("When a LeveledLogger is adapted", func() {
+		It("should call the method of the level with the event as message", func() {
+			leveled := &recordingLeveledLogger{}
+			info, dbg := buildInfoDebugLogger(NewLeveledLoggerAdapter(leveled), true, nil, nil)
+			_ = info.Log(evt, "handshake", "protocol", "json")
+			_ = dbg.Log(evt, "write")
+			Expect(leveled.info).To(Equal([]string{"handshake[protocol json]"}))
+			Expect(leveled.debug).To(HaveLen(1))
+			Expect(strings.HasPrefix(leveled.debug[0], "write[caller logging_test.go:")).To(BeTrue())
+		})
+	})
+})
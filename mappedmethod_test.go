@@ -0,0 +1,80 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type mappedMethodHub struct {
+	Hub
+}
+
+func (m *mappedMethodHub) SendMessage(message string) string {
+	return "sent " + message
+}
+
+func (m *mappedMethodHub) Helper() string {
+	return "helper"
+}
+
+func (m *mappedMethodHub) Sum(values ...int) int {
+	sum := 0
+	for _, value := range values {
+		sum += value
+	}
+	return sum
+}
+
+func connectMappedMethodHub(options ...Option) *testingConnection {
+	server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&mappedMethodHub{}),
+		Logger(log.NewNopLogger(), false)}, options...)...)
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	go server.Run(conn)
+	return conn
+}
+
+var _ = Describe("MapMethod", func() {
+
+	Context("When a method is mapped to an alias", func() {
+		It("should invoke the method by the alias, case-insensitive", func() {
+			conn := connectMappedMethodHub(MapMethod("post", (*mappedMethodHub).SendMessage))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"POST","arguments":["hello"]}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.Error).To(BeEmpty())
+			Expect(completion.Result).To(Equal("sent hello"))
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"sendMessage","arguments":["again"]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal("sent again"))
+		})
+		It("should invoke variadic methods", func() {
+			conn := connectMappedMethodHub(MapMethod("add", (*mappedMethodHub).Sum))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"add","arguments":[1,2,3]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal(float64(6)))
+		})
+	})
+
+	Context("When a method is excluded", func() {
+		It("should not be callable by its name, but by its alias", func() {
+			conn := connectMappedMethodHub(ExcludeMethods("sendmessage", "Helper"),
+				MapMethod("post", (*mappedMethodHub).SendMessage))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"helper"}`)
+			Expect(receiveCompletion(conn).Error).NotTo(BeEmpty())
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"SendMessage","arguments":["hello"]}`)
+			Expect(receiveCompletion(conn).Error).NotTo(BeEmpty())
+			conn.ClientSend(`{"type":1,"invocationId":"3","target":"post","arguments":["hello"]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal("sent hello"))
+		})
+	})
+
+	Context("When the mapped method has no hub receiver", func() {
+		It("should return an error", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&mappedMethodHub{}),
+				MapMethod("post", func(message string) {}))
+			Expect(err).NotTo(BeNil())
+			_, err = NewServer(context.Background(), SimpleHubFactory(&mappedMethodHub{}), MapMethod("post", "post"))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,78 @@
+package signalr
+
+import (
+	"context"
+	"net/http"
+)
+
+// MessageHeaders are the headers of invocation, stream item, completion and cancel invocation messages,
+// e.g. for tracing or tenancy metadata.
+// Arguments of type MessageHeaders are not sent as arguments, but as headers of the invocation message, e.g.
+//
+//	server.HubClients().All().Send("receive", signalr.MessageHeaders{"tenant": "a"}, message)
+//	client.Invoke(ctx, "add", &sum, signalr.MessageHeaders{"traceparent": traceParent}, 1, 2)
+//
+// The headers of received invocations are passed to the HubFilters and can be read from the context of the
+// hub method with MessageHeadersFromContext
+type MessageHeaders map[string]string
+
+type messageHeadersKey struct{}
+
+// MessageHeadersFromContext returns the headers of the invocation message of a hub method call,
+// or nil if the message had no headers
+func MessageHeadersFromContext(ctx context.Context) MessageHeaders {
+	headers, _ := ctx.Value(messageHeadersKey{}).(MessageHeaders)
+	return headers
+}
+
+// splitHeaders removes the arguments of type MessageHeaders from args and returns them merged
+func splitHeaders(args []interface{}) (MessageHeaders, []interface{}) {
+	var headers MessageHeaders
+	var rest []interface{}
+	for i, arg := range args {
+		argHeaders, ok := arg.(MessageHeaders)
+		if !ok {
+			if headers != nil {
+				rest = append(rest, arg)
+			}
+			continue
+		}
+		if headers == nil {
+			// args is owned by the caller, so the other arguments are copied
+			headers = make(MessageHeaders, len(argHeaders))
+			rest = append(make([]interface{}, 0, len(args)-1), args[:i]...)
+		}
+		for key, value := range argHeaders {
+			headers[key] = value
+		}
+	}
+	if headers == nil {
+		return nil, args
+	}
+	return headers, rest
+}
+
+// withHeaders appends headers as argument to args, so they are split again when the message is sent
+func withHeaders(args []interface{}, headers MessageHeaders) []interface{} {
+	if len(headers) == 0 {
+		return args
+	}
+	return append(append(make([]interface{}, 0, len(args)+1), args...), headers)
+}
+
+// httpHeader returns the headers as http.Header, e.g. to extract a trace context
+func (h MessageHeaders) httpHeader() http.Header {
+	header := make(http.Header, len(h))
+	for key, value := range h {
+		header.Set(key, value)
+	}
+	return header
+}
+
+// orEmpty returns the headers or, if they are nil, an empty map. The MessagePack encoding always has a map
+func (h MessageHeaders) orEmpty() map[string]string {
+	if h == nil {
+		return map[string]string{}
+	}
+	return h
+}
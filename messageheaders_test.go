@@ -0,0 +1,165 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+func receiveInvocation(conn *testingConnection) invocationMessage {
+	for {
+		select {
+		case message := <-conn.received:
+			if invocation, ok := message.(invocationMessage); ok {
+				return invocation
+			}
+		case <-time.After(time.Second):
+			Fail("timed out")
+			return invocationMessage{}
+		}
+	}
+}
+
+var _ = Describe("MessageHeaders", func() {
+
+	Context("When messages with headers are written and parsed", func() {
+		It("should keep the headers", func() {
+			headers := MessageHeaders{"traceparent": "00-1-2-01", "tenant": "a"}
+			for _, protocol := range []HubProtocol{&JSONHubProtocol{}, newMessagePackProtocol()} {
+				protocol.setDebugLogger(log.NewNopLogger())
+				for _, message := range []interface{}{
+					invocationMessage{Type: 1, Headers: headers, Target: "add", InvocationID: "1", Arguments: []interface{}{}},
+					streamItemMessage{Type: 2, Headers: headers, InvocationID: "1", Item: 1.0},
+					completionMessage{Type: 3, Headers: headers, InvocationID: "1", Result: 1.0},
+					cancelInvocationMessage{Type: 5, Headers: headers, InvocationID: "1"},
+				} {
+					var buf bytes.Buffer
+					Expect(protocol.WriteMessage(message, &buf)).To(BeNil())
+					_, frame, err := protocol.splitFrame(buf.Bytes(), true)
+					Expect(err).To(BeNil())
+					parsed, err := protocol.parseFrame(frame)
+					Expect(err).To(BeNil())
+					var parsedHeaders MessageHeaders
+					switch parsed := parsed.(type) {
+					case invocationMessage:
+						parsedHeaders = parsed.Headers
+					case streamItemMessage:
+						parsedHeaders = parsed.Headers
+					case completionMessage:
+						parsedHeaders = parsed.Headers
+					case cancelInvocationMessage:
+						parsedHeaders = parsed.Headers
+					}
+					Expect(parsedHeaders).To(Equal(headers), "%T %T", protocol, message)
+				}
+			}
+		})
+	})
+
+	Context("When messages with invalid headers are parsed", func() {
+		It("should reject them", func() {
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			for _, frame := range []string{
+				`{"type":1,"headers":{"a":1},"target":"add","arguments":[]}`,
+				`{"type":3,"headers":["a"],"invocationId":"1"}`,
+			} {
+				_, err := protocol.parseFrame([]byte(frame))
+				Expect(err).NotTo(BeNil(), frame)
+			}
+			mpProtocol := newMessagePackProtocol()
+			for _, frame := range [][]byte{
+				msgpackFrame(1, map[string]interface{}{"a": 1}, "1", "add", []interface{}{}),
+				msgpackFrame(5, []interface{}{"a"}, "1"),
+			} {
+				_, err := mpProtocol.parseFrame(frame)
+				Expect(err).NotTo(BeNil(), "%v", frame)
+			}
+		})
+	})
+
+	Context("When an invocation with headers is received", func() {
+		It("should pass the headers to the filters and the context of the hub method", func() {
+			headers := make(chan MessageHeaders, 2)
+			conn := connectFilterHub(HubFilterFunc(func(invocation *HubInvocationContext,
+				next func() ([]interface{}, error)) ([]interface{}, error) {
+				headers <- invocation.Headers
+				headers <- MessageHeadersFromContext(invocation.Context)
+				return next()
+			}))
+			conn.ClientSend(`{"type":1,"headers":{"tenant":"a"},"invocationId":"1","target":"add","arguments":[1,2]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal(3.0))
+			Expect(<-headers).To(Equal(MessageHeaders{"tenant": "a"}))
+			Expect(<-headers).To(Equal(MessageHeaders{"tenant": "a"}))
+		})
+		It("should pass no headers if the message has none", func() {
+			headers := make(chan MessageHeaders, 1)
+			conn := connectFilterHub(HubFilterFunc(func(invocation *HubInvocationContext,
+				next func() ([]interface{}, error)) ([]interface{}, error) {
+				headers <- MessageHeadersFromContext(invocation.Context)
+				return next()
+			}))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"add","arguments":[1,2]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal(3.0))
+			Expect(<-headers).To(BeNil())
+		})
+	})
+
+	Context("When a client method is invoked with headers", func() {
+		It("should send them as headers of the invocation", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&chatHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := runAddHubConnection(server)
+			server.HubClients().Client(conn.ConnectionID()).Send("clientFunc", MessageHeaders{"tenant": "a"}, 1)
+			invocation := receiveInvocation(conn)
+			Expect(invocation.Headers).To(Equal(MessageHeaders{"tenant": "a"}))
+			Expect(invocation.Arguments).To(Equal([]interface{}{1.0}))
+		})
+		It("should send them to all connections", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&chatHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conns := []*testingConnection{runAddHubConnection(server), runAddHubConnection(server)}
+			server.HubClients().All().Send("clientFunc", MessageHeaders{"tenant": "a"}, 1, MessageHeaders{"user": "b"})
+			for _, conn := range conns {
+				invocation := receiveInvocation(conn)
+				Expect(invocation.Headers).To(Equal(MessageHeaders{"tenant": "a", "user": "b"}))
+				Expect(invocation.Arguments).To(Equal([]interface{}{1.0}))
+			}
+		})
+		It("should send them through the backplane", func() {
+			backplane := &memoryBackplane{}
+			var servers []*Server
+			var conns []*testingConnection
+			for i := 0; i < 2; i++ {
+				server, err := NewServer(context.Background(), SimpleHubFactory(&chatHub{}), UseBackplane(backplane),
+					Logger(log.NewNopLogger(), false))
+				Expect(err).To(BeNil())
+				servers = append(servers, server)
+				conns = append(conns, runAddHubConnection(server))
+			}
+			servers[0].HubClients().Client(conns[1].ConnectionID()).Send("clientFunc", MessageHeaders{"tenant": "a"}, 1)
+			invocation := receiveInvocation(conns[1])
+			Expect(invocation.Headers).To(Equal(MessageHeaders{"tenant": "a"}))
+			Expect(invocation.Arguments).To(Equal([]interface{}{1.0}))
+		})
+	})
+
+	Context("When the arguments are split", func() {
+		It("should not change arguments without headers", func() {
+			args := []interface{}{1, "a"}
+			headers, rest := splitHeaders(args)
+			Expect(headers).To(BeNil())
+			Expect(rest).To(Equal(args))
+		})
+		It("should not change the arguments of the caller", func() {
+			args := []interface{}{1, MessageHeaders{"a": "1"}, "b"}
+			headers, rest := splitHeaders(args)
+			Expect(headers).To(Equal(MessageHeaders{"a": "1"}))
+			Expect(rest).To(Equal([]interface{}{1, "b"}))
+			Expect(args).To(Equal([]interface{}{1, MessageHeaders{"a": "1"}, "b"}))
+		})
+	})
+})
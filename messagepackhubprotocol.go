@@ -0,0 +1,436 @@
+package signalr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"io"
+)
+
+// MessagePackHubProtocol is the MessagePack based SignalR protocol
+type MessagePackHubProtocol struct {
+	dbg StructuredLogger
+	// strict rejects messages which violate the protocol, see StrictProtocol
+	strict bool
+}
+
+// Completion result kinds, see https://github.com/aspnet/AspNetCore/blob/master/src/SignalR/docs/specs/HubProtocol.md#messagepack-msgpack-encoding
+const (
+	msgpackResultError   = 1
+	msgpackResultVoid    = 2
+	msgpackResultNonVoid = 3
+)
+
+// A varint length prefix is at most 5 bytes long and encodes at most 2GB
+const maxLengthPrefixSize = 5
+
+type messagePackError struct {
+	raw []byte
+	err error
+}
+
+func (m *messagePackError) Error() string {
+	return fmt.Sprintf("%v (source: %v)", m.err, m.raw)
+}
+
+func (m *messagePackError) Unwrap() error {
+	return m.err
+}
+
+// UnmarshalArgument unmarshals a msgpack.RawMessage depending of the specified value type into value
+func (m *MessagePackHubProtocol) UnmarshalArgument(argument interface{}, value interface{}) error {
+	raw, ok := argument.(msgpack.RawMessage)
+	if !ok {
+		return fmt.Errorf("invalid argument %v. Expected msgpack.RawMessage", argument)
+	}
+	decoder := msgpack.NewDecoder(bytes.NewReader(raw))
+	decoder.SetCustomStructTag("json")
+	if err := decoder.Decode(value); err != nil {
+		return &messagePackError{raw, err}
+	}
+	return nil
+}
+
+func (m *MessagePackHubProtocol) unmarshalNumber(argument interface{}) (interface{}, error) {
+	var value interface{}
+	err := m.UnmarshalArgument(argument, &value)
+	return value, err
+}
+
+// ReadMessage reads a MessagePack message from buf and returns the message if the buf contained one completely.
+// If buf does not contain the whole message, it returns a nil message and complete false
+func (m *MessagePackHubProtocol) ReadMessage(buf *bytes.Buffer) (message interface{}, complete bool, err error) {
+	data, err := parseBinaryMessageFormat(buf)
+	if err != nil {
+		return nil, errors.Is(err, errInvalidLengthPrefix), err
+	}
+	message, err = m.parseFrame(data)
+	return message, true, err
+}
+
+func (m *MessagePackHubProtocol) splitFrame(data []byte, atEOF bool) (int, []byte, error) {
+	return splitBinaryFrame(data, atEOF)
+}
+
+// splitBinaryFrame splits data by the varint length prefix of the frames
+func splitBinaryFrame(data []byte, _ bool) (int, []byte, error) {
+	length := 0
+	for i := 0; i < maxLengthPrefixSize; i++ {
+		if i == len(data) {
+			return 0, nil, nil
+		}
+		length |= int(data[i]&0x7f) << (7 * uint(i))
+		if data[i]&0x80 == 0 {
+			if len(data) < i+1+length {
+				return 0, nil, nil
+			}
+			return i + 1 + length, data[i+1 : i+1+length], nil
+		}
+	}
+	return 0, nil, errInvalidLengthPrefix
+}
+
+func (m *MessagePackHubProtocol) parseFrame(data []byte) (message interface{}, err error) {
+	_ = m.dbg.Log(evt, "read", msg, fmt.Sprintf("%v", data))
+	decoder := msgpack.GetDecoder()
+	reader := bytes.NewReader(data)
+	decoder.Reset(reader)
+	decoder.SetCustomStructTag("json")
+	if message, err = m.decodeMessage(decoder); err == nil && m.strict {
+		if reader.Len() > 0 {
+			err = newProtocolError("%v bytes after the message", reader.Len())
+		} else {
+			err = validateMessage(message)
+		}
+	}
+	if err != nil {
+		// The decoder is not put back to the pool, its buffer might have grown by the lengths of a malformed message.
+		// data is part of the buffer of the connection, which is reused
+		return message, &messagePackError{append([]byte(nil), data...), err}
+	}
+	msgpack.PutDecoder(decoder)
+	return message, nil
+}
+
+var errInvalidLengthPrefix = errors.New("messagepack message length prefix is invalid")
+
+// parseBinaryMessageFormat reads a varint length prefixed message out of buf.
+// Like parseTextMessageFormat, it consumes buf when it does not contain the whole message.
+func parseBinaryMessageFormat(buf *bytes.Buffer) ([]byte, error) {
+	advance, data, err := splitBinaryFrame(buf.Bytes(), false)
+	switch {
+	case err != nil:
+		buf.Reset()
+		return nil, err
+	case advance == 0:
+		buf.Reset()
+		return nil, io.EOF
+	}
+	buf.Next(advance)
+	return data, nil
+}
+
+func (m *MessagePackHubProtocol) decodeMessage(decoder *msgpack.Decoder) (interface{}, error) {
+	arrayLen, err := decoder.DecodeArrayLen()
+	if err != nil {
+		return nil, err
+	}
+	if arrayLen < 1 {
+		return nil, errors.New("messagepack message has no type")
+	}
+	messageType, err := decoder.DecodeInt()
+	if err != nil {
+		return nil, err
+	}
+	switch messageType {
+	case 1, 4:
+		if arrayLen < 5 {
+			return nil, fmt.Errorf("invalid invocation message length %v", arrayLen)
+		}
+		invocation := invocationMessage{Type: messageType}
+		if invocation.Headers, err = m.readHeaders(decoder); err != nil {
+			return nil, err
+		}
+		if invocation.InvocationID, err = decoder.DecodeString(); err != nil {
+			return nil, err
+		}
+		if invocation.Target, err = decoder.DecodeString(); err != nil {
+			return nil, err
+		}
+		argLen, err := decoder.DecodeArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		// argLen is not preallocated, hostile messages could announce huge arrays
+		invocation.Arguments = make([]interface{}, 0)
+		for i := 0; i < argLen; i++ {
+			raw, err := decoder.DecodeRaw()
+			if err != nil {
+				return nil, err
+			}
+			invocation.Arguments = append(invocation.Arguments, raw)
+		}
+		// StreamIds are optional
+		if arrayLen > 5 {
+			streamIDLen, err := decoder.DecodeArrayLen()
+			if err != nil {
+				return nil, err
+			}
+			for i := 0; i < streamIDLen; i++ {
+				streamID, err := decoder.DecodeString()
+				if err != nil {
+					return nil, err
+				}
+				invocation.StreamIds = append(invocation.StreamIds, streamID)
+			}
+		}
+		return invocation, nil
+	case 2:
+		if arrayLen != 4 {
+			return nil, fmt.Errorf("invalid stream item message length %v", arrayLen)
+		}
+		streamItem := streamItemMessage{Type: messageType}
+		if streamItem.Headers, err = m.readHeaders(decoder); err != nil {
+			return nil, err
+		}
+		if streamItem.InvocationID, err = decoder.DecodeString(); err != nil {
+			return nil, err
+		}
+		if streamItem.Item, err = decodeJSONCompatible(decoder); err != nil {
+			return nil, err
+		}
+		return streamItem, nil
+	case 3:
+		if arrayLen < 4 {
+			return nil, fmt.Errorf("invalid completion message length %v", arrayLen)
+		}
+		completion := completionMessage{Type: messageType}
+		if completion.Headers, err = m.readHeaders(decoder); err != nil {
+			return nil, err
+		}
+		if completion.InvocationID, err = decoder.DecodeString(); err != nil {
+			return nil, err
+		}
+		resultKind, err := decoder.DecodeInt()
+		if err != nil {
+			return nil, err
+		}
+		switch resultKind {
+		case msgpackResultError:
+			if completion.Error, err = decoder.DecodeString(); err != nil {
+				return nil, err
+			}
+			// The code and data of a HubError follow the error
+			if arrayLen > 5 {
+				if completion.ErrorCode, err = decoder.DecodeString(); err != nil {
+					return nil, err
+				}
+			}
+			if arrayLen > 6 {
+				if completion.ErrorData, err = decodeJSONCompatible(decoder); err != nil {
+					return nil, err
+				}
+			}
+		case msgpackResultVoid:
+		case msgpackResultNonVoid:
+			if completion.Result, err = decodeJSONCompatible(decoder); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("invalid completion result kind %v", resultKind)
+		}
+		return completion, nil
+	case 5:
+		if arrayLen != 3 {
+			return nil, fmt.Errorf("invalid cancel invocation message length %v", arrayLen)
+		}
+		cancel := cancelInvocationMessage{Type: messageType}
+		if cancel.Headers, err = m.readHeaders(decoder); err != nil {
+			return nil, err
+		}
+		if cancel.InvocationID, err = decoder.DecodeString(); err != nil {
+			return nil, err
+		}
+		return cancel, nil
+	case 7:
+		if arrayLen < 2 {
+			return nil, fmt.Errorf("invalid close message length %v", arrayLen)
+		}
+		cm := closeMessage{Type: messageType}
+		if cm.Error, err = decoder.DecodeString(); err != nil {
+			return nil, err
+		}
+		// AllowReconnect is optional
+		if arrayLen > 2 {
+			if cm.AllowReconnect, err = decoder.DecodeBool(); err != nil {
+				return nil, err
+			}
+		}
+		return cm, nil
+	case 8, 9:
+		if arrayLen != 2 {
+			return nil, fmt.Errorf("invalid ack or sequence message length %v", arrayLen)
+		}
+		sequenceID, err := decoder.DecodeInt64()
+		if err != nil {
+			return nil, err
+		}
+		if messageType == 8 {
+			return ackMessage{Type: messageType, SequenceID: sequenceID}, nil
+		}
+		return sequenceMessage{Type: messageType, SequenceID: sequenceID}, nil
+	default:
+		return hubMessage{Type: messageType}, nil
+	}
+}
+
+// readHeaders reads the headers of the message, which must be a map of strings
+func (m *MessagePackHubProtocol) readHeaders(decoder *msgpack.Decoder) (MessageHeaders, error) {
+	n, err := decoder.DecodeMapLen()
+	if err != nil || n <= 0 {
+		return nil, err
+	}
+	headers := make(MessageHeaders)
+	for i := 0; i < n; i++ {
+		key, err := decoder.DecodeString()
+		if err != nil {
+			return nil, fmt.Errorf("invalid header name: %w", err)
+		}
+		if headers[key], err = decoder.DecodeString(); err != nil {
+			return nil, fmt.Errorf("invalid value of header %v: %w", key, err)
+		}
+	}
+	return headers, nil
+}
+
+// decodeJSONCompatible decodes the next value in a representation the JSON protocol would have used,
+// i.e. all numbers as float64, arrays as []interface{} and maps as map[string]interface{}.
+// This allows the stream client to convert values independent of the protocol.
+func decodeJSONCompatible(decoder *msgpack.Decoder) (interface{}, error) {
+	value, err := decoder.DecodeInterfaceLoose()
+	if err != nil {
+		return nil, err
+	}
+	return toJSONCompatible(value), nil
+}
+
+func toJSONCompatible(value interface{}) interface{} {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case []interface{}:
+		for i, e := range v {
+			v[i] = toJSONCompatible(e)
+		}
+		return v
+	case map[string]interface{}:
+		for k, e := range v {
+			v[k] = toJSONCompatible(e)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = toJSONCompatible(e)
+		}
+		return m
+	default:
+		return v
+	}
+}
+
+// WriteMessage writes a message as MessagePack to the specified writer
+func (m *MessagePackHubProtocol) WriteMessage(message interface{}, writer io.Writer) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	encoder := msgpack.GetEncoder()
+	defer msgpack.PutEncoder(encoder)
+	encoder.Reset(buf)
+	encoder.SetCustomStructTag("json")
+	if err := m.encodeMessage(encoder, message); err != nil {
+		return err
+	}
+	_ = m.dbg.Log(evt, "write", msg, fmt.Sprintf("%v", message))
+	// We're copying because we want to write complete messages to the underlying Writer
+	frame := getBuffer()
+	defer putBuffer(frame)
+	for length := buf.Len(); ; {
+		if length < 0x80 {
+			frame.WriteByte(byte(length))
+			break
+		}
+		frame.WriteByte(byte(length&0x7f | 0x80))
+		length >>= 7
+	}
+	frame.Write(buf.Bytes())
+	_, err := writer.Write(frame.Bytes())
+	return err
+}
+
+func (m *MessagePackHubProtocol) encodeMessage(encoder *msgpack.Encoder, message interface{}) error {
+	switch message := message.(type) {
+	case sendOnlyHubInvocationMessage:
+		return encodeArray(encoder, 1, message.Headers.orEmpty(), nil, message.Target, message.Arguments, []string{})
+	case invocationMessage:
+		var invocationID interface{}
+		if message.InvocationID != "" {
+			invocationID = message.InvocationID
+		}
+		streamIds := message.StreamIds
+		if streamIds == nil {
+			streamIds = []string{}
+		}
+		return encodeArray(encoder, message.Type, message.Headers.orEmpty(), invocationID, message.Target, message.Arguments, streamIds)
+	case streamItemMessage:
+		return encodeArray(encoder, 2, message.Headers.orEmpty(), message.InvocationID, message.Item)
+	case completionMessage:
+		switch {
+		case message.Error != "" && (message.ErrorCode != "" || message.ErrorData != nil):
+			return encodeArray(encoder, 3, message.Headers.orEmpty(), message.InvocationID, msgpackResultError, message.Error,
+				message.ErrorCode, message.ErrorData)
+		case message.Error != "":
+			return encodeArray(encoder, 3, message.Headers.orEmpty(), message.InvocationID, msgpackResultError, message.Error)
+		case message.Result == nil:
+			return encodeArray(encoder, 3, message.Headers.orEmpty(), message.InvocationID, msgpackResultVoid)
+		default:
+			return encodeArray(encoder, 3, message.Headers.orEmpty(), message.InvocationID, msgpackResultNonVoid, message.Result)
+		}
+	case cancelInvocationMessage:
+		return encodeArray(encoder, 5, message.Headers.orEmpty(), message.InvocationID)
+	case closeMessage:
+		return encodeArray(encoder, 7, message.Error, message.AllowReconnect)
+	case ackMessage:
+		return encodeArray(encoder, 8, message.SequenceID)
+	case sequenceMessage:
+		return encodeArray(encoder, 9, message.SequenceID)
+	case hubMessage:
+		return encodeArray(encoder, message.Type)
+	default:
+		return fmt.Errorf("%#v is no message which can be written with the messagepack protocol", message)
+	}
+}
+
+func encodeArray(encoder *msgpack.Encoder, values ...interface{}) error {
+	if err := encoder.EncodeArrayLen(len(values)); err != nil {
+		return err
+	}
+	for _, value := range values {
+		if err := encoder.Encode(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MessagePackHubProtocol) setDebugLogger(dbg StructuredLogger) {
+	m.dbg = withPrefix(dbg, "ts", defaultTimestampUTC, "protocol", LogSubsystemMessagePack)
+}
+
+func (m *MessagePackHubProtocol) setStrict(strict bool) {
+	m.strict = strict
+}
@@ -0,0 +1,189 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net"
+	"time"
+)
+
+type pipeConnection struct {
+	net.Conn
+	connectionID string
+}
+
+func (p *pipeConnection) ConnectionID() string {
+	return p.connectionID
+}
+
+func newMessagePackProtocol() *MessagePackHubProtocol {
+	protocol := &MessagePackHubProtocol{}
+	protocol.setDebugLogger(log.NewNopLogger())
+	return protocol
+}
+
+var _ = Describe("MessagePackHubProtocol", func() {
+
+	Context("ReadMessage", func() {
+		It("should read the invocation example of the spec", func() {
+			protocol := newMessagePackProtocol()
+			// [1, {}, "xyz", "method", [42]]
+			buf := bytes.NewBuffer([]byte{0x10, 0x95, 0x01, 0x80, 0xa3, 0x78, 0x79, 0x7a,
+				0xa6, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x91, 0x2a})
+			message, complete, err := protocol.ReadMessage(buf)
+			Expect(err).To(BeNil())
+			Expect(complete).To(BeTrue())
+			Expect(message).To(BeAssignableToTypeOf(invocationMessage{}))
+			invocation := message.(invocationMessage)
+			Expect(invocation.InvocationID).To(Equal("xyz"))
+			Expect(invocation.Target).To(Equal("method"))
+			Expect(len(invocation.Arguments)).To(Equal(1))
+			var value int
+			Expect(protocol.UnmarshalArgument(invocation.Arguments[0], &value)).To(BeNil())
+			Expect(value).To(Equal(42))
+		})
+		It("should report a partial message as incomplete", func() {
+			protocol := newMessagePackProtocol()
+			buf := bytes.NewBuffer([]byte{0x10, 0x95, 0x01, 0x80})
+			message, complete, _ := protocol.ReadMessage(buf)
+			Expect(complete).To(BeFalse())
+			Expect(message).To(BeNil())
+		})
+		It("should read a non blocking invocation with nil invocation id", func() {
+			protocol := newMessagePackProtocol()
+			// [1, {}, nil, "method", []]
+			buf := bytes.NewBuffer([]byte{0x0c, 0x95, 0x01, 0x80, 0xc0,
+				0xa6, 0x6d, 0x65, 0x74, 0x68, 0x6f, 0x64, 0x90})
+			message, complete, err := protocol.ReadMessage(buf)
+			Expect(err).To(BeNil())
+			Expect(complete).To(BeTrue())
+			Expect(message.(invocationMessage).InvocationID).To(Equal(""))
+		})
+		It("should return an error for an invalid message", func() {
+			protocol := newMessagePackProtocol()
+			buf := bytes.NewBuffer([]byte{0x02, 0x91, 0xa1})
+			_, complete, err := protocol.ReadMessage(buf)
+			Expect(complete).To(BeTrue())
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("WriteMessage", func() {
+		It("should write completions which can be read again", func() {
+			protocol := newMessagePackProtocol()
+			var buf bytes.Buffer
+			Expect(protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "1", Result: 1.5}, &buf)).To(BeNil())
+			Expect(protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "2"}, &buf)).To(BeNil())
+			Expect(protocol.WriteMessage(completionMessage{Type: 3, InvocationID: "3", Error: "failed"}, &buf)).To(BeNil())
+			message, _, err := protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(completionMessage{Type: 3, InvocationID: "1", Result: 1.5}))
+			message, _, err = protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(completionMessage{Type: 3, InvocationID: "2"}))
+			message, _, err = protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(completionMessage{Type: 3, InvocationID: "3", Error: "failed"}))
+		})
+		It("should write stream items with a multi byte length prefix", func() {
+			protocol := newMessagePackProtocol()
+			var buf bytes.Buffer
+			item := string(make([]byte, 300))
+			Expect(protocol.WriteMessage(streamItemMessage{Type: 2, InvocationID: "s", Item: item}, &buf)).To(BeNil())
+			Expect(buf.Bytes()[0] & 0x80).To(Equal(byte(0x80)))
+			message, complete, err := protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(complete).To(BeTrue())
+			Expect(message.(streamItemMessage).Item).To(Equal(item))
+		})
+		It("should write invocations, pings and close messages", func() {
+			protocol := newMessagePackProtocol()
+			var buf bytes.Buffer
+			Expect(protocol.WriteMessage(sendOnlyHubInvocationMessage{Type: 1, Target: "t", Arguments: []interface{}{1, "a"}}, &buf)).To(BeNil())
+			Expect(protocol.WriteMessage(hubMessage{Type: 6}, &buf)).To(BeNil())
+			Expect(protocol.WriteMessage(closeMessage{Type: 7, Error: "bye", AllowReconnect: true}, &buf)).To(BeNil())
+			message, _, err := protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(message.(invocationMessage).Target).To(Equal("t"))
+			var s string
+			Expect(protocol.UnmarshalArgument(message.(invocationMessage).Arguments[1], &s)).To(BeNil())
+			Expect(s).To(Equal("a"))
+			message, _, err = protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(hubMessage{Type: 6}))
+			message, _, err = protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(closeMessage{Type: 7, Error: "bye", AllowReconnect: true}))
+		})
+		It("should write and read ack and sequence messages", func() {
+			protocol := newMessagePackProtocol()
+			var buf bytes.Buffer
+			Expect(protocol.WriteMessage(ackMessage{Type: 8, SequenceID: 42}, &buf)).To(BeNil())
+			Expect(protocol.WriteMessage(sequenceMessage{Type: 9, SequenceID: 43}, &buf)).To(BeNil())
+			message, _, err := protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(ackMessage{Type: 8, SequenceID: 42}))
+			message, _, err = protocol.ReadMessage(&buf)
+			Expect(err).To(BeNil())
+			Expect(message).To(Equal(sequenceMessage{Type: 9, SequenceID: 43}))
+		})
+	})
+
+	Context("When a client connects with the messagepack protocol", func() {
+		It("should answer invocations with messagepack", func() {
+			server, _ := NewServer(context.Background(), SimpleHubFactory(&invocationHub{}), Logger(log.NewNopLogger(), false))
+			srvConn, cliConn := net.Pipe()
+			go server.Run(&pipeConnection{srvConn, "msgpack"})
+			_, err := cliConn.Write(append([]byte(`{"protocol":"messagepack","version":1}`), 30))
+			Expect(err).To(BeNil())
+			var buf bytes.Buffer
+			data := make([]byte, 1<<12)
+			for {
+				n, err := cliConn.Read(data)
+				Expect(err).To(BeNil())
+				buf.Write(data[:n])
+				if _, err = parseTextMessageFormat(&buf); err == nil {
+					break
+				}
+			}
+			protocol := newMessagePackProtocol()
+			go func() {
+				defer GinkgoRecover()
+				Expect(protocol.WriteMessage(invocationMessage{
+					Type:         1,
+					InvocationID: "mp",
+					Target:       "simpleint",
+					Arguments:    []interface{}{41},
+				}, cliConn)).To(BeNil())
+			}()
+			Expect(<-invocationQueue).To(Equal("SimpleInt(41)"))
+			result := make(chan completionMessage, 1)
+			go func() {
+				for {
+					n, err := cliConn.Read(data)
+					if err != nil {
+						return
+					}
+					buf.Write(data[:n])
+					if message, complete, err := protocol.ReadMessage(&buf); complete && err == nil {
+						if completion, ok := message.(completionMessage); ok {
+							result <- completion
+							return
+						}
+					}
+				}
+			}()
+			select {
+			case completion := <-result:
+				Expect(completion.InvocationID).To(Equal("mp"))
+				Expect(completion.Result).To(Equal(42.0))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+			_ = cliConn.Close()
+		})
+	})
+})
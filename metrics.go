@@ -0,0 +1,152 @@
+package signalr
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics receives the events a server can be monitored with. Implementations must be safe for concurrent use
+type Metrics interface {
+	ConnectionOpened()
+	ConnectionClosed()
+	HandshakeFailed()
+	MessageReceived()
+	MessageSent()
+	// InvocationCompleted is called when the hub method has returned
+	InvocationCompleted(method string, duration time.Duration)
+}
+
+type noMetrics struct{}
+
+func (noMetrics) ConnectionOpened()                         {}
+func (noMetrics) ConnectionClosed()                         {}
+func (noMetrics) HandshakeFailed()                          {}
+func (noMetrics) MessageReceived()                          {}
+func (noMetrics) MessageSent()                              {}
+func (noMetrics) InvocationCompleted(string, time.Duration) {}
+
+// PrometheusMetrics is a Metrics implementation which serves the metrics in the Prometheus text exposition format.
+// Register it as http.Handler for the scrape path, e.g. mux.Handle("/metrics", metrics)
+type PrometheusMetrics struct {
+	// first fields for 64 bit alignment of atomic access
+	activeConnections int64
+	connections       uint64
+	handshakeFailures uint64
+	messagesReceived  uint64
+	messagesSent      uint64
+	namespace         string
+	mx                sync.Mutex
+	invocations       map[string]*histogram
+}
+
+// invocationDurationBuckets are the upper bounds in seconds of the invocation duration histogram
+var invocationDurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// NewPrometheusMetrics creates a PrometheusMetrics. The names of all metrics start with namespace
+func NewPrometheusMetrics(namespace string) *PrometheusMetrics {
+	return &PrometheusMetrics{
+		namespace:   namespace,
+		invocations: make(map[string]*histogram),
+	}
+}
+
+func (p *PrometheusMetrics) ConnectionOpened() {
+	atomic.AddInt64(&p.activeConnections, 1)
+	atomic.AddUint64(&p.connections, 1)
+}
+
+func (p *PrometheusMetrics) ConnectionClosed() {
+	atomic.AddInt64(&p.activeConnections, -1)
+}
+
+func (p *PrometheusMetrics) HandshakeFailed() {
+	atomic.AddUint64(&p.handshakeFailures, 1)
+}
+
+func (p *PrometheusMetrics) MessageReceived() {
+	atomic.AddUint64(&p.messagesReceived, 1)
+}
+
+func (p *PrometheusMetrics) MessageSent() {
+	atomic.AddUint64(&p.messagesSent, 1)
+}
+
+func (p *PrometheusMetrics) InvocationCompleted(method string, duration time.Duration) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	h, ok := p.invocations[method]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(invocationDurationBuckets))}
+		p.invocations[method] = h
+	}
+	seconds := duration.Seconds()
+	for i, bound := range invocationDurationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (p *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	var b strings.Builder
+	p.writeMetric(&b, "connections_active", "gauge", "Number of active connections.",
+		atomic.LoadInt64(&p.activeConnections))
+	p.writeMetric(&b, "connections_total", "counter", "Number of connections since the server started.",
+		atomic.LoadUint64(&p.connections))
+	p.writeMetric(&b, "handshake_failures_total", "counter", "Number of failed handshakes.",
+		atomic.LoadUint64(&p.handshakeFailures))
+	p.writeMetric(&b, "messages_received_total", "counter", "Number of messages received from clients.",
+		atomic.LoadUint64(&p.messagesReceived))
+	p.writeMetric(&b, "messages_sent_total", "counter", "Number of messages sent to clients.",
+		atomic.LoadUint64(&p.messagesSent))
+	p.writeInvocations(&b)
+	_, _ = w.Write([]byte(b.String()))
+}
+
+func (p *PrometheusMetrics) name(name string) string {
+	if p.namespace == "" {
+		return name
+	}
+	return p.namespace + "_" + name
+}
+
+func (p *PrometheusMetrics) writeMetric(b *strings.Builder, name, metricType, help string, value interface{}) {
+	name = p.name(name)
+	_, _ = fmt.Fprintf(b, "# HELP %v %v\n# TYPE %v %v\n%v %v\n", name, help, name, metricType, name, value)
+}
+
+func (p *PrometheusMetrics) writeInvocations(b *strings.Builder) {
+	name := p.name("invocation_duration_seconds")
+	_, _ = fmt.Fprintf(b, "# HELP %v Duration of hub method invocations.\n# TYPE %v histogram\n", name, name)
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	methods := make([]string, 0, len(p.invocations))
+	for method := range p.invocations {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	for _, method := range methods {
+		h := p.invocations[method]
+		for i, bound := range invocationDurationBuckets {
+			_, _ = fmt.Fprintf(b, "%v_bucket{method=%q,le=\"%v\"} %v\n", name, method, bound, h.counts[i])
+		}
+		_, _ = fmt.Fprintf(b, "%v_bucket{method=%q,le=\"+Inf\"} %v\n", name, method, h.count)
+		_, _ = fmt.Fprintf(b, "%v_sum{method=%q} %v\n", name, method, h.sum)
+		_, _ = fmt.Fprintf(b, "%v_count{method=%q} %v\n", name, method, h.count)
+	}
+}
@@ -0,0 +1,63 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http/httptest"
+	"time"
+)
+
+func scrape(metrics *PrometheusMetrics) string {
+	recorder := httptest.NewRecorder()
+	metrics.ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	return recorder.Body.String()
+}
+
+var _ = Describe("PrometheusMetrics", func() {
+
+	Context("When a client connects and invokes a hub method", func() {
+		It("should count the connection, the messages and the invocation", func() {
+			metrics := NewPrometheusMetrics("signalr")
+			server, err := NewServer(context.Background(), SimpleHubFactory(&filterHub{}), UseMetrics(metrics), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"add","arguments":[1,2]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal(3.0))
+			Eventually(func() string { return scrape(metrics) }).Should(ContainSubstring("signalr_connections_active 1\n"))
+			text := scrape(metrics)
+			Expect(text).To(ContainSubstring("# TYPE signalr_connections_total counter\nsignalr_connections_total 1\n"))
+			Expect(text).To(ContainSubstring("signalr_messages_received_total 1\n"))
+			Expect(text).To(ContainSubstring(`signalr_invocation_duration_seconds_count{method="add"} 1`))
+			Expect(text).To(ContainSubstring(`signalr_invocation_duration_seconds_bucket{method="add",le="+Inf"} 1`))
+			Expect(text).NotTo(ContainSubstring("signalr_messages_sent_total 0\n"))
+			conn.ClientSend(`{"type":7}`)
+			Eventually(func() string { return scrape(metrics) }).Should(ContainSubstring("signalr_connections_active 0\n"))
+		})
+	})
+
+	Context("When the handshake fails", func() {
+		It("should count the failure", func() {
+			metrics := NewPrometheusMetrics("")
+			server, err := NewServer(context.Background(), SimpleHubFactory(&filterHub{}), UseMetrics(metrics), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := newTestingConnectionBeforeHandshake()
+			conn.ClientSend(`{"protocol": "xml","version": 1}`)
+			go server.Run(conn)
+			Eventually(func() string { return scrape(metrics) }, time.Second).Should(ContainSubstring("\nhandshake_failures_total 1\n"))
+		})
+	})
+
+	Context("When durations are observed", func() {
+		It("should count them in all buckets with a greater bound", func() {
+			metrics := NewPrometheusMetrics("")
+			metrics.InvocationCompleted("m", 30*time.Millisecond)
+			text := scrape(metrics)
+			Expect(text).To(ContainSubstring(`invocation_duration_seconds_bucket{method="m",le="0.025"} 0`))
+			Expect(text).To(ContainSubstring(`invocation_duration_seconds_bucket{method="m",le="0.05"} 1`))
+			Expect(text).To(ContainSubstring(`invocation_duration_seconds_sum{method="m"} 0.03`))
+		})
+	})
+})
@@ -0,0 +1,282 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NATSBackplane is a Backplane using NATS core publish/subscribe. All servers publish and subscribe on the same subject.
+// Publish and Subscribe share one connection to the NATS server. If it breaks, the NATSBackplane reconnects
+// and renews its subscriptions. Messages published while the connection was broken are lost.
+// Errors of the NATS server for a published message, e.g. a permissions violation, are not returned by Publish
+type NATSBackplane struct {
+	address string
+	subject string
+	// ioTimeout limits dialing, the handshake and each write to the NATS server
+	ioTimeout time.Duration
+	mx        sync.Mutex
+	// conn is nil while the NATSBackplane reconnects
+	conn       net.Conn
+	maxPayload int64
+	handlers   map[int]func(message []byte)
+	nextSID    int
+	closed     bool
+}
+
+const natsReconnectInterval = time.Second
+const natsIOTimeout = 5 * time.Second
+
+var errNATSBackplaneClosed = errors.New("NATSBackplane closed")
+var errNATSBackplaneDisconnected = errors.New("NATSBackplane not connected")
+
+// NewNATSBackplane creates a NATSBackplane which connects to the NATS server at address (host:port)
+// and sends all messages with the subject
+func NewNATSBackplane(address string, subject string) (*NATSBackplane, error) {
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("invalid NATS subject %q", subject)
+	}
+	n := &NATSBackplane{
+		address:   address,
+		subject:   subject,
+		ioTimeout: natsIOTimeout,
+		handlers:  make(map[int]func(message []byte)),
+	}
+	conn, reader, maxPayload, err := n.connect()
+	if err != nil {
+		return nil, err
+	}
+	n.conn, n.maxPayload = conn, maxPayload
+	go n.receive(conn, reader)
+	return n, nil
+}
+
+// Publish publishes the message with the subject of the NATSBackplane
+func (n *NATSBackplane) Publish(message []byte) error {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	if n.closed {
+		return errNATSBackplaneClosed
+	}
+	if n.conn == nil {
+		return errNATSBackplaneDisconnected
+	}
+	if n.maxPayload > 0 && int64(len(message)) > n.maxPayload {
+		return fmt.Errorf("NATS message size %v exceeds max_payload %v", len(message), n.maxPayload)
+	}
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("PUB %s %d\r\n", n.subject, len(message)))
+	buf.Write(message)
+	buf.WriteString("\r\n")
+	err := n.write(n.conn, buf.Bytes())
+	if err != nil {
+		// The receive loop notices the closed connection and reconnects
+		_ = n.conn.Close()
+	}
+	return err
+}
+
+// Subscribe subscribes the subject of the NATSBackplane. handler is called with every message published with the subject
+func (n *NATSBackplane) Subscribe(handler func(message []byte)) error {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	if n.closed {
+		return errNATSBackplaneClosed
+	}
+	if n.conn == nil {
+		return errNATSBackplaneDisconnected
+	}
+	n.nextSID++
+	if err := n.write(n.conn, []byte(fmt.Sprintf("SUB %s %d\r\n", n.subject, n.nextSID))); err != nil {
+		_ = n.conn.Close()
+		return err
+	}
+	n.handlers[n.nextSID] = handler
+	return nil
+}
+
+// Close closes the connection to the NATS server
+func (n *NATSBackplane) Close() error {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.closed = true
+	if n.conn != nil {
+		return n.conn.Close()
+	}
+	return nil
+}
+
+// write writes to conn with a deadline. n.mx must be locked
+func (n *NATSBackplane) write(conn net.Conn, data []byte) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(n.ioTimeout)); err != nil {
+		return err
+	}
+	_, err := conn.Write(data)
+	return err
+}
+
+// connect connects to the NATS server. The handshake is finished when the server answered the PING after CONNECT.
+// It returns the max_payload announced by the server
+func (n *NATSBackplane) connect() (net.Conn, *bufio.Reader, int64, error) {
+	conn, err := net.DialTimeout("tcp", n.address, n.ioTimeout)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	reader := bufio.NewReader(conn)
+	maxPayload, err := natsHandshake(conn, reader, n.ioTimeout)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, 0, err
+	}
+	return conn, reader, maxPayload, nil
+}
+
+func natsHandshake(conn net.Conn, reader *bufio.Reader, timeout time.Duration) (int64, error) {
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return 0, err
+	}
+	line, err := readNATSLine(reader)
+	if err != nil {
+		return 0, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return 0, fmt.Errorf("invalid NATS handshake: %q", line)
+	}
+	info := struct {
+		MaxPayload int64 `json:"max_payload"`
+	}{}
+	if err = json.Unmarshal([]byte(line[len("INFO "):]), &info); err != nil {
+		return 0, err
+	}
+	if _, err = conn.Write([]byte("CONNECT {\"verbose\":false,\"pedantic\":false,\"lang\":\"go\",\"name\":\"signalr\"}\r\nPING\r\n")); err != nil {
+		return 0, err
+	}
+	for {
+		if line, err = readNATSLine(reader); err != nil {
+			return 0, err
+		}
+		switch {
+		case line == "PONG":
+			// Wait for published messages without deadline
+			return info.MaxPayload, conn.SetDeadline(time.Time{})
+		case strings.HasPrefix(line, "-ERR"):
+			return 0, natsError(strings.TrimSpace(line[len("-ERR"):]))
+		}
+	}
+}
+
+func (n *NATSBackplane) receive(conn net.Conn, reader *bufio.Reader) {
+	for {
+		_ = n.dispatch(conn, reader)
+		n.mx.Lock()
+		if n.conn == conn {
+			n.conn = nil
+		}
+		n.mx.Unlock()
+		_ = conn.Close()
+		if conn, reader = n.reconnect(); conn == nil {
+			return
+		}
+	}
+}
+
+// dispatch calls the handlers with the messages received on conn until conn breaks
+func (n *NATSBackplane) dispatch(conn net.Conn, reader *bufio.Reader) error {
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			if len(fields) < 4 || len(fields) > 5 {
+				return fmt.Errorf("invalid NATS message: %q", line)
+			}
+			sid, err := strconv.Atoi(fields[2])
+			if err != nil {
+				return err
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 {
+				return fmt.Errorf("invalid NATS message: %q", line)
+			}
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, payload); err != nil {
+				return err
+			}
+			n.mx.Lock()
+			handler, ok := n.handlers[sid]
+			n.mx.Unlock()
+			if ok {
+				handler(payload[:size])
+			}
+		case line == "PING":
+			n.mx.Lock()
+			err = n.write(conn, []byte("PONG\r\n"))
+			n.mx.Unlock()
+			if err != nil {
+				return err
+			}
+		}
+		// INFO, +OK, PONG and -ERR need no reaction. After fatal errors the NATS server closes the connection
+	}
+}
+
+// reconnect connects again and renews the subscriptions. It returns nil when the NATSBackplane is closed
+func (n *NATSBackplane) reconnect() (net.Conn, *bufio.Reader) {
+	for {
+		n.mx.Lock()
+		closed := n.closed
+		n.mx.Unlock()
+		if closed {
+			return nil, nil
+		}
+		time.Sleep(natsReconnectInterval)
+		conn, reader, maxPayload, err := n.connect()
+		if err != nil {
+			continue
+		}
+		n.mx.Lock()
+		if n.closed {
+			n.mx.Unlock()
+			_ = conn.Close()
+			return nil, nil
+		}
+		var buf bytes.Buffer
+		for sid := range n.handlers {
+			buf.WriteString(fmt.Sprintf("SUB %s %d\r\n", n.subject, sid))
+		}
+		if err = n.write(conn, buf.Bytes()); err != nil {
+			n.mx.Unlock()
+			_ = conn.Close()
+			continue
+		}
+		n.conn, n.maxPayload = conn, maxPayload
+		n.mx.Unlock()
+		return conn, reader
+	}
+}
+
+type natsError string
+
+func (n natsError) Error() string {
+	return string(n)
+}
+
+func readNATSLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
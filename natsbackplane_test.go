@@ -0,0 +1,199 @@
+package signalr
+
+import (
+	"bufio"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeNATS implements the NATS protocol operations CONNECT, PING, SUB and PUB
+type fakeNATS struct {
+	listener   net.Listener
+	maxPayload int
+	mx         sync.Mutex
+	// subscriptions maps each connection to its subscription ids by subject
+	subscriptions map[net.Conn]map[string][]string
+}
+
+func newFakeNATS() *fakeNATS {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	f := &fakeNATS{listener: listener, maxPayload: 1024, subscriptions: make(map[net.Conn]map[string][]string)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeNATS) serve(conn net.Conn) {
+	f.mx.Lock()
+	f.subscriptions[conn] = make(map[string][]string)
+	f.mx.Unlock()
+	defer func() {
+		f.mx.Lock()
+		delete(f.subscriptions, conn)
+		f.mx.Unlock()
+		_ = conn.Close()
+	}()
+	_, _ = conn.Write([]byte(fmt.Sprintf("INFO {\"server_id\":\"fake\",\"max_payload\":%d}\r\n", f.maxPayload)))
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := readNATSLine(reader)
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "CONNECT":
+		case "PING":
+			_, _ = conn.Write([]byte("PONG\r\n"))
+		case "SUB":
+			f.mx.Lock()
+			f.subscriptions[conn][fields[1]] = append(f.subscriptions[conn][fields[1]], fields[2])
+			f.mx.Unlock()
+		case "PUB":
+			size, _ := strconv.Atoi(fields[2])
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(reader, payload); err != nil {
+				return
+			}
+			f.mx.Lock()
+			for subscriber, subjects := range f.subscriptions {
+				for _, sid := range subjects[fields[1]] {
+					_, _ = subscriber.Write([]byte(fmt.Sprintf("MSG %s %s %d\r\n%s", fields[1], sid, size, payload)))
+				}
+			}
+			f.mx.Unlock()
+		default:
+			_, _ = conn.Write([]byte("-ERR 'Unknown Protocol Operation'\r\n"))
+			return
+		}
+	}
+}
+
+// dropConnections closes all client connections, the listener keeps accepting
+func (f *fakeNATS) dropConnections() {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	for conn := range f.subscriptions {
+		_ = conn.Close()
+	}
+}
+
+func (f *fakeNATS) subscribed() int {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	count := 0
+	for _, subjects := range f.subscriptions {
+		count += len(subjects)
+	}
+	return count
+}
+
+func (f *fakeNATS) Close() {
+	_ = f.listener.Close()
+}
+
+var _ = Describe("NATSBackplane", func() {
+
+	Context("When a message is published", func() {
+		It("should be received by all subscribers of the subject", func() {
+			nats := newFakeNATS()
+			defer nats.Close()
+			publisher, err := NewNATSBackplane(nats.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			defer publisher.Close()
+			subscriber, err := NewNATSBackplane(nats.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			defer subscriber.Close()
+			received := make(chan string, 1)
+			Expect(subscriber.Subscribe(func(message []byte) { received <- string(message) })).To(BeNil())
+			Eventually(nats.subscribed).Should(Equal(1))
+			Expect(publisher.Publish([]byte("hello\r\nMSG nats"))).To(BeNil())
+			select {
+			case message := <-received:
+				Expect(message).To(Equal("hello\r\nMSG nats"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+		})
+	})
+
+	Context("When servers are connected over the NATSBackplane", func() {
+		It("should invoke the clients of all servers", func() {
+			nats := newFakeNATS()
+			defer nats.Close()
+			backplane, err := NewNATSBackplane(nats.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			defer backplane.Close()
+			connA, connB := connectBackplaneServers(backplane)
+			Eventually(nats.subscribed).Should(Equal(1))
+			connA.ClientSend(`{"type":1,"target":"callall"}`)
+			expectClientFunc(connA)
+			expectClientFunc(connB)
+		})
+	})
+
+	Context("When the connection to the NATS server breaks", func() {
+		It("should reconnect and renew the subscriptions", func() {
+			nats := newFakeNATS()
+			defer nats.Close()
+			backplane, err := NewNATSBackplane(nats.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			defer backplane.Close()
+			received := make(chan string, 10)
+			Expect(backplane.Subscribe(func(message []byte) { received <- string(message) })).To(BeNil())
+			Eventually(nats.subscribed).Should(Equal(1))
+			nats.dropConnections()
+			Eventually(nats.subscribed).Should(Equal(0))
+			Eventually(nats.subscribed, 3*time.Second).Should(Equal(1))
+			Eventually(func() error { return backplane.Publish([]byte("again")) }).Should(BeNil())
+			Eventually(received).Should(Receive(Equal("again")))
+		})
+	})
+
+	Context("When a message exceeds the max_payload of the NATS server", func() {
+		It("should return an error on Publish", func() {
+			nats := newFakeNATS()
+			defer nats.Close()
+			backplane, err := NewNATSBackplane(nats.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			defer backplane.Close()
+			Expect(backplane.Publish(make([]byte, nats.maxPayload+1))).NotTo(BeNil())
+		})
+	})
+
+	Context("When the subject is invalid", func() {
+		It("should return an error", func() {
+			_, err := NewNATSBackplane("127.0.0.1:4222", "a hub")
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When the backplane is closed", func() {
+		It("should return an error on Publish", func() {
+			nats := newFakeNATS()
+			defer nats.Close()
+			backplane, err := NewNATSBackplane(nats.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			Expect(backplane.Close()).To(BeNil())
+			Expect(backplane.Publish([]byte("hello"))).NotTo(BeNil())
+		})
+	})
+})
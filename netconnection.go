@@ -0,0 +1,59 @@
+package signalr
+
+import (
+	"net"
+)
+
+// netConnection runs the hub protocol directly over a stream connection like TCP, without HTTP
+type netConnection struct {
+	conn         net.Conn
+	connectionID string
+	// pending is data which was read ahead, e.g. the messages following the handshake
+	pending []byte
+}
+
+func newNetConnection(conn net.Conn, connectionID string) *netConnection {
+	return &netConnection{conn: conn, connectionID: connectionID}
+}
+
+func (n *netConnection) ConnectionID() string {
+	return n.connectionID
+}
+
+func (n *netConnection) Read(p []byte) (int, error) {
+	if len(n.pending) > 0 {
+		read := copy(p, n.pending)
+		n.pending = n.pending[read:]
+		return read, nil
+	}
+	return n.conn.Read(p)
+}
+
+func (n *netConnection) Write(p []byte) (int, error) {
+	return n.conn.Write(p)
+}
+
+// unread lets the next Read return data again
+func (n *netConnection) unread(data []byte) {
+	n.pending = append(append([]byte(nil), data...), n.pending...)
+}
+
+func (n *netConnection) Close() error {
+	return n.conn.Close()
+}
+
+// Serve accepts connections on listener and runs the hub protocol directly on them, including the handshake.
+// There is no HTTP negotiation, so clients must know the protocol, e.g. a Client dialing a tcp:// address.
+// Serve returns when Accept fails, e.g. because the listener was closed
+func (s *Server) Serve(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			defer func() { _ = conn.Close() }()
+			s.Run(newNetConnection(conn, s.connectionIDGenerator()))
+		}()
+	}
+}
@@ -0,0 +1,55 @@
+package signalr
+
+import (
+	"bufio"
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net"
+)
+
+func startNetTestServer() net.Listener {
+	server, err := NewServer(context.Background(), SimpleHubFactory(&clientTestHub{}), Logger(log.NewNopLogger(), false))
+	Expect(err).To(BeNil())
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	go func() { _ = server.Serve(listener) }()
+	return listener
+}
+
+var _ = Describe("Raw TCP transport", func() {
+
+	Context("When a client dials a tcp address", func() {
+		It("should invoke hub methods without negotiation", func() {
+			listener := startNetTestServer()
+			defer listener.Close()
+			client, err := Dial("tcp://"+listener.Addr().String(), WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(Succeed())
+			Expect(sum).To(Equal(3))
+		})
+	})
+
+	Context("When the handshake and an invocation arrive in one read", func() {
+		It("should not lose the invocation", func() {
+			listener := startNetTestServer()
+			defer listener.Close()
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			Expect(err).To(BeNil())
+			defer conn.Close()
+			_, err = conn.Write([]byte("{\"protocol\":\"json\",\"version\":1}\x1e" +
+				"{\"type\":1,\"invocationId\":\"1\",\"target\":\"add\",\"arguments\":[1,2]}\x1e"))
+			Expect(err).To(BeNil())
+			reader := bufio.NewReader(conn)
+			handshakeResponse, err := reader.ReadString(30)
+			Expect(err).To(BeNil())
+			Expect(handshakeResponse).To(Equal("{}\x1e"))
+			completion, err := reader.ReadString(30)
+			Expect(err).To(BeNil())
+			Expect(completion).To(ContainSubstring(`"result":3`))
+		})
+	})
+})
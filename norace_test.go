@@ -0,0 +1,7 @@
+//go:build !race
+// +build !race
+
+package signalr
+
+// raceEnabled is true if the tests run with the race detector, which changes allocations, e.g. of sync.Pool
+const raceEnabled = false
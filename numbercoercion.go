@@ -0,0 +1,141 @@
+package signalr
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"github.com/vmihailenco/msgpack/v5"
+	"math"
+	"reflect"
+	"strconv"
+)
+
+// isCoercedNumber tells if arguments for parameters of type t are bound by coerceNumber.
+// Types which unmarshal themselves are left to the protocol
+func isCoercedNumber(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+	default:
+		return false
+	}
+	for _, unmarshaler := range []reflect.Type{
+		reflect.TypeOf((*json.Unmarshaler)(nil)).Elem(),
+		reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem(),
+		reflect.TypeOf((*msgpack.Unmarshaler)(nil)).Elem(),
+		reflect.TypeOf((*msgpack.CustomDecoder)(nil)).Elem(),
+	} {
+		if reflect.PtrTo(t).Implements(unmarshaler) {
+			return false
+		}
+	}
+	return true
+}
+
+// coerceNumber converts the number sent by the client to the numeric type t.
+// Integer types accept integral numbers, e.g. 2.0 or 1e3, in their range. Float types accept all numbers in their range.
+// null is bound to 0
+func coerceNumber(number interface{}, t reflect.Type) (reflect.Value, error) {
+	value := reflect.New(t).Elem()
+	if number == nil {
+		return value, nil
+	}
+	if n, ok := number.(json.Number); ok {
+		var err error
+		if number, err = parseJSONNumber(n); err != nil {
+			return value, err
+		}
+	}
+	source := reflect.ValueOf(number)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch source.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			i = source.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if source.Uint() > math.MaxInt64 {
+				return value, fmt.Errorf("%v overflows %v", number, t)
+			}
+			i = int64(source.Uint())
+		case reflect.Float32, reflect.Float64:
+			f := source.Float()
+			if f != math.Trunc(f) {
+				return value, fmt.Errorf("%v is not an integer", number)
+			}
+			if f < math.MinInt64 || f >= math.MaxInt64 {
+				return value, fmt.Errorf("%v overflows %v", number, t)
+			}
+			i = int64(f)
+		default:
+			return value, fmt.Errorf("%v is not a number", number)
+		}
+		if value.OverflowInt(i) {
+			return value, fmt.Errorf("%v overflows %v", number, t)
+		}
+		value.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch source.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if source.Int() < 0 {
+				return value, fmt.Errorf("%v is negative, %v is unsigned", number, t)
+			}
+			u = uint64(source.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			u = source.Uint()
+		case reflect.Float32, reflect.Float64:
+			f := source.Float()
+			if f != math.Trunc(f) {
+				return value, fmt.Errorf("%v is not an integer", number)
+			}
+			if f < 0 {
+				return value, fmt.Errorf("%v is negative, %v is unsigned", number, t)
+			}
+			if f >= math.MaxUint64 {
+				return value, fmt.Errorf("%v overflows %v", number, t)
+			}
+			u = uint64(f)
+		default:
+			return value, fmt.Errorf("%v is not a number", number)
+		}
+		if value.OverflowUint(u) {
+			return value, fmt.Errorf("%v overflows %v", number, t)
+		}
+		value.SetUint(u)
+	default:
+		var f float64
+		switch source.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(source.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			f = float64(source.Uint())
+		case reflect.Float32, reflect.Float64:
+			f = source.Float()
+		default:
+			return value, fmt.Errorf("%v is not a number", number)
+		}
+		if value.OverflowFloat(f) {
+			return value, fmt.Errorf("%v overflows %v", number, t)
+		}
+		value.SetFloat(f)
+	}
+	return value, nil
+}
+
+// parseJSONNumber returns n as int64 or uint64 if it is an integer in their range, otherwise as float64
+func parseJSONNumber(n json.Number) (interface{}, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(string(n), 10, 64); err == nil {
+		return u, nil
+	}
+	f, err := strconv.ParseFloat(string(n), 64)
+	if err != nil {
+		// Out of the range of float64
+		return nil, fmt.Errorf("%v overflows float64", n)
+	}
+	return f, nil
+}
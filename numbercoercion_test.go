@@ -0,0 +1,125 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"math"
+	"reflect"
+	"time"
+)
+
+type numberHub struct {
+	Hub
+}
+
+func (n *numberHub) Int(value int) string {
+	return fmt.Sprint(value)
+}
+
+func (n *numberHub) Int8(value int8) string {
+	return fmt.Sprint(value)
+}
+
+func (n *numberHub) Int64(value int64) string {
+	return fmt.Sprint(value)
+}
+
+func (n *numberHub) Uint(value uint) string {
+	return fmt.Sprint(value)
+}
+
+func (n *numberHub) Duration(value time.Duration) string {
+	return value.String()
+}
+
+func (n *numberHub) Any(value interface{}) string {
+	return fmt.Sprintf("%T %v", value, value)
+}
+
+func connectNumberHub(options ...Option) *testingConnection {
+	server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&numberHub{}),
+		Logger(log.NewNopLogger(), false)}, options...)...)
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	go server.Run(conn)
+	return conn
+}
+
+func invokeNumberHub(conn *testingConnection, method string, argument string) completionMessage {
+	conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"1","target":"%v","arguments":[%v]}`, method, argument))
+	return receiveCompletion(conn)
+}
+
+var _ = Describe("Number coercion", func() {
+
+	Context("When a JSON number is passed to a numeric parameter", func() {
+		for _, c := range []struct {
+			name, method, argument, result, failure string
+		}{
+			{"integral float", "int", "2.0", "2", ""},
+			{"exponent", "int", "1e3", "1000", ""},
+			{"fraction", "int", "3.5", "", "is not an integer"},
+			{"overflow", "int8", "300", "", "overflows int8"},
+			{"largest int64", "int64", "9223372036854775807", "9223372036854775807", ""},
+			{"int64 overflow", "int64", "9223372036854775808", "", "overflows int64"},
+			{"negative unsigned", "uint", "-1", "", "is negative"},
+			{"null", "int", "null", "0", ""},
+			{"string", "int", `"5"`, "", "is not a number"},
+			{"named integer type", "duration", "1000000000", "1s", ""},
+		} {
+			c := c
+			It("should coerce or reject the "+c.name, func() {
+				completion := invokeNumberHub(connectNumberHub(), c.method, c.argument)
+				if c.failure == "" {
+					Expect(completion.Error).To(BeEmpty())
+					Expect(completion.Result).To(Equal(c.result))
+				} else {
+					Expect(completion.Error).To(ContainSubstring(c.failure))
+				}
+			})
+		}
+	})
+
+	Context("When a MessagePack number is coerced", func() {
+		for _, c := range []struct {
+			name     string
+			number   interface{}
+			t        reflect.Type
+			expected interface{}
+			failure  string
+		}{
+			{"int64 to int8", int64(-128), reflect.TypeOf(int8(0)), int8(-128), ""},
+			{"int64 overflowing int8", int64(300), reflect.TypeOf(int8(0)), nil, "overflows int8"},
+			{"uint64 overflowing int64", uint64(math.MaxUint64), reflect.TypeOf(int64(0)), nil, "overflows int64"},
+			{"negative int8 to uint", int8(-1), reflect.TypeOf(uint(0)), nil, "is negative"},
+			{"float64 to uint16", float64(65535), reflect.TypeOf(uint16(0)), uint16(65535), ""},
+			{"float64 overflowing float32", math.MaxFloat64, reflect.TypeOf(float32(0)), nil, "overflows float32"},
+			{"int64 to float64", int64(7), reflect.TypeOf(float64(0)), float64(7), ""},
+			{"bool", true, reflect.TypeOf(0), nil, "is not a number"},
+		} {
+			c := c
+			It("should coerce or reject "+c.name, func() {
+				value, err := coerceNumber(c.number, c.t)
+				if c.failure == "" {
+					Expect(err).To(BeNil())
+					Expect(value.Interface()).To(Equal(c.expected))
+				} else {
+					Expect(err).To(MatchError(ContainSubstring(c.failure)))
+				}
+			})
+		}
+	})
+
+	Context("When UseJSONNumber is set", func() {
+		It("should pass numbers to interface{} parameters as json.Number", func() {
+			completion := invokeNumberHub(connectNumberHub(UseJSONNumber()), "any", "12345678901234567890")
+			Expect(completion.Result).To(Equal(fmt.Sprintf("%T 12345678901234567890", json.Number(""))))
+			completion = invokeNumberHub(connectNumberHub(), "any", "2")
+			Expect(completion.Result).To(Equal("float64 2"))
+		})
+	})
+})
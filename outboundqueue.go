@@ -0,0 +1,182 @@
+package signalr
+
+import (
+	"errors"
+	"sync"
+)
+
+// SlowClientPolicy is the reaction of the server when the outbound queue of a connection is full,
+// because the client receives the messages slower than they are sent
+type SlowClientPolicy int
+
+// Slow client policies
+const (
+	// SlowClientBlock lets the senders wait until the queue has space again
+	SlowClientBlock SlowClientPolicy = iota
+	// SlowClientDropOldest drops the oldest queued message to make space for the new one
+	SlowClientDropOldest
+	// SlowClientDropMessage drops the new message
+	SlowClientDropMessage
+	// SlowClientDisconnect drops all queued messages and closes the connection
+	SlowClientDisconnect
+)
+
+var errMessageDropped = errors.New("message dropped, because the outbound queue is full")
+var errSlowClient = errors.New("outbound queue full, client too slow")
+
+// outboundQueue is the queue of the requests for the writeLoop. The number of queued messages is limited,
+// requests for closing and resuming the connection are always queued
+type outboundQueue struct {
+	mx       sync.Mutex
+	requests []writeRequest
+	// messages is the number of queued requests which are not closing or resuming
+	messages int
+	// limit is the maximum number of queued messages. 0 means no limit
+	limit  int
+	policy SlowClientPolicy
+	closed bool
+	// ready is signaled when a request was queued, space when a message was removed
+	ready chan struct{}
+	space chan struct{}
+}
+
+func newOutboundQueue(limit int, policy SlowClientPolicy) *outboundQueue {
+	return &outboundQueue{
+		limit:  limit,
+		policy: policy,
+		ready:  make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+	}
+}
+
+// configure sets the limit and the policy. It must be called before the first message is queued
+func (q *outboundQueue) configure(limit int, policy SlowClientPolicy) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.limit, q.policy = limit, policy
+}
+
+func (r writeRequest) isMessage() bool {
+	return !r.closing && r.resume == nil
+}
+
+// push queues request. If the queue is full, it applies the policy. Requests which are dropped are answered.
+// It returns errSlowClient if the connection has to be closed because of the policy
+func (q *outboundQueue) push(request writeRequest, closed <-chan struct{}) error {
+	for {
+		q.mx.Lock()
+		if q.closed {
+			q.mx.Unlock()
+			request.answer(errHubConnectionClosed)
+			return nil
+		}
+		if !request.isMessage() || q.limit == 0 || q.messages < q.limit {
+			q.append(request)
+			q.mx.Unlock()
+			return nil
+		}
+		switch q.policy {
+		case SlowClientDropOldest:
+			dropped := q.removeMessages(1)
+			q.append(request)
+			q.mx.Unlock()
+			answerAll(dropped, errMessageDropped)
+			return nil
+		case SlowClientDropMessage:
+			q.mx.Unlock()
+			request.answer(errMessageDropped)
+			return nil
+		case SlowClientDisconnect:
+			dropped := q.removeMessages(q.messages)
+			q.mx.Unlock()
+			answerAll(append(dropped, request), errSlowClient)
+			return errSlowClient
+		}
+		q.mx.Unlock()
+		select {
+		case <-q.space:
+		case <-closed:
+			request.answer(errHubConnectionClosed)
+			return nil
+		}
+	}
+}
+
+// append adds request to the queue. q.mx must be locked
+func (q *outboundQueue) append(request writeRequest) {
+	q.requests = append(q.requests, request)
+	if request.isMessage() {
+		q.messages++
+	}
+	signal(q.ready)
+}
+
+// removeMessages removes the oldest n messages. q.mx must be locked
+func (q *outboundQueue) removeMessages(n int) []writeRequest {
+	removed := make([]writeRequest, 0, n)
+	kept := q.requests[:0]
+	for _, request := range q.requests {
+		if request.isMessage() && len(removed) < n {
+			removed = append(removed, request)
+		} else {
+			kept = append(kept, request)
+		}
+	}
+	for i := len(kept); i < len(q.requests); i++ {
+		q.requests[i] = writeRequest{}
+	}
+	q.requests = kept
+	q.messages -= len(removed)
+	return removed
+}
+
+// pop waits for the next request
+func (q *outboundQueue) pop() writeRequest {
+	for {
+		q.mx.Lock()
+		if len(q.requests) > 0 {
+			request := q.requests[0]
+			q.requests[0] = writeRequest{}
+			q.requests = q.requests[1:]
+			if request.isMessage() {
+				q.messages--
+				signal(q.space)
+			}
+			q.mx.Unlock()
+			return request
+		}
+		q.mx.Unlock()
+		<-q.ready
+	}
+}
+
+// queued returns the number of queued messages
+func (q *outboundQueue) queued() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return q.messages
+}
+
+// close answers all queued requests with errHubConnectionClosed. Requests pushed afterwards are answered at once
+func (q *outboundQueue) close() {
+	q.mx.Lock()
+	q.closed = true
+	requests := q.requests
+	q.requests, q.messages = nil, 0
+	q.mx.Unlock()
+	answerAll(requests, errHubConnectionClosed)
+}
+
+func answerAll(requests []writeRequest, err error) {
+	for _, request := range requests {
+		request.answer(err)
+	}
+}
+
+// signal signals ch, which has a buffer of 1, without waiting
+func signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,120 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+	"time"
+)
+
+func newTestWriteRequest(message interface{}) writeRequest {
+	return writeRequest{message: message, result: make(chan error, 1), once: &sync.Once{}}
+}
+
+// blockingConnection is a Connection whose writes wait until it is released
+type blockingConnection struct {
+	discardConnection
+	release chan struct{}
+}
+
+func (b *blockingConnection) Write(p []byte) (int, error) {
+	<-b.release
+	return len(p), nil
+}
+
+var _ = Describe("OutboundQueue", func() {
+
+	Context("When the queue is full and the policy is SlowClientBlock", func() {
+		It("should let the sender wait until a message was removed", func() {
+			q := newOutboundQueue(2, SlowClientBlock)
+			closed := make(chan struct{})
+			Expect(q.push(newTestWriteRequest(1), closed)).To(BeNil())
+			Expect(q.push(newTestWriteRequest(2), closed)).To(BeNil())
+			pushed := make(chan struct{})
+			go func() {
+				defer close(pushed)
+				Expect(q.push(newTestWriteRequest(3), closed)).To(BeNil())
+			}()
+			Consistently(pushed, 50*time.Millisecond).ShouldNot(BeClosed())
+			Expect(q.pop().message).To(Equal(1))
+			Eventually(pushed).Should(BeClosed())
+			Expect(q.pop().message).To(Equal(2))
+			Expect(q.pop().message).To(Equal(3))
+		})
+	})
+
+	Context("When the queue is full and the policy is SlowClientDropOldest", func() {
+		It("should drop the oldest message", func() {
+			q := newOutboundQueue(2, SlowClientDropOldest)
+			requests := []writeRequest{newTestWriteRequest(1), newTestWriteRequest(2), newTestWriteRequest(3)}
+			for _, request := range requests {
+				Expect(q.push(request, nil)).To(BeNil())
+			}
+			Expect(<-requests[0].result).To(Equal(errMessageDropped))
+			Expect(q.pop().message).To(Equal(2))
+			Expect(q.pop().message).To(Equal(3))
+		})
+	})
+
+	Context("When the queue is full and the policy is SlowClientDropMessage", func() {
+		It("should drop the new message", func() {
+			q := newOutboundQueue(1, SlowClientDropMessage)
+			dropped := newTestWriteRequest(2)
+			Expect(q.push(newTestWriteRequest(1), nil)).To(BeNil())
+			Expect(q.push(dropped, nil)).To(BeNil())
+			Expect(<-dropped.result).To(Equal(errMessageDropped))
+			Expect(q.pop().message).To(Equal(1))
+		})
+	})
+
+	Context("When the queue is full and the policy is SlowClientDisconnect", func() {
+		It("should drop all messages, but still queue the close message", func() {
+			q := newOutboundQueue(1, SlowClientDisconnect)
+			queued, rejected := newTestWriteRequest(1), newTestWriteRequest(2)
+			Expect(q.push(queued, nil)).To(BeNil())
+			Expect(q.push(rejected, nil)).To(Equal(errSlowClient))
+			Expect(<-queued.result).To(Equal(errSlowClient))
+			Expect(<-rejected.result).To(Equal(errSlowClient))
+			closing := newTestWriteRequest(closeMessage{Type: 7})
+			closing.closing = true
+			Expect(q.push(closing, nil)).To(BeNil())
+			Expect(q.pop().closing).To(BeTrue())
+		})
+	})
+
+	Context("When the queue is closed", func() {
+		It("should answer the queued and the new requests", func() {
+			q := newOutboundQueue(0, SlowClientBlock)
+			queued, late := newTestWriteRequest(1), newTestWriteRequest(2)
+			Expect(q.push(queued, nil)).To(BeNil())
+			q.close()
+			Expect(q.push(late, nil)).To(BeNil())
+			Expect(<-queued.result).To(Equal(errHubConnectionClosed))
+			Expect(<-late.result).To(Equal(errHubConnectionClosed))
+		})
+	})
+
+	Context("When a hubConnection can not write and drops messages", func() {
+		It("should return the error to the sender", func() {
+			conn := &blockingConnection{discardConnection: discardConnection{"c"}, release: make(chan struct{})}
+			hubConn := newHubConnection(context.Background(), conn, newJSONTestProtocol(), 0, log.NewNopLogger(), log.NewNopLogger())
+			hubConn.(*defaultHubConnection).outbound.configure(1, SlowClientDropMessage)
+			hubConn.Start()
+			// The first message is written, the second queued
+			first := hubConn.SendInvocation("a")
+			Eventually(func() int {
+				q := hubConn.(*defaultHubConnection).outbound
+				q.mx.Lock()
+				defer q.mx.Unlock()
+				return len(q.requests)
+			}).Should(Equal(0))
+			second := hubConn.SendInvocation("b")
+			Expect(<-hubConn.SendInvocation("c")).To(Equal(errMessageDropped))
+			close(conn.release)
+			Expect(<-first).To(BeNil())
+			Expect(<-second).To(BeNil())
+		})
+	})
+})
@@ -0,0 +1,44 @@
+package signalr
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+)
+
+// preparedMessage is a message sent to many connections. It is serialized only once per protocol type,
+// as all connections of a server use the same settings for each protocol
+type preparedMessage struct {
+	message    interface{}
+	mx         sync.Mutex
+	serialized map[reflect.Type]serializedMessage
+}
+
+type serializedMessage struct {
+	data []byte
+	err  error
+}
+
+func newPreparedMessage(message interface{}) *preparedMessage {
+	return &preparedMessage{message: message, serialized: make(map[reflect.Type]serializedMessage)}
+}
+
+func isPrepared(message interface{}) bool {
+	_, ok := message.(*preparedMessage)
+	return ok
+}
+
+// serialize returns the message written by protocol. The returned data must not be changed
+func (p *preparedMessage) serialize(protocol HubProtocol) ([]byte, error) {
+	protocolType := reflect.TypeOf(protocol)
+	// Connections of the same protocol wait for the first one to serialize the message
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if s, ok := p.serialized[protocolType]; ok {
+		return s.data, s.err
+	}
+	var buf bytes.Buffer
+	err := protocol.WriteMessage(p.message, &buf)
+	p.serialized[protocolType] = serializedMessage{data: buf.Bytes(), err: err}
+	return buf.Bytes(), err
+}
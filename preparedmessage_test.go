@@ -0,0 +1,67 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"sync"
+)
+
+// bufferConnection is a Connection which collects what is written
+type bufferConnection struct {
+	discardConnection
+	mx  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *bufferConnection) Write(p []byte) (int, error) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *bufferConnection) written() *bytes.Buffer {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	return bytes.NewBuffer(append([]byte(nil), b.buf.Bytes()...))
+}
+
+var _ = Describe("PreparedMessage", func() {
+
+	Context("When a prepared message is serialized for connections of the same protocol", func() {
+		It("should serialize it only once", func() {
+			message := newPreparedMessage(sendOnlyHubInvocationMessage{Type: 1, Target: "a", Arguments: []interface{}{1}})
+			first, err := message.serialize(newJSONTestProtocol())
+			Expect(err).To(BeNil())
+			second, err := message.serialize(newJSONTestProtocol())
+			Expect(err).To(BeNil())
+			Expect(&second[0]).To(BeIdenticalTo(&first[0]))
+			packed, err := message.serialize(newMessagePackProtocol())
+			Expect(err).To(BeNil())
+			Expect(packed).NotTo(Equal(first))
+		})
+	})
+
+	Context("When a message is broadcast to connections with different protocols", func() {
+		It("should send each connection the message in its protocol", func() {
+			manager := &defaultHubLifetimeManager{}
+			protocols := []HubProtocol{newJSONTestProtocol(), newMessagePackProtocol(), newJSONTestProtocol()}
+			conns := make([]*bufferConnection, len(protocols))
+			for i, protocol := range protocols {
+				conns[i] = &bufferConnection{discardConnection: discardConnection{fmt.Sprint(i)}}
+				hubConn := newHubConnection(context.Background(), conns[i], protocol, 0, log.NewNopLogger(), log.NewNopLogger())
+				manager.OnConnected(hubConn)
+			}
+			Expect(<-manager.InvokeAll("broadcast", []interface{}{"hello"})).To(BeNil())
+			for i, protocol := range protocols {
+				message, complete, err := protocol.ReadMessage(conns[i].written())
+				Expect(err).To(BeNil())
+				Expect(complete).To(BeTrue())
+				Expect(message.(invocationMessage).Target).To(Equal("broadcast"))
+			}
+		})
+	})
+})
@@ -0,0 +1,89 @@
+package signalr
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// RateLimitAction is the reaction of the server to an invocation which exceeds a rate limit
+type RateLimitAction int
+
+// Rate limit actions
+const (
+	// RateLimitReject drops the invocation and sends a completion with error, if the client waits for one
+	RateLimitReject RateLimitAction = iota
+	// RateLimitDisconnect closes the connection
+	RateLimitDisconnect
+)
+
+var errRateLimitExceeded = errors.New("rate limit exceeded")
+
+// rateLimit allows burst invocations at once and rate invocations per second on average
+type rateLimit struct {
+	rate   float64
+	burst  float64
+	action RateLimitAction
+}
+
+// tokenBucket holds the tokens left of a rateLimit. Each invocation takes one token
+type tokenBucket struct {
+	limit  rateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(limit rateLimit, now time.Time) *tokenBucket {
+	return &tokenBucket{limit: limit, tokens: limit.burst, last: now}
+}
+
+// refill adds the tokens for the time since the last refill and tells if a token is available
+func (b *tokenBucket) refill(now time.Time) bool {
+	b.tokens += now.Sub(b.last).Seconds() * b.limit.rate
+	if b.tokens > b.limit.burst {
+		b.tokens = b.limit.burst
+	}
+	b.last = now
+	return b.tokens >= 1
+}
+
+// invocationRateLimiter limits the invocations of one connection. It is only used by the message loop
+type invocationRateLimiter struct {
+	connection   *tokenBucket
+	methodLimits map[string]rateLimit
+	methods      map[string]*tokenBucket
+}
+
+func newInvocationRateLimiter(connectionLimit *rateLimit, methodLimits map[string]rateLimit) *invocationRateLimiter {
+	l := &invocationRateLimiter{methodLimits: methodLimits, methods: make(map[string]*tokenBucket)}
+	if connectionLimit != nil {
+		l.connection = newTokenBucket(*connectionLimit, time.Now())
+	}
+	return l
+}
+
+// allow takes a token for an invocation of method. If a limit is exceeded, no token is taken
+// and allow returns false and the action of the exceeded limit
+func (l *invocationRateLimiter) allow(method string, now time.Time) (bool, RateLimitAction) {
+	method = strings.ToLower(method)
+	var methodBucket *tokenBucket
+	if limit, ok := l.methodLimits[method]; ok {
+		if methodBucket, ok = l.methods[method]; !ok {
+			methodBucket = newTokenBucket(limit, now)
+			l.methods[method] = methodBucket
+		}
+		if !methodBucket.refill(now) {
+			return false, limit.action
+		}
+	}
+	if l.connection != nil {
+		if !l.connection.refill(now) {
+			return false, l.connection.limit.action
+		}
+		l.connection.tokens--
+	}
+	if methodBucket != nil {
+		methodBucket.tokens--
+	}
+	return true, RateLimitReject
+}
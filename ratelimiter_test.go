@@ -0,0 +1,88 @@
+package signalr
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"time"
+)
+
+var _ = Describe("RateLimit", func() {
+
+	Context("When the tokens of a connection are used up", func() {
+		It("should allow invocations again after the bucket is refilled", func() {
+			limiter := newInvocationRateLimiter(&rateLimit{rate: 2, burst: 2}, nil)
+			now := time.Now()
+			for i := 0; i < 2; i++ {
+				allowed, _ := limiter.allow("a", now)
+				Expect(allowed).To(BeTrue())
+			}
+			allowed, _ := limiter.allow("b", now)
+			Expect(allowed).To(BeFalse())
+			allowed, _ = limiter.allow("b", now.Add(500*time.Millisecond))
+			Expect(allowed).To(BeTrue())
+			allowed, _ = limiter.allow("b", now.Add(500*time.Millisecond))
+			Expect(allowed).To(BeFalse())
+		})
+	})
+
+	Context("When a method has its own limit", func() {
+		It("should limit the method and not take tokens of the connection for rejected invocations", func() {
+			limiter := newInvocationRateLimiter(&rateLimit{rate: 1, burst: 3},
+				map[string]rateLimit{"send": {rate: 1, burst: 1, action: RateLimitDisconnect}})
+			now := time.Now()
+			allowed, _ := limiter.allow("Send", now)
+			Expect(allowed).To(BeTrue())
+			allowed, action := limiter.allow("send", now)
+			Expect(allowed).To(BeFalse())
+			Expect(action).To(Equal(RateLimitDisconnect))
+			for i := 0; i < 2; i++ {
+				allowed, _ = limiter.allow("other", now)
+				Expect(allowed).To(BeTrue())
+			}
+		})
+	})
+
+	Context("When a client exceeds the RateLimit with action RateLimitReject", func() {
+		It("should send a completion with error for the rejected invocation", func() {
+			conn := connectConverterHub(RateLimit(0.001, 2, RateLimitReject))
+			for _, id := range []string{"1", "2", "3"} {
+				conn.ClientSend(`{"type":1,"invocationId":"` + id + `","target":"optional","arguments":[null]}`)
+			}
+			errors := make(map[string]string)
+			for i := 0; i < 3; i++ {
+				completion := receiveCompletion(conn)
+				errors[completion.InvocationID] = completion.Error
+			}
+			Expect(errors).To(Equal(map[string]string{"1": "", "2": "", "3": errRateLimitExceeded.Error()}))
+		})
+	})
+
+	Context("When a client exceeds a MethodRateLimit with action RateLimitDisconnect", func() {
+		It("should close the connection", func() {
+			conn := connectConverterHub(MethodRateLimit("optional", 0.001, 1, RateLimitDisconnect))
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"optional","arguments":[null]}`)
+			Expect(receiveCompletion(conn).Error).To(BeEmpty())
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"optional","arguments":[null]}`)
+			for {
+				select {
+				case message := <-conn.received:
+					if closeMsg, ok := message.(closeMessage); ok {
+						Expect(closeMsg.Error).To(ContainSubstring(errRateLimitExceeded.Error()))
+						return
+					}
+					Expect(message).NotTo(BeAssignableToTypeOf(completionMessage{}))
+				case <-time.After(time.Second):
+					Fail("timed out")
+					return
+				}
+			}
+		})
+	})
+
+	Context("When the rate is not positive", func() {
+		It("should return an error", func() {
+			Expect(RateLimit(0, 1, RateLimitReject)(&Server{})).NotTo(BeNil())
+			Expect(MethodRateLimit("a", 1, 0, RateLimitReject)(&Server{})).NotTo(BeNil())
+		})
+	})
+})
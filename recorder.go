@@ -0,0 +1,167 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// FrameDirection tells if a RecordedFrame was received or sent by the server
+type FrameDirection string
+
+// Directions of recorded frames
+const (
+	FrameIn  FrameDirection = "in"
+	FrameOut FrameDirection = "out"
+)
+
+// RecordedFrame is the raw data of one read from or write to a connection, including the handshake
+type RecordedFrame struct {
+	Time         time.Time      `json:"time"`
+	ConnectionID string         `json:"connectionId"`
+	Direction    FrameDirection `json:"direction"`
+	Data         []byte         `json:"data"`
+}
+
+// frameRecorder writes RecordedFrames as JSON lines. A nil frameRecorder records nothing
+type frameRecorder struct {
+	mx sync.Mutex
+	w  io.Writer
+}
+
+func (r *frameRecorder) record(connectionID string, direction FrameDirection, data []byte) {
+	if r == nil {
+		return
+	}
+	line, _ := json.Marshal(RecordedFrame{
+		Time:         time.Now().UTC(),
+		ConnectionID: connectionID,
+		Direction:    direction,
+		Data:         data,
+	})
+	line = append(line, '\n')
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	_, _ = r.w.Write(line)
+}
+
+// writer returns conn, which records all writes if r is not nil
+func (r *frameRecorder) writer(conn Connection) Connection {
+	if r == nil {
+		return conn
+	}
+	return &recordingConnection{Connection: conn, recorder: r}
+}
+
+type recordingConnection struct {
+	Connection
+	recorder *frameRecorder
+}
+
+func (r *recordingConnection) Write(p []byte) (int, error) {
+	n, err := r.Connection.Write(p)
+	if n > 0 {
+		r.recorder.record(r.ConnectionID(), FrameOut, p[:n])
+	}
+	return n, err
+}
+
+// ReplayedMessage is a message parsed from a recording.
+// Frame is the RecordedFrame which completed the message. Message is nil if the data could not be parsed
+type ReplayedMessage struct {
+	Frame   RecordedFrame
+	Message interface{}
+	Err     error
+}
+
+// replayStream is the state of one direction of a recorded connection
+type replayStream struct {
+	buf           bytes.Buffer
+	handshakeDone bool
+}
+
+type replayConnection struct {
+	protocol HubProtocol
+	in, out  replayStream
+}
+
+// ReplayRecording reads the frames written by the RecordFrames option from r and feeds them into the parser
+// of the protocol the client requested in the handshake. handler is called with each parsed message in the
+// order of the recording, including the handshake request and response
+func ReplayRecording(r io.Reader, handler func(message ReplayedMessage)) error {
+	connections := make(map[string]*replayConnection)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, readBufferSize), 1<<26)
+	for scanner.Scan() {
+		frame := RecordedFrame{}
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			return err
+		}
+		conn, ok := connections[frame.ConnectionID]
+		if !ok {
+			conn = &replayConnection{}
+			connections[frame.ConnectionID] = conn
+		}
+		conn.replay(frame, handler)
+	}
+	return scanner.Err()
+}
+
+func (c *replayConnection) replay(frame RecordedFrame, handler func(message ReplayedMessage)) {
+	stream := &c.in
+	if frame.Direction == FrameOut {
+		stream = &c.out
+	}
+	stream.buf.Write(frame.Data)
+	if !stream.handshakeDone {
+		if bytes.IndexByte(stream.buf.Bytes(), 30) < 0 {
+			return
+		}
+		stream.handshakeDone = true
+		data, _ := parseTextMessageFormat(&stream.buf)
+		handler(c.parseHandshake(frame, data))
+	}
+	for stream.buf.Len() > 0 {
+		if c.protocol == nil {
+			handler(ReplayedMessage{Frame: frame, Err: errors.New("no protocol, because the handshake request is missing")})
+			stream.buf.Reset()
+			return
+		}
+		pending := append([]byte(nil), stream.buf.Bytes()...)
+		message, complete, err := c.protocol.ReadMessage(&stream.buf)
+		if !complete {
+			// ReadMessage consumed the partial message, keep it for the next frame
+			stream.buf.Reset()
+			stream.buf.Write(pending)
+			return
+		}
+		handler(ReplayedMessage{Frame: frame, Message: message, Err: err})
+	}
+}
+
+func (c *replayConnection) parseHandshake(frame RecordedFrame, data []byte) ReplayedMessage {
+	if frame.Direction == FrameOut {
+		response := handshakeResponse{}
+		err := json.Unmarshal(data, &response)
+		return ReplayedMessage{Frame: frame, Message: response, Err: err}
+	}
+	request := handshakeRequest{}
+	if err := json.Unmarshal(data, &request); err != nil {
+		return ReplayedMessage{Frame: frame, Err: err}
+	}
+	registration, ok := protocolMap[request.Protocol]
+	if !ok {
+		return ReplayedMessage{Frame: frame, Message: request, Err: fmt.Errorf("protocol %v not supported", request.Protocol)}
+	}
+	c.protocol = reflect.New(reflect.ValueOf(registration.protocol).Elem().Type()).Interface().(HubProtocol)
+	_, dbg := buildInfoDebugLogger(newLogfmtLogger(ioutil.Discard), false, nil, nil)
+	c.protocol.setDebugLogger(dbg)
+	return ReplayedMessage{Frame: frame, Message: request}
+}
@@ -0,0 +1,109 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"strings"
+	"sync"
+)
+
+type recordingBuffer struct {
+	mx  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (r *recordingBuffer) Write(p []byte) (int, error) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.buf.Write(p)
+}
+
+func (r *recordingBuffer) String() string {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.buf.String()
+}
+
+type recorderHub struct {
+	Hub
+}
+
+func (r *recorderHub) Echo(message string) string {
+	return message
+}
+
+func replayAll(recording string) []ReplayedMessage {
+	var messages []ReplayedMessage
+	Expect(ReplayRecording(strings.NewReader(recording), func(message ReplayedMessage) {
+		messages = append(messages, message)
+	})).To(BeNil())
+	return messages
+}
+
+func recordedLine(connectionID string, direction FrameDirection, data string) string {
+	line, err := json.Marshal(RecordedFrame{ConnectionID: connectionID, Direction: direction, Data: []byte(data)})
+	Expect(err).To(BeNil())
+	return string(line) + "\n"
+}
+
+var _ = Describe("RecordFrames", func() {
+
+	Context("When the server records frames", func() {
+		It("should record the session so it can be replayed", func() {
+			recording := &recordingBuffer{}
+			server, err := NewServer(context.Background(), SimpleHubFactory(&recorderHub{}),
+				Logger(log.NewNopLogger(), false), RecordFrames(recording))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"echo","arguments":["recorded"]}`)
+			Expect(receiveCompletion(conn).Result).To(Equal("recorded"))
+			var messages []ReplayedMessage
+			Eventually(func() int {
+				messages = replayAll(recording.String())
+				return len(messages)
+			}).Should(BeNumerically(">=", 4))
+			for _, message := range messages {
+				Expect(message.Err).To(BeNil())
+				Expect(message.Frame.ConnectionID).To(Equal(conn.ConnectionID()))
+			}
+			Expect(messages[0].Frame.Direction).To(Equal(FrameIn))
+			Expect(messages[0].Message).To(Equal(handshakeRequest{Protocol: "json", Version: 1}))
+			Expect(messages[1].Frame.Direction).To(Equal(FrameOut))
+			Expect(messages[1].Message).To(Equal(handshakeResponse{}))
+			Expect(messages[2].Frame.Direction).To(Equal(FrameIn))
+			Expect(messages[2].Message).To(BeAssignableToTypeOf(invocationMessage{}))
+			Expect(messages[2].Message.(invocationMessage).Target).To(Equal("echo"))
+			Expect(messages[3].Frame.Direction).To(Equal(FrameOut))
+			Expect(messages[3].Message).To(BeAssignableToTypeOf(completionMessage{}))
+		})
+	})
+
+	Context("When recorded frames contain partial and multiple messages", func() {
+		It("should replay each message once", func() {
+			recording := recordedLine("a", FrameIn, "{\"protocol\":\"json\",\"version\":1}\x1e{\"type\":6}\x1e{\"type\":1,") +
+				recordedLine("b", FrameIn, "{\"protocol\":\"json\",\"version\":1}\x1e") +
+				recordedLine("a", FrameIn, "\"target\":\"echo\"}\x1e{\"type\":6}\x1e")
+			messages := replayAll(recording)
+			Expect(messages).To(HaveLen(5))
+			Expect(messages[0].Frame.ConnectionID).To(Equal("a"))
+			Expect(messages[1].Message).To(BeAssignableToTypeOf(hubMessage{}))
+			Expect(messages[2].Frame.ConnectionID).To(Equal("b"))
+			Expect(messages[3].Message).To(BeAssignableToTypeOf(invocationMessage{}))
+			Expect(messages[4].Message).To(BeAssignableToTypeOf(hubMessage{}))
+		})
+	})
+
+	Context("When a recording does not start with a handshake", func() {
+		It("should replay an error", func() {
+			messages := replayAll(recordedLine("a", FrameIn, "{}\x1e{\"type\":6}\x1e"))
+			Expect(messages).To(HaveLen(2))
+			Expect(messages[0].Err).NotTo(BeNil())
+			Expect(messages[1].Err).NotTo(BeNil())
+		})
+	})
+})
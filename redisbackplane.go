@@ -0,0 +1,248 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RedisBackplane is a Backplane using Redis Pub/Sub. All servers publish and subscribe on the same Redis channel.
+// If the subscription connection to Redis breaks, the RedisBackplane reconnects.
+// Messages published while the connection was broken are lost
+type RedisBackplane struct {
+	address string
+	channel string
+	// ioTimeout limits dialing and each request to the Redis server
+	ioTimeout time.Duration
+	mx        sync.Mutex
+	// pubConn is the idle publishing connection. While a Publish uses it, it is nil
+	pubConn   net.Conn
+	pubReader *bufio.Reader
+	subConns  map[net.Conn]struct{}
+	closed    bool
+}
+
+const redisReconnectInterval = time.Second
+const redisIOTimeout = 5 * time.Second
+
+var errRedisBackplaneClosed = errors.New("RedisBackplane closed")
+
+// NewRedisBackplane creates a RedisBackplane which connects to the Redis server at address (host:port)
+// and sends all messages over the Redis channel
+func NewRedisBackplane(address string, channel string) (*RedisBackplane, error) {
+	conn, err := net.DialTimeout("tcp", address, redisIOTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &RedisBackplane{
+		address:   address,
+		channel:   channel,
+		ioTimeout: redisIOTimeout,
+		pubConn:   conn,
+		pubReader: bufio.NewReader(conn),
+		subConns:  make(map[net.Conn]struct{}),
+	}, nil
+}
+
+// Publish publishes the message on the Redis channel.
+// Concurrent Publish calls do not wait for each other, each request is limited by a timeout
+func (r *RedisBackplane) Publish(message []byte) error {
+	// Take the idle connection, so the lock is not held during the request
+	r.mx.Lock()
+	if r.closed {
+		r.mx.Unlock()
+		return errRedisBackplaneClosed
+	}
+	conn, reader := r.pubConn, r.pubReader
+	r.pubConn, r.pubReader = nil, nil
+	r.mx.Unlock()
+	if conn == nil {
+		var err error
+		if conn, err = net.DialTimeout("tcp", r.address, r.ioTimeout); err != nil {
+			return err
+		}
+		reader = bufio.NewReader(conn)
+	}
+	err := conn.SetDeadline(time.Now().Add(r.ioTimeout))
+	if err == nil {
+		err = writeRESPCommand(conn, []byte("PUBLISH"), []byte(r.channel), message)
+	}
+	if err == nil {
+		_, err = readRESP(reader)
+	}
+	var redisErr redisError
+	if err != nil && !errors.As(err, &redisErr) {
+		// The connection is broken or timed out, connect again on the next Publish
+		_ = conn.Close()
+		return err
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.closed || r.pubConn != nil {
+		// Another Publish already returned its connection
+		_ = conn.Close()
+	} else {
+		r.pubConn, r.pubReader = conn, reader
+	}
+	return err
+}
+
+// Subscribe subscribes the Redis channel. handler is called with every message published on the channel
+func (r *RedisBackplane) Subscribe(handler func(message []byte)) error {
+	conn, reader, err := r.subscribe()
+	if err != nil {
+		return err
+	}
+	go r.receive(conn, reader, handler)
+	return nil
+}
+
+// Close closes the connections to the Redis server
+func (r *RedisBackplane) Close() error {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.closed = true
+	for conn := range r.subConns {
+		_ = conn.Close()
+	}
+	if r.pubConn != nil {
+		return r.pubConn.Close()
+	}
+	return nil
+}
+
+func (r *RedisBackplane) subscribe() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", r.address, r.ioTimeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	err = conn.SetDeadline(time.Now().Add(r.ioTimeout))
+	if err == nil {
+		err = writeRESPCommand(conn, []byte("SUBSCRIBE"), []byte(r.channel))
+	}
+	if err == nil {
+		// Subscription confirmation
+		_, err = readRESP(reader)
+	}
+	if err == nil {
+		// Wait for published messages without deadline
+		err = conn.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.closed {
+		_ = conn.Close()
+		return nil, nil, errRedisBackplaneClosed
+	}
+	r.subConns[conn] = struct{}{}
+	return conn, reader, nil
+}
+
+func (r *RedisBackplane) receive(conn net.Conn, reader *bufio.Reader, handler func(message []byte)) {
+	for {
+		reply, err := readRESP(reader)
+		if err != nil {
+			r.mx.Lock()
+			delete(r.subConns, conn)
+			r.mx.Unlock()
+			_ = conn.Close()
+			for {
+				r.mx.Lock()
+				closed := r.closed
+				r.mx.Unlock()
+				if closed {
+					return
+				}
+				time.Sleep(redisReconnectInterval)
+				if conn, reader, err = r.subscribe(); err == nil {
+					break
+				}
+			}
+			continue
+		}
+		// Pushed messages are ["message", channel, payload]
+		if push, ok := reply.([]interface{}); ok && len(push) == 3 {
+			if kind, ok := push[0].([]byte); ok && string(kind) == "message" {
+				if payload, ok := push[2].([]byte); ok {
+					handler(payload)
+				}
+			}
+		}
+	}
+}
+
+type redisError string
+
+func (r redisError) Error() string {
+	return string(r)
+}
+
+// writeRESPCommand writes a command in the Redis serialization protocol
+func writeRESPCommand(writer io.Writer, args ...[]byte) error {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("*%d\r\n", len(args)))
+	for _, arg := range args {
+		buf.WriteString(fmt.Sprintf("$%d\r\n", len(arg)))
+		buf.Write(arg)
+		buf.WriteString("\r\n")
+	}
+	_, err := writer.Write(buf.Bytes())
+	return err
+}
+
+// readRESP reads a value in the Redis serialization protocol. Bulk strings are returned as []byte,
+// arrays as []interface{}. Redis errors are returned as redisError
+func readRESP(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("invalid RESP: empty line")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		array := make([]interface{}, n)
+		for i := range array {
+			if array[i], err = readRESP(reader); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	default:
+		return nil, fmt.Errorf("invalid RESP type %q", line[0])
+	}
+}
@@ -0,0 +1,173 @@
+package signalr
+
+import (
+	"bufio"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net"
+	"sync"
+	"time"
+)
+
+// fakeRedis implements the Redis commands PUBLISH, SUBSCRIBE, SADD, SREM, SMEMBERS and DEL
+type fakeRedis struct {
+	listener    net.Listener
+	mx          sync.Mutex
+	subscribers map[net.Conn]string
+	sets        map[string]map[string]struct{}
+}
+
+func newFakeRedis() *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).To(BeNil())
+	f := &fakeRedis{listener: listener, subscribers: make(map[net.Conn]string), sets: make(map[string]map[string]struct{})}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	for {
+		command, err := readRESP(reader)
+		if err != nil {
+			return
+		}
+		args := command.([]interface{})
+		switch string(args[0].([]byte)) {
+		case "SUBSCRIBE":
+			f.mx.Lock()
+			f.subscribers[conn] = string(args[1].([]byte))
+			f.mx.Unlock()
+			_, _ = conn.Write([]byte("*3\r\n$9\r\nsubscribe\r\n"))
+			_ = writeRESPCommand(conn, args[1].([]byte))
+			_, _ = conn.Write([]byte(":1\r\n"))
+		case "PUBLISH":
+			f.mx.Lock()
+			for subscriber, channel := range f.subscribers {
+				if channel == string(args[1].([]byte)) {
+					_ = writeRESPCommand(subscriber, []byte("message"), args[1].([]byte), args[2].([]byte))
+				}
+			}
+			f.mx.Unlock()
+			_, _ = conn.Write([]byte(":1\r\n"))
+		case "SADD":
+			f.mx.Lock()
+			addToSet(f.sets, string(args[1].([]byte)), string(args[2].([]byte)))
+			f.mx.Unlock()
+			_, _ = conn.Write([]byte(":1\r\n"))
+		case "SREM":
+			f.mx.Lock()
+			removeFromSet(f.sets, string(args[1].([]byte)), string(args[2].([]byte)))
+			f.mx.Unlock()
+			_, _ = conn.Write([]byte(":1\r\n"))
+		case "SMEMBERS":
+			f.mx.Lock()
+			members := make([][]byte, 0)
+			for member := range f.sets[string(args[1].([]byte))] {
+				members = append(members, []byte(member))
+			}
+			f.mx.Unlock()
+			_ = writeRESPCommand(conn, members...)
+		case "DEL":
+			f.mx.Lock()
+			delete(f.sets, string(args[1].([]byte)))
+			f.mx.Unlock()
+			_, _ = conn.Write([]byte(":1\r\n"))
+		default:
+			_, _ = conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+	}
+}
+
+func (f *fakeRedis) Close() {
+	_ = f.listener.Close()
+}
+
+var _ = Describe("RedisBackplane", func() {
+
+	Context("When a message is published", func() {
+		It("should be received by all subscribers of the channel", func() {
+			redis := newFakeRedis()
+			defer redis.Close()
+			publisher, err := NewRedisBackplane(redis.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			defer publisher.Close()
+			subscriber, err := NewRedisBackplane(redis.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			defer subscriber.Close()
+			received := make(chan string, 1)
+			Expect(subscriber.Subscribe(func(message []byte) { received <- string(message) })).To(BeNil())
+			Expect(publisher.Publish([]byte("hello\r\nredis"))).To(BeNil())
+			select {
+			case message := <-received:
+				Expect(message).To(Equal("hello\r\nredis"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+		})
+	})
+
+	Context("When servers are connected over the RedisBackplane", func() {
+		It("should invoke the clients of all servers", func() {
+			redis := newFakeRedis()
+			defer redis.Close()
+			backplane, err := NewRedisBackplane(redis.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			defer backplane.Close()
+			connA, connB := connectBackplaneServers(backplane)
+			connA.ClientSend(`{"type":1,"target":"callall"}`)
+			expectClientFunc(connA)
+			expectClientFunc(connB)
+		})
+	})
+
+	Context("When the Redis server does not answer", func() {
+		It("should return an error on Publish after the timeout without blocking other Publish calls", func() {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).To(BeNil())
+			defer listener.Close()
+			go func() {
+				for {
+					// Accept, but never answer
+					if _, err := listener.Accept(); err != nil {
+						return
+					}
+				}
+			}()
+			backplane, err := NewRedisBackplane(listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			defer backplane.Close()
+			backplane.ioTimeout = 100 * time.Millisecond
+			errs := make(chan error, 2)
+			start := time.Now()
+			for i := 0; i < 2; i++ {
+				go func() { errs <- backplane.Publish([]byte("hello")) }()
+			}
+			for i := 0; i < 2; i++ {
+				Expect(<-errs).NotTo(BeNil())
+			}
+			// Both requests timed out concurrently
+			Expect(time.Since(start)).To(BeNumerically("<", 190*time.Millisecond))
+		})
+	})
+
+	Context("When the backplane is closed", func() {
+		It("should return an error on Publish", func() {
+			redis := newFakeRedis()
+			defer redis.Close()
+			backplane, err := NewRedisBackplane(redis.listener.Addr().String(), "hub")
+			Expect(err).To(BeNil())
+			Expect(backplane.Close()).To(BeNil())
+			Expect(backplane.Publish([]byte("hello"))).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,23 @@
+package signalr
+
+import "time"
+
+// RetryPolicy decides if and when the Client tries to reconnect after the connection to the server was lost
+type RetryPolicy interface {
+	// NextRetryDelay returns the delay before the next reconnect attempt. retryCount is the number of
+	// failed attempts so far, elapsed the time since the connection was lost. If ok is false, the Client stops reconnecting
+	NextRetryDelay(retryCount int, elapsed time.Duration) (delay time.Duration, ok bool)
+}
+
+// delayRetryPolicy retries once for each delay
+type delayRetryPolicy []time.Duration
+
+func (d delayRetryPolicy) NextRetryDelay(retryCount int, elapsed time.Duration) (time.Duration, bool) {
+	if retryCount >= len(d) {
+		return 0, false
+	}
+	return d[retryCount], true
+}
+
+// defaultRetryDelays are the reconnect delays used by WithAutomaticReconnect when no delays are given
+var defaultRetryDelays = []time.Duration{0, 2 * time.Second, 10 * time.Second, 30 * time.Second}
@@ -2,13 +2,15 @@ package signalr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/go-kit/kit/log"
-	"github.com/go-kit/kit/log/level"
+	"net/http"
+	"net/url"
 	"os"
 	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -16,33 +18,106 @@ import (
 
 // Server is a SignalR server for one type of hub
 type Server struct {
-	newHub                func() HubInterface
-	lifetimeManager       HubLifetimeManager
-	defaultHubClients     *defaultHubClients
-	groupManager          GroupManager
-	info                  log.Logger
-	dbg                   log.Logger
-	hubChanReceiveTimeout time.Duration
-}
-
-// NewServer creates a new server for one type of hub
-// newHub is called each time a hub method is invoked by a client to create the transient hub instance
-func NewServer(options ...func(*Server) error) (*Server, error) {
+	ctx                        context.Context
+	newHub                     func(connection ConnectionContext) HubInterface
+	lifetimeManager            HubLifetimeManager
+	defaultHubClients          *defaultHubClients
+	groupManager               *defaultGroupManager
+	logger                     StructuredLogger
+	debug                      bool
+	logLevels                  map[string]LogLevel
+	logLevelValues             *logLevelValues
+	info                       StructuredLogger
+	dbg                        StructuredLogger
+	hubChanReceiveTimeout      time.Duration
+	keepAliveInterval          time.Duration
+	clientTimeoutInterval      time.Duration
+	handshakeTimeout           time.Duration
+	maximumReceiveMessageSize  uint
+	maximumParallelInvocations uint
+	authenticate               func(req *http.Request) (Claims, error)
+	userIDProvider             func(claims Claims) string
+	requireClientCertificate   bool
+	trustForwardedHeaders      bool
+	publicURL                  *url.URL
+	hubFilters                 []HubFilter
+	connectionIDGenerator      func() string
+	protocols                  map[string]protocolRegistration
+	frameTypes                 map[string]FrameType
+	argumentConverters         map[reflect.Type]ArgumentConverter
+	recorder                   *frameRecorder
+	webSocketCompression       *webSocketCompression
+	webSocket                  webSocketOptions
+	longPolling                longPollingOptions
+	corsOptions                *CORSOptions
+	jsonEncoder                JSONEncoder
+	jsonNumbers                bool
+	strictProtocol             bool
+	rateLimit                  *rateLimit
+	outboundQueueSize          uint
+	slowClientPolicy           SlowClientPolicy
+	methodRateLimits           map[string]rateLimit
+	invocationTimeout          time.Duration
+	methodInvocationTimeouts   map[string]time.Duration
+	itemsTokenizer             *itemsTokenizer
+	heartbeat                  func(connection ConnectionContext)
+	mappedMethods              map[string]*mappedMethod
+	excludedMethods            map[string]bool
+	hubRequirement             *AuthorizationRequirement
+	methodRequirements         map[string]*AuthorizationRequirement
+	policyEvaluator            PolicyEvaluator
+	events                     eventBus
+	methods                    methodCache
+	streamBufferCapacity       uint
+	streamBufferPolicy         StreamBufferPolicy
+	streamBatching             streamBatching
+	statefulReconnectTimeout   time.Duration
+	// statefulReconnectBufferSize is the maximum size of the unacknowledged messages. 0 is the default
+	statefulReconnectBufferSize int
+	metrics                     Metrics
+	tracer                      Tracer
+	backplane                   Backplane
+	groupStore                  GroupStore
+	stats                       *serverStats
+	loopsMx                     sync.Mutex
+	loops                       map[*serverLoop]struct{}
+	loopsWg                     sync.WaitGroup
+	shuttingDown                bool
+	draining                    bool
+	drainRedirectURL            string
+	// options are the options of NewServer, hubs the servers added with AddHub
+	options []Option
+	hubs    []*Server
+}
+
+// NewServer creates a new server for one type of hub, which is configured by options.
+// The contexts of all connections are derived from ctx. When ctx is canceled, all connections end
+func NewServer(ctx context.Context, options ...Option) (*Server, error) {
 	lifetimeManager := defaultHubLifetimeManager{}
-	i, d := buildInfoDebugLogger(log.NewLogfmtLogger(os.Stderr), false)
 	server := &Server{
-		lifetimeManager: &lifetimeManager,
-		defaultHubClients: &defaultHubClients{
-			lifetimeManager: &lifetimeManager,
-			allCache:        allClientProxy{lifetimeManager: &lifetimeManager},
-		},
-		groupManager: &defaultGroupManager{
-			lifetimeManager: &lifetimeManager,
-		},
-		info:                  i,
-		dbg:                   d,
-		hubChanReceiveTimeout: time.Millisecond * 5000,
+		ctx:                        ctx,
+		logger:                     newLogfmtLogger(os.Stderr),
+		logLevels:                  make(map[string]LogLevel),
+		hubChanReceiveTimeout:      time.Millisecond * 5000,
+		keepAliveInterval:          defaultKeepAliveInterval,
+		clientTimeoutInterval:      defaultClientTimeoutInterval,
+		handshakeTimeout:           defaultHandshakeTimeout,
+		maximumReceiveMessageSize:  defaultMaximumReceiveMessageSize,
+		maximumParallelInvocations: defaultMaximumParallelInvocations,
+		outboundQueueSize:          outboundQueueSize,
+		userIDProvider:             subjectUserID,
+		connectionIDGenerator:      getConnectionID,
+		protocols:                  protocolMap,
+		frameTypes:                 make(map[string]FrameType),
+		argumentConverters:         make(map[reflect.Type]ArgumentConverter),
+		streamBufferCapacity:       defaultStreamBufferCapacity,
+		streamBufferPolicy:         StreamBufferBlock,
+		metrics:                    noMetrics{},
+		loops:                      make(map[*serverLoop]struct{}),
+		stats:                      newServerStats(),
 	}
+	server.setLifetimeManager(&lifetimeManager)
+	server.options = options
 	for _, option := range options {
 		if option != nil {
 			if err := option(server); err != nil {
@@ -50,49 +125,274 @@ func NewServer(options ...func(*Server) error) (*Server, error) {
 			}
 		}
 	}
+	server.info, server.dbg = buildInfoDebugLogger(server.logger, server.debug, server.logLevels, server.logLevelValues)
 	if server.newHub == nil {
 		return server, errors.New("cannot determine hub type. Neither UseHub, HubFactory or SimpleHubFactory given as option")
 	}
+	lifetimeManager.store = server.groupStore
+	lifetimeManager.info, _ = server.prefixLogger()
+	// Plan the hub methods before the first invocation
+	server.hubMethods(server.newHub(ConnectionContext{Context: server.ctx}))
+	if server.backplane != nil {
+		backplaneManager, err := newBackplaneHubLifetimeManager(&lifetimeManager, server.backplane,
+			server.hubType().String(), server.info)
+		if err != nil {
+			return nil, err
+		}
+		server.setLifetimeManager(backplaneManager)
+	}
 	return server, nil
 }
 
+func (s *Server) setLifetimeManager(lifetimeManager HubLifetimeManager) {
+	s.lifetimeManager = lifetimeManager
+	s.defaultHubClients = &defaultHubClients{
+		lifetimeManager: lifetimeManager,
+		allCache:        allClientProxy{lifetimeManager: lifetimeManager},
+	}
+	s.groupManager = &defaultGroupManager{
+		lifetimeManager: lifetimeManager,
+	}
+}
+
+// HubClients gets a ServerHubClients that can be used to invoke methods on the clients connected to the hub
+// from outside of hub methods
+func (s *Server) HubClients() ServerHubClients {
+	return s.defaultHubClients
+}
+
+// Groups gets a GroupManager that can be used to add and remove connections to named groups
+// from outside of hub methods
+func (s *Server) Groups() GroupManager {
+	return s.groupManager
+}
+
 // Run runs the server on one connection. The same server might be run on different connections in parallel
 func (s *Server) Run(conn Connection) {
-	if protocol, err := s.processHandshake(conn); err != nil {
-		info, _ := s.prefixLogger()
+	s.run(s.ctx, conn)
+}
+
+// run runs the connection. The context of the connection is derived from ctx
+func (s *Server) run(ctx context.Context, conn Connection) {
+	info, _ := s.prefixLogger()
+	if s.isShuttingDown() {
+		_ = info.Log(evt, "run", "error", errServerShutdown, react, "do not connect")
+		return
+	}
+	if protocol, request, err := s.processHandshake(conn); err != nil {
+		s.metrics.HandshakeFailed()
 		_ = info.Log(evt, "processHandshake", "error", err, react, "do not connect")
 	} else {
-		s.newServerLoop(conn, protocol).Run()
+		loop := s.newServerLoop(ctx, conn, protocol)
+		loop.restoreItems(request)
+		if !s.addLoop(loop) {
+			_ = info.Log(evt, "run", "error", errServerShutdown, react, "do not connect")
+			return
+		}
+		defer s.removeLoop(loop)
+		loop.Run()
 	}
 }
 
-func (s *Server) prefixLogger() (info log.Logger, debug log.Logger) {
-	return log.WithPrefix(s.info, "ts", log.DefaultTimestampUTC,
-			"class", "Server",
-			"hub", reflect.ValueOf(s.newHub()).Elem().Type()),
-		log.WithPrefix(s.dbg, "ts", log.DefaultTimestampUTC,
-			"class", "Server",
-			"hub", reflect.ValueOf(s.newHub()).Elem().Type())
+var errServerShutdown = errors.New("server is shutting down")
+
+var errServerDraining = errors.New("server is draining")
+
+// Shutdown gracefully shuts down the server. It stops accepting new connections and new invocations,
+// waits for the running hub method invocations to end and then closes all connections with
+// a close message which allows the clients to reconnect.
+// If ctx ends before the invocations ended, the connections are closed anyway.
+// If ctx ends before the connections are closed, Shutdown returns the error of ctx.
+// The servers added with AddHub are shut down at the same time
+func (s *Server) Shutdown(ctx context.Context) error {
+	hubs := s.addedHubs()
+	results := make(chan error, len(hubs)+1)
+	for _, hub := range hubs {
+		go func(hub *Server) { results <- hub.Shutdown(ctx) }(hub)
+	}
+	results <- s.shutdown(ctx)
+	var err error
+	for i := 0; i <= len(hubs); i++ {
+		if result := <-results; result != nil {
+			err = result
+		}
+	}
+	return err
 }
 
-func buildInfoDebugLogger(logger log.Logger, debug bool) (log.Logger, log.Logger) {
-	if debug {
-		logger = level.NewFilter(logger, level.AllowDebug())
-	} else {
-		logger = level.NewFilter(logger, level.AllowInfo())
+func (s *Server) shutdown(ctx context.Context) error {
+	s.loopsMx.Lock()
+	s.shuttingDown = true
+	loops := make([]*serverLoop, 0, len(s.loops))
+	for loop := range s.loops {
+		loops = append(loops, loop)
+	}
+	s.loopsMx.Unlock()
+	for _, loop := range loops {
+		loop.drain()
+	}
+	invocationsDone := make(chan struct{})
+	go func() {
+		for _, loop := range loops {
+			loop.invocations.Wait()
+		}
+		close(invocationsDone)
+	}()
+	select {
+	case <-invocationsDone:
+	case <-ctx.Done():
+	}
+	for _, loop := range loops {
+		loop.stop()
+	}
+	loopsDone := make(chan struct{})
+	go func() {
+		s.loopsWg.Wait()
+		close(loopsDone)
+	}()
+	select {
+	case <-loopsDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// AddHub creates a Server for another hub type, configured by the options of s, followed by hubOption, e.g.
+// SimpleHubFactory, and options. It has its own connections, groups and lifetime events. Drain and Shutdown of s
+// include it. Map it on another path than s:
+//
+//	server.MapHTTP(router, "/chat")
+//	metrics, err := server.AddHub(signalr.SimpleHubFactory(&metricsHub{}))
+//	metrics.MapHTTP(router, "/metrics")
+func (s *Server) AddHub(hubOption Option, options ...Option) (*Server, error) {
+	if hubOption == nil {
+		return nil, errors.New("AddHub needs a hub option")
+	}
+	hubOptions := append(append(append(make([]Option, 0, len(s.options)+len(options)+1),
+		s.options...), hubOption), options...)
+	hub, err := NewServer(s.ctx, hubOptions...)
+	if err != nil {
+		return nil, err
+	}
+	s.loopsMx.Lock()
+	defer s.loopsMx.Unlock()
+	s.hubs = append(s.hubs, hub)
+	if s.draining {
+		hub.draining = true
 	}
-	return level.Info(logger), log.With(level.Debug(logger), "caller", log.DefaultCaller)
+	return hub, nil
 }
 
-func startPingClientLoop(conn hubConnection) *sync.WaitGroup {
+// addedHubs returns the servers added with AddHub
+func (s *Server) addedHubs() []*Server {
+	s.loopsMx.Lock()
+	defer s.loopsMx.Unlock()
+	return append([]*Server(nil), s.hubs...)
+}
+
+// Drain lets the server keep its connections, but refuse new ones, e.g. before a rolling deploy replaces it.
+// Negotiate requests are redirected to the DrainRedirectURL, or answered with 503 Service Unavailable and an error.
+// Transports of connections negotiated before, stateful reconnects and connections over Serve are still accepted.
+// Call Shutdown when the connections moved to other instances
+func (s *Server) Drain() {
+	for _, hub := range s.addedHubs() {
+		hub.Drain()
+	}
+	s.loopsMx.Lock()
+	defer s.loopsMx.Unlock()
+	s.draining = true
+}
+
+// Draining tells if Drain was called, e.g. for the readiness probe of the server
+func (s *Server) Draining() bool {
+	s.loopsMx.Lock()
+	defer s.loopsMx.Unlock()
+	return s.draining
+}
+
+func (s *Server) isShuttingDown() bool {
+	s.loopsMx.Lock()
+	defer s.loopsMx.Unlock()
+	return s.shuttingDown
+}
+
+func (s *Server) addLoop(loop *serverLoop) bool {
+	s.loopsMx.Lock()
+	defer s.loopsMx.Unlock()
+	if s.shuttingDown {
+		return false
+	}
+	s.loops[loop] = struct{}{}
+	s.loopsWg.Add(1)
+	return true
+}
+
+func (s *Server) removeLoop(loop *serverLoop) {
+	s.loopsMx.Lock()
+	delete(s.loops, loop)
+	s.loopsMx.Unlock()
+	s.loopsWg.Done()
+}
+
+func (s *Server) prefixLogger() (info StructuredLogger, debug StructuredLogger) {
+	hubType := s.hubType()
+	return withPrefix(s.info, "ts", defaultTimestampUTC,
+			"class", LogSubsystemServer,
+			"hub", hubType),
+		withPrefix(s.dbg, "ts", defaultTimestampUTC,
+			"class", LogSubsystemServer,
+			"hub", hubType)
+}
+
+// webSocketOptions returns the options for WebSocket connections. With pings and without read timeout,
+// connections which do not even answer pings are closed after the ClientTimeoutInterval
+func (s *Server) webSocketOptions() webSocketOptions {
+	options := s.webSocket
+	if options.pingInterval > 0 && options.readTimeout <= 0 {
+		options.readTimeout = s.clientTimeoutInterval
+	}
+	return options
+}
+
+// hubType creates a hub without connection to get its type
+func (s *Server) hubType() reflect.Type {
+	return reflect.ValueOf(s.newHub(ConnectionContext{Context: s.ctx})).Elem().Type()
+}
+
+// Same defaults as the ASP.NET Core server
+const defaultKeepAliveInterval = 15 * time.Second
+const defaultClientTimeoutInterval = 30 * time.Second
+const defaultHandshakeTimeout = 15 * time.Second
+const defaultMaximumReceiveMessageSize = 1 << 15 // 32KB
+const defaultMaximumParallelInvocations = 1
+
+// startPingClientLoop sends a ping when nothing else was sent over conn during keepAliveInterval.
+// The loop ends when conn is disconnected or done is closed
+func startPingClientLoop(conn hubConnection, keepAliveInterval time.Duration, done <-chan struct{}) *sync.WaitGroup {
 	var waitgroup sync.WaitGroup
 	waitgroup.Add(1)
 	go func(waitGroup *sync.WaitGroup, conn hubConnection) {
 		defer waitGroup.Done()
-
-		for conn.IsConnected() {
-			conn.Ping()
-			time.Sleep(5 * time.Second)
+		timer := time.NewTimer(keepAliveInterval)
+		defer timer.Stop()
+		// Loop until done. The loop might start before conn is started
+		for {
+			select {
+			case <-timer.C:
+			case <-done:
+				return
+			}
+			if !conn.IsConnected() {
+				timer.Reset(keepAliveInterval)
+				continue
+			}
+			if idle := time.Since(conn.LastWriteStamp()); idle >= keepAliveInterval {
+				conn.Ping()
+				timer.Reset(keepAliveInterval)
+			} else {
+				timer.Reset(keepAliveInterval - idle)
+			}
 		}
 	}(&waitgroup, conn)
 	return &waitgroup
@@ -106,48 +406,101 @@ func (s *Server) newConnectionHubContext(conn hubConnection) HubContext {
 		},
 		groups: s.groupManager,
 		items:  conn.Items(),
+		ctx:    conn.Context(),
+		conn:   conn,
 	}
 }
 
 func (s *Server) getHub(conn hubConnection) HubInterface {
-	hub := s.newHub()
+	hub := s.newHub(ConnectionContext{Context: conn.Context(), ConnectionID: conn.GetConnectionID()})
 	hub.Initialize(s.newConnectionHubContext(conn))
 	return hub
 }
 
-func getMethod(hub HubInterface, name string) (reflect.Value, bool) {
-	hubType := reflect.TypeOf(hub)
-	hubValue := reflect.ValueOf(hub)
-	name = strings.ToLower(name)
-	for i := 0; i < hubType.NumMethod(); i++ {
-		if m := hubType.Method(i); strings.ToLower(m.Name) == name {
-			return hubValue.Method(i), true
-		}
+// mappedMethod is a method expression of a hub type, e.g. (*ChatHub).SendMessage
+type mappedMethod struct {
+	method reflect.Value
+	// bound is the type of the method without its receiver
+	bound reflect.Type
+}
+
+var hubInterfaceType = reflect.TypeOf((*HubInterface)(nil)).Elem()
+
+func newMappedMethod(method interface{}) (*mappedMethod, error) {
+	value := reflect.ValueOf(method)
+	if value.Kind() != reflect.Func {
+		return nil, fmt.Errorf("method %v is not a func", method)
+	}
+	methodType := value.Type()
+	if methodType.NumIn() == 0 || !methodType.In(0).Implements(hubInterfaceType) {
+		return nil, fmt.Errorf("method %v has no hub as first parameter", methodType)
+	}
+	in := make([]reflect.Type, methodType.NumIn()-1)
+	for i := range in {
+		in[i] = methodType.In(i + 1)
+	}
+	out := make([]reflect.Type, methodType.NumOut())
+	for i := range out {
+		out[i] = methodType.Out(i)
 	}
-	return reflect.Value{}, false
+	return &mappedMethod{method: value, bound: reflect.FuncOf(in, out, methodType.IsVariadic())}, nil
 }
 
-func buildMethodArguments(method reflect.Value, invocation invocationMessage,
+// bind returns the method for hub, which has to be of the type of the method expression
+func (m *mappedMethod) bind(hub HubInterface) reflect.Value {
+	receiver := reflect.ValueOf(hub)
+	return reflect.MakeFunc(m.bound, func(args []reflect.Value) []reflect.Value {
+		if m.bound.IsVariadic() {
+			return m.method.CallSlice(append([]reflect.Value{receiver}, args...))
+		}
+		return m.method.Call(append([]reflect.Value{receiver}, args...))
+	})
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+func buildMethodArguments(ctx context.Context, method *hubMethod, invocation invocationMessage,
 	streamClient *streamClient, protocol HubProtocol) (arguments []reflect.Value, clientStreaming bool, err error) {
-	arguments = make([]reflect.Value, method.Type().NumIn())
+	arguments = make([]reflect.Value, method.numIn)
+	if len(invocation.Arguments) < method.required || len(invocation.Arguments) > method.maximum {
+		return arguments, false, argumentCountError(invocation.Target, method.required, method.maximum, len(invocation.Arguments))
+	}
 	chanCount := 0
-	for i := 0; i < method.Type().NumIn(); i++ {
-		t := method.Type().In(i)
-		// Is it a channel for client streaming?
-		if arg, clientStreaming, err := streamClient.buildChannelArgument(invocation, t, chanCount); err != nil {
-			// it is, but channel count in invocation and method mismatch
-			return nil, false, err
-		} else if clientStreaming {
-			// it is
+	// index of the next argument sent by the client
+	index := 0
+	for i, param := range method.params {
+		switch param.kind {
+		case contextParameter:
+			// The method wants to know when the connection ends or the invocation is canceled
+			arguments[i] = reflect.ValueOf(ctx)
+		case streamParameter:
+			arg, _, err := streamClient.buildChannelArgument(invocation, param.t, chanCount)
+			if err != nil {
+				// channel count in invocation and method mismatch
+				return nil, false, err
+			}
 			chanCount++
 			arguments[i] = arg
-		} else {
-			// it is not, so do the normal thing
-			arg := reflect.New(t)
-			if err := protocol.UnmarshalArgument(invocation.Arguments[i-chanCount], arg.Interface()); err != nil {
+		case variadicParameter:
+			// The remaining arguments are passed one by one, method.Call builds the slice
+			arguments = arguments[:i]
+			for ; index < len(invocation.Arguments); index++ {
+				arg, err := bindArgument(invocation, index, param, protocol)
+				if err != nil {
+					return arguments, chanCount > 0, err
+				}
+				arguments = append(arguments, arg)
+			}
+		default:
+			if index >= len(invocation.Arguments) {
+				// Omitted optional argument
+				arguments[i] = reflect.Zero(param.t)
+				continue
+			}
+			if arguments[i], err = bindArgument(invocation, index, param, protocol); err != nil {
 				return arguments, chanCount > 0, err
 			}
-			arguments[i] = arg.Elem()
+			index++
 		}
 	}
 	if len(invocation.StreamIds) > chanCount {
@@ -156,6 +509,42 @@ func buildMethodArguments(method reflect.Value, invocation invocationMessage,
 	return arguments, chanCount > 0, nil
 }
 
+// bindArgument unmarshals the argument with index of the invocation for param
+func bindArgument(invocation invocationMessage, index int, param methodParameter, protocol HubProtocol) (value reflect.Value, err error) {
+	argument := invocation.Arguments[index]
+	switch {
+	case param.converter != nil:
+		if value, err = convertArgument(param.converter, protocol, argument, param.t); err != nil {
+			return value, fmt.Errorf("invalid argument %v of method %v: %w", index, invocation.Target, err)
+		}
+		return value, nil
+	case param.number:
+		number, err := protocol.unmarshalNumber(argument)
+		if err == nil {
+			value, err = coerceNumber(number, param.t)
+		}
+		if err != nil {
+			return value, fmt.Errorf("invalid argument %v of method %v: %w", index, invocation.Target, err)
+		}
+		return value, nil
+	}
+	arg := reflect.New(param.t)
+	if err := protocol.UnmarshalArgument(argument, arg.Interface()); err != nil {
+		return value, err
+	}
+	return arg.Elem(), nil
+}
+
+func argumentCountError(method string, required int, maximum int, provided int) error {
+	if maximum == unlimitedArguments {
+		return fmt.Errorf("method %v expects at least %v arguments, but %v were provided", method, required, provided)
+	}
+	if required == maximum {
+		return fmt.Errorf("method %v expects %v arguments, but %v were provided", method, required, provided)
+	}
+	return fmt.Errorf("method %v expects %v to %v arguments, but %v were provided", method, required, maximum, provided)
+}
+
 type connFunc func(conn hubConnection, invocation invocationMessage, value interface{})
 
 func completion(conn hubConnection, invocation invocationMessage, value interface{}) {
@@ -181,62 +570,184 @@ func invokeConnection(conn hubConnection, invocation invocationMessage, connFunc
 	}
 }
 
-func (s *Server) processHandshake(conn Connection) (HubProtocol, error) {
-	var err error
-	var protocol HubProtocol
-	var ok bool
-	const handshakeResponse = "{}\u001e"
-	const errorHandshakeResponse = "{\"error\":\"%s\"}\u001e"
+// processHandshake processes the handshake request on conn. It returns the protocol and the request, which
+// might hold the items token to restore the Items of a previous connection
+func (s *Server) processHandshake(conn Connection) (HubProtocol, handshakeRequest, error) {
 	info, dbg := s.prefixLogger()
+	type readResult struct {
+		rawHandshake []byte
+		err          error
+	}
+	// Read in a separate goroutine, so the handshake can time out
+	readChan := make(chan readResult, 1)
+	go func() {
+		rawHandshake, err := readHandshakeRequest(conn)
+		readChan <- readResult{rawHandshake, err}
+	}()
+	timeout := time.NewTimer(s.handshakeTimeout)
+	defer timeout.Stop()
+	var result readResult
+	select {
+	case result = <-readChan:
+	case <-timeout.C:
+		// Let the reader goroutine end
+		closeTransport(conn)
+		return nil, handshakeRequest{}, fmt.Errorf("handshake timeout (%v) elapsed", s.handshakeTimeout)
+	}
+	if result.err != nil {
+		return nil, handshakeRequest{}, result.err
+	}
+	_ = dbg.Log(evt, "handshake received", "msg", string(result.rawHandshake))
+	s.recorder.record(conn.ConnectionID(), FrameIn, append(result.rawHandshake, 30))
+	// The handshake response is recorded, everything else is written to conn
+	responseConn := s.recorder.writer(conn)
+	reject := func(err error) (HubProtocol, handshakeRequest, error) {
+		// Log what the client sent, the error alone often does not tell what is wrong with the client
+		_ = info.Log(evt, "handshake rejected", "error", err, "payload", handshakePayload(result.rawHandshake))
+		return nil, handshakeRequest{}, writeHandshakeResponse(responseConn, dbg, err)
+	}
+	request := handshakeRequest{}
+	if err := json.Unmarshal(result.rawHandshake, &request); err != nil {
+		return reject(fmt.Errorf("handshake request is not valid JSON: %v", err))
+	}
+	if request.Protocol == "" {
+		return reject(errors.New("handshake request has no protocol"))
+	}
+	registration, ok := s.protocols[request.Protocol]
+	if !ok {
+		return reject(fmt.Errorf("protocol %v not supported, supported protocols are %v",
+			request.Protocol, strings.Join(s.protocolNames(), ", ")))
+	}
+	// Like the ASP.NET Core server, accept all versions up to the version of the protocol
+	maxVersion := registration.version
+	if _, isStateful := conn.(*statefulConnection); isStateful {
+		// Version 2 adds the messages for stateful reconnect
+		maxVersion = 2
+	}
+	if request.Version > maxVersion {
+		return reject(fmt.Errorf("version %v of protocol %v not supported, the maximum version is %v",
+			request.Version, request.Protocol, maxVersion))
+	}
+	protocol := registration.protocol
+	if registration.binary {
+		if binaryConn, ok := conn.(binaryConnection); ok {
+			if err := binaryConn.setBinary(); err != nil {
+				return reject(fmt.Errorf("protocol %v can not be used with this transport: %v", request.Protocol, err))
+			}
+		}
+	}
+	if frameType, ok := s.frameTypes[request.Protocol]; ok {
+		if frameConn, ok := conn.(frameTypeConnection); ok {
+			frameConn.setFrameType(frameType)
+		}
+	}
+	return protocol, request, writeHandshakeResponse(responseConn, dbg, nil)
+}
+
+// maxLoggedHandshakePayload limits the size of rejected handshake requests in the log
+const maxLoggedHandshakePayload = 1024
 
-	// TODO 5 seconds to process the handshake
-	// ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+func handshakePayload(rawHandshake []byte) string {
+	if len(rawHandshake) > maxLoggedHandshakePayload {
+		return fmt.Sprintf("%s... (%v bytes)", rawHandshake[:maxLoggedHandshakePayload], len(rawHandshake))
+	}
+	return string(rawHandshake)
+}
 
+// protocolNames returns the sorted names of the protocols of the server
+func (s *Server) protocolNames() []string {
+	names := make([]string, 0, len(s.protocols))
+	for name := range s.protocols {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readHandshakeRequest reads from conn until the handshake request is complete and returns it without separator
+func readHandshakeRequest(conn Connection) ([]byte, error) {
 	var buf bytes.Buffer
 	data := make([]byte, 1<<12)
 	for {
-		var n int
-		if n, err = conn.Read(data); err != nil {
-			break
-		} else {
-			buf.Write(data[:n])
-			var rawHandshake []byte
-			if rawHandshake, err = parseTextMessageFormat(&buf); err != nil {
-				// Partial message, read more data
-				buf.Write(data[:n])
-			} else {
-				_ = dbg.Log(evt, "handshake received", "msg", string(rawHandshake))
-				request := handshakeRequest{}
-				if err = json.Unmarshal(rawHandshake, &request); err != nil {
-					// Malformed handshake
-					break
-				}
-				if protocol, ok = protocolMap[request.Protocol]; ok {
-					// Send the handshake response
-					if _, err = conn.Write([]byte(handshakeResponse)); err != nil {
-						_ = dbg.Log(evt, "handshake sent", "error", err)
-					} else {
-						_ = dbg.Log(evt, "handshake sent", "msg", handshakeResponse)
-					}
-				} else {
-					err = fmt.Errorf("protocol %v not supported", request.Protocol)
-					_ = info.Log(evt, "protocol requested", "error", err)
-					if _, respErr := conn.Write([]byte(fmt.Sprintf(errorHandshakeResponse, err))); respErr != nil {
-						_ = dbg.Log(evt, "handshake sent", "error", respErr)
-						err = respErr
-					}
-				}
-				break
-			}
+		n, err := conn.Read(data)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(data[:n])
+		if bytes.IndexByte(buf.Bytes(), 30) >= 0 {
+			request, err := parseTextMessageFormat(&buf)
+			unreadRemainder(conn, &buf)
+			return request, err
+		}
+		// Partial message, read more data
+	}
+}
+
+// unreadRemainder returns the data following the handshake to conn, if conn is a stream which can deliver both in one Read
+func unreadRemainder(conn Connection, buf *bytes.Buffer) {
+	if unreadConn, ok := conn.(unreadConnection); ok && buf.Len() > 0 {
+		unreadConn.unread(buf.Bytes())
+	}
+}
+
+// writeHandshakeResponse sends the handshake response for handshakeErr, which is nil if the handshake succeeded.
+// It returns handshakeErr or the error which occurred while sending
+func writeHandshakeResponse(conn Connection, dbg StructuredLogger, handshakeErr error) error {
+	response := handshakeResponse{}
+	if handshakeErr != nil {
+		response.Error = handshakeErr.Error()
+	}
+	rawResponse, _ := json.Marshal(response)
+	rawResponse = append(rawResponse, 30)
+	if _, err := conn.Write(rawResponse); err != nil {
+		_ = dbg.Log(evt, "handshake sent", "error", err)
+		return err
+	}
+	_ = dbg.Log(evt, "handshake sent", "msg", string(rawResponse))
+	return handshakeErr
+}
+
+// protocolRegistration describes a protocol which can be requested by the handshake
+type protocolRegistration struct {
+	protocol HubProtocol
+	version  int
+	binary   bool
+}
+
+// protocolMap contains all protocols this package implements
+var protocolMap = map[string]protocolRegistration{
+	"json":        {protocol: &JSONHubProtocol{}, version: 1},
+	"messagepack": {protocol: &MessagePackHubProtocol{}, version: 1, binary: true},
+}
+
+// protocolName returns the name protocol is registered with
+func (s *Server) protocolName(protocol HubProtocol) string {
+	for name, registration := range s.protocols {
+		if reflect.TypeOf(registration.protocol) == reflect.TypeOf(protocol) {
+			return name
 		}
 	}
-	// TODO Disable the timeout (either we already timeout out or)
-	//ws.SetReadDeadline(time.Time{})
-	return protocol, err
+	return ""
 }
 
-var protocolMap = map[string]HubProtocol{
-	"json": &JSONHubProtocol{},
+// transferFormats returns the transfer formats needed by the protocols of the server
+func (s *Server) transferFormats() []string {
+	text, binary := false, false
+	for _, registration := range s.protocols {
+		if registration.binary {
+			binary = true
+		} else {
+			text = true
+		}
+	}
+	formats := make([]string, 0, 2)
+	if text {
+		formats = append(formats, "Text")
+	}
+	if binary {
+		formats = append(formats, "Binary")
+	}
+	return formats
 }
 
 // const for logging
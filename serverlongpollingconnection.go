@@ -0,0 +1,224 @@
+package signalr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LongPollingOversizePolicy is the reaction of the server to messages which are larger than the maximum
+// response size of the LongPolling transport
+type LongPollingOversizePolicy int
+
+// LongPolling oversize policies
+const (
+	// LongPollingSplitMessages sends oversized messages in chunks of the maximum response size over consecutive polls.
+	// The client must join the responses to one stream before it parses the messages
+	LongPollingSplitMessages LongPollingOversizePolicy = iota
+	// LongPollingRejectMessages does not send oversized messages. Sending them fails with an error and the connection stays open
+	LongPollingRejectMessages
+)
+
+// longPollingOptions are the options of the LongPolling transport
+type longPollingOptions struct {
+	// maxResponseSize limits the size of a poll response. 0 means no limit
+	maxResponseSize uint
+	oversizePolicy  LongPollingOversizePolicy
+}
+
+// serverLongPollingConnection is the server side of the LongPolling transport.
+// Messages sent by the server are buffered until the client polls them with http GET,
+// the client sends its messages over http POST
+type serverLongPollingConnection struct {
+	connectionID      string
+	pollTimeout       time.Duration
+	disconnectTimeout time.Duration
+	mx                sync.Mutex
+	buf               bytes.Buffer
+	// frames holds the sizes of the writes in buf, so responses end at message boundaries
+	frames          []int
+	options         longPollingOptions
+	closed          bool
+	polling         bool
+	notify          chan struct{}
+	done            chan struct{}
+	disconnectTimer *time.Timer
+	postReader      *io.PipeReader
+	postWriter      *io.PipeWriter
+	onRelease       func()
+}
+
+// Same defaults as the ASP.NET Core server
+const longPollingPollTimeout = 90 * time.Second
+const longPollingDisconnectTimeout = 5 * time.Second
+
+var errLongPollingConnectionClosed = errors.New("LongPolling connection closed")
+
+type errLongPollingMessageTooLarge struct {
+	size, maxSize uint
+}
+
+func (e errLongPollingMessageTooLarge) Error() string {
+	return fmt.Sprintf("message of %v bytes exceeds the LongPolling response size of %v bytes", e.size, e.maxSize)
+}
+
+func newServerLongPollingConnection(connectionID string, options longPollingOptions, onRelease func()) *serverLongPollingConnection {
+	postReader, postWriter := io.Pipe()
+	l := &serverLongPollingConnection{
+		connectionID:      connectionID,
+		pollTimeout:       longPollingPollTimeout,
+		disconnectTimeout: longPollingDisconnectTimeout,
+		notify:            make(chan struct{}, 1),
+		done:              make(chan struct{}),
+		postReader:        postReader,
+		postWriter:        postWriter,
+		options:           options,
+		onRelease:         onRelease,
+	}
+	l.mx.Lock()
+	l.disconnectTimer = time.AfterFunc(l.disconnectTimeout, l.release)
+	l.mx.Unlock()
+	return l
+}
+
+func (l *serverLongPollingConnection) ConnectionID() string {
+	return l.connectionID
+}
+
+func (l *serverLongPollingConnection) Read(p []byte) (n int, err error) {
+	return l.postReader.Read(p)
+}
+
+// Write buffers p until the client polls. Each write holds whole messages.
+// With LongPollingRejectMessages, writes larger than the maximum response size fail
+func (l *serverLongPollingConnection) Write(p []byte) (n int, err error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.closed {
+		return 0, errLongPollingConnectionClosed
+	}
+	if maxSize := l.options.maxResponseSize; maxSize > 0 && uint(len(p)) > maxSize &&
+		l.options.oversizePolicy == LongPollingRejectMessages {
+		return 0, errLongPollingMessageTooLarge{size: uint(len(p)), maxSize: maxSize}
+	}
+	n, _ = l.buf.Write(p)
+	if n > 0 {
+		l.frames = append(l.frames, n)
+	}
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+	return n, nil
+}
+
+func (l *serverLongPollingConnection) consume(body io.Reader) error {
+	_, err := io.Copy(l.postWriter, body)
+	return err
+}
+
+// nextResponse removes the buffered messages which fit into the maximum response size from buf and returns them.
+// Responses end at message boundaries and hold at least one message. Messages larger than the maximum size
+// are returned in chunks of the maximum size. It must be called with l.mx locked
+func (l *serverLongPollingConnection) nextResponse() []byte {
+	size := 0
+	for len(l.frames) > 0 {
+		frame := l.frames[0]
+		if maxSize := int(l.options.maxResponseSize); maxSize > 0 && size+frame > maxSize {
+			if size == 0 {
+				// Oversized message, send the first chunk. The response size is the frame size of the rest
+				size = maxSize
+				l.frames[0] -= maxSize
+			}
+			break
+		}
+		size += frame
+		l.frames = l.frames[1:]
+	}
+	data := make([]byte, size)
+	copy(data, l.buf.Next(size))
+	if l.buf.Len() == 0 {
+		l.buf.Reset()
+	}
+	return data
+}
+
+// poll writes the buffered messages to the response, up to the maximum response size. If there are no buffered messages,
+// it waits for new messages until the poll timeout is reached.
+// When the connection is closed and all messages are polled, it responds with 204 NoContent
+func (l *serverLongPollingConnection) poll(w http.ResponseWriter, req *http.Request) {
+	l.mx.Lock()
+	if l.polling {
+		l.mx.Unlock()
+		// Only one poll at a time
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	l.polling = true
+	l.disconnectTimer.Stop()
+	l.mx.Unlock()
+	defer func() {
+		l.mx.Lock()
+		l.polling = false
+		if !l.closed {
+			// If the client does not poll again, it is gone
+			l.disconnectTimer.Reset(l.disconnectTimeout)
+		}
+		l.mx.Unlock()
+	}()
+	timeout := time.NewTimer(l.pollTimeout)
+	defer timeout.Stop()
+	for {
+		l.mx.Lock()
+		if l.buf.Len() > 0 {
+			data := l.nextResponse()
+			l.mx.Unlock()
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(data)
+			return
+		}
+		closed := l.closed
+		l.mx.Unlock()
+		if closed {
+			w.WriteHeader(http.StatusNoContent)
+			l.release()
+			return
+		}
+		select {
+		case <-l.notify:
+		case <-l.done:
+		case <-timeout.C:
+			// Nothing to send, the client will poll again
+			w.WriteHeader(http.StatusOK)
+			return
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
+
+// close stops receiving and sending, but messages already buffered can still be polled
+func (l *serverLongPollingConnection) close() {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+		_ = l.postWriter.CloseWithError(errLongPollingConnectionClosed)
+	}
+}
+
+// release closes the connection and removes it from the transport
+func (l *serverLongPollingConnection) release() {
+	l.close()
+	l.mx.Lock()
+	l.disconnectTimer.Stop()
+	l.mx.Unlock()
+	if l.onRelease != nil {
+		l.onRelease()
+	}
+}
@@ -0,0 +1,143 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var _ = Describe("LongPolling transport", func() {
+
+	Context("A negotiation request is sent", func() {
+		It("should advertise LongPolling with text and binary protocol", func() {
+			router := http.NewServeMux()
+			MapHub(router, "/hub", &webSocketHub{})
+			port := freePort()
+			go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			negResp := negotiateWebSocketTestServer(port)
+			Expect(negResp["availableTransports"]).To(ContainElement(map[string]interface{}{
+				"transport":       "LongPolling",
+				"transferFormats": []interface{}{"Text", "Binary"},
+			}))
+		})
+	})
+
+	Context("When the client polls after negotiation", func() {
+		It("should receive invocations over POST, return the results on poll and close on DELETE", func() {
+			router := http.NewServeMux()
+			MapHub(router, "/hub", &webSocketHub{})
+			port := freePort()
+			go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			negResp := negotiateWebSocketTestServer(port)
+			hubURL := fmt.Sprintf("http://127.0.0.1:%v/hub?id=%v", port, url.QueryEscape(fmt.Sprint(negResp["connectionId"])))
+			poll := func() (int, string) {
+				resp, err := http.Get(hubURL)
+				Expect(err).To(BeNil())
+				defer resp.Body.Close()
+				body, err := ioutil.ReadAll(resp.Body)
+				Expect(err).To(BeNil())
+				return resp.StatusCode, string(body)
+			}
+			post := func(message string) {
+				resp, err := http.Post(hubURL, "text/plain", bytes.NewBufferString(message+"\u001e"))
+				Expect(err).To(BeNil())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			}
+			// The first poll returns immediately
+			status, body := poll()
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(Equal(""))
+			post(`{"protocol": "json","version": 1}`)
+			status, body = poll()
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(body).To(HavePrefix("{}\u001e"))
+			post(`{"type":1,"invocationId":"lp","target":"add2","arguments":[3]}`)
+		pollLoop:
+			for {
+				status, body = poll()
+				Expect(status).To(Equal(http.StatusOK))
+				for _, frame := range strings.Split(body, "\u001e") {
+					message := make(map[string]interface{})
+					if json.Unmarshal([]byte(frame), &message) == nil && message["type"] == 3.0 {
+						Expect(message["invocationId"]).To(Equal("lp"))
+						Expect(message["result"]).To(Equal(5.0))
+						break pollLoop
+					}
+				}
+			}
+			req, _ := http.NewRequest("DELETE", hubURL, nil)
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusAccepted))
+			status, _ = poll()
+			Expect(status).NotTo(Equal(http.StatusOK))
+		})
+	})
+
+	Context("When the buffered messages exceed the maximum response size", func() {
+		newLimitedConnection := func(policy LongPollingOversizePolicy) *serverLongPollingConnection {
+			l := newServerLongPollingConnection("limited", longPollingOptions{maxResponseSize: 8, oversizePolicy: policy}, nil)
+			l.pollTimeout = 10 * time.Millisecond
+			return l
+		}
+		poll := func(l *serverLongPollingConnection) string {
+			recorder := httptest.NewRecorder()
+			l.poll(recorder, httptest.NewRequest("GET", "/hub?id=limited", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			return recorder.Body.String()
+		}
+		It("should end the responses at message boundaries", func() {
+			l := newLimitedConnection(LongPollingSplitMessages)
+			defer l.release()
+			for _, message := range []string{"abc\u001e", "def\u001e", "gh\u001e"} {
+				_, err := l.Write([]byte(message))
+				Expect(err).To(BeNil())
+			}
+			Expect(poll(l)).To(Equal("abc\u001edef\u001e"))
+			Expect(poll(l)).To(Equal("gh\u001e"))
+			Expect(poll(l)).To(Equal(""))
+		})
+		It("should split oversized messages over consecutive polls with LongPollingSplitMessages", func() {
+			l := newLimitedConnection(LongPollingSplitMessages)
+			defer l.release()
+			for _, message := range []string{"0123456789abcdefghi\u001e", "xy\u001e"} {
+				_, err := l.Write([]byte(message))
+				Expect(err).To(BeNil())
+			}
+			Expect(poll(l)).To(Equal("01234567"))
+			Expect(poll(l)).To(Equal("89abcdef"))
+			// The rest of the oversized message is followed by the messages which fit
+			Expect(poll(l)).To(Equal("ghi\u001exy\u001e"))
+		})
+		It("should reject oversized messages with LongPollingRejectMessages and send the others", func() {
+			l := newLimitedConnection(LongPollingRejectMessages)
+			defer l.release()
+			_, err := l.Write([]byte("0123456789\u001e"))
+			Expect(err).To(MatchError(errLongPollingMessageTooLarge{size: 11, maxSize: 8}))
+			_, err = l.Write([]byte("abc\u001e"))
+			Expect(err).To(BeNil())
+			Expect(poll(l)).To(Equal("abc\u001e"))
+		})
+	})
+
+	Context("When LongPollingMaxResponseSize has invalid arguments", func() {
+		It("should fail to create the server", func() {
+			for _, option := range []Option{
+				LongPollingMaxResponseSize(0, LongPollingSplitMessages),
+				LongPollingMaxResponseSize(1024, LongPollingOversizePolicy(5)),
+			} {
+				_, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), option)
+				Expect(err).NotTo(BeNil())
+			}
+		})
+	})
+})
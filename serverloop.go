@@ -1,57 +1,183 @@
 package signalr
 
 import (
+	"context"
 	"fmt"
-	"github.com/go-kit/kit/log"
 	"reflect"
 	"runtime/debug"
 	"sync"
+	"time"
 )
 
 type serverLoop struct {
 	server       *Server
+	ctx          context.Context
+	cancel       context.CancelFunc
 	info         StructuredLogger
 	dbg          StructuredLogger
 	protocol     HubProtocol
 	hubConn      hubConnection
+	transport    string
 	pings        *sync.WaitGroup
 	streamer     *streamer
 	streamClient *streamClient
+	drainMx      sync.Mutex
+	draining     bool
+	invocations  sync.WaitGroup
+	limiter      *invocationLimiter
+	rateLimiter  *invocationRateLimiter
+	stopped      chan struct{}
+	stopOnce     sync.Once
+	itemsMx      sync.Mutex
+	// itemsVersion is the version of the Items sent with the last items token
+	itemsVersion uint64
+	// itemsTokens tells if the client accepts items tokens
+	itemsTokens bool
 }
 
-func (s *Server) newServerLoop(conn Connection, protocol HubProtocol) *serverLoop {
+func (s *Server) newServerLoop(parentCtx context.Context, conn Connection, protocol HubProtocol) *serverLoop {
+	fields := &logFields{}
 	info, dbg := s.prefixLogger()
+	info, dbg = withFields(info, fields), withFields(dbg, fields)
+	protocolName := s.protocolName(protocol)
 	protocol = reflect.New(reflect.ValueOf(protocol).Elem().Type()).Interface().(HubProtocol)
 	protocol.setDebugLogger(s.dbg)
-	hubConn := newHubConnection(conn, protocol, s.info, s.dbg)
+	protocol.setStrict(s.strictProtocol)
+	if jsonProtocol, ok := protocol.(*JSONHubProtocol); ok {
+		jsonProtocol.encoder = s.jsonEncoder
+		if s.jsonEncoder == nil && s.jsonNumbers {
+			jsonProtocol.encoder = stdJSONEncoder{useNumber: true}
+		}
+	}
+	ctx, cancel := context.WithCancel(parentCtx)
+	fields.add("connection", conn.ConnectionID())
+	if claims := ClaimsFromContext(ctx); claims != nil {
+		if userID := s.userIDProvider(claims); userID != "" {
+			ctx = context.WithValue(ctx, userIDKey{}, userID)
+			fields.add("user", userID)
+		}
+	}
+	transport := transportName(conn)
+	fields.add("transport", transport, "hubProtocol", protocolName)
+	ctx = context.WithValue(ctx, logFieldsKey{}, &connectionLog{fields: fields, info: info})
+	hubConn := newHubConnection(ctx, conn, protocol, s.maximumReceiveMessageSize,
+		withFields(s.info, fields), withFields(s.dbg, fields))
+	connectionID := conn.ConnectionID()
+	hubConn.(*defaultHubConnection).messageSent = func() {
+		s.metrics.MessageSent()
+		if s.events.active() {
+			s.events.publish(ServerEvent{Type: MessageSent, ConnectionID: connectionID, UserID: UserIDFromContext(ctx)})
+		}
+	}
+	hubConn.(*defaultHubConnection).recorder = s.recorder
+	hubConn.(*defaultHubConnection).outbound.configure(int(s.outboundQueueSize), s.slowClientPolicy)
+	if defaultConn := hubConn.(*defaultHubConnection); defaultConn.stateful != nil && s.statefulReconnectBufferSize > 0 {
+		defaultConn.maxSentBytes = s.statefulReconnectBufferSize
+	}
+	if s.tracer != nil {
+		hubConn = &tracedHubConnection{hubConnection: hubConn, tracer: s.tracer}
+	}
 	return &serverLoop{
 		server:       s,
+		ctx:          ctx,
+		cancel:       cancel,
 		info:         info,
 		dbg:          dbg,
 		protocol:     protocol,
 		hubConn:      hubConn,
-		pings:        startPingClientLoop(hubConn),
-		streamer:     newStreamer(hubConn),
+		transport:    transport,
+		streamer:     newStreamer(hubConn, s.streamBufferCapacity, s.streamBufferPolicy, s.streamBatching, info),
 		streamClient: newStreamClient(s.hubChanReceiveTimeout),
+		limiter:      newInvocationLimiter(s.maximumParallelInvocations),
+		rateLimiter:  newInvocationRateLimiter(s.rateLimit, s.methodRateLimits),
+		stopped:      make(chan struct{}),
 	}
 }
 
+// drain lets the loop reject all further invocations
+func (sl *serverLoop) drain() {
+	sl.drainMx.Lock()
+	sl.draining = true
+	sl.drainMx.Unlock()
+}
+
+// stop ends the message loop
+func (sl *serverLoop) stop() {
+	sl.stopOnce.Do(func() { close(sl.stopped) })
+}
+
+// beginInvocation registers a new invocation. It returns false if the loop is draining
+func (sl *serverLoop) beginInvocation() bool {
+	sl.drainMx.Lock()
+	defer sl.drainMx.Unlock()
+	if sl.draining {
+		return false
+	}
+	sl.invocations.Add(1)
+	return true
+}
+
 func (sl *serverLoop) Run() {
 	sl.hubConn.Start()
+	sl.server.metrics.ConnectionOpened()
+	defer sl.server.metrics.ConnectionClosed()
+	sl.pings = startPingClientLoop(sl.hubConn, sl.server.keepAliveInterval, sl.ctx.Done())
 	sl.server.lifetimeManager.OnConnected(sl.hubConn)
-	sl.server.getHub(sl.hubConn).OnConnected(sl.hubConn.GetConnectionID())
+	sl.publishEvent(ServerEvent{Type: ConnectionStarted})
+	sl.recoverHubPanic("OnConnected", func() {
+		sl.server.getHub(sl.hubConn).OnConnected(sl.hubConn.GetConnectionID())
+	})
+	sl.sendItemsToken()
+	heartbeats := sl.startHeartbeats()
+	// The reader goroutine of the connection parses the messages, so the message loop is able to wait
+	// for the messages and for timeouts at once
+	recvChan := sl.hubConn.Messages()
+	// If the client sends nothing, not even pings, during the ClientTimeoutInterval it is gone
+	timeout := time.NewTimer(sl.server.clientTimeoutInterval)
+	defer timeout.Stop()
 	// Process messages
 	var message interface{}
 	var connErr error
 messageLoop:
 	for sl.hubConn.IsConnected() {
-		if message, connErr = sl.hubConn.Receive(); connErr != nil {
+		var received receiveResult
+		select {
+		case <-timeout.C:
+			connErr = fmt.Errorf("client timeout interval elapsed (%v)", sl.server.clientTimeoutInterval)
+			_ = sl.info.Log(evt, msgRecv, "error", connErr, react, "disconnect")
+			break messageLoop
+		case <-sl.stopped:
+			_ = sl.info.Log(evt, "shutdown", react, "disconnect")
+			break messageLoop
+		case <-sl.ctx.Done():
+			_ = sl.info.Log(evt, "server context done", react, "disconnect")
+			break messageLoop
+		case <-sl.hubConn.Closed():
+			// Only Abort closes the connection while the loop is running
+			connErr = errConnectionAborted
+			_ = sl.info.Log(evt, "abort", react, "disconnect")
+			break messageLoop
+		case result, ok := <-recvChan:
+			if !ok {
+				result.err = errHubConnectionClosed
+			}
+			received = result
+		}
+		if !timeout.Stop() {
+			<-timeout.C
+		}
+		timeout.Reset(sl.server.clientTimeoutInterval)
+		if message, connErr = received.message, received.err; connErr != nil {
 			_ = sl.info.Log(evt, msgRecv, "error", connErr, msg, message, react, "disconnect")
 			break messageLoop
 		} else {
+			sl.server.metrics.MessageReceived()
 			switch message.(type) {
 			case invocationMessage:
-				sl.handleInvocationMessage(message)
+				var allowed bool
+				if allowed, connErr = sl.limitRate(message.(invocationMessage)); allowed {
+					sl.handleInvocationMessage(message)
+				}
 			case cancelInvocationMessage:
 				_ = sl.dbg.Log(evt, msgRecv, msg, message.(cancelInvocationMessage))
 				sl.streamer.Stop(message.(cancelInvocationMessage).InvocationID)
@@ -60,59 +186,253 @@ messageLoop:
 			case completionMessage:
 				connErr = sl.handleCompletionMessage(message)
 			case closeMessage:
+				// The client is gone, end the connection without waiting for the transport to fail
 				_ = sl.dbg.Log(evt, msgRecv, msg, message.(closeMessage))
+				if closeErr := message.(closeMessage).Error; closeErr != "" {
+					connErr = fmt.Errorf("client closed the connection with error: %v", closeErr)
+				}
 				break messageLoop
 			case hubMessage:
 				connErr = sl.handleOtherMessage(message)
 			}
 			if connErr != nil {
-				break
+				break messageLoop
 			}
 		}
 	}
-	sl.server.getHub(sl.hubConn).OnDisconnected(sl.hubConn.GetConnectionID())
+	// Let hub methods still receiving client streams know that there is nothing more to receive
+	sl.streamClient.closeUpstreamChannels()
+	// Let hub methods and streams waiting for the context know that the connection ended
+	sl.cancel()
+	// No heartbeat must refresh the state of the connection after OnDisconnected removed it
+	heartbeats.Wait()
+	sl.recoverHubPanic("OnDisconnected", func() {
+		sl.server.getHub(sl.hubConn).OnDisconnected(sl.hubConn.GetConnectionID(), connErr)
+	})
 	sl.server.lifetimeManager.OnDisconnected(sl.hubConn)
-	sl.hubConn.Close(fmt.Sprintf("%v", connErr))
+	sl.server.groupManager.onDisconnected(sl.hubConn.GetConnectionID())
+	sl.publishEvent(ServerEvent{Type: ConnectionEnded, Err: connErr})
+	if connErr != nil {
+		sl.hubConn.Close(connErr.Error())
+	} else {
+		sl.hubConn.Close("")
+	}
 	// Wait for pings to complete
 	sl.pings.Wait()
 	_ = sl.dbg.Log(evt, "messageloop ended")
 }
 
+// limitRate checks the rate limits for the invocation. If a limit rejects the invocation, it sends the
+// completion with error. If the connection has to be closed, it returns an error
+func (sl *serverLoop) limitRate(invocation invocationMessage) (bool, error) {
+	allowed, action := sl.rateLimiter.allow(invocation.Target, time.Now())
+	if allowed {
+		return true, nil
+	}
+	if action == RateLimitDisconnect {
+		err := fmt.Errorf("%w by invocation of %v", errRateLimitExceeded, invocation.Target)
+		_ = sl.info.Log(evt, msgRecv, "error", err, "name", invocation.Target, react, "disconnect")
+		return false, err
+	}
+	_ = sl.info.Log(evt, msgRecv, "error", errRateLimitExceeded, "name", invocation.Target, react, "send completion with error")
+	if invocation.InvocationID != "" {
+		sl.hubConn.Completion(invocation.InvocationID, nil, errRateLimitExceeded.Error())
+	}
+	return false, nil
+}
+
 func (sl *serverLoop) handleInvocationMessage(message interface{}) {
 	invocation := message.(invocationMessage)
 	_ = sl.dbg.Log(evt, msgRecv, msg, fmt.Sprintf("%v", invocation))
+	if !sl.beginInvocation() {
+		_ = sl.info.Log(evt, msgRecv, "error", errServerShutdown, "name", invocation.Target, react, "send completion with error")
+		sl.hubConn.Completion(invocation.InvocationID, nil, errServerShutdown.Error())
+		return
+	}
 	// Transient hub, dispatch invocation here
-	if method, ok := getMethod(sl.server.getHub(sl.hubConn), invocation.Target); !ok {
+	hub := sl.server.getHub(sl.hubConn)
+	ctx, span := sl.startInvocationSpan(hub, invocation)
+	dispatched := false
+	defer func() {
+		if !dispatched {
+			span.End()
+			sl.invocations.Done()
+		}
+	}()
+	// A panic while dispatching must not end the connection
+	defer recoverInvocationPanic(sl.info, invocation, sl.hubConn)
+	if method, plan, ok := sl.server.getMethod(hub, invocation.Target); !ok {
 		// Unable to find the method
 		_ = sl.info.Log(evt, "getMethod", "error", "missing method", "name", invocation.Target, react, "send completion with error")
+		span.RecordError(fmt.Errorf("unknown method %s", invocation.Target))
 		sl.hubConn.Completion(invocation.InvocationID, nil, fmt.Sprintf("Unknown method %s", invocation.Target))
-	} else if in, clientStreaming, err := buildMethodArguments(method, invocation, sl.streamClient, sl.protocol); err != nil {
-		// argument build failed
-		_ = sl.info.Log(evt, "buildMethodArguments", "error", err, "name", invocation.Target, react, "send completion with error")
-		sl.hubConn.Completion(invocation.InvocationID, nil, err.Error())
-	} else if clientStreaming {
-		// let the receiving method run independently
-		go func() {
-			defer recoverInvocationPanic(sl.info, invocation, sl.hubConn)
-			method.Call(in)
-		}()
+	} else if err := sl.server.authorize(ctx, invocation.Target); err != nil {
+		_ = sl.info.Log(evt, "authorize", "error", err, "name", invocation.Target, react, "send completion with error")
+		span.RecordError(err)
+		sl.hubConn.CompletionError(invocation.InvocationID, err)
 	} else {
-		// hub method might take a long time
-		go func() {
-			result := func() []reflect.Value {
-				defer recoverInvocationPanic(sl.info, invocation, sl.hubConn)
-				return method.Call(in)
-			}()
-			returnInvocationResult(sl.hubConn, invocation, sl.streamer, result)
-		}()
+		release := func() {}
+		if invocation.Type == 4 && invocation.InvocationID != "" {
+			// Stream invocations can be canceled by the client
+			ctx = sl.streamer.NewContext(ctx, invocation.InvocationID)
+			release = func() { sl.streamer.Stop(invocation.InvocationID) }
+		}
+		conn := sl.hubConn
+		var deadline *invocationDeadline
+		if timeout := sl.server.methodInvocationTimeout(invocation.Target); timeout > 0 {
+			// The context of a stream is the parent of the deadline context, so the stream ends it
+			ctx, deadline = newInvocationDeadline(ctx, sl.hubConn, invocation, timeout, sl.info)
+			conn = deadline
+		}
+		if in, _, err := buildMethodArguments(ctx, plan, invocation, sl.streamClient, sl.protocol); err != nil {
+			// argument build failed
+			_ = sl.info.Log(evt, "buildMethodArguments", "error", err, "name", invocation.Target, react, "send completion with error")
+			span.RecordError(err)
+			sl.hubConn.CompletionError(invocation.InvocationID, err)
+			release()
+		} else {
+			// hub method might take a long time and client streaming methods receive their items while running,
+			// so let the method run independently of the message loop
+			dispatched = true
+			invoke := func() {
+				defer sl.invocations.Done()
+				defer span.End()
+				streaming := false
+				defer func() {
+					if deadline != nil {
+						deadline.returned(streaming)
+					}
+					if !streaming {
+						release()
+					}
+				}()
+				defer recoverInvocationPanic(sl.info, invocation, conn)
+				if result, ok := sl.callHubMethod(ctx, conn, hub, invocation, method, in); ok {
+					// The client has the token of the changed items before the result
+					sl.sendItemsToken()
+					streaming = returnInvocationResult(ctx, conn, invocation, sl.streamer, result)
+				} else {
+					span.RecordError(fmt.Errorf("invocation of %s failed", invocation.Target))
+				}
+			}
+			sl.limiter.run(func() {
+				if deadline == nil {
+					invoke()
+					return
+				}
+				// After the timeout, the next invocations of the client may run while the hub method still runs
+				deadline.start()
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					invoke()
+				}()
+				select {
+				case <-done:
+				case <-deadline.expired:
+				}
+			})
+		}
+	}
+}
+
+// startInvocationSpan starts the span for the invocation if the server has a Tracer.
+// The returned context is the parent context for the hub method and holds the MessageHeaders of the invocation
+func (sl *serverLoop) startInvocationSpan(hub HubInterface, invocation invocationMessage) (context.Context, Span) {
+	ctx := sl.ctx
+	if invocation.Headers != nil {
+		ctx = context.WithValue(ctx, messageHeadersKey{}, invocation.Headers)
+	}
+	if sl.server.tracer == nil {
+		return ctx, noSpan{}
+	}
+	if len(invocation.Headers) > 0 {
+		// A trace context propagated by the headers is the parent of the span
+		ctx = sl.server.tracer.Extract(ctx, invocation.Headers.httpHeader())
+	}
+	hubName := reflect.ValueOf(hub).Elem().Type().Name()
+	return sl.server.tracer.Start(ctx, hubName+"/"+invocation.Target,
+		SpanAttribute{SpanAttributeConnectionID, sl.hubConn.GetConnectionID()},
+		SpanAttribute{SpanAttributeHub, hubName},
+		SpanAttribute{SpanAttributeTarget, invocation.Target})
+}
+
+// publishEvent publishes event for the connection of the loop
+func (sl *serverLoop) publishEvent(event ServerEvent) {
+	if !sl.server.events.active() {
+		return
+	}
+	event.ConnectionID = sl.hubConn.GetConnectionID()
+	event.UserID = UserIDFromContext(sl.ctx)
+	sl.server.events.publish(event)
+}
+
+// callHubMethod calls the hub method through the HubFilters of the server.
+// If the method panics, the panic is recovered and ok is false. If a filter returns an error, it is sent as completion
+// and ok is false
+func (sl *serverLoop) callHubMethod(ctx context.Context, conn hubConnection, hub HubInterface, invocation invocationMessage,
+	method reflect.Value, in []reflect.Value) (result []reflect.Value, ok bool) {
+	defer recoverInvocationPanic(sl.info, invocation, conn)
+	start := time.Now()
+	sl.publishEvent(ServerEvent{Type: InvocationStarted, Method: invocation.Target, InvocationID: invocation.InvocationID})
+	var err error
+	defer func() {
+		duration := time.Since(start)
+		sl.server.metrics.InvocationCompleted(invocation.Target, duration)
+		sl.server.stats.invocationCompleted(invocation.Target)
+		switch {
+		case err != nil:
+		case ok:
+			_, err = splitResultError(result)
+		default:
+			// The hub method panicked
+			err = fmt.Errorf("invocation of %s failed", invocation.Target)
+		}
+		sl.publishEvent(ServerEvent{Type: InvocationCompleted, Method: invocation.Target,
+			InvocationID: invocation.InvocationID, Duration: duration, Err: err})
+	}()
+	if len(sl.server.hubFilters) == 0 {
+		return method.Call(in), true
+	}
+	arguments := make([]interface{}, len(in))
+	for i, arg := range in {
+		arguments[i] = arg.Interface()
 	}
+	result, err = invokeFiltered(sl.server.hubFilters, &HubInvocationContext{
+		Context:      ctx,
+		Hub:          hub,
+		ConnectionID: sl.hubConn.GetConnectionID(),
+		MethodName:   invocation.Target,
+		Arguments:    arguments,
+		Headers:      invocation.Headers,
+	}, method, in)
+	if err != nil {
+		if invocation.InvocationID != "" {
+			conn.CompletionError(invocation.InvocationID, err)
+		}
+		return nil, false
+	}
+	return result, true
 }
 
-func returnInvocationResult(conn hubConnection, invocation invocationMessage, streamer *streamer, result []reflect.Value) {
+// returnInvocationResult sends the result of the hub method to the client.
+// If it started a stream, it returns true. The stream releases ctx when it ends.
+func returnInvocationResult(ctx context.Context, conn hubConnection, invocation invocationMessage,
+	streamer *streamer, result []reflect.Value) (streaming bool) {
 	// No invocation id, no completion
 	if invocation.InvocationID != "" {
+		// if the hub method returns an error as last value, it is sent as error of the completion
+		result, err := splitResultError(result)
+		if err != nil {
+			conn.CompletionError(invocation.InvocationID, err)
+			return false
+		}
 		// if the hub method returns a chan, it should be considered asynchronous or source for a stream
 		if len(result) == 1 && result[0].Kind() == reflect.Chan {
+			if result[0].Type().ChanDir()&reflect.RecvDir == 0 {
+				conn.Completion(invocation.InvocationID, nil, "hub func returned send-only chan")
+				return false
+			}
 			switch invocation.Type {
 			// Simple invocation
 			case 1:
@@ -126,7 +446,8 @@ func returnInvocationResult(conn hubConnection, invocation invocationMessage, st
 				}()
 			// StreamInvocation
 			case 4:
-				streamer.Start(invocation.InvocationID, result[0])
+				streamer.Start(ctx, invocation.InvocationID, result[0])
+				return true
 			}
 		} else {
 			switch invocation.Type {
@@ -136,13 +457,35 @@ func returnInvocationResult(conn hubConnection, invocation invocationMessage, st
 			case 4:
 				// Stream invocation of method with no stream result.
 				// Return a single StreamItem and an empty Completion
-				invokeConnection(conn, invocation, streamItem, result)
+				if len(result) > 0 {
+					invokeConnection(conn, invocation, streamItem, result)
+				}
 				conn.Completion(invocation.InvocationID, nil, "")
 			}
 		}
 	}
+	return false
 }
 
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// splitResultError separates an error returned as last value by a hub method from the other return values
+func splitResultError(result []reflect.Value) ([]reflect.Value, error) {
+	if len(result) == 0 {
+		return result, nil
+	}
+	last := result[len(result)-1]
+	if !last.Type().Implements(errorType) {
+		return result, nil
+	}
+	switch last.Kind() {
+	case reflect.Interface, reflect.Ptr, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		if last.IsNil() {
+			return result[:len(result)-1], nil
+		}
+	}
+	return result[:len(result)-1], last.Interface().(error)
+}
 
 func (sl *serverLoop) handleStreamItemMessage(message interface{}) error {
 	streamItemMessage := message.(streamItemMessage)
@@ -161,6 +504,10 @@ func (sl *serverLoop) handleStreamItemMessage(message interface{}) error {
 
 func (sl *serverLoop) handleCompletionMessage(message interface{}) error {
 	_ = sl.dbg.Log(evt, msgRecv, msg, message.(completionMessage))
+	if completion := message.(completionMessage); completion.Error != "" {
+		// The hub method can not receive the error over its channel, so the client error ends the stream
+		_ = sl.info.Log(evt, msgRecv, "error", completion.Error, "streamid", completion.InvocationID, react, "close upstream channel")
+	}
 	var err error
 	if err = sl.streamClient.receiveCompletionItem(message.(completionMessage)); err != nil {
 		_ = sl.info.Log(evt, msgRecv, "error", err, msg, message, react, "disconnect")
@@ -180,13 +527,24 @@ func (sl *serverLoop) handleOtherMessage(message interface{}) error {
 	return nil
 }
 
-func recoverInvocationPanic(info log.Logger, invocation invocationMessage, hubConn hubConnection) {
+// recoverInvocationPanic recovers a panic while dispatching the invocation, logs the stack
+// and sends a completion with the error to the caller. The stack is not sent to the client
+func recoverInvocationPanic(info StructuredLogger, invocation invocationMessage, hubConn hubConnection) {
 	if err := recover(); err != nil {
-		_ = info.Log(evt, "recover", "error", err, "name", invocation.Target, react, "send completion with error")
+		_ = info.Log(evt, "recover", "error", err, "name", invocation.Target, "stack", string(debug.Stack()),
+			react, "send completion with error")
 		if invocation.InvocationID != "" {
-			hubConn.Completion(invocation.InvocationID, nil, fmt.Sprintf("%v\n%v", err, string(debug.Stack())))
+			hubConn.Completion(invocation.InvocationID, nil, fmt.Sprintf("panic in hub method %v: %v", invocation.Target, err))
 		}
 	}
 }
 
-
+// recoverHubPanic calls the hub func and recovers and logs a panic in it, so it does not end the connection
+func (sl *serverLoop) recoverHubPanic(name string, hubFunc func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			_ = sl.info.Log(evt, "recover", "error", err, "name", name, "stack", string(debug.Stack()), react, "ignore")
+		}
+	}()
+	hubFunc()
+}
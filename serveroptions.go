@@ -1,14 +1,24 @@
 package signalr
 
 import (
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
 	"reflect"
+	"strings"
 	"time"
 )
 
+// Option configures a Server, see NewServer
+type Option func(*Server) error
+
 // UseHub sets the hub instance used by the server
-func UseHub(hub HubInterface) func(*Server) error {
+func UseHub(hub HubInterface) Option {
 	return func(s *Server) error {
-		s.newHub = func() HubInterface { return hub }
+		s.newHub = func(ConnectionContext) HubInterface { return hub }
 		return nil
 	}
 }
@@ -17,8 +27,22 @@ func UseHub(hub HubInterface) func(*Server) error {
 // The function might create a new hub instance on every invocation.
 // If hub instances should be created and initialized by a DI framework,
 // the frameworks factory method can be called here.
-func HubFactory(factoryFunc func() HubInterface) func(*Server) error {
+func HubFactory(factoryFunc func() HubInterface) Option {
+	return func(s *Server) error {
+		s.newHub = func(ConnectionContext) HubInterface { return factoryFunc() }
+		return nil
+	}
+}
+
+// ConnectionHubFactory sets the function which returns the hub instance for every hub method invocation
+// of a connection, including OnConnected and OnDisconnected. It can inject dependencies depending on the connection,
+// e.g. services for the user of the connection.
+// The server also calls it with a ConnectionContext without ConnectionID to determine the type of the hub
+func ConnectionHubFactory(factoryFunc func(connection ConnectionContext) HubInterface) Option {
 	return func(s *Server) error {
+		if factoryFunc == nil {
+			return errors.New("hub factory is nil")
+		}
 		s.newHub = factoryFunc
 		return nil
 	}
@@ -26,36 +50,684 @@ func HubFactory(factoryFunc func() HubInterface) func(*Server) error {
 
 // SimpleHubFactory sets a HubFactory which creates a new hub with the underlying type
 // of hubProto on each hub method invocation.
-func SimpleHubFactory(hubProto HubInterface) func(*Server) error {
+func SimpleHubFactory(hubProto HubInterface) Option {
 	return HubFactory(
 		func() HubInterface {
 			return reflect.New(reflect.ValueOf(hubProto).Elem().Type()).Interface().(HubInterface)
 		})
 }
 
+// UseBackplane sets the Backplane which connects the server with other servers running the same hub.
+// Invocations of all clients, groups or clients connected to other servers are sent over the backplane
+func UseBackplane(backplane Backplane) Option {
+	return func(s *Server) error {
+		if backplane == nil {
+			return errors.New("backplane is nil")
+		}
+		s.backplane = backplane
+		return nil
+	}
+}
+
+// UseGroupStore sets the GroupStore which stores the group memberships of the connections, e.g. a RedisGroupStore
+// shared by all servers connected by the backplane. Servers for different hubs, also the servers of AddHub,
+// need stores of their own, e.g. RedisGroupStores with different prefixes. Default is an in-memory store
+func UseGroupStore(store GroupStore) Option {
+	return func(s *Server) error {
+		if store == nil {
+			return errors.New("group store is nil")
+		}
+		s.groupStore = store
+		return nil
+	}
+}
+
 // HubChanReceiveTimeout is the timeout for receiving stream items from the client.
 // If the hub method is not able to receive a stream item during the timeout duration,
 // the server will send a completion with error
-func HubChanReceiveTimeout(duration time.Duration) func(*Server) error {
+func HubChanReceiveTimeout(duration time.Duration) Option {
 	return func(s *Server) error {
 		s.hubChanReceiveTimeout = duration
 		return nil
 	}
 }
 
+// KeepAliveInterval sets the interval after which the server sends a ping message to keep the connection alive,
+// if it has not sent any other message in the meantime. Default is 15 seconds
+func KeepAliveInterval(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("KeepAliveInterval must be positive")
+		}
+		s.keepAliveInterval = interval
+		return nil
+	}
+}
+
+// OnHeartbeat sets a handler which is called for each connection every KeepAliveInterval while it is connected,
+// whether a ping was sent or not, e.g. to refresh the ttl of the presence of the connection in a shared store.
+// The calls for one connection are made one after another, the last one ends before OnDisconnected
+func OnHeartbeat(handler func(connection ConnectionContext)) Option {
+	return func(s *Server) error {
+		if handler == nil {
+			return errors.New("OnHeartbeat needs a handler")
+		}
+		s.heartbeat = handler
+		return nil
+	}
+}
+
+// ClientTimeoutInterval sets the interval the server waits for a message from the client.
+// If the client sends no message, not even a ping, during the interval, the server closes the connection.
+// The interval should be at least double the KeepAliveInterval of the client. Default is 30 seconds
+func ClientTimeoutInterval(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("ClientTimeoutInterval must be positive")
+		}
+		s.clientTimeoutInterval = interval
+		return nil
+	}
+}
+
+// HandshakeTimeout sets the time the server waits for the handshake of a new connection.
+// If the client has not sent the handshake during the timeout, the connection is not established.
+// Default is 15 seconds
+func HandshakeTimeout(timeout time.Duration) Option {
+	return func(s *Server) error {
+		if timeout <= 0 {
+			return errors.New("HandshakeTimeout must be positive")
+		}
+		s.handshakeTimeout = timeout
+		return nil
+	}
+}
+
+// MaximumReceiveMessageSize sets the maximum size in bytes of a message received from the client.
+// If the client sends a larger message, the server closes the connection with an error. Default is 32KB
+func MaximumReceiveMessageSize(size uint) Option {
+	return func(s *Server) error {
+		if size == 0 {
+			return errors.New("MaximumReceiveMessageSize must be positive")
+		}
+		s.maximumReceiveMessageSize = size
+		return nil
+	}
+}
+
+// MaximumParallelInvocationsPerClient sets how many hub method invocations of one client may run at once.
+// Further invocations are queued and run in the order they were received. 0 means no limit.
+// Default is 1, so the hub methods of a client run one after another
+func MaximumParallelInvocationsPerClient(count uint) Option {
+	return func(s *Server) error {
+		s.maximumParallelInvocations = count
+		return nil
+	}
+}
+
+// UseArgumentConverter sets the ArgumentConverter for all hub method parameters with the type of typeProto,
+// e.g. uuid.UUID{} or (*Order)(nil)
+func UseArgumentConverter(typeProto interface{}, converter ArgumentConverter) Option {
+	return func(s *Server) error {
+		if typeProto == nil || converter == nil {
+			return errors.New("UseArgumentConverter needs a typeProto and a converter")
+		}
+		s.argumentConverters[reflect.TypeOf(typeProto)] = converter
+		return nil
+	}
+}
+
+// RecordFrames records the raw data of all connections, including the handshake, to writer.
+// Each read and write is written as one line with the JSON encoded RecordedFrame.
+// The recording can be parsed with ReplayRecording, e.g. to debug interoperability issues
+func RecordFrames(writer io.Writer) Option {
+	return func(s *Server) error {
+		if writer == nil {
+			return errors.New("RecordFrames needs a writer")
+		}
+		s.recorder = &frameRecorder{w: writer}
+		return nil
+	}
+}
+
+// MapMethod makes method callable by clients with name. The name is case-insensitive.
+// method is a method expression of the hub type, e.g. (*ChatHub).SendMessage, or a func with the hub as first parameter.
+// Mapped methods take precedence over the methods of the hub with the same name.
+// To make a method only callable by its alias, exclude its own name with ExcludeMethods
+func MapMethod(name string, method interface{}) Option {
+	return func(s *Server) error {
+		mapped, err := newMappedMethod(method)
+		if err != nil {
+			return err
+		}
+		if s.mappedMethods == nil {
+			s.mappedMethods = make(map[string]*mappedMethod)
+		}
+		s.mappedMethods[strings.ToLower(name)] = mapped
+		return nil
+	}
+}
+
+// ExcludeMethods makes the exported methods of the hub with the names not callable by clients,
+// e.g. helpers which are exported for other packages. The names are case-insensitive
+func ExcludeMethods(names ...string) Option {
+	return func(s *Server) error {
+		if s.excludedMethods == nil {
+			s.excludedMethods = make(map[string]bool)
+		}
+		for _, name := range names {
+			s.excludedMethods[strings.ToLower(name)] = true
+		}
+		return nil
+	}
+}
+
+// OutboundQueue limits the number of messages queued for sending to each connection to size.
+// When the queue of a connection is full, because the client receives slower than the server sends, policy decides
+// what happens to further messages. Dropped messages are reported by the error channel returned when sending them,
+// e.g. by Clients().Caller().Send(). Size 0 means no limit. Default is a size of 64 with SlowClientBlock
+func OutboundQueue(size uint, policy SlowClientPolicy) Option {
+	return func(s *Server) error {
+		if policy < SlowClientBlock || policy > SlowClientDisconnect {
+			return fmt.Errorf("unknown slow client policy %v", policy)
+		}
+		s.outboundQueueSize, s.slowClientPolicy = size, policy
+		return nil
+	}
+}
+
+// RateLimit limits the invocations of each connection to invocationsPerSecond on average, with bursts of
+// up to burst invocations. Invocations exceeding the limit are handled by action
+func RateLimit(invocationsPerSecond float64, burst uint, action RateLimitAction) Option {
+	return func(s *Server) error {
+		if invocationsPerSecond <= 0 || burst == 0 {
+			return errors.New("RateLimit needs a positive rate and burst")
+		}
+		s.rateLimit = &rateLimit{rate: invocationsPerSecond, burst: float64(burst), action: action}
+		return nil
+	}
+}
+
+// MethodRateLimit limits the invocations of the hub method by each connection to invocationsPerSecond on average,
+// with bursts of up to burst invocations. Invocations exceeding the limit are handled by action.
+// Invocations of the method count for the RateLimit of the connection, too
+func MethodRateLimit(method string, invocationsPerSecond float64, burst uint, action RateLimitAction) Option {
+	return func(s *Server) error {
+		if invocationsPerSecond <= 0 || burst == 0 {
+			return errors.New("MethodRateLimit needs a positive rate and burst")
+		}
+		if s.methodRateLimits == nil {
+			s.methodRateLimits = make(map[string]rateLimit)
+		}
+		s.methodRateLimits[strings.ToLower(method)] = rateLimit{rate: invocationsPerSecond, burst: float64(burst), action: action}
+		return nil
+	}
+}
+
+// InvocationTimeout sets the time a hub method invocation may run until it completes. When the time is over,
+// the context of the invocation is canceled and the client receives a completion with a HubError with the
+// Code InvocationTimeoutErrorCode. The invocation does not block other invocations of the client after the timeout,
+// even if the hub method still runs. Its results are dropped. Streams must be started during the timeout, and then
+// run until they end. Default is 0, which means no timeout
+func InvocationTimeout(timeout time.Duration) Option {
+	return func(s *Server) error {
+		if timeout < 0 {
+			return errors.New("InvocationTimeout must not be negative")
+		}
+		s.invocationTimeout = timeout
+		return nil
+	}
+}
+
+// MethodInvocationTimeout sets the InvocationTimeout of the hub method. 0 means the method has no timeout
+func MethodInvocationTimeout(method string, timeout time.Duration) Option {
+	return func(s *Server) error {
+		if timeout < 0 {
+			return errors.New("MethodInvocationTimeout must not be negative")
+		}
+		if s.methodInvocationTimeouts == nil {
+			s.methodInvocationTimeouts = make(map[string]time.Duration)
+		}
+		s.methodInvocationTimeouts[strings.ToLower(method)] = timeout
+		return nil
+	}
+}
+
+// PersistItems lets the server send the Items of each connection, encrypted and signed with AES-GCM, as token to
+// the client whenever they changed. A Client which connects again, e.g. behind a load balancer without sticky sessions,
+// sends the token with the handshake and the Items are restored in the new connection before OnConnected.
+// A token is bound to the user id of the connection and only restores the Items for connections of the same user.
+// Only clients which announce in the handshake that they handle ItemsTokenTarget, like Client, get tokens.
+// key must have 16, 24 or 32 bytes and be the same on all server instances. Tokens older than maxAge are ignored,
+// 0 means they do not expire. The items must be JSON serializable, restored items have the types JSON
+// unmarshals into interface{}, e.g. float64 for numbers
+func PersistItems(key []byte, maxAge time.Duration) Option {
+	return func(s *Server) error {
+		if maxAge < 0 {
+			return errors.New("PersistItems maxAge must not be negative")
+		}
+		tokenizer, err := newItemsTokenizer(key, maxAge)
+		if err != nil {
+			return fmt.Errorf("PersistItems: %w", err)
+		}
+		s.itemsTokenizer = tokenizer
+		return nil
+	}
+}
+
+// JSONEncoding sets the JSONEncoder used by the "json" hub protocol, e.g. jsoniter or sonic for
+// higher throughput. Default is encoding/json. The handshake is always parsed with encoding/json
+func JSONEncoding(encoder JSONEncoder) Option {
+	return func(s *Server) error {
+		if encoder == nil {
+			return errors.New("JSONEncoding needs an encoder")
+		}
+		s.jsonEncoder = encoder
+		return nil
+	}
+}
+
+// UseJSONNumber lets the "json" hub protocol unmarshal numbers in interface{} values, e.g. parameters of type
+// interface{} or map[string]interface{}, as json.Number instead of float64, so large integers keep their precision.
+// A JSONEncoder set by JSONEncoding has to be configured to use numbers itself
+func UseJSONNumber() Option {
+	return func(s *Server) error {
+		s.jsonNumbers = true
+		return nil
+	}
+}
+
+// StrictProtocol lets the server reject messages which are well-formed, but violate the SignalR protocol:
+// unknown message types, invocations without target, missing invocation ids, ids longer than 128 characters or with
+// other than printable ASCII characters, duplicate stream ids, completions with result and error and MessagePack frames
+// with data after the message. The connection is closed with a protocol error
+func StrictProtocol() Option {
+	return func(s *Server) error {
+		s.strictProtocol = true
+		return nil
+	}
+}
+
+// UseCORS allows browser clients of other origins to negotiate and connect.
+// It handles the preflight requests and rejects WebSocket connections from origins which are not allowed
+func UseCORS(options CORSOptions) Option {
+	return func(s *Server) error {
+		if len(options.AllowedOrigins) == 0 {
+			return errors.New("UseCORS needs at least one allowed origin")
+		}
+		if options.AllowCredentials && options.allowsAll() {
+			return errors.New("UseCORS can not allow credentials for all origins")
+		}
+		s.corsOptions = &options
+		return nil
+	}
+}
+
+// Authenticate sets the function which authenticates the http requests of negotiate and the transports.
+// The function can use BearerToken to get the access token of the request.
+// If it returns an error, the request is rejected with 401 Unauthorized.
+// Otherwise the returned claims are attached to the context of the connection, see ClaimsFromContext
+func Authenticate(authenticate func(req *http.Request) (Claims, error)) Option {
+	return func(s *Server) error {
+		if authenticate == nil {
+			return errors.New("authenticate func is nil")
+		}
+		s.authenticate = authenticate
+		return nil
+	}
+}
+
+// TrustForwardedHeaders lets the server trust the X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto headers
+// of a reverse proxy. The http request of a connection, see RequestFromContext, has the RemoteAddr, Host and scheme
+// the client used to connect to the proxy. Only use it if all requests pass a proxy which sets these headers
+func TrustForwardedHeaders() Option {
+	return func(s *Server) error {
+		s.trustForwardedHeaders = true
+		return nil
+	}
+}
+
+// PublicURL sets the url clients use to connect to the hub, e.g. https://example.com/api/chat behind a reverse proxy.
+// Negotiate requests which were sent to another host are redirected to it. With TrustForwardedHeaders,
+// the host is taken from the X-Forwarded-Host header
+func PublicURL(publicURL string) Option {
+	return func(s *Server) error {
+		u, err := url.Parse(publicURL)
+		if err != nil {
+			return err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("PublicURL %q is not an absolute http(s) url", publicURL)
+		}
+		s.publicURL = u
+		return nil
+	}
+}
+
+// DrainRedirectURL sets the url negotiate requests are redirected to while the server is draining,
+// e.g. the url of the load balancer in front of the other instances. See Server.Drain
+func DrainRedirectURL(redirectURL string) Option {
+	return func(s *Server) error {
+		u, err := url.Parse(redirectURL)
+		if err != nil {
+			return err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("DrainRedirectURL %q is not an absolute http(s) url", redirectURL)
+		}
+		s.drainRedirectURL = redirectURL
+		return nil
+	}
+}
+
+// RequireClientCertificate rejects http requests without a verified client certificate with 401 Unauthorized.
+// The TLS config of the http.Server has to verify client certificates, e.g. with ClientAuth tls.VerifyClientCertIfGiven
+// and the ClientCAs. Without Authenticate function, the "sub" claim of the connection is the common name of the
+// certificate. See PeerCertificate and PeerCertificateFromContext
+func RequireClientCertificate() Option {
+	return func(s *Server) error {
+		s.requireClientCertificate = true
+		return nil
+	}
+}
+
+// UserIDProvider sets the function which derives the user id of a connection from the claims
+// returned by the Authenticate function. Connections with the same user id can be invoked by Clients().User().
+// Default is the "sub" claim
+func UserIDProvider(provider func(claims Claims) string) Option {
+	return func(s *Server) error {
+		if provider == nil {
+			return errors.New("UserIDProvider func is nil")
+		}
+		s.userIDProvider = provider
+		return nil
+	}
+}
+
+// AuthorizeHub sets the requirement for invoking any method of the hub.
+// The claims of the connection are returned by the Authenticate function
+func AuthorizeHub(requirement AuthorizationRequirement) Option {
+	return func(s *Server) error {
+		s.hubRequirement = &requirement
+		return nil
+	}
+}
+
+// AuthorizeMethod sets the requirement for invoking the hub method with name, in addition to the requirement of the hub.
+// The name is case-insensitive
+func AuthorizeMethod(name string, requirement AuthorizationRequirement) Option {
+	return func(s *Server) error {
+		if s.methodRequirements == nil {
+			s.methodRequirements = make(map[string]*AuthorizationRequirement)
+		}
+		s.methodRequirements[strings.ToLower(name)] = &requirement
+		return nil
+	}
+}
+
+// AuthorizationPolicies sets the PolicyEvaluator which evaluates the Policies of the AuthorizationRequirements
+func AuthorizationPolicies(evaluator PolicyEvaluator) Option {
+	return func(s *Server) error {
+		if evaluator == nil {
+			return errors.New("PolicyEvaluator is nil")
+		}
+		s.policyEvaluator = evaluator
+		return nil
+	}
+}
+
+// UseHubFilter adds a HubFilter which wraps all hub method invocations.
+// Filters are called in the order they were added, the first filter is the outermost
+func UseHubFilter(filter HubFilter) Option {
+	return func(s *Server) error {
+		if filter == nil {
+			return errors.New("HubFilter is nil")
+		}
+		s.hubFilters = append(s.hubFilters, filter)
+		return nil
+	}
+}
+
+// ConnectionIDGenerator sets the function which generates the ids of connections created by the http transports,
+// e.g. to embed a node prefix for backplane routing. The ids must be unique.
+// Default are base64 encoded 128 bit crypto random ids
+func ConnectionIDGenerator(generator func() string) Option {
+	return func(s *Server) error {
+		if generator == nil {
+			return errors.New("ConnectionIDGenerator func is nil")
+		}
+		s.connectionIDGenerator = generator
+		return nil
+	}
+}
+
+// HubProtocols limits the protocols a client can request during the handshake to the protocols with the given names.
+// Supported are "json" and "messagepack". Default are all supported protocols
+func HubProtocols(names ...string) Option {
+	return func(s *Server) error {
+		if len(names) == 0 {
+			return errors.New("no hub protocol given")
+		}
+		protocols := make(map[string]protocolRegistration)
+		for _, name := range names {
+			registration, ok := protocolMap[name]
+			if !ok {
+				return fmt.Errorf("hub protocol %v not supported", name)
+			}
+			protocols[name] = registration
+		}
+		s.protocols = protocols
+		return nil
+	}
+}
+
+// WebSocketFrames forces the type of the WebSocket frames the server sends to clients using the protocol.
+// By default, text protocols are sent in text frames and binary protocols in binary frames
+func WebSocketFrames(protocol string, frameType FrameType) Option {
+	return func(s *Server) error {
+		if _, ok := protocolMap[protocol]; !ok {
+			return fmt.Errorf("hub protocol %v not supported", protocol)
+		}
+		if frameType != TextFrame && frameType != BinaryFrame {
+			return fmt.Errorf("unknown frame type %v", frameType)
+		}
+		s.frameTypes[protocol] = frameType
+		return nil
+	}
+}
+
+// WebSocketReadTimeout closes WebSocket connections when nothing, not even a pong, is received during timeout.
+// Connections with permessage-deflate compression are not affected
+func WebSocketReadTimeout(timeout time.Duration) Option {
+	return func(s *Server) error {
+		if timeout <= 0 {
+			return errors.New("WebSocketReadTimeout must be positive")
+		}
+		s.webSocket.readTimeout = timeout
+		return nil
+	}
+}
+
+// WebSocketWriteTimeout fails writes to WebSocket connections which do not finish during timeout.
+// Connections with permessage-deflate compression are not affected
+func WebSocketWriteTimeout(timeout time.Duration) Option {
+	return func(s *Server) error {
+		if timeout <= 0 {
+			return errors.New("WebSocketWriteTimeout must be positive")
+		}
+		s.webSocket.writeTimeout = timeout
+		return nil
+	}
+}
+
+// WebSocketPingInterval sends WebSocket pings in interval. Each pong extends the read deadline, so connections
+// which do not answer are closed after the WebSocketReadTimeout or, if it is not set, the ClientTimeoutInterval.
+// Connections with permessage-deflate compression are not affected
+func WebSocketPingInterval(interval time.Duration) Option {
+	return func(s *Server) error {
+		if interval <= 0 {
+			return errors.New("WebSocketPingInterval must be positive")
+		}
+		s.webSocket.pingInterval = interval
+		return nil
+	}
+}
+
+// WebSocketCompression compresses the messages of WebSocket connections with permessage-deflate,
+// if the client supports it. level is a compress/flate level. Messages smaller than threshold bytes are sent uncompressed
+func WebSocketCompression(level int, threshold int) Option {
+	return func(s *Server) error {
+		if level < flate.HuffmanOnly || level > flate.BestCompression {
+			return fmt.Errorf("invalid WebSocketCompression level %v", level)
+		}
+		if threshold < 0 {
+			return errors.New("WebSocketCompression threshold must not be negative")
+		}
+		s.webSocketCompression = &webSocketCompression{level: level, threshold: threshold}
+		return nil
+	}
+}
+
+// LongPollingMaxResponseSize limits the size of the responses of the LongPolling transport to size bytes.
+// Responses end at message boundaries, so buffered messages which do not fit are sent with the next polls.
+// policy decides what happens to messages which are larger than size. Default is no limit
+func LongPollingMaxResponseSize(size uint, policy LongPollingOversizePolicy) Option {
+	return func(s *Server) error {
+		if size == 0 {
+			return errors.New("LongPollingMaxResponseSize must be positive")
+		}
+		if policy < LongPollingSplitMessages || policy > LongPollingRejectMessages {
+			return fmt.Errorf("unknown LongPolling oversize policy %v", policy)
+		}
+		s.longPolling = longPollingOptions{maxResponseSize: size, oversizePolicy: policy}
+		return nil
+	}
+}
+
+// StreamBuffer sets the number of items of each stream returned by a hub method which are buffered
+// while the items can not be sent as fast as the hub method produces them, and the policy when the buffer is full.
+// The drop policies need a capacity greater than 0. Default is a capacity of 10 and StreamBufferBlock
+func StreamBuffer(capacity uint, policy StreamBufferPolicy) Option {
+	return func(s *Server) error {
+		switch policy {
+		case StreamBufferBlock:
+		case StreamBufferDropNewest, StreamBufferDropOldest:
+			if capacity == 0 {
+				return errors.New("stream buffer capacity must be greater than 0 for drop policies")
+			}
+		default:
+			return fmt.Errorf("unknown stream buffer policy %v", policy)
+		}
+		s.streamBufferCapacity = capacity
+		s.streamBufferPolicy = policy
+		return nil
+	}
+}
+
+// StreamItemBatching sends up to maxItems items of each stream returned by a hub method in one write,
+// e.g. one WebSocket frame. A batch is sent when it is full, when its first item waited for flushInterval,
+// when the hub method calls FlushStream and when the stream ends. A flushInterval of 0 lets the items wait
+// until one of the other cases. Default is no batching
+func StreamItemBatching(maxItems int, flushInterval time.Duration) Option {
+	return func(s *Server) error {
+		if maxItems < 1 {
+			return errors.New("StreamItemBatching maxItems must be at least 1")
+		}
+		if flushInterval < 0 {
+			return errors.New("StreamItemBatching flushInterval must not be negative")
+		}
+		s.streamBatching = streamBatching{maxItems: maxItems, flushInterval: flushInterval}
+		return nil
+	}
+}
+
+// StatefulReconnect allows clients to negotiate stateful reconnect. If the WebSockets transport of such client fails,
+// the connection is kept for reconnectTimeout. If the client reconnects in the meantime, it resumes the connection
+// and all messages which were not acknowledged by the other side are sent again
+func StatefulReconnect(reconnectTimeout time.Duration) Option {
+	return func(s *Server) error {
+		if reconnectTimeout <= 0 {
+			return errors.New("reconnectTimeout must be positive")
+		}
+		s.statefulReconnectTimeout = reconnectTimeout
+		return nil
+	}
+}
+
+// StatefulReconnectBuffer sets the maximum size in bytes of the messages to a stateful reconnect client which are
+// buffered until the client acknowledges them. When a message would exceed it, the connection is closed.
+// Default is 100000 bytes
+func StatefulReconnectBuffer(size uint) Option {
+	return func(s *Server) error {
+		if size == 0 {
+			return errors.New("StatefulReconnectBuffer must be positive")
+		}
+		s.statefulReconnectBufferSize = int(size)
+		return nil
+	}
+}
+
+// UseMetrics sets the Metrics which receive the connection, message and invocation events of the server,
+// e.g. a PrometheusMetrics
+func UseMetrics(metrics Metrics) Option {
+	return func(s *Server) error {
+		if metrics == nil {
+			return errors.New("metrics is nil")
+		}
+		s.metrics = metrics
+		return nil
+	}
+}
+
+// UseTracer sets the Tracer which creates spans for hub method invocations and invocations sent to clients.
+// The parent of the spans is the trace context of the http request which started the connection
+func UseTracer(tracer Tracer) Option {
+	return func(s *Server) error {
+		if tracer == nil {
+			return errors.New("tracer is nil")
+		}
+		s.tracer = tracer
+		return nil
+	}
+}
+
 // StructuredLogger is the simplest logging interface for structured logging.
-// See github.com/go-kit/kit/log
+// Loggers of github.com/go-kit/kit/log implement it, for other loggers see NewLeveledLoggerAdapter, NewZerologAdapter
+// and NewSlogAdapter.
+// The values of the "level" key are LogLevels. Filters of other logging packages which look for their own level values,
+// e.g. level.NewFilter of github.com/go-kit/kit/log/level, need the LogLevelValues option
 type StructuredLogger interface {
 	Log(keyvals ...interface{}) error
 }
 
 // Logger stets the logger used by the server to log info events.
 // If debug is true, debug log event are generated, too
-func Logger(logger StructuredLogger, debug bool) func(*Server) error {
+func Logger(logger StructuredLogger, debug bool) Option {
+	return func(s *Server) error {
+		s.logger = logger
+		s.debug = debug
+		return nil
+	}
+}
+
+// LogLevelValues sets the values of the "level" key which the server passes to its logger instead of the LogLevels.
+// Use it with the values of the logging package, e.g. LogLevelValues(level.DebugValue(), level.InfoValue()) to
+// filter the events of the server by level.NewFilter of github.com/go-kit/kit/log/level
+func LogLevelValues(debug, info interface{}) Option {
+	return func(s *Server) error {
+		if debug == nil || info == nil {
+			return errors.New("log level values must not be nil")
+		}
+		s.logLevelValues = &logLevelValues{debug: debug, info: info}
+		return nil
+	}
+}
+
+// SubsystemLogLevel sets the level of the log events of a subsystem, e.g. LogSubsystemHubConnection.
+// With LogLevelDebug, debug log events of the subsystem are generated even if the Logger option disabled them
+func SubsystemLogLevel(subsystem string, level LogLevel) Option {
 	return func(s *Server) error {
-		i, d := buildInfoDebugLogger(logger, debug)
-		s.info = i
-		s.dbg = d
+		s.logLevels[subsystem] = level
 		return nil
 	}
 }
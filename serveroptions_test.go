@@ -1,12 +1,15 @@
 package signalr
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/go-kit/kit/log"
 	"github.com/google/uuid"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"net"
+	"strings"
 	"time"
 )
 
@@ -39,7 +42,7 @@ var _ = Describe("Server options", func() {
 	Describe("UseHub option", func() {
 		Context("When the UseHub option is used", func() {
 			It("should use the same hub instance on all invocations", func() {
-				server, err := NewServer(UseHub(&singleHub{}))
+				server, err := NewServer(context.Background(), UseHub(&singleHub{}))
 				Expect(server).NotTo(BeNil())
 				Expect(err).To(BeNil())
 				conn1 := newTestingConnection()
@@ -87,7 +90,7 @@ var _ = Describe("Server options", func() {
 	Describe("SimpleHubFactory option", func() {
 		Context("When the SimpleHubFactory option is used", func() {
 			It("should call the hubfactory on each hub method invocation", func() {
-				server, err := NewServer(SimpleHubFactory(&singleHub{}))
+				server, err := NewServer(context.Background(), SimpleHubFactory(&singleHub{}))
 				Expect(server).NotTo(BeNil())
 				Expect(err).To(BeNil())
 				conn := newTestingConnection()
@@ -126,11 +129,140 @@ var _ = Describe("Server options", func() {
 		})
 	})
 
+	Describe("KeepAliveInterval option", func() {
+		// connectPipe connects to a server with the KeepAliveInterval option and returns the client side of the connection
+		connectPipe := func(interval time.Duration) net.Conn {
+			server, err := NewServer(context.Background(), UseHub(&Hub{}), KeepAliveInterval(interval), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			cliConn, srvConn := net.Pipe()
+			go server.Run(&pipeConnection{srvConn, "keepalive"})
+			_, err = cliConn.Write(append([]byte(`{"protocol":"json","version":1}`), 30))
+			Expect(err).To(BeNil())
+			return cliConn
+		}
+		// receivePings reads from conn for duration d and counts the received pings
+		receivePings := func(conn net.Conn, d time.Duration) int {
+			_ = conn.SetReadDeadline(time.Now().Add(d))
+			var received strings.Builder
+			data := make([]byte, 1<<12)
+			for {
+				n, err := conn.Read(data)
+				received.Write(data[:n])
+				if err != nil {
+					break
+				}
+			}
+			return strings.Count(received.String(), `{"type":6}`)
+		}
+		Context("When the server sends no messages", func() {
+			It("should send pings in the interval", func() {
+				conn := connectPipe(50 * time.Millisecond)
+				defer conn.Close()
+				Expect(receivePings(conn, 325*time.Millisecond)).To(BeNumerically(">=", 4))
+			})
+		})
+		Context("When the server sends messages more often than the interval", func() {
+			It("should send no pings", func() {
+				conn := connectPipe(200 * time.Millisecond)
+				defer conn.Close()
+				done := make(chan struct{})
+				go func() {
+					for {
+						select {
+						case <-done:
+							return
+						case <-time.After(20 * time.Millisecond):
+							// The completion with error for the missing method keeps the connection busy
+							_, _ = conn.Write(append([]byte(`{"type":1,"invocationId":"1","target":"missing"}`), 30))
+						}
+					}
+				}()
+				Expect(receivePings(conn, 500*time.Millisecond)).To(Equal(0))
+				close(done)
+			})
+		})
+		Context("When the interval is not positive", func() {
+			It("should return an error", func() {
+				_, err := NewServer(context.Background(), UseHub(&Hub{}), KeepAliveInterval(0))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("ClientTimeoutInterval option", func() {
+		Context("When the client sends no messages during the interval", func() {
+			It("should close the connection with an error", func() {
+				server, err := NewServer(context.Background(), UseHub(&Hub{}), ClientTimeoutInterval(100*time.Millisecond),
+					Logger(log.NewNopLogger(), false))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(conn)
+				select {
+				case message := <-conn.received:
+					Expect(message).To(BeAssignableToTypeOf(closeMessage{}))
+					Expect(message.(closeMessage).Error).NotTo(Equal(""))
+				case <-time.After(time.Second):
+					Fail("timed out")
+				}
+			})
+		})
+		Context("When the client sends pings more often than the interval", func() {
+			It("should not close the connection", func() {
+				server, err := NewServer(context.Background(), UseHub(&Hub{}), ClientTimeoutInterval(100*time.Millisecond),
+					Logger(log.NewNopLogger(), false))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(conn)
+				for i := 0; i < 10; i++ {
+					conn.ClientSend(`{"type":6}`)
+					select {
+					case message := <-conn.received:
+						Fail(fmt.Sprintf("received %v", message))
+					case <-time.After(30 * time.Millisecond):
+					}
+				}
+			})
+		})
+		Context("When the interval is not positive", func() {
+			It("should return an error", func() {
+				_, err := NewServer(context.Background(), UseHub(&Hub{}), ClientTimeoutInterval(-time.Second))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
+	Describe("MaximumReceiveMessageSize option", func() {
+		Context("When the client sends a message larger than the maximum size", func() {
+			It("should close the connection with an error", func() {
+				server, err := NewServer(context.Background(), UseHub(&Hub{}), MaximumReceiveMessageSize(100),
+					Logger(log.NewNopLogger(), false))
+				Expect(err).To(BeNil())
+				conn := newTestingConnection()
+				go server.Run(conn)
+				conn.ClientSend(fmt.Sprintf(`{"type":1,"invocationId":"1","target":"unknown","arguments":["%v"]}`,
+					strings.Repeat("x", 200)))
+				select {
+				case message := <-conn.received:
+					Expect(message).To(BeAssignableToTypeOf(closeMessage{}))
+					Expect(message.(closeMessage).Error).To(ContainSubstring("MaximumReceiveMessageSize"))
+				case <-time.After(time.Second):
+					Fail("timed out")
+				}
+			})
+		})
+		Context("When the size is 0", func() {
+			It("should return an error", func() {
+				_, err := NewServer(context.Background(), UseHub(&Hub{}), MaximumReceiveMessageSize(0))
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+
 	Describe("Logger option", func() {
 		Context("When the Logger option with debug false is used", func() {
 			It("calling a method correctly should log no events", func() {
 				cw := newChannelWriter()
-				server, err := NewServer(UseHub(&invocationHub{}), Logger(log.NewLogfmtLogger(cw), false))
+				server, err := NewServer(context.Background(), UseHub(&invocationHub{}), Logger(log.NewLogfmtLogger(cw), false))
 				Expect(server).NotTo(BeNil())
 				Expect(err).To(BeNil())
 				conn := newTestingConnection()
@@ -149,7 +281,7 @@ var _ = Describe("Server options", func() {
 		Context("When the Logger option with debug true is used", func() {
 			It("calling a method correctly should log events", func() {
 				cw := newChannelWriter()
-				server, err := NewServer(UseHub(&invocationHub{}), Logger(log.NewLogfmtLogger(cw), true))
+				server, err := NewServer(context.Background(), UseHub(&invocationHub{}), Logger(log.NewLogfmtLogger(cw), true))
 				Expect(server).NotTo(BeNil())
 				Expect(err).To(BeNil())
 				conn := newTestingConnection()
@@ -168,7 +300,7 @@ var _ = Describe("Server options", func() {
 		Context("When the Logger option with debug false is used", func() {
 			It("calling a method incorrectly should log events", func() {
 				cw := newChannelWriter()
-				server, err := NewServer(UseHub(&invocationHub{}), Logger(log.NewLogfmtLogger(cw), false))
+				server, err := NewServer(context.Background(), UseHub(&invocationHub{}), Logger(log.NewLogfmtLogger(cw), false))
 				Expect(server).NotTo(BeNil())
 				Expect(err).To(BeNil())
 				conn := newTestingConnection()
@@ -185,13 +317,49 @@ var _ = Describe("Server options", func() {
 		})
 		Context("When no option which sets the hub type is used, NewServer", func() {
 			It("should return an error", func() {
-				_, err := NewServer()
+				_, err := NewServer(context.Background())
 				Expect(err).NotTo(BeNil())
 			})
 		})
 		Context("When an option returns an error, NewServer", func() {
 			It("should return an error", func() {
-				_, err := NewServer(func(*Server) error { return errors.New("bad option") })
+				_, err := NewServer(context.Background(), func(*Server) error { return errors.New("bad option") })
+				Expect(err).NotTo(BeNil())
+			})
+		})
+	})
+	Describe("HubProtocols option", func() {
+		Context("When only json is allowed", func() {
+			It("should reject a messagepack handshake", func() {
+				server, err := NewServer(context.Background(), SimpleHubFactory(&singleHub{}), HubProtocols("json"),
+					Logger(log.NewNopLogger(), false))
+				Expect(err).To(BeNil())
+				conn := newTestingConnectionBeforeHandshake()
+				conn.ClientSend(`{"protocol": "messagepack","version": 1}`)
+				go server.Run(conn)
+				response, err := conn.ClientReceive()
+				Expect(err).To(BeNil())
+				Expect(response).To(ContainSubstring("not supported"))
+				Expect(server.transferFormats()).To(Equal([]string{"Text"}))
+			})
+		})
+		Context("When only messagepack is allowed", func() {
+			It("should only offer binary transports", func() {
+				server, err := NewServer(context.Background(), SimpleHubFactory(&singleHub{}), HubProtocols("messagepack"),
+					Logger(log.NewNopLogger(), false))
+				Expect(err).To(BeNil())
+				transports := newHTTPMux(server).availableTransports()
+				Expect(transports).To(HaveLen(2))
+				for _, transport := range transports {
+					Expect(transport.TransferFormats).To(Equal([]string{"Binary"}))
+				}
+			})
+		})
+		Context("When an unknown protocol is given", func() {
+			It("should return an error", func() {
+				_, err := NewServer(context.Background(), SimpleHubFactory(&singleHub{}), HubProtocols("xml"))
+				Expect(err).NotTo(BeNil())
+				_, err = NewServer(context.Background(), SimpleHubFactory(&singleHub{}), HubProtocols())
 				Expect(err).NotTo(BeNil())
 			})
 		})
@@ -203,7 +371,7 @@ type channelWriter struct {
 }
 
 func (c *channelWriter) Write(p []byte) (n int, err error) {
-	c.channel <- p
+	c.channel <- append([]byte(nil), p...)
 	return len(p), nil
 }
 
@@ -214,3 +382,36 @@ func (c *channelWriter) Chan() chan []byte {
 func newChannelWriter() *channelWriter {
 	return &channelWriter{make(chan []byte, 100)}
 }
+
+type injectedHub struct {
+	Hub
+	connectionID string
+	greeting     string
+}
+
+func (i *injectedHub) Greet() string {
+	return i.greeting + " " + i.connectionID
+}
+
+var _ = Describe("ConnectionHubFactory", func() {
+
+	Context("When a hub method is invoked", func() {
+		It("should create the hub with the context of the connection", func() {
+			server, err := NewServer(context.Background(), ConnectionHubFactory(func(connection ConnectionContext) HubInterface {
+				return &injectedHub{connectionID: connection.ConnectionID, greeting: "hello"}
+			}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"greet"}`)
+			Expect(receiveCompletion(conn).Result).To(Equal("hello " + conn.ConnectionID()))
+		})
+	})
+
+	Context("When the factory is nil", func() {
+		It("should fail", func() {
+			_, err := NewServer(context.Background(), ConnectionHubFactory(nil))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
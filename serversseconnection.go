@@ -0,0 +1,97 @@
+package signalr
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// serverSSEConnection is the server side of the ServerSentEvents transport.
+// The server sends over the text/event-stream response, the client sends over http POST
+type serverSSEConnection struct {
+	connectionID string
+	writer       http.ResponseWriter
+	flusher      http.Flusher
+	mx           sync.Mutex
+	postReader   *io.PipeReader
+	postWriter   *io.PipeWriter
+	closed       bool
+}
+
+func newServerSSEConnection(writer http.ResponseWriter, connectionID string) (*serverSSEConnection, error) {
+	flusher, ok := writer.(http.Flusher)
+	if !ok {
+		return nil, errors.New("http.ResponseWriter does not support flushing, which is required for ServerSentEvents")
+	}
+	postReader, postWriter := io.Pipe()
+	return &serverSSEConnection{
+		connectionID: connectionID,
+		writer:       writer,
+		flusher:      flusher,
+		postReader:   postReader,
+		postWriter:   postWriter,
+	}, nil
+}
+
+// open sends the header of the event stream
+func (s *serverSSEConnection) open() {
+	header := s.writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	s.writer.WriteHeader(http.StatusOK)
+	s.flusher.Flush()
+}
+
+func (s *serverSSEConnection) ConnectionID() string {
+	return s.connectionID
+}
+
+func (s *serverSSEConnection) Read(p []byte) (n int, err error) {
+	return s.postReader.Read(p)
+}
+
+// setBinary fails, because server sent events transfer only text
+func (s *serverSSEConnection) setBinary() error {
+	return errors.New("transport ServerSentEvents does not support binary protocols")
+}
+
+// Write sends p as one event. Each line of p is sent in its own data field
+func (s *serverSSEConnection) Write(p []byte) (n int, err error) {
+	var event bytes.Buffer
+	for _, line := range bytes.Split(bytes.TrimSuffix(p, []byte("\n")), []byte("\n")) {
+		event.WriteString("data: ")
+		event.Write(line)
+		event.WriteByte('\n')
+	}
+	event.WriteByte('\n')
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.closed {
+		// The http handler might already be finished, so the writer must not be used anymore
+		return 0, errSSEConnectionClosed
+	}
+	if _, err = s.writer.Write(event.Bytes()); err != nil {
+		return 0, err
+	}
+	s.flusher.Flush()
+	return len(p), nil
+}
+
+func (s *serverSSEConnection) consume(body io.Reader) error {
+	_, err := io.Copy(s.postWriter, body)
+	return err
+}
+
+func (s *serverSSEConnection) close() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.closed {
+		s.closed = true
+		_ = s.postWriter.CloseWithError(errSSEConnectionClosed)
+	}
+}
+
+var errSSEConnectionClosed = errors.New("ServerSentEvents connection closed")
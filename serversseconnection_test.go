@@ -0,0 +1,106 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var _ = Describe("ServerSentEvents transport", func() {
+
+	Context("A negotiation request is sent", func() {
+		It("should advertise ServerSentEvents with text protocol", func() {
+			router := http.NewServeMux()
+			MapHub(router, "/hub", &webSocketHub{})
+			port := freePort()
+			go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			negResp := negotiateWebSocketTestServer(port)
+			Expect(negResp["availableTransports"]).To(ContainElement(map[string]interface{}{
+				"transport":       "ServerSentEvents",
+				"transferFormats": []interface{}{"Text"},
+			}))
+		})
+	})
+
+	Context("When the client connects without negotiation", func() {
+		It("should refuse the connection", func() {
+			router := http.NewServeMux()
+			MapHub(router, "/hub", &webSocketHub{})
+			port := freePort()
+			go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			waitForPort(port)
+			req, _ := http.NewRequest("GET", fmt.Sprintf("http://127.0.0.1:%v/hub?id=unknown", port), nil)
+			req.Header.Set("Accept", "text/event-stream")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			Expect(resp.StatusCode).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("When the client connects after negotiation", func() {
+		It("should receive invocations over POST and send the results as events", func() {
+			router := http.NewServeMux()
+			MapHub(router, "/hub", &webSocketHub{})
+			port := freePort()
+			go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			negResp := negotiateWebSocketTestServer(port)
+			hubURL := fmt.Sprintf("http://127.0.0.1:%v/hub?id=%v", port, url.QueryEscape(fmt.Sprint(negResp["connectionId"])))
+			req, _ := http.NewRequest("GET", hubURL, nil)
+			req.Header.Set("Accept", "text/event-stream")
+			resp, err := http.DefaultClient.Do(req)
+			Expect(err).To(BeNil())
+			defer resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			Expect(resp.Header.Get("Content-Type")).To(Equal("text/event-stream"))
+			events := make(chan string, 20)
+			go func() {
+				scanner := bufio.NewScanner(resp.Body)
+				var data []string
+				for scanner.Scan() {
+					line := scanner.Text()
+					if line == "" {
+						events <- strings.Join(data, "\n")
+						data = nil
+					} else if strings.HasPrefix(line, "data: ") {
+						data = append(data, strings.TrimPrefix(line, "data: "))
+					}
+				}
+			}()
+			post := func(message string) {
+				resp, err := http.Post(hubURL, "text/plain", bytes.NewBufferString(message+"\u001e"))
+				Expect(err).To(BeNil())
+				Expect(resp.StatusCode).To(Equal(http.StatusOK))
+			}
+			post(`{"protocol": "json","version": 1}`)
+			select {
+			case event := <-events:
+				Expect(event).To(Equal("{}\u001e"))
+			case <-time.After(time.Second):
+				Fail("timed out")
+			}
+			post(`{"type":1,"invocationId":"sse","target":"add2","arguments":[2]}`)
+			for {
+				select {
+				case event := <-events:
+					message := make(map[string]interface{})
+					Expect(json.Unmarshal([]byte(strings.TrimSuffix(event, "\u001e")), &message)).To(BeNil())
+					if message["type"] == 3.0 {
+						Expect(message["invocationId"]).To(Equal("sse"))
+						Expect(message["result"]).To(Equal(4.0))
+						return
+					}
+				case <-time.After(time.Second):
+					Fail("timed out")
+					return
+				}
+			}
+		})
+	})
+})
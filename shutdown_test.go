@@ -0,0 +1,200 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+type shutdownHub struct {
+	Hub
+}
+
+var shutdownHubStarted = make(chan struct{}, 1)
+var shutdownHubRelease = make(chan struct{}, 1)
+
+func (s *shutdownHub) Slow() int {
+	shutdownHubStarted <- struct{}{}
+	<-shutdownHubRelease
+	return 1
+}
+
+func (s *shutdownHub) Fast() int {
+	return 2
+}
+
+func receiveClose(conn *testingConnection) closeMessage {
+	for {
+		select {
+		case message := <-conn.received:
+			if closeMsg, ok := message.(closeMessage); ok {
+				return closeMsg
+			}
+		case <-time.After(time.Second):
+			Fail("timed out")
+			return closeMessage{}
+		}
+	}
+}
+
+func runShutdownServer() (*Server, *testingConnection, chan struct{}) {
+	server, err := NewServer(context.Background(), SimpleHubFactory(&shutdownHub{}), Logger(log.NewNopLogger(), false))
+	Expect(err).To(BeNil())
+	conn := newTestingConnection()
+	ended := make(chan struct{})
+	go func() {
+		server.Run(conn)
+		close(ended)
+	}()
+	return server, conn, ended
+}
+
+var _ = Describe("Server.Shutdown", func() {
+
+	Context("When an invocation is running", func() {
+		It("should reject new invocations, wait for the running one and close with allowReconnect", func() {
+			server, conn, ended := runShutdownServer()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"slow"}`)
+			<-shutdownHubStarted
+			shutdownErr := make(chan error, 1)
+			go func() {
+				shutdownErr <- server.Shutdown(context.Background())
+			}()
+			Eventually(server.isShuttingDown).Should(BeTrue())
+			// Wait for drain to reach the loop
+			time.Sleep(50 * time.Millisecond)
+			conn.ClientSend(`{"type":1,"invocationId":"2","target":"fast"}`)
+			completion := receiveCompletion(conn)
+			Expect(completion.InvocationID).To(Equal("2"))
+			Expect(completion.Error).To(Equal(errServerShutdown.Error()))
+			shutdownHubRelease <- struct{}{}
+			completion = receiveCompletion(conn)
+			Expect(completion.InvocationID).To(Equal("1"))
+			Expect(completion.Result).To(Equal(1.0))
+			Expect(receiveClose(conn).AllowReconnect).To(BeTrue())
+			Eventually(ended).Should(BeClosed())
+			Expect(<-shutdownErr).To(BeNil())
+		})
+	})
+
+	Context("When the context ends before the invocation", func() {
+		It("should close the connection anyway", func() {
+			server, conn, ended := runShutdownServer()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"slow"}`)
+			<-shutdownHubStarted
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			go func() { _ = server.Shutdown(ctx) }()
+			Expect(receiveClose(conn).AllowReconnect).To(BeTrue())
+			Eventually(ended).Should(BeClosed())
+			shutdownHubRelease <- struct{}{}
+		})
+	})
+
+	Context("When the server was shut down", func() {
+		It("should not accept new connections", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&shutdownHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			Expect(server.Shutdown(context.Background())).To(BeNil())
+			conn := newTestingConnection()
+			ended := make(chan struct{})
+			go func() {
+				server.Run(conn)
+				close(ended)
+			}()
+			Eventually(ended).Should(BeClosed())
+		})
+	})
+})
+
+var _ = Describe("Server context", func() {
+
+	Context("When the context of the server is canceled", func() {
+		It("should end all connections", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			server, err := NewServer(ctx, SimpleHubFactory(&shutdownHub{}), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			ended := make(chan struct{})
+			go func() {
+				server.Run(conn)
+				close(ended)
+			}()
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"fast"}`)
+			Expect(receiveCompletion(conn).Result).To(Equal(2.0))
+			cancel()
+			receiveClose(conn)
+			Eventually(ended).Should(BeClosed())
+		})
+	})
+})
+
+var _ = Describe("Drain", func() {
+
+	startDrainTestServer := func(options ...Option) (*Server, *httptest.Server) {
+		server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&clientTestHub{}),
+			Logger(log.NewNopLogger(), false)}, options...)...)
+		Expect(err).To(BeNil())
+		router := http.NewServeMux()
+		server.MapHTTP(router, "/hub")
+		return server, httptest.NewServer(router)
+	}
+
+	negotiate := func(testServer *httptest.Server) (int, negotiateResponse) {
+		resp, err := http.Post(testServer.URL+"/hub/negotiate?negotiateVersion=1", "text/plain;charset=UTF-8", nil)
+		Expect(err).To(BeNil())
+		defer func() { _ = resp.Body.Close() }()
+		response := negotiateResponse{}
+		Expect(json.NewDecoder(resp.Body).Decode(&response)).To(BeNil())
+		return resp.StatusCode, response
+	}
+
+	Context("When the server drains", func() {
+		It("should keep the existing connections and refuse new ones", func() {
+			server, testServer := startDrainTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			server.Drain()
+			Expect(server.Draining()).To(BeTrue())
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+			status, response := negotiate(testServer)
+			Expect(status).To(Equal(http.StatusServiceUnavailable))
+			Expect(response.Error).To(Equal("server is draining"))
+			_, err = Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false))
+			Expect(err).NotTo(BeNil())
+			_, err = Dial(testServer.URL+"/hub", WithSkipNegotiation(), WithLogger(log.NewNopLogger(), false))
+			Expect(err).NotTo(BeNil())
+		})
+		It("should accept the transports of connections negotiated before", func() {
+			server, testServer := startDrainTestServer()
+			defer testServer.Close()
+			_, response := negotiate(testServer)
+			server.Drain()
+			ws, err := websocket.Dial(strings.Replace(testServer.URL, "http", "ws", 1)+"/hub?id="+response.ConnectionToken, "", testServer.URL)
+			Expect(err).To(BeNil())
+			_ = ws.Close()
+		})
+	})
+
+	Context("When the server drains with a DrainRedirectURL", func() {
+		It("should redirect negotiate requests", func() {
+			server, testServer := startDrainTestServer(DrainRedirectURL("https://example.com/hub"))
+			defer testServer.Close()
+			server.Drain()
+			status, response := negotiate(testServer)
+			Expect(status).To(Equal(http.StatusOK))
+			Expect(response.URL).To(Equal("https://example.com/hub"))
+		})
+	})
+})
@@ -1,13 +1,13 @@
 package signalr
 
 import (
-	"os"
-	"testing"
-	"time"
-
+	"context"
 	"github.com/go-kit/kit/log"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"os"
+	"testing"
+	"time"
 )
 
 func TestSignalR(t *testing.T) {
@@ -16,7 +16,7 @@ func TestSignalR(t *testing.T) {
 }
 
 func connect(hubProto HubInterface) *testingConnection {
-	server, err := NewServer(SimpleHubFactory(hubProto),
+	server, err := NewServer(context.Background(), SimpleHubFactory(hubProto),
 		Logger(log.NewLogfmtLogger(os.Stderr), false),
 		HubChanReceiveTimeout(200*time.Millisecond))
 	if err != nil {
@@ -0,0 +1,285 @@
+package signalrtest
+
+import (
+	"context"
+	"github.com/philippseith/signalr"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SentMessage is a message sent by a MockClientProxy.
+// Receiver describes the ClientProxy it was sent to, e.g. "all", "caller", "others", "client:<connectionID>",
+// "clients:<connectionID>,<connectionID>", "group:<groupName>", "user:<userID>" or "users:<userID>,<userID>"
+type SentMessage struct {
+	Receiver  string
+	Target    string
+	Arguments []interface{}
+}
+
+// MockHubClients is a signalr.HubClients and signalr.ServerHubClients which records the messages sent to its
+// ClientProxies instead of sending them, e.g. to test code using the HubClients of a server
+type MockHubClients struct {
+	// SendErr is returned by all Sends
+	SendErr error
+	mx      sync.Mutex
+	sent    []SentMessage
+	aborted map[string]string
+}
+
+// NewMockHubClients creates a MockHubClients without sent messages
+func NewMockHubClients() *MockHubClients {
+	return &MockHubClients{aborted: make(map[string]string)}
+}
+
+// All returns the MockClientProxy for "all"
+func (m *MockHubClients) All() signalr.ClientProxy {
+	return m.proxy("all")
+}
+
+// Caller returns the MockClientProxy for "caller"
+func (m *MockHubClients) Caller() signalr.ClientProxy {
+	return m.proxy("caller")
+}
+
+// Others returns the MockClientProxy for "others"
+func (m *MockHubClients) Others() signalr.ClientProxy {
+	return m.proxy("others")
+}
+
+// Client returns the MockClientProxy for "client:<connectionID>"
+func (m *MockHubClients) Client(connectionID string) signalr.SingleClientProxy {
+	return m.proxy("client:" + connectionID)
+}
+
+// Clients returns the MockClientProxy for "clients:<connectionIDs>"
+func (m *MockHubClients) Clients(connectionIDs []string) signalr.ClientProxy {
+	return m.proxy("clients:" + strings.Join(connectionIDs, ","))
+}
+
+// Group returns the MockClientProxy for "group:<groupName>"
+func (m *MockHubClients) Group(groupName string) signalr.ClientProxy {
+	return m.proxy("group:" + groupName)
+}
+
+// User returns the MockClientProxy for "user:<userID>"
+func (m *MockHubClients) User(userID string) signalr.ClientProxy {
+	return m.proxy("user:" + userID)
+}
+
+// Users returns the MockClientProxy for "users:<userIDs>"
+func (m *MockHubClients) Users(userIDs []string) signalr.ClientProxy {
+	return m.proxy("users:" + strings.Join(userIDs, ","))
+}
+
+// Sent returns all messages sent so far, in the order they were sent
+func (m *MockHubClients) Sent() []SentMessage {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return append([]SentMessage(nil), m.sent...)
+}
+
+// SentTo returns the messages sent to the receiver
+func (m *MockHubClients) SentTo(receiver string) []SentMessage {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	var sent []SentMessage
+	for _, message := range m.sent {
+		if message.Receiver == receiver {
+			sent = append(sent, message)
+		}
+	}
+	return sent
+}
+
+// Aborted returns the reason the connection was aborted with by Client(connectionID).Abort
+func (m *MockHubClients) Aborted(connectionID string) (reason string, ok bool) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	reason, ok = m.aborted[connectionID]
+	return reason, ok
+}
+
+// Reset removes all recorded messages and aborts
+func (m *MockHubClients) Reset() {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.sent = nil
+	m.aborted = make(map[string]string)
+}
+
+func (m *MockHubClients) proxy(receiver string) *MockClientProxy {
+	return &MockClientProxy{Receiver: receiver, clients: m}
+}
+
+// MockClientProxy is the signalr.SingleClientProxy returned by MockHubClients
+type MockClientProxy struct {
+	Receiver string
+	clients  *MockHubClients
+}
+
+// Send records the message and returns the SendErr of the MockHubClients
+func (p *MockClientProxy) Send(target string, args ...interface{}) <-chan error {
+	p.clients.mx.Lock()
+	p.clients.sent = append(p.clients.sent, SentMessage{Receiver: p.Receiver, Target: target, Arguments: args})
+	err := p.clients.SendErr
+	p.clients.mx.Unlock()
+	return result(err)
+}
+
+// Abort records the reason for the connection of a "client:<connectionID>" proxy
+func (p *MockClientProxy) Abort(reason string) <-chan error {
+	p.clients.mx.Lock()
+	defer p.clients.mx.Unlock()
+	if p.clients.aborted == nil {
+		p.clients.aborted = make(map[string]string)
+	}
+	p.clients.aborted[strings.TrimPrefix(p.Receiver, "client:")] = reason
+	return result(nil)
+}
+
+// MockGroupManager is a signalr.GroupManager which only keeps the memberships. Memberships added by
+// AddToGroupFor do not expire, their ttl can be checked with TTL
+type MockGroupManager struct {
+	mx sync.Mutex
+	// groups holds the ttls of the memberships by group name and connection id. 0 means no ttl
+	groups map[string]map[string]time.Duration
+}
+
+// NewMockGroupManager creates a MockGroupManager without groups
+func NewMockGroupManager() *MockGroupManager {
+	return &MockGroupManager{groups: make(map[string]map[string]time.Duration)}
+}
+
+// AddToGroup adds the connection to the group
+func (m *MockGroupManager) AddToGroup(groupName string, connectionID string) {
+	m.add(groupName, connectionID, 0)
+}
+
+// AddToGroupFor adds the connection to the group and records the ttl
+func (m *MockGroupManager) AddToGroupFor(groupName string, connectionID string, ttl time.Duration) {
+	m.add(groupName, connectionID, ttl)
+}
+
+// RemoveFromGroup removes the connection from the group
+func (m *MockGroupManager) RemoveFromGroup(groupName string, connectionID string) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	delete(m.groups[groupName], connectionID)
+	if len(m.groups[groupName]) == 0 {
+		delete(m.groups, groupName)
+	}
+}
+
+// IsMember returns if the connection is in the group
+func (m *MockGroupManager) IsMember(groupName string, connectionID string) bool {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	_, ok := m.groups[groupName][connectionID]
+	return ok
+}
+
+// TTL returns the ttl the connection was added to the group with, or 0 if it was added by AddToGroup
+func (m *MockGroupManager) TTL(groupName string, connectionID string) time.Duration {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.groups[groupName][connectionID]
+}
+
+// Members returns the connection ids in the group
+func (m *MockGroupManager) Members(groupName string) []string {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	members := make([]string, 0, len(m.groups[groupName]))
+	for connectionID := range m.groups[groupName] {
+		members = append(members, connectionID)
+	}
+	return members
+}
+
+func (m *MockGroupManager) add(groupName string, connectionID string, ttl time.Duration) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.groups == nil {
+		m.groups = make(map[string]map[string]time.Duration)
+	}
+	members, ok := m.groups[groupName]
+	if !ok {
+		members = make(map[string]time.Duration)
+		m.groups[groupName] = members
+	}
+	members[connectionID] = ttl
+}
+
+// MockHubContext is a signalr.HubContext with MockHubClients and a MockGroupManager.
+// Hubs can be tested without server by initializing them with it, e.g.
+//
+//	ctx := signalrtest.NewMockHubContext("conn1")
+//	hub := &chat{}
+//	hub.Initialize(ctx)
+//	hub.Join("room")
+//	joined := ctx.GroupManager.IsMember("room", "conn1")
+//	sent := ctx.HubClients.SentTo("group:room")
+type MockHubContext struct {
+	HubClients   *MockHubClients
+	GroupManager *MockGroupManager
+	// Ctx is returned by Context
+	Ctx          context.Context
+	ConnectionID string
+	items        signalr.Items
+	mx           sync.Mutex
+	abortReason  string
+	aborted      bool
+}
+
+// NewMockHubContext creates a MockHubContext for the connection
+func NewMockHubContext(connectionID string) *MockHubContext {
+	return &MockHubContext{
+		HubClients:   NewMockHubClients(),
+		GroupManager: NewMockGroupManager(),
+		Ctx:          context.Background(),
+		ConnectionID: connectionID,
+	}
+}
+
+// Clients returns the MockHubClients
+func (m *MockHubContext) Clients() signalr.HubClients {
+	return m.HubClients
+}
+
+// Groups returns the MockGroupManager
+func (m *MockHubContext) Groups() signalr.GroupManager {
+	return m.GroupManager
+}
+
+// Items returns the items of the connection
+func (m *MockHubContext) Items() *signalr.Items {
+	return &m.items
+}
+
+// Context returns Ctx
+func (m *MockHubContext) Context() context.Context {
+	return m.Ctx
+}
+
+// Abort records the reason
+func (m *MockHubContext) Abort(reason string) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	m.abortReason = reason
+	m.aborted = true
+}
+
+// Aborted returns the reason the hub aborted the connection with
+func (m *MockHubContext) Aborted() (reason string, ok bool) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return m.abortReason, m.aborted
+}
+
+func result(err error) <-chan error {
+	ch := make(chan error, 1)
+	ch <- err
+	close(ch)
+	return ch
+}
@@ -0,0 +1,97 @@
+package signalrtest
+
+import (
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/philippseith/signalr"
+	"time"
+)
+
+type roomHub struct {
+	signalr.Hub
+}
+
+func (r *roomHub) Join(room string, connectionID string) {
+	r.Groups().AddToGroup(room, connectionID)
+	r.Clients().Group(room).Send("joined", connectionID)
+	r.Clients().Caller().Send("welcome", room)
+	r.Items().Set("room", room)
+}
+
+func (r *roomHub) Kick(connectionID string) {
+	r.Clients().Client(connectionID).Abort("kicked")
+	r.Abort("done")
+}
+
+var _ = Describe("Mocks", func() {
+
+	Context("When a hub is initialized with a MockHubContext", func() {
+		It("should record the messages and group memberships", func() {
+			ctx := NewMockHubContext("conn1")
+			hub := &roomHub{}
+			hub.Initialize(ctx)
+			hub.Join("room", "conn1")
+			Expect(ctx.GroupManager.IsMember("room", "conn1")).To(BeTrue())
+			Expect(ctx.GroupManager.Members("room")).To(Equal([]string{"conn1"}))
+			Expect(ctx.HubClients.Sent()).To(Equal([]SentMessage{
+				{Receiver: "group:room", Target: "joined", Arguments: []interface{}{"conn1"}},
+				{Receiver: "caller", Target: "welcome", Arguments: []interface{}{"room"}},
+			}))
+			Expect(ctx.HubClients.SentTo("caller")).To(HaveLen(1))
+			room, ok := ctx.Items().Get("room")
+			Expect(ok).To(BeTrue())
+			Expect(room).To(Equal("room"))
+			Expect(ctx.Context()).NotTo(BeNil())
+		})
+		It("should record the aborts", func() {
+			ctx := NewMockHubContext("conn1")
+			hub := &roomHub{}
+			hub.Initialize(ctx)
+			_, ok := ctx.Aborted()
+			Expect(ok).To(BeFalse())
+			hub.Kick("conn2")
+			reason, ok := ctx.HubClients.Aborted("conn2")
+			Expect(ok).To(BeTrue())
+			Expect(reason).To(Equal("kicked"))
+			reason, ok = ctx.Aborted()
+			Expect(ok).To(BeTrue())
+			Expect(reason).To(Equal("done"))
+		})
+	})
+
+	Context("When MockHubClients are used as ServerHubClients", func() {
+		It("should name the receivers and return SendErr", func() {
+			clients := NewMockHubClients()
+			var serverClients signalr.ServerHubClients = clients
+			Expect(<-serverClients.All().Send("a")).To(BeNil())
+			Expect(<-serverClients.Clients([]string{"c1", "c2"}).Send("b", 1)).To(BeNil())
+			Expect(<-serverClients.Users([]string{"u1", "u2"}).Send("c")).To(BeNil())
+			Expect(<-serverClients.User("u1").Send("d")).To(BeNil())
+			clients.SendErr = errors.New("send failed")
+			Expect(<-serverClients.Client("c1").Send("e")).To(MatchError("send failed"))
+			var receivers []string
+			for _, message := range clients.Sent() {
+				receivers = append(receivers, message.Receiver)
+			}
+			Expect(receivers).To(Equal([]string{"all", "clients:c1,c2", "users:u1,u2", "user:u1", "client:c1"}))
+			clients.Reset()
+			Expect(clients.Sent()).To(BeEmpty())
+		})
+	})
+
+	Context("When connections are added to and removed from groups", func() {
+		It("should keep the memberships and ttls", func() {
+			groups := NewMockGroupManager()
+			groups.AddToGroupFor("room", "conn1", time.Minute)
+			groups.AddToGroup("room", "conn2")
+			Expect(groups.Members("room")).To(ConsistOf("conn1", "conn2"))
+			Expect(groups.TTL("room", "conn1")).To(Equal(time.Minute))
+			Expect(groups.TTL("room", "conn2")).To(BeZero())
+			groups.RemoveFromGroup("room", "conn1")
+			Expect(groups.IsMember("room", "conn1")).To(BeFalse())
+			groups.RemoveFromGroup("room", "conn2")
+			Expect(groups.Members("room")).To(BeEmpty())
+		})
+	})
+})
@@ -0,0 +1,53 @@
+// Package signalrtest provides in-memory connections, a TestServer and mocks of HubContext, HubClients and
+// GroupManager to unit test hubs without network
+package signalrtest
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// InMemoryConnection is one end of an in-memory pipe. It implements signalr.Connection.
+// A Write blocks until the other end has read the data
+type InMemoryConnection struct {
+	connectionID string
+	reader       *io.PipeReader
+	writer       *io.PipeWriter
+	closeOnce    sync.Once
+}
+
+var pipeCount uint64
+
+// NewInMemoryPipe returns two connections which are connected to each other without network.
+// The server end can be run by signalr.Server.Run, the client end speaks the hub protocol with it.
+// Both ends have the same, unique ConnectionID
+func NewInMemoryPipe() (server *InMemoryConnection, client *InMemoryConnection) {
+	connectionID := fmt.Sprintf("inmemory-%v", atomic.AddUint64(&pipeCount, 1))
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+	return &InMemoryConnection{connectionID: connectionID, reader: serverReader, writer: serverWriter},
+		&InMemoryConnection{connectionID: connectionID, reader: clientReader, writer: clientWriter}
+}
+
+func (c *InMemoryConnection) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *InMemoryConnection) Write(p []byte) (int, error) {
+	return c.writer.Write(p)
+}
+
+func (c *InMemoryConnection) ConnectionID() string {
+	return c.connectionID
+}
+
+// Close closes the connection. The other end reads io.EOF
+func (c *InMemoryConnection) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.reader.Close()
+		_ = c.writer.Close()
+	})
+	return nil
+}
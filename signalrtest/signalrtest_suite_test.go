@@ -0,0 +1,12 @@
+package signalrtest
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"testing"
+)
+
+func TestSignalRTest(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "SignalRTest Suite")
+}
@@ -0,0 +1,332 @@
+package signalrtest
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/philippseith/signalr"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// TestServer runs a hub for unit tests. Its TestClients are connected over in-memory pipes
+// and speak the JSON hub protocol, e.g.
+//
+//	server, _ := signalrtest.NewTestServer(signalr.SimpleHubFactory(&chat{}))
+//	defer server.Close()
+//	client, _ := server.Connect()
+//	var sum int
+//	err := client.Invoke(ctx, "add", &sum, 1, 2)
+//	message, err := client.ExpectInvocation(ctx, "receive")
+type TestServer struct {
+	// Server is the server running the hub, e.g. to send to the clients with HubClients
+	Server *signalr.Server
+	cancel context.CancelFunc
+}
+
+// NewTestServer creates the server with the options, which must contain the hub.
+// The server logs nothing, unless the options contain a signalr.Logger
+func NewTestServer(options ...signalr.Option) (*TestServer, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	options = append([]signalr.Option{signalr.Logger(discardLogger{}, false)}, options...)
+	server, err := signalr.NewServer(ctx, options...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &TestServer{Server: server, cancel: cancel}, nil
+}
+
+// Close ends all connections of the server
+func (s *TestServer) Close() {
+	s.cancel()
+}
+
+// Connect connects a new TestClient to the server and processes the handshake
+func (s *TestServer) Connect() (*TestClient, error) {
+	serverConn, clientConn := NewInMemoryPipe()
+	go s.Server.Run(serverConn)
+	client := &TestClient{
+		conn:    clientConn,
+		reader:  bufio.NewReader(clientConn),
+		pending: make(map[string]chan Message),
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	if err := client.handshake(); err != nil {
+		_ = clientConn.Close()
+		return nil, err
+	}
+	go client.receiveLoop()
+	return client, nil
+}
+
+// Message is a message the server sent to a TestClient
+type Message struct {
+	Type           int               `json:"type"`
+	Headers        map[string]string `json:"headers,omitempty"`
+	Target         string            `json:"target,omitempty"`
+	InvocationID   string            `json:"invocationId,omitempty"`
+	Arguments      []json.RawMessage `json:"arguments,omitempty"`
+	Item           json.RawMessage   `json:"item,omitempty"`
+	Result         json.RawMessage   `json:"result,omitempty"`
+	Error          string            `json:"error,omitempty"`
+	ErrorCode      string            `json:"errorCode,omitempty"`
+	ErrorData      json.RawMessage   `json:"errorData,omitempty"`
+	AllowReconnect bool              `json:"allowReconnect,omitempty"`
+}
+
+// Argument unmarshals the argument with the index into value
+func (m Message) Argument(index int, value interface{}) error {
+	if index < 0 || index >= len(m.Arguments) {
+		return fmt.Errorf("message has %v arguments, no argument %v", len(m.Arguments), index)
+	}
+	return json.Unmarshal(m.Arguments[index], value)
+}
+
+// TestClient is a client of a TestServer
+type TestClient struct {
+	conn   *InMemoryConnection
+	reader *bufio.Reader
+	nextID uint64
+	mx     sync.Mutex
+	// pending are the channels of the invocations waiting for their stream items and completions
+	pending map[string]chan Message
+	// queue are the other messages, which are returned by Next
+	queue  []Message
+	notify chan struct{}
+	done   chan struct{}
+	err    error
+}
+
+// ConnectionID is the ConnectionID of the client on the server
+func (c *TestClient) ConnectionID() string {
+	return c.conn.ConnectionID()
+}
+
+// Close closes the connection of the client
+func (c *TestClient) Close() {
+	_ = c.conn.Close()
+}
+
+// Invoke invokes the hub method and waits for its completion. The result of the method is unmarshaled into
+// result, if result is not nil. Errors of the method are returned as *signalr.HubError
+func (c *TestClient) Invoke(ctx context.Context, method string, result interface{}, args ...interface{}) error {
+	id, messages, err := c.invoke(1, method, args)
+	if err != nil {
+		return err
+	}
+	defer c.release(id)
+	for {
+		message, err := c.receivePending(ctx, messages)
+		if err != nil {
+			return err
+		}
+		if message.Type != 3 {
+			continue
+		}
+		if err := completionError(message); err != nil {
+			return err
+		}
+		if result == nil || message.Result == nil {
+			return nil
+		}
+		return json.Unmarshal(message.Result, result)
+	}
+}
+
+// Stream invokes the streaming hub method and returns all items of the stream when it is completed
+func (c *TestClient) Stream(ctx context.Context, method string, args ...interface{}) ([]json.RawMessage, error) {
+	id, messages, err := c.invoke(4, method, args)
+	if err != nil {
+		return nil, err
+	}
+	defer c.release(id)
+	var items []json.RawMessage
+	for {
+		message, err := c.receivePending(ctx, messages)
+		if err != nil {
+			return items, err
+		}
+		if message.Type == 2 {
+			items = append(items, message.Item)
+			continue
+		}
+		return items, completionError(message)
+	}
+}
+
+// Send invokes the hub method without waiting for its completion
+func (c *TestClient) Send(method string, args ...interface{}) error {
+	return c.write(map[string]interface{}{"type": 1, "target": method, "arguments": arguments(args)})
+}
+
+// Next returns the next message which is no response to Invoke or Stream, e.g. invocations of client methods
+// or the close message. Pings are skipped
+func (c *TestClient) Next(ctx context.Context) (Message, error) {
+	for {
+		c.mx.Lock()
+		if len(c.queue) > 0 {
+			message := c.queue[0]
+			c.queue = c.queue[1:]
+			c.mx.Unlock()
+			return message, nil
+		}
+		c.mx.Unlock()
+		select {
+		case <-c.notify:
+		case <-c.done:
+			// Messages received before the end of the connection are returned first
+			c.mx.Lock()
+			empty := len(c.queue) == 0
+			c.mx.Unlock()
+			if empty {
+				return Message{}, c.err
+			}
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		}
+	}
+}
+
+// ExpectInvocation returns the next invocation of the client method target. The name is case-insensitive.
+// Messages before it are skipped
+func (c *TestClient) ExpectInvocation(ctx context.Context, target string) (Message, error) {
+	for {
+		message, err := c.Next(ctx)
+		if err != nil {
+			return Message{}, fmt.Errorf("no invocation of %v: %w", target, err)
+		}
+		if message.Type == 1 && strings.EqualFold(message.Target, target) {
+			return message, nil
+		}
+	}
+}
+
+func (c *TestClient) handshake() error {
+	if _, err := c.conn.Write([]byte("{\"protocol\":\"json\",\"version\":1}\x1e")); err != nil {
+		return err
+	}
+	data, err := c.reader.ReadBytes(0x1e)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(data[:len(data)-1], &response); err != nil {
+		return err
+	}
+	if response.Error != "" {
+		return fmt.Errorf("handshake failed: %v", response.Error)
+	}
+	return nil
+}
+
+func (c *TestClient) receiveLoop() {
+	defer close(c.done)
+	for {
+		data, err := c.reader.ReadBytes(0x1e)
+		if err != nil {
+			c.err = fmt.Errorf("connection ended: %w", err)
+			return
+		}
+		var message Message
+		if err := json.Unmarshal(data[:len(data)-1], &message); err != nil {
+			c.err = fmt.Errorf("invalid message %q: %w", data, err)
+			_ = c.conn.Close()
+			return
+		}
+		c.dispatch(message)
+	}
+}
+
+func (c *TestClient) dispatch(message Message) {
+	if message.Type == 6 {
+		return
+	}
+	c.mx.Lock()
+	messages, ok := c.pending[message.InvocationID]
+	if ok && (message.Type == 2 || message.Type == 3) {
+		c.mx.Unlock()
+		// The invocation reads its messages until its completion
+		messages <- message
+		return
+	}
+	defer c.mx.Unlock()
+	c.queue = append(c.queue, message)
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *TestClient) invoke(messageType int, method string, args []interface{}) (string, chan Message, error) {
+	id := strconv.FormatUint(atomic.AddUint64(&c.nextID, 1), 10)
+	messages := make(chan Message, 100)
+	c.mx.Lock()
+	c.pending[id] = messages
+	c.mx.Unlock()
+	err := c.write(map[string]interface{}{"type": messageType, "invocationId": id, "target": method,
+		"arguments": arguments(args)})
+	if err != nil {
+		c.release(id)
+	}
+	return id, messages, err
+}
+
+func (c *TestClient) release(id string) {
+	c.mx.Lock()
+	delete(c.pending, id)
+	c.mx.Unlock()
+}
+
+func (c *TestClient) receivePending(ctx context.Context, messages chan Message) (Message, error) {
+	select {
+	case message := <-messages:
+		return message, nil
+	case <-c.done:
+		return Message{}, c.err
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	}
+}
+
+func (c *TestClient) write(message map[string]interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = c.conn.Write(append(data, 0x1e))
+	return err
+}
+
+// arguments returns args, or an empty array for no args. The protocol needs the arguments array
+func arguments(args []interface{}) []interface{} {
+	if args == nil {
+		return []interface{}{}
+	}
+	return args
+}
+
+func completionError(message Message) error {
+	if message.Error == "" {
+		return nil
+	}
+	hubErr := &signalr.HubError{Message: message.Error, Code: message.ErrorCode}
+	if message.ErrorData != nil {
+		if err := json.Unmarshal(message.ErrorData, &hubErr.Data); err != nil {
+			return err
+		}
+	}
+	return hubErr
+}
+
+type discardLogger struct{}
+
+func (discardLogger) Log(...interface{}) error {
+	return nil
+}
@@ -0,0 +1,192 @@
+package signalrtest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/philippseith/signalr"
+	"io"
+	"time"
+)
+
+type testHub struct {
+	signalr.Hub
+}
+
+func (t *testHub) Add(a, b int) int {
+	return a + b
+}
+
+func (t *testHub) Validate(name string) error {
+	if name == "" {
+		return &signalr.HubError{Message: "invalid name", Code: "validation", Data: map[string]string{"field": "name"}}
+	}
+	return nil
+}
+
+func (t *testHub) Count(to int) <-chan int {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 1; i <= to; i++ {
+			ch <- i
+		}
+	}()
+	return ch
+}
+
+func (t *testHub) Broadcast(message string) {
+	t.Clients().All().Send("receive", message, len(message))
+}
+
+func newTestHubServer() *TestServer {
+	server, err := NewTestServer(signalr.SimpleHubFactory(&testHub{}))
+	Expect(err).To(BeNil())
+	return server
+}
+
+func testContext() context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return ctx
+}
+
+var _ = Describe("InMemoryPipe", func() {
+
+	Context("When one end writes", func() {
+		It("should be read by the other end", func() {
+			server, client := NewInMemoryPipe()
+			Expect(server.ConnectionID()).To(Equal(client.ConnectionID()))
+			go func() { _, _ = client.Write([]byte("hello")) }()
+			data := make([]byte, 5)
+			_, err := io.ReadFull(server, data)
+			Expect(err).To(BeNil())
+			Expect(string(data)).To(Equal("hello"))
+		})
+	})
+
+	Context("When one end is closed", func() {
+		It("should end the other end", func() {
+			server, client := NewInMemoryPipe()
+			Expect(client.Close()).To(BeNil())
+			_, err := server.Read(make([]byte, 1))
+			Expect(err).To(Equal(io.EOF))
+			_, err = server.Write([]byte("hello"))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When pipes are created", func() {
+		It("should have unique connection ids", func() {
+			first, _ := NewInMemoryPipe()
+			second, _ := NewInMemoryPipe()
+			Expect(first.ConnectionID()).NotTo(Equal(second.ConnectionID()))
+		})
+	})
+})
+
+var _ = Describe("TestServer", func() {
+
+	Context("When a client invokes a hub method", func() {
+		It("should return the result", func() {
+			server := newTestHubServer()
+			defer server.Close()
+			client, err := server.Connect()
+			Expect(err).To(BeNil())
+			var sum int
+			Expect(client.Invoke(testContext(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+		})
+		It("should return the errors of the method as HubError", func() {
+			server := newTestHubServer()
+			defer server.Close()
+			client, err := server.Connect()
+			Expect(err).To(BeNil())
+			err = client.Invoke(testContext(), "validate", nil, "")
+			var hubErr *signalr.HubError
+			Expect(errors.As(err, &hubErr)).To(BeTrue())
+			Expect(*hubErr).To(Equal(signalr.HubError{Message: "invalid name", Code: "validation",
+				Data: map[string]interface{}{"field": "name"}}))
+			Expect(client.Invoke(testContext(), "unknown", nil)).To(MatchError(ContainSubstring("nknown method")))
+		})
+	})
+
+	Context("When a client invokes a streaming hub method", func() {
+		It("should return the items", func() {
+			server := newTestHubServer()
+			defer server.Close()
+			client, err := server.Connect()
+			Expect(err).To(BeNil())
+			items, err := client.Stream(testContext(), "count", 3)
+			Expect(err).To(BeNil())
+			Expect(items).To(Equal([]json.RawMessage{json.RawMessage("1"), json.RawMessage("2"), json.RawMessage("3")}))
+		})
+	})
+
+	Context("When a hub method invokes client methods", func() {
+		It("should pass the invocations to the clients", func() {
+			server := newTestHubServer()
+			defer server.Close()
+			sender, err := server.Connect()
+			Expect(err).To(BeNil())
+			receiver, err := server.Connect()
+			Expect(err).To(BeNil())
+			Expect(sender.Send("broadcast", "hello")).To(BeNil())
+			for _, client := range []*TestClient{sender, receiver} {
+				message, err := client.ExpectInvocation(testContext(), "Receive")
+				Expect(err).To(BeNil())
+				var text string
+				var length int
+				Expect(message.Argument(0, &text)).To(BeNil())
+				Expect(message.Argument(1, &length)).To(BeNil())
+				Expect(text).To(Equal("hello"))
+				Expect(length).To(Equal(5))
+				Expect(message.Argument(2, &length)).NotTo(BeNil())
+			}
+		})
+		It("should pass the invocations of the server to the client", func() {
+			server := newTestHubServer()
+			defer server.Close()
+			client, err := server.Connect()
+			Expect(err).To(BeNil())
+			Eventually(func() error {
+				return <-server.Server.HubClients().Client(client.ConnectionID()).Send("notify", 1)
+			}).Should(BeNil())
+			message, err := client.ExpectInvocation(testContext(), "notify")
+			Expect(err).To(BeNil())
+			Expect(message.Arguments).To(Equal([]json.RawMessage{json.RawMessage("1")}))
+		})
+	})
+
+	Context("When no message is sent", func() {
+		It("should return the error of the context", func() {
+			server := newTestHubServer()
+			defer server.Close()
+			client, err := server.Connect()
+			Expect(err).To(BeNil())
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			_, err = client.Next(ctx)
+			Expect(err).To(Equal(context.DeadlineExceeded))
+		})
+	})
+
+	Context("When the server is closed", func() {
+		It("should send the close message and end the connection", func() {
+			server := newTestHubServer()
+			client, err := server.Connect()
+			Expect(err).To(BeNil())
+			server.Close()
+			message, err := client.Next(testContext())
+			Expect(err).To(BeNil())
+			Expect(message.Type).To(Equal(7))
+			_, err = client.Next(testContext())
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
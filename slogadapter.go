@@ -0,0 +1,29 @@
+//go:build go1.21
+// +build go1.21
+
+package signalr
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogAdapter creates a StructuredLogger which logs to a *slog.Logger.
+// The message is the value of the "event" key
+func NewSlogAdapter(logger *slog.Logger) StructuredLogger {
+	return &slogAdapter{logger: logger}
+}
+
+type slogAdapter struct {
+	logger *slog.Logger
+}
+
+func (s *slogAdapter) Log(keyvals ...interface{}) error {
+	eventLevel, msg, kvs := splitLogEvent(keyvals)
+	slogLevel := slog.LevelInfo
+	if eventLevel == LogLevelDebug {
+		slogLevel = slog.LevelDebug
+	}
+	s.logger.Log(context.Background(), slogLevel, msg, kvs...)
+	return nil
+}
@@ -0,0 +1,30 @@
+//go:build go1.21
+// +build go1.21
+
+package signalr
+
+import (
+	"bytes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"log/slog"
+)
+
+var _ = Describe("SlogAdapter", func() {
+
+	Context("When events are logged", func() {
+		It("should log them with the level and the event as message", func() {
+			var b bytes.Buffer
+			handler := slog.NewTextHandler(&b, &slog.HandlerOptions{Level: slog.LevelDebug,
+				ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+					if a.Key == slog.TimeKey {
+						return slog.Attr{}
+					}
+					return a
+				}})
+			info, _ := buildInfoDebugLogger(NewSlogAdapter(slog.New(handler)), false, nil, nil)
+			_ = info.Log(evt, "handshake", "protocol", "json")
+			Expect(b.String()).To(Equal("level=INFO msg=handshake protocol=json\n"))
+		})
+	})
+})
@@ -0,0 +1,197 @@
+package signalr
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// statefulConnection is a Connection for stateful reconnect. Its transport can be replaced when the
+// transport failed, e.g. after a short network drop. While no transport is available, Read waits for a new one
+// and Write drops the data, which is replayed by the hubConnection after the transport was replaced.
+type statefulConnection struct {
+	connectionID string
+	// reconnectTimeout is the time Read waits for a new transport
+	reconnectTimeout time.Duration
+	// redial dials a new transport. If redial is nil, the new transport is passed by resume
+	redial         func() (Connection, error)
+	mx             sync.Mutex
+	transport      Connection
+	writeTransport Connection
+	generation     uint64
+	replaced       chan struct{}
+	released       chan struct{}
+	frameType      FrameType
+	// onResume is called with each new transport
+	onResume  func(transport Connection)
+	closed    chan struct{}
+	closeOnce sync.Once
+	info      StructuredLogger
+	// failedTransport is the last transport a Write failed on. Only the first failure of each transport is logged
+	failedTransport Connection
+}
+
+// statefulRedialInterval is the time between two redial attempts of a client
+const statefulRedialInterval = 100 * time.Millisecond
+
+func newStatefulConnection(connectionID string, transport Connection, reconnectTimeout time.Duration,
+	redial func() (Connection, error), info StructuredLogger) *statefulConnection {
+	return &statefulConnection{
+		connectionID:     connectionID,
+		reconnectTimeout: reconnectTimeout,
+		redial:           redial,
+		transport:        transport,
+		writeTransport:   transport,
+		replaced:         make(chan struct{}),
+		released:         make(chan struct{}),
+		onResume:         func(Connection) {},
+		closed:           make(chan struct{}),
+		info:             info,
+	}
+}
+
+func (s *statefulConnection) ConnectionID() string {
+	return s.connectionID
+}
+
+func (s *statefulConnection) Read(p []byte) (n int, err error) {
+	for {
+		s.mx.Lock()
+		transport, replaced := s.transport, s.replaced
+		s.mx.Unlock()
+		if n, err = transport.Read(p); err == nil {
+			return n, nil
+		}
+		if !s.awaitTransport(replaced) {
+			return 0, err
+		}
+	}
+}
+
+// awaitTransport waits until the failed transport is replaced. It returns false if the connection was closed
+// or the reconnect timeout elapsed
+func (s *statefulConnection) awaitTransport(replaced chan struct{}) bool {
+	timeout := time.NewTimer(s.reconnectTimeout)
+	defer timeout.Stop()
+	for {
+		var retry <-chan time.Time
+		if s.redial != nil {
+			if transport, err := s.redial(); err == nil {
+				s.resume(transport)
+				return true
+			}
+			retry = time.After(statefulRedialInterval)
+		}
+		select {
+		case <-replaced:
+			return true
+		case <-s.closed:
+			return false
+		case <-timeout.C:
+			return false
+		case <-retry:
+		}
+	}
+}
+
+// Write writes to the transport. If the transport fails, the data is dropped. It is replayed after resume
+func (s *statefulConnection) Write(p []byte) (n int, err error) {
+	s.mx.Lock()
+	transport := s.writeTransport
+	s.mx.Unlock()
+	if _, err := transport.Write(p); err != nil {
+		s.mx.Lock()
+		first := s.failedTransport != transport
+		s.failedTransport = transport
+		s.mx.Unlock()
+		if first {
+			_ = s.info.Log(evt, "write", "connection", s.connectionID, "error", err,
+				react, "drop data until the transport is replaced")
+		}
+	}
+	return len(p), nil
+}
+
+// resume replaces the transport. The returned channel is closed when transport is not used anymore
+func (s *statefulConnection) resume(transport Connection) <-chan struct{} {
+	s.mx.Lock()
+	select {
+	case <-s.closed:
+		// Too late
+		s.mx.Unlock()
+		return s.closed
+	default:
+	}
+	if frameConn, ok := transport.(frameTypeConnection); ok {
+		frameConn.setFrameType(s.frameType)
+	}
+	s.transport = transport
+	s.generation++
+	close(s.replaced)
+	s.replaced = make(chan struct{})
+	oldReleased := s.released
+	s.released = make(chan struct{})
+	released := s.released
+	onResume := s.onResume
+	s.mx.Unlock()
+	close(oldReleased)
+	onResume(transport)
+	return released
+}
+
+// setOnResume sets the func which is called with each new transport
+func (s *statefulConnection) setOnResume(onResume func(transport Connection)) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.onResume = onResume
+}
+
+// setWriteTransport lets Write use transport. It is called by the writer after the first messages for the new
+// transport were written
+func (s *statefulConnection) setWriteTransport(transport Connection) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.writeTransport = transport
+}
+
+// currentGeneration is incremented each time the transport is replaced
+func (s *statefulConnection) currentGeneration() uint64 {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	return s.generation
+}
+
+func (s *statefulConnection) setBinary() error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.frameType = BinaryFrame
+	if binaryConn, ok := s.transport.(binaryConnection); ok {
+		return binaryConn.setBinary()
+	}
+	return nil
+}
+
+func (s *statefulConnection) setFrameType(frameType FrameType) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.frameType = frameType
+	if frameConn, ok := s.transport.(frameTypeConnection); ok {
+		frameConn.setFrameType(frameType)
+	}
+}
+
+// Close closes the connection and the current transport, if it can be closed
+func (s *statefulConnection) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.mx.Lock()
+		transport := s.transport
+		close(s.closed)
+		close(s.released)
+		s.mx.Unlock()
+		if closer, ok := transport.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	return err
+}
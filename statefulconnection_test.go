@@ -0,0 +1,149 @@
+package signalr
+
+import (
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"time"
+)
+
+func startStatefulTestServer() *httptest.Server {
+	server, _ := NewServer(context.Background(), SimpleHubFactory(&clientTestHub{}), Logger(log.NewNopLogger(), false),
+		StatefulReconnect(5*time.Second))
+	router := http.NewServeMux()
+	server.MapHTTP(router, "/hub")
+	return httptest.NewServer(router)
+}
+
+// dropTransport closes the current transport of the client like a network failure would do
+func dropTransport(client *Client) {
+	stateful := client.currentConnection().transport.(*statefulConnection)
+	stateful.mx.Lock()
+	transport := stateful.transport.(*webSocketConnection)
+	stateful.mx.Unlock()
+	_ = transport.Close()
+}
+
+func unacknowledgedCount(client *Client) int {
+	hubConn := client.currentConnection().hubConn.(*defaultHubConnection)
+	hubConn.sentMx.Lock()
+	defer hubConn.sentMx.Unlock()
+	return len(hubConn.sent)
+}
+
+// failingConnection fails all writes
+type failingConnection struct {
+	discardConnection
+}
+
+func (f *failingConnection) Write([]byte) (int, error) {
+	return 0, errors.New("transport failed")
+}
+
+func newStatefulHubConnection(transport Connection, logger StructuredLogger) *defaultHubConnection {
+	stateful := newStatefulConnection("stateful", transport, time.Second, nil, logger)
+	return newHubConnection(context.Background(), stateful, &JSONHubProtocol{}, 0, logger, logger).(*defaultHubConnection)
+}
+
+var _ = Describe("Stateful reconnect", func() {
+
+	Context("When the transport of a stateful connection fails", func() {
+		It("should resume the connection and send the messages which were not acknowledged", func() {
+			testServer := startStatefulTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false),
+				WithStatefulReconnect(5*time.Second))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(client.currentConnection().transport).To(BeAssignableToTypeOf(&statefulConnection{}))
+			received := make(chan string, 10)
+			Expect(client.On("receive", func(message string, length int) {
+				received <- message
+			})).To(BeNil())
+			connectionID := client.ConnectionID()
+			dropTransport(client)
+			Expect(client.Send("echo", "after drop")).To(BeNil())
+			Eventually(received, 3*time.Second).Should(Receive(Equal("after drop")))
+			Expect(client.ConnectionID()).To(Equal(connectionID))
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(BeNil())
+			Expect(sum).To(Equal(3))
+		})
+	})
+
+	Context("When the messages are received", func() {
+		It("should acknowledge them, so they are not buffered anymore", func() {
+			testServer := startStatefulTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false),
+				WithStatefulReconnect(5*time.Second))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(client.Send("add", 1, 2)).To(BeNil())
+			Expect(client.Send("add", 3, 4)).To(BeNil())
+			Expect(unacknowledgedCount(client)).To(BeNumerically(">", 0))
+			Eventually(func() int { return unacknowledgedCount(client) }, 3*time.Second).Should(Equal(0))
+		})
+	})
+
+	Context("When the server does not allow stateful reconnect", func() {
+		It("should connect without", func() {
+			testServer := startClientTestServer()
+			defer testServer.Close()
+			client, err := Dial(testServer.URL+"/hub", WithLogger(log.NewNopLogger(), false),
+				WithStatefulReconnect(5*time.Second))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(client.currentConnection().transport).To(BeAssignableToTypeOf(&webSocketConnection{}))
+		})
+	})
+
+	Context("When the messages are not acknowledged", func() {
+		It("should close the connection when the buffer is full", func() {
+			conn := newStatefulHubConnection(&discardConnection{"stateful"}, log.NewNopLogger())
+			conn.sentMx.Lock()
+			conn.maxSentBytes = 120
+			conn.sentMx.Unlock()
+			Expect(<-conn.SendInvocation("receive", "message")).To(BeNil())
+			Expect(<-conn.SendInvocation("receive", "message")).To(BeNil())
+			// The acknowledged messages leave the buffer
+			conn.acknowledge(1)
+			Expect(<-conn.SendInvocation("receive", "message")).To(BeNil())
+			Expect(<-conn.SendInvocation("receive", "message")).To(Equal(errReplayBufferFull))
+			Eventually(conn.Closed()).Should(BeClosed())
+			conn.sentMx.Lock()
+			defer conn.sentMx.Unlock()
+			Expect(conn.sent).To(HaveLen(2))
+			Expect(conn.sentBytes).To(BeNumerically("<=", 120))
+		})
+		It("should refuse a buffer size of 0", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&clientTestHub{}), StatefulReconnectBuffer(0))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When the transport fails on write", func() {
+		It("should log the error once per transport", func() {
+			logger := &recordingLogger{}
+			conn := newStatefulHubConnection(&failingConnection{discardConnection{"stateful"}}, logger)
+			// The data is kept for replay, the failure is not returned
+			Expect(<-conn.SendInvocation("receive", "message")).To(BeNil())
+			Expect(<-conn.SendInvocation("receive", "message")).To(BeNil())
+			var failures int
+			logger.mx.Lock()
+			for _, event := range logger.events {
+				for _, value := range event {
+					if err, ok := value.(error); ok && err.Error() == "transport failed" {
+						failures++
+					}
+				}
+			}
+			logger.mx.Unlock()
+			Expect(failures).To(Equal(1))
+		})
+	})
+})
@@ -0,0 +1,135 @@
+package signalr
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ServerStats is a snapshot of the state of a server, e.g. for dashboards
+type ServerStats struct {
+	// StartTime is the time the server was created
+	StartTime time.Time `json:"startTime"`
+	// Uptime is the time since StartTime. In JSON, it is in nanoseconds
+	Uptime time.Duration `json:"uptime"`
+	// Connections is the count of the active connections of all hubs
+	Connections int `json:"connections"`
+	// Hubs are the stats of the hub of the server and the hubs added with AddHub
+	Hubs []HubStats `json:"hubs"`
+}
+
+// HubStats is a snapshot of the state of one hub of a server
+type HubStats struct {
+	// Hub is the type of the hub
+	Hub string `json:"hub"`
+	// Connections is the count of the active connections of the hub
+	Connections int `json:"connections"`
+	// Invocations holds the count of the completed invocations by hub method, since the server was created
+	Invocations map[string]uint64 `json:"invocations"`
+	// Groups holds the count of the members by group name. Only the default group store can list its groups,
+	// so Groups is nil with UseGroupStore
+	Groups map[string]int `json:"groups"`
+	// QueuedMessages is the count of the messages waiting in the outbound queues of all connections of the hub,
+	// MaxQueuedMessages is the longest outbound queue of a connection
+	QueuedMessages    int `json:"queuedMessages"`
+	MaxQueuedMessages int `json:"maxQueuedMessages"`
+}
+
+// serverStats counts what ServerStats can not take from the state of the server
+type serverStats struct {
+	startTime   time.Time
+	mx          sync.Mutex
+	invocations map[string]uint64
+}
+
+func newServerStats() *serverStats {
+	return &serverStats{startTime: time.Now(), invocations: make(map[string]uint64)}
+}
+
+func (s *serverStats) invocationCompleted(method string) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.invocations[method]++
+}
+
+func (s *serverStats) invocationCounts() map[string]uint64 {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	counts := make(map[string]uint64, len(s.invocations))
+	for method, count := range s.invocations {
+		counts[method] = count
+	}
+	return counts
+}
+
+// Stats returns a snapshot of the state of the server and the hubs added with AddHub
+func (s *Server) Stats() ServerStats {
+	stats := ServerStats{
+		StartTime: s.stats.startTime,
+		Uptime:    time.Since(s.stats.startTime),
+		Hubs:      []HubStats{s.hubStats()},
+	}
+	for _, hub := range s.addedHubs() {
+		stats.Hubs = append(stats.Hubs, hub.hubStats())
+	}
+	for _, hub := range stats.Hubs {
+		stats.Connections += hub.Connections
+	}
+	return stats
+}
+
+// StatsHandler returns a http.Handler which serves the Stats of the server as JSON, e.g.
+//
+//	router.Handle("/stats", server.StatsHandler())
+func (s *Server) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(s.Stats())
+	})
+}
+
+func (s *Server) hubStats() HubStats {
+	stats := HubStats{
+		Hub:         s.hubType().String(),
+		Invocations: s.stats.invocationCounts(),
+	}
+	s.loopsMx.Lock()
+	stats.Connections = len(s.loops)
+	for loop := range s.loops {
+		conn := loop.hubConn
+		if traced, ok := conn.(*tracedHubConnection); ok {
+			conn = traced.hubConnection
+		}
+		if q, ok := conn.(interface{ queuedMessages() int }); ok {
+			queued := q.queuedMessages()
+			stats.QueuedMessages += queued
+			if queued > stats.MaxQueuedMessages {
+				stats.MaxQueuedMessages = queued
+			}
+		}
+	}
+	s.loopsMx.Unlock()
+	if local := s.localLifetimeManager(); local != nil {
+		if store, ok := local.groupStore().(*memoryGroupStore); ok {
+			stats.Groups = store.groupSizes()
+		}
+	}
+	return stats
+}
+
+// localLifetimeManager returns the defaultHubLifetimeManager of the server, also behind a backplane
+func (s *Server) localLifetimeManager() *defaultHubLifetimeManager {
+	switch manager := s.lifetimeManager.(type) {
+	case *defaultHubLifetimeManager:
+		return manager
+	case *backplaneHubLifetimeManager:
+		return manager.local
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,88 @@
+package signalr
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+)
+
+var _ = Describe("Stats", func() {
+
+	newStatsServer := func(options ...Option) *Server {
+		server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&chatHub{}),
+			Logger(log.NewNopLogger(), false)}, options...)...)
+		Expect(err).To(BeNil())
+		return server
+	}
+
+	Context("When clients are connected and invoke methods", func() {
+		It("should count the connections and invocations", func() {
+			server := newStatsServer()
+			conn := runAddHubConnection(server)
+			runAddHubConnection(server)
+			for i := 0; i < 2; i++ {
+				conn.ClientSend(`{"type":1,"invocationId":"stats","target":"name"}`)
+				Expect(receiveCompletion(conn).Result).To(Equal("chat"))
+			}
+			Eventually(func() map[string]uint64 { return server.Stats().Hubs[0].Invocations }).
+				Should(Equal(map[string]uint64{"name": 2}))
+			stats := server.Stats()
+			Expect(stats.Connections).To(Equal(2))
+			Expect(stats.Hubs).To(HaveLen(1))
+			Expect(stats.Hubs[0].Hub).To(Equal("signalr.chatHub"))
+			Expect(stats.Hubs[0].Connections).To(Equal(2))
+			Expect(stats.Uptime).To(BeNumerically(">", 0))
+		})
+	})
+
+	Context("When connections are in groups", func() {
+		It("should return the group sizes", func() {
+			server := newStatsServer(UseBackplane(&memoryBackplane{}))
+			first, second := runAddHubConnection(server), runAddHubConnection(server)
+			server.Groups().AddToGroup("room", first.ConnectionID())
+			server.Groups().AddToGroup("room", second.ConnectionID())
+			server.Groups().AddToGroup("lobby", second.ConnectionID())
+			Expect(server.Stats().Hubs[0].Groups).To(Equal(map[string]int{"room": 2, "lobby": 1}))
+		})
+	})
+
+	Context("When hubs are added", func() {
+		It("should return the stats of each hub", func() {
+			server := newStatsServer()
+			metrics, err := server.AddHub(SimpleHubFactory(&metricsHub{}))
+			Expect(err).To(BeNil())
+			runAddHubConnection(server)
+			runAddHubConnection(metrics)
+			runAddHubConnection(metrics)
+			stats := server.Stats()
+			Expect(stats.Connections).To(Equal(3))
+			Expect(stats.Hubs).To(HaveLen(2))
+			Expect(stats.Hubs[1].Hub).To(Equal("signalr.metricsHub"))
+			Expect(stats.Hubs[1].Connections).To(Equal(2))
+		})
+	})
+
+	Context("When the StatsHandler is requested", func() {
+		It("should return the stats as JSON", func() {
+			server := newStatsServer()
+			runAddHubConnection(server)
+			recorder := httptest.NewRecorder()
+			server.StatsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/stats", nil))
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			Expect(recorder.Header().Get("Content-Type")).To(Equal("application/json"))
+			var stats ServerStats
+			Expect(json.Unmarshal(recorder.Body.Bytes(), &stats)).To(BeNil())
+			Expect(stats.Connections).To(Equal(1))
+			Expect(stats.Hubs[0].Hub).To(Equal("signalr.chatHub"))
+		})
+		It("should only allow GET", func() {
+			recorder := httptest.NewRecorder()
+			newStatsServer().StatsHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/stats", nil))
+			Expect(recorder.Code).To(Equal(http.StatusMethodNotAllowed))
+		})
+	})
+})
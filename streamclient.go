@@ -79,7 +79,8 @@ func (c *streamClient) receiveStreamItem(streamItem streamItemMessage) error {
 }
 
 func (c *streamClient) sendChanValSave(upChan reflect.Value, chanVal reflect.Value) error {
-	done := make(chan error, 0)
+	// buffered, so the sending goroutine can end even if the send timed out
+	done := make(chan error, 1)
 	go func() {
 		defer func() {
 			if r := recover(); r != nil {
@@ -160,3 +161,12 @@ func (c *streamClient) receiveCompletionItem(completion completionMessage) error
 	}
 	return fmt.Errorf("received completion with unknown id %v", completion.InvocationID)
 }
+
+// closeUpstreamChannels closes the channels of all client streams which did not receive a completion
+func (c *streamClient) closeUpstreamChannels() {
+	for id, channel := range c.upstreamChannels {
+		channel.Close()
+		delete(c.upstreamChannels, id)
+		delete(c.runningStreams, id)
+	}
+}
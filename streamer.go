@@ -1,57 +1,268 @@
 package signalr
 
 import (
+	"context"
 	"reflect"
 	"sync"
+	"time"
 )
 
-func newStreamer(conn hubConnection) *streamer {
-	return &streamer{make(map[string]chan bool), sync.Mutex{}, conn}
+// StreamBufferPolicy tells what happens when a hub method streams items faster than they can be sent to the client
+type StreamBufferPolicy int
+
+const (
+	// StreamBufferBlock blocks the channel returned by the hub method until the stream buffer has room again
+	StreamBufferBlock StreamBufferPolicy = iota
+	// StreamBufferDropNewest drops the received item when the stream buffer is full
+	StreamBufferDropNewest
+	// StreamBufferDropOldest drops the oldest buffered item when the stream buffer is full
+	StreamBufferDropOldest
+)
+
+// defaultStreamBufferCapacity is the number of items which are buffered per stream by default
+const defaultStreamBufferCapacity = 10
+
+// streamBatching tells how many items of a stream are sent in one write. maxItems below 2 disables batching
+type streamBatching struct {
+	maxItems int
+	// flushInterval is the time the first item of a batch waits for more items. 0 means no limit
+	flushInterval time.Duration
+}
+
+func (b streamBatching) enabled() bool {
+	return b.maxItems > 1
+}
+
+func newStreamer(conn hubConnection, bufferCapacity uint, bufferPolicy StreamBufferPolicy, batching streamBatching,
+	info StructuredLogger) *streamer {
+	return &streamer{
+		streamCancelFuncs: make(map[string]context.CancelFunc),
+		conn:              conn,
+		bufferCapacity:    bufferCapacity,
+		bufferPolicy:      bufferPolicy,
+		batching:          batching,
+		info:              info,
+	}
 }
 
 type streamer struct {
-	streamCancelChans map[string]chan bool
+	streamCancelFuncs map[string]context.CancelFunc
 	sccMutex          sync.Mutex
 	conn              hubConnection
+	bufferCapacity    uint
+	bufferPolicy      StreamBufferPolicy
+	batching          streamBatching
+	info              StructuredLogger
 }
 
-func (s *streamer) Start(invocationID string, reflectedChannel reflect.Value) {
-	cancelChan := make(chan bool)
+type streamFlushKey struct{}
+
+// streamFlush marks the position of a FlushStream call in the items of a stream
+type streamFlush struct{}
+
+// FlushStream sends the batched items of the stream of a streaming hub method at once, see StreamItemBatching.
+// ctx is the context passed to the hub method. The items already sent to the channel returned by the hub method
+// are flushed. Without batching, FlushStream does nothing
+func FlushStream(ctx context.Context) {
+	if flush, ok := ctx.Value(streamFlushKey{}).(chan struct{}); ok {
+		select {
+		case flush <- struct{}{}:
+		default:
+			// A flush is already pending
+		}
+	}
+}
+
+// NewContext creates the context for the stream with the invocationID. It is canceled by Stop
+func (s *streamer) NewContext(parent context.Context, invocationID string) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+	if s.batching.enabled() {
+		ctx = context.WithValue(ctx, streamFlushKey{}, make(chan struct{}, 1))
+	}
 	s.sccMutex.Lock()
 	defer s.sccMutex.Unlock()
-	s.streamCancelChans[invocationID] = cancelChan
-	go func(cancelChan chan bool) {
-		defer func() {
-			s.sccMutex.Lock()
-			defer s.sccMutex.Unlock()
-			delete(s.streamCancelChans, invocationID)
-			close(cancelChan)
-		}()
-		for {
-			// Waits for channel, so might hang
-			if chanResult, ok := reflectedChannel.Recv(); ok {
-				select {
-				case <-cancelChan:
+	s.streamCancelFuncs[invocationID] = cancel
+	return ctx
+}
+
+// Start sends the items received over reflectedChannel as StreamItems until the channel is closed
+// or ctx is canceled. Only one item of the stream is queued for sending at once, the others wait in the stream buffer
+func (s *streamer) Start(ctx context.Context, invocationID string, reflectedChannel reflect.Value) {
+	items := make(chan interface{}, s.bufferCapacity)
+	go s.bufferItems(ctx, invocationID, reflectedChannel, items)
+	if s.batching.enabled() {
+		go s.sendBatches(ctx, invocationID, items)
+		return
+	}
+	go func() {
+		defer s.Stop(invocationID)
+		for item := range items {
+			if ctx.Err() != nil {
+				break
+			}
+			if !s.conn.IsConnected() {
+				// Nobody is listening anymore
+				return
+			}
+			if err := <-s.conn.StreamItem(invocationID, item); err != nil {
+				return
+			}
+		}
+		if s.conn.IsConnected() {
+			s.conn.Completion(invocationID, nil, "")
+		}
+	}()
+}
+
+// sendBatches sends the items in batches of up to maxItems. A batch is sent when it is full, when its first item
+// waited for the flush interval, at a flush and at the end of the stream
+func (s *streamer) sendBatches(ctx context.Context, invocationID string, items chan interface{}) {
+	defer s.Stop(invocationID)
+	batch := make([]interface{}, 0, s.batching.maxItems)
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	send := func() bool {
+		if timer != nil {
+			timer.Stop()
+			timer, timeout = nil, nil
+		}
+		if len(batch) == 0 {
+			return true
+		}
+		if ctx.Err() != nil || !s.conn.IsConnected() {
+			return false
+		}
+		if err := <-s.conn.StreamItems(invocationID, batch); err != nil {
+			return false
+		}
+		batch = make([]interface{}, 0, s.batching.maxItems)
+		return true
+	}
+	for {
+		select {
+		case item, ok := <-items:
+			if !ok {
+				if !send() {
+					return
+				}
+				if ctx.Err() == nil && s.conn.IsConnected() {
 					s.conn.Completion(invocationID, nil, "")
+				}
+				return
+			}
+			if _, flush := item.(streamFlush); flush {
+				if !send() {
+					return
+				}
+				continue
+			}
+			batch = append(batch, item)
+			if len(batch) >= s.batching.maxItems {
+				if !send() {
 					return
+				}
+			} else if len(batch) == 1 && s.batching.flushInterval > 0 {
+				timer = time.NewTimer(s.batching.flushInterval)
+				timeout = timer.C
+			}
+		case <-timeout:
+			timer, timeout = nil, nil
+			if !send() {
+				return
+			}
+		}
+	}
+}
+
+// bufferItems receives the items from reflectedChannel and puts them into items according to the buffer policy.
+// items is closed when reflectedChannel is closed or ctx is canceled.
+// A FlushStream call puts the items left in reflectedChannel and a streamFlush into items
+func (s *streamer) bufferItems(ctx context.Context, invocationID string, reflectedChannel reflect.Value, items chan interface{}) {
+	defer close(items)
+	// Wait for the channel and the cancellation at once, so a hanging producer can be canceled
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: reflectedChannel},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+	}
+	if flush, ok := ctx.Value(streamFlushKey{}).(chan struct{}); ok {
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(flush)})
+	}
+	for {
+		chosen, chanResult, ok := reflect.Select(cases)
+		if chosen == 2 {
+			if !s.flushItems(ctx, invocationID, reflectedChannel, items) {
+				return
+			}
+			continue
+		}
+		if chosen == 1 || !ok {
+			// Canceled or closed
+			return
+		}
+		if !s.pushItem(ctx, invocationID, chanResult.Interface(), items) {
+			return
+		}
+	}
+}
+
+// flushItems puts the items buffered by reflectedChannel and a streamFlush into items.
+// It returns false if the stream ended
+func (s *streamer) flushItems(ctx context.Context, invocationID string, reflectedChannel reflect.Value, items chan interface{}) bool {
+	for i := reflectedChannel.Len(); i > 0; i-- {
+		chanResult, ok := reflectedChannel.TryRecv()
+		if !ok {
+			break
+		}
+		if !s.pushItem(ctx, invocationID, chanResult.Interface(), items) {
+			return false
+		}
+	}
+	select {
+	case items <- streamFlush{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// pushItem puts item into items according to the buffer policy. It returns false if ctx was canceled
+func (s *streamer) pushItem(ctx context.Context, invocationID string, item interface{}, items chan interface{}) bool {
+	switch s.bufferPolicy {
+	case StreamBufferDropNewest:
+		select {
+		case items <- item:
+		default:
+			_ = s.info.Log(evt, "stream buffer full", "invocationId", invocationID, react, "drop newest item")
+		}
+	case StreamBufferDropOldest:
+		for pushed := false; !pushed; {
+			select {
+			case items <- item:
+				pushed = true
+			default:
+				select {
+				case <-items:
+					_ = s.info.Log(evt, "stream buffer full", "invocationId", invocationID, react, "drop oldest item")
 				default:
 				}
-				s.conn.StreamItem(invocationID, chanResult.Interface())
-			} else {
-				s.conn.Completion(invocationID, nil, "")
-				break
 			}
 		}
-	}(cancelChan)
+	default:
+		select {
+		case items <- item:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
 }
 
+// Stop cancels the context of the stream with the invocationID
 func (s *streamer) Stop(invocationID string) {
-	// in goroutine, because cancel might not be read when stream producer hangs
-	go func() {
-		s.sccMutex.Lock()
-		defer s.sccMutex.Unlock()
-		if cancel, ok := s.streamCancelChans[invocationID]; ok {
-			cancel <- true
-		}
-	}()
+	s.sccMutex.Lock()
+	defer s.sccMutex.Unlock()
+	if cancel, ok := s.streamCancelFuncs[invocationID]; ok {
+		cancel()
+		delete(s.streamCancelFuncs, invocationID)
+	}
 }
@@ -0,0 +1,265 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// gatedHubConnection blocks all StreamItem results until release is closed
+type gatedHubConnection struct {
+	hubConnection
+	called    chan interface{}
+	release   chan struct{}
+	completed chan struct{}
+}
+
+func newGatedHubConnection() *gatedHubConnection {
+	return &gatedHubConnection{
+		called:    make(chan interface{}, 10),
+		release:   make(chan struct{}),
+		completed: make(chan struct{}),
+	}
+}
+
+func (g *gatedHubConnection) IsConnected() bool {
+	return true
+}
+
+func (g *gatedHubConnection) StreamItem(id string, item interface{}) <-chan error {
+	g.called <- item
+	result := make(chan error, 1)
+	go func() {
+		<-g.release
+		result <- nil
+	}()
+	return result
+}
+
+func (g *gatedHubConnection) Completion(id string, result interface{}, error string) <-chan error {
+	close(g.completed)
+	return sendResult(nil)
+}
+
+func streamThroughGate(capacity uint, policy StreamBufferPolicy) []interface{} {
+	conn := newGatedHubConnection()
+	s := newStreamer(conn, capacity, policy, streamBatching{}, log.NewNopLogger())
+	ch := make(chan int)
+	s.Start(s.NewContext(context.Background(), "1"), "1", reflect.ValueOf(ch))
+	ch <- 1
+	// 1 is sent, all others have to wait in the buffer
+	first := <-conn.called
+	for i := 2; i <= 5; i++ {
+		ch <- i
+	}
+	close(ch)
+	// Let the streamer buffer the last item before sending goes on
+	time.Sleep(50 * time.Millisecond)
+	close(conn.release)
+	items := []interface{}{first}
+	for {
+		select {
+		case item := <-conn.called:
+			items = append(items, item)
+		case <-conn.completed:
+			// All items were sent before the completion
+			close(conn.called)
+			for item := range conn.called {
+				items = append(items, item)
+			}
+			return items
+		case <-time.After(time.Second):
+			Fail("timed out")
+			return nil
+		}
+	}
+}
+
+// batchingHubConnection records the batches of StreamItems
+type batchingHubConnection struct {
+	hubConnection
+	batches   chan []interface{}
+	completed chan struct{}
+}
+
+func newBatchingHubConnection() *batchingHubConnection {
+	return &batchingHubConnection{batches: make(chan []interface{}, 10), completed: make(chan struct{})}
+}
+
+func (b *batchingHubConnection) IsConnected() bool {
+	return true
+}
+
+func (b *batchingHubConnection) StreamItems(id string, items []interface{}) <-chan error {
+	b.batches <- items
+	return sendResult(nil)
+}
+
+func (b *batchingHubConnection) Completion(id string, result interface{}, error string) <-chan error {
+	close(b.completed)
+	return sendResult(nil)
+}
+
+// writesConnection passes each write to writes
+type writesConnection struct {
+	discardConnection
+	writes chan []byte
+}
+
+func (w *writesConnection) Write(p []byte) (int, error) {
+	w.writes <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+type batchStreamHub struct {
+	Hub
+}
+
+func (b *batchStreamHub) Numbers(count int) <-chan int {
+	ch := make(chan int)
+	go func() {
+		defer close(ch)
+		for i := 1; i <= count; i++ {
+			ch <- i
+		}
+	}()
+	return ch
+}
+
+var _ = Describe("Streamer", func() {
+
+	Context("When the stream buffer is full and the policy is StreamBufferDropNewest", func() {
+		It("should drop the newest items", func() {
+			Expect(streamThroughGate(2, StreamBufferDropNewest)).To(Equal([]interface{}{1, 2, 3}))
+		})
+	})
+
+	Context("When the stream buffer is full and the policy is StreamBufferDropOldest", func() {
+		It("should drop the oldest items", func() {
+			Expect(streamThroughGate(2, StreamBufferDropOldest)).To(Equal([]interface{}{1, 4, 5}))
+		})
+	})
+
+	Context("When the stream buffer is full and the policy is StreamBufferBlock", func() {
+		It("should block the hub channel", func() {
+			conn := newGatedHubConnection()
+			s := newStreamer(conn, 1, StreamBufferBlock, streamBatching{}, log.NewNopLogger())
+			ch := make(chan int)
+			s.Start(s.NewContext(context.Background(), "1"), "1", reflect.ValueOf(ch))
+			ch <- 1
+			<-conn.called
+			// 2 is buffered, 3 waits to be buffered
+			ch <- 2
+			ch <- 3
+			select {
+			case ch <- 4:
+				Fail("buffer did not block")
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(conn.release)
+			Eventually(ch).Should(BeSent(4))
+			close(ch)
+			Eventually(conn.completed).Should(BeClosed())
+		})
+	})
+
+	Context("When stream items are batched by count", func() {
+		It("should send full batches and the rest at the end of the stream", func() {
+			conn := newBatchingHubConnection()
+			s := newStreamer(conn, 10, StreamBufferBlock, streamBatching{maxItems: 3}, log.NewNopLogger())
+			ch := make(chan int)
+			s.Start(s.NewContext(context.Background(), "1"), "1", reflect.ValueOf(ch))
+			for i := 1; i <= 7; i++ {
+				ch <- i
+			}
+			Eventually(conn.batches).Should(Receive(Equal([]interface{}{1, 2, 3})))
+			Eventually(conn.batches).Should(Receive(Equal([]interface{}{4, 5, 6})))
+			Consistently(conn.batches, 50*time.Millisecond).ShouldNot(Receive())
+			close(ch)
+			Eventually(conn.batches).Should(Receive(Equal([]interface{}{7})))
+			Eventually(conn.completed).Should(BeClosed())
+		})
+	})
+
+	Context("When stream items are batched with a flush interval", func() {
+		It("should send the batch when the interval is over", func() {
+			conn := newBatchingHubConnection()
+			s := newStreamer(conn, 10, StreamBufferBlock, streamBatching{maxItems: 100, flushInterval: 50 * time.Millisecond},
+				log.NewNopLogger())
+			ch := make(chan int)
+			s.Start(s.NewContext(context.Background(), "1"), "1", reflect.ValueOf(ch))
+			ch <- 1
+			ch <- 2
+			Eventually(conn.batches).Should(Receive(Equal([]interface{}{1, 2})))
+			close(ch)
+			Eventually(conn.completed).Should(BeClosed())
+		})
+	})
+
+	Context("When the hub method flushes the stream", func() {
+		It("should send the items sent before at once", func() {
+			conn := newBatchingHubConnection()
+			s := newStreamer(conn, 10, StreamBufferBlock, streamBatching{maxItems: 100}, log.NewNopLogger())
+			ch := make(chan int, 5)
+			ctx := s.NewContext(context.Background(), "1")
+			s.Start(ctx, "1", reflect.ValueOf(ch))
+			ch <- 1
+			ch <- 2
+			FlushStream(ctx)
+			Eventually(conn.batches).Should(Receive(Equal([]interface{}{1, 2})))
+			ch <- 3
+			close(ch)
+			Eventually(conn.batches).Should(Receive(Equal([]interface{}{3})))
+			Eventually(conn.completed).Should(BeClosed())
+		})
+	})
+
+	Context("When a batch of stream items is sent", func() {
+		It("should write all items at once", func() {
+			writes := &writesConnection{discardConnection: discardConnection{"1"}, writes: make(chan []byte, 10)}
+			conn := newHubConnection(context.Background(), writes, newJSONTestProtocol(), 0, log.NewNopLogger(), log.NewNopLogger())
+			Expect(<-conn.StreamItems("1", []interface{}{1, 2, 3})).To(BeNil())
+			var data []byte
+			Eventually(writes.writes).Should(Receive(&data))
+			Expect(strings.Count(string(data), "\x1e")).To(Equal(3))
+			Expect(string(data)).To(ContainSubstring(`"item":3`))
+		})
+	})
+
+	Context("When a server batches stream items", func() {
+		It("should send all items and the completion", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&batchStreamHub{}), StreamItemBatching(4, 0),
+				Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(conn)
+			conn.ClientSend(`{"type":4,"invocationId":"1","target":"numbers","arguments":[10]}`)
+			for i := 1; i <= 10; i++ {
+				var message interface{}
+				Eventually(conn.received).Should(Receive(&message))
+				Expect(message.(streamItemMessage).Item).To(Equal(float64(i)))
+			}
+			Expect(receiveCompletion(conn).Error).To(Equal(""))
+		})
+	})
+
+	Context("When StreamItemBatching is used with less than one item", func() {
+		It("should return an error", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&batchStreamHub{}), StreamItemBatching(0, 0))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+
+	Context("When StreamBuffer is used with a drop policy and capacity 0", func() {
+		It("should return an error", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&singleHub{}), StreamBuffer(0, StreamBufferDropNewest))
+			Expect(err).NotTo(BeNil())
+			_, err = NewServer(context.Background(), SimpleHubFactory(&singleHub{}), StreamBuffer(0, StreamBufferBlock), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+		})
+	})
+})
@@ -1,6 +1,8 @@
 package signalr
 
 import (
+	"context"
+	"errors"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"time"
@@ -36,6 +38,28 @@ func (s *streamHub) EndlessStream() <-chan int {
 	return r
 }
 
+func (s *streamHub) EndlessStreamWithContext(ctx context.Context) <-chan int {
+	r := make(chan int)
+	go func() {
+		defer close(r)
+		for i := 1; ; i++ {
+			select {
+			case r <- i:
+			case <-ctx.Done():
+				streamInvocationQueue <- "EndlessStreamWithContext canceled"
+				return
+			}
+		}
+	}()
+	streamInvocationQueue <- "EndlessStreamWithContext()"
+	return r
+}
+
+func (s *streamHub) HangingStream() <-chan int {
+	streamInvocationQueue <- "HangingStream()"
+	return make(chan int)
+}
+
 func (s *streamHub) SliceStream() <-chan []int {
 	r := make(chan []int)
 	go func() {
@@ -51,6 +75,23 @@ func (s *streamHub) SliceStream() <-chan []int {
 	return r
 }
 
+func (s *streamHub) StreamOrError(fail bool) (<-chan int, error) {
+	streamInvocationQueue <- "StreamOrError()"
+	if fail {
+		return nil, errors.New("no stream")
+	}
+	r := make(chan int, 2)
+	r <- 1
+	r <- 2
+	close(r)
+	return r, nil
+}
+
+func (s *streamHub) SendOnlyStream() chan<- int {
+	streamInvocationQueue <- "SendOnlyStream()"
+	return make(chan int)
+}
+
 func (s *streamHub) SimpleInt() int {
 	streamInvocationQueue <- "SimpleInt()"
 	return -1
@@ -79,6 +120,48 @@ var _ = Describe("Streaminvocation", func() {
 		})
 	})
 
+	Describe("Stream invocation of method returning a stream and an error", func() {
+		Context("When the method returns no error", func() {
+			It("should return stream items and a final completion without error", func() {
+				conn := connect(&streamHub{})
+				conn.ClientSend(`{"type":4,"invocationId": "soe1","target":"streamorerror","arguments":[false]}`)
+				Expect(<-streamInvocationQueue).To(Equal("StreamOrError()"))
+				for i := 1; i < 3; i++ {
+					recv := (<-conn.received).(streamItemMessage)
+					Expect(recv.InvocationID).To(Equal("soe1"))
+					Expect(recv.Item).To(Equal(float64(i)))
+				}
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("soe1"))
+				Expect(recv.Error).To(Equal(""))
+			})
+		})
+		Context("When the method returns an error", func() {
+			It("should return a completion with the error", func() {
+				conn := connect(&streamHub{})
+				conn.ClientSend(`{"type":4,"invocationId": "soe2","target":"streamorerror","arguments":[true]}`)
+				Expect(<-streamInvocationQueue).To(Equal("StreamOrError()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("soe2"))
+				Expect(recv.Result).To(BeNil())
+				Expect(recv.Error).To(Equal("no stream"))
+			})
+		})
+	})
+
+	Describe("Stream invocation of method returning a send-only chan", func() {
+		Context("When invoked by the client", func() {
+			It("should return a completion with error", func() {
+				conn := connect(&streamHub{})
+				conn.ClientSend(`{"type":4,"invocationId": "sendonly","target":"sendonlystream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("SendOnlyStream()"))
+				recv := (<-conn.received).(completionMessage)
+				Expect(recv.InvocationID).To(Equal("sendonly"))
+				Expect(recv.Error).NotTo(Equal(""))
+			})
+		})
+	})
+
 	Describe("Slice stream invocation", func() {
 		Context("When invoked by the client", func() {
 			It("should be invoked on the server, return stream items and a final completion without result", func() {
@@ -134,6 +217,55 @@ var _ = Describe("Streaminvocation", func() {
 		})
 	})
 
+	Describe("Stop stream invocation of method with context", func() {
+		Context("When invoked by the client and stopped", func() {
+			It("should cancel the context of the method", func() {
+				conn := connect(&streamHub{})
+				conn.ClientSend(`{"type":4,"invocationId": "ctx","target":"endlessstreamwithcontext"}`)
+				Expect(<-streamInvocationQueue).To(Equal("EndlessStreamWithContext()"))
+				conn.ClientSend(`{"type":5,"invocationId": "ctx"}`)
+				select {
+				case r := <-streamInvocationQueue:
+					Expect(r).To(Equal("EndlessStreamWithContext canceled"))
+				case <-time.After(time.Second):
+					Fail("timed out")
+				}
+			})
+		})
+	})
+
+	Describe("Stop hanging stream invocation", func() {
+		Context("When invoked by the client and stopped before the stream sent any item", func() {
+			It("should return a final completion without result", func() {
+				conn := connect(&streamHub{})
+				conn.ClientSend(`{"type":4,"invocationId": "hang","target":"hangingstream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("HangingStream()"))
+				conn.ClientSend(`{"type":5,"invocationId": "hang"}`)
+				select {
+				case message := <-conn.received:
+					Expect(message).To(BeAssignableToTypeOf(completionMessage{}))
+					Expect(message.(completionMessage).InvocationID).To(Equal("hang"))
+					Expect(message.(completionMessage).Error).To(Equal(""))
+				case <-time.After(1000 * time.Millisecond):
+					Fail("timed out")
+				}
+			})
+		})
+	})
+
+	Describe("CancelInvocation with unknown id", func() {
+		Context("When the client cancels a stream which does not exist", func() {
+			It("should ignore it", func() {
+				conn := connect(&streamHub{})
+				conn.ClientSend(`{"type":5,"invocationId": "unknown"}`)
+				conn.ClientSend(`{"type":4,"invocationId": "after","target":"simplestream"}`)
+				Expect(<-streamInvocationQueue).To(Equal("SimpleStream()"))
+				recv := (<-conn.received).(streamItemMessage)
+				Expect(recv.InvocationID).To(Equal("after"))
+			})
+		})
+	})
+
 	Describe("Invalid CancelInvocation", func() {
 		Context("When invoked by the client and receiving an invalid CancelInvocation", func() {
 			It("should close the connection with an error", func() {
@@ -0,0 +1,89 @@
+package signalr
+
+import "fmt"
+
+// protocolError is the error of a message which is well-formed, but violates the SignalR protocol.
+// Only protocols in strict mode return it
+type protocolError struct {
+	reason string
+}
+
+func (p *protocolError) Error() string {
+	return "protocol error: " + p.reason
+}
+
+func newProtocolError(format string, args ...interface{}) error {
+	return &protocolError{reason: fmt.Sprintf(format, args...)}
+}
+
+// maxInvocationIDLength is the maximum length of invocation and stream ids in strict mode
+const maxInvocationIDLength = 128
+
+// validateMessage checks the rules of the SignalR protocol which the parsers do not check themselves:
+// known message types, the presence of targets and invocation ids and the format of the ids
+func validateMessage(message interface{}) error {
+	switch message := message.(type) {
+	case invocationMessage:
+		if message.Target == "" {
+			return newProtocolError("invocation without target")
+		}
+		if err := validateInvocationID(message.InvocationID, message.Type == 4); err != nil {
+			return err
+		}
+		streamIDs := make(map[string]bool, len(message.StreamIds))
+		for _, streamID := range message.StreamIds {
+			if err := validateInvocationID(streamID, true); err != nil {
+				return err
+			}
+			if streamIDs[streamID] {
+				return newProtocolError("duplicate stream id %q", streamID)
+			}
+			streamIDs[streamID] = true
+		}
+	case streamItemMessage:
+		return validateInvocationID(message.InvocationID, true)
+	case completionMessage:
+		if message.Result != nil && message.Error != "" {
+			return newProtocolError("completion with result and error")
+		}
+		if message.Error == "" && (message.ErrorCode != "" || message.ErrorData != nil) {
+			return newProtocolError("completion with error code or data, but without error")
+		}
+		return validateInvocationID(message.InvocationID, true)
+	case cancelInvocationMessage:
+		return validateInvocationID(message.InvocationID, true)
+	case ackMessage:
+		if message.SequenceID < 1 {
+			return newProtocolError("invalid sequence id %v", message.SequenceID)
+		}
+	case sequenceMessage:
+		if message.SequenceID < 1 {
+			return newProtocolError("invalid sequence id %v", message.SequenceID)
+		}
+	case hubMessage:
+		// All other known types are parsed to their own message types
+		if message.Type != 6 {
+			return newProtocolError("unknown message type %v", message.Type)
+		}
+	}
+	return nil
+}
+
+// validateInvocationID checks that id has at most maxInvocationIDLength printable ASCII characters without spaces
+func validateInvocationID(id string, required bool) error {
+	if id == "" {
+		if required {
+			return newProtocolError("missing invocation id")
+		}
+		return nil
+	}
+	if len(id) > maxInvocationIDLength {
+		return newProtocolError("invocation id longer than %v characters", maxInvocationIDLength)
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return newProtocolError("invalid character in invocation id %q", id)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package signalr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/vmihailenco/msgpack/v5"
+	"math/rand"
+	"strings"
+)
+
+func newStrictProtocols() []HubProtocol {
+	j, m := &JSONHubProtocol{}, &MessagePackHubProtocol{}
+	for _, protocol := range []HubProtocol{j, m} {
+		protocol.setDebugLogger(log.NewNopLogger())
+		protocol.setStrict(true)
+	}
+	return []HubProtocol{j, m}
+}
+
+func isProtocolError(err error) bool {
+	var pErr *protocolError
+	return errors.As(err, &pErr)
+}
+
+// seedMessages are the messages whose frames seed the mutation tests and the fuzz tests
+var seedMessages = []interface{}{
+	invocationMessage{Type: 4, Target: "add", InvocationID: "1", Arguments: []interface{}{1, "a"},
+		StreamIds: []string{"s"}},
+	streamItemMessage{Type: 2, InvocationID: "1", Item: []interface{}{1, map[string]interface{}{"a": 1}}},
+	completionMessage{Type: 3, InvocationID: "1", Result: 5},
+	closeMessage{Type: 7, Error: "failed", AllowReconnect: true},
+	ackMessage{Type: 8, SequenceID: 3},
+}
+
+// writeSeedMessages returns the seedMessages written by protocol, including separators or length prefixes
+func writeSeedMessages(protocol HubProtocol) ([][]byte, error) {
+	frames := make([][]byte, 0, len(seedMessages))
+	for _, message := range seedMessages {
+		var buf bytes.Buffer
+		if err := protocol.WriteMessage(message, &buf); err != nil {
+			return nil, err
+		}
+		frames = append(frames, buf.Bytes())
+	}
+	return frames, nil
+}
+
+func msgpackFrame(values ...interface{}) []byte {
+	data, err := msgpack.Marshal(values)
+	Expect(err).To(BeNil())
+	return data
+}
+
+var _ = Describe("Strict protocol", func() {
+
+	Context("When valid messages are parsed in strict mode", func() {
+		It("should accept them", func() {
+			for _, protocol := range newStrictProtocols() {
+				for _, message := range []interface{}{
+					invocationMessage{Type: 1, Target: "add", InvocationID: "1", Arguments: []interface{}{1}},
+					invocationMessage{Type: 1, Target: "send", Arguments: []interface{}{}},
+					invocationMessage{Type: 4, Target: "count", InvocationID: "a-1", Arguments: []interface{}{},
+						StreamIds: []string{"s1", "s2"}},
+					streamItemMessage{Type: 2, InvocationID: "1", Item: 1.0},
+					completionMessage{Type: 3, InvocationID: "1", Result: 1.0},
+					completionMessage{Type: 3, InvocationID: "1", Error: "failed"},
+					cancelInvocationMessage{Type: 5, InvocationID: "1"},
+					hubMessage{Type: 6},
+					closeMessage{Type: 7},
+					ackMessage{Type: 8, SequenceID: 1},
+				} {
+					var buf bytes.Buffer
+					Expect(protocol.WriteMessage(message, &buf)).To(BeNil())
+					_, frame, err := protocol.splitFrame(buf.Bytes(), true)
+					Expect(err).To(BeNil())
+					_, err = protocol.parseFrame(frame)
+					Expect(err).To(BeNil(), "%T %v", protocol, message)
+				}
+			}
+		})
+	})
+
+	Context("When invalid JSON messages are parsed", func() {
+		It("should reject them in strict mode only", func() {
+			for _, frame := range []string{
+				`{"type":0}`,
+				`{"type":42}`,
+				`null`,
+				`{"type":1,"target":"","arguments":[]}`,
+				`{"type":4,"target":"count","arguments":[]}`,
+				`{"type":1,"target":"add","invocationId":"with space","arguments":[]}`,
+				`{"type":1,"target":"add","invocationId":"` + strings.Repeat("x", 129) + `","arguments":[]}`,
+				`{"type":1,"target":"add","invocationId":"1","arguments":[],"streamIds":["s","s"]}`,
+				`{"type":2,"item":1}`,
+				`{"type":3,"invocationId":"1","result":1,"error":"failed"}`,
+				`{"type":5}`,
+				`{"type":8,"sequenceId":0}`,
+			} {
+				protocol := &JSONHubProtocol{}
+				protocol.setDebugLogger(log.NewNopLogger())
+				_, err := protocol.parseFrame([]byte(frame))
+				Expect(err).To(BeNil(), frame)
+				protocol.setStrict(true)
+				_, err = protocol.parseFrame([]byte(frame))
+				Expect(isProtocolError(err)).To(BeTrue(), frame)
+			}
+		})
+	})
+
+	Context("When invalid MessagePack messages are parsed", func() {
+		It("should reject them in strict mode only", func() {
+			for _, frame := range [][]byte{
+				msgpackFrame(42),
+				msgpackFrame(1, map[string]interface{}{}, "1", "", []interface{}{}),
+				msgpackFrame(4, map[string]interface{}{}, "", "count", []interface{}{}),
+				msgpackFrame(5, map[string]interface{}{}, "bad\x01id"),
+				append(msgpackFrame(5, map[string]interface{}{}, "1"), 0x01),
+			} {
+				protocol := newMessagePackProtocol()
+				_, err := protocol.parseFrame(frame)
+				Expect(err).To(BeNil(), "%v", frame)
+				protocol.setStrict(true)
+				_, err = protocol.parseFrame(frame)
+				Expect(isProtocolError(err)).To(BeTrue(), "%v", frame)
+			}
+		})
+	})
+
+	Context("When a client of a strict server sends an invalid message", func() {
+		It("should close the connection with a protocol error", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&chatHub{}), StrictProtocol(),
+				Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := runAddHubConnection(server)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"","arguments":[]}`)
+			Expect(receiveClose(conn).Error).To(ContainSubstring("protocol error"))
+		})
+	})
+
+	Context("When mutated frames are parsed", func() {
+		It("should never panic", func() {
+			var seeds [][]byte
+			protocols := []HubProtocol{&JSONHubProtocol{}, newMessagePackProtocol()}
+			protocols[0].setDebugLogger(log.NewNopLogger())
+			protocols = append(protocols, newStrictProtocols()...)
+			for _, protocol := range protocols[:2] {
+				written, err := writeSeedMessages(protocol)
+				Expect(err).To(BeNil())
+				for _, data := range written {
+					_, frame, _ := protocol.splitFrame(data, true)
+					seeds = append(seeds, frame)
+				}
+			}
+			random := rand.New(rand.NewSource(1))
+			for i := 0; i < 20000; i++ {
+				data := append([]byte(nil), seeds[random.Intn(len(seeds))]...)
+				for k := random.Intn(4); k >= 0 && len(data) > 0; k-- {
+					p := random.Intn(len(data))
+					switch random.Intn(3) {
+					case 0:
+						data[p] = byte(random.Intn(256))
+					case 1:
+						data = append(data[:p], data[p+1:]...)
+					default:
+						data = append(data[:p], append([]byte{byte(random.Intn(256))}, data[p:]...)...)
+					}
+				}
+				for _, protocol := range protocols {
+					Expect(func() { _, _ = protocol.parseFrame(data) }).NotTo(Panic(), "%T %q", protocol, data)
+				}
+			}
+		})
+	})
+})
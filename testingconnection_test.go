@@ -2,8 +2,10 @@ package signalr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"github.com/go-kit/kit/log"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"io"
@@ -22,11 +24,17 @@ type testingConnection struct {
 	connected    bool
 	cliSendChan  chan string
 	srvSendChan  chan []byte
+	// cliBuf keeps the received data after the last message, a write might contain several messages
+	cliBuf   bytes.Buffer
+	cliBufMx sync.Mutex
 }
 
 var connNum = 0
+var connNumMx sync.Mutex
 
 func (t *testingConnection) ConnectionID() string {
+	connNumMx.Lock()
+	defer connNumMx.Unlock()
 	if t.connectionID == "" {
 		connNum++
 		t.connectionID = fmt.Sprintf("test%v", connNum)
@@ -39,7 +47,8 @@ func (t *testingConnection) Read(b []byte) (n int, err error) {
 }
 
 func (t *testingConnection) Write(b []byte) (n int, err error) {
-	t.srvSendChan <- b
+	// b must not be retained
+	t.srvSendChan <- append([]byte(nil), b...)
 	return len(b), nil
 }
 
@@ -97,20 +106,22 @@ func (t *testingConnection) ClientSend(message string) {
 }
 
 func (t *testingConnection) ClientReceive() (string, error) {
-	var buf bytes.Buffer
 	var data = make([]byte, 1<<15) // 32K
-	var n int
 	for {
-		if message, err := buf.ReadString(30); err != nil {
-			buf.Write(data[:n])
-			if n, err = t.cliReader.Read(data); err == nil {
-				buf.Write(data[:n])
-			} else {
-				return "", err
-			}
-		} else {
-			return message[:len(message)-1], nil
+		t.cliBufMx.Lock()
+		if i := bytes.IndexByte(t.cliBuf.Bytes(), 30); i >= 0 {
+			message := string(t.cliBuf.Next(i + 1)[:i])
+			t.cliBufMx.Unlock()
+			return message, nil
+		}
+		t.cliBufMx.Unlock()
+		n, err := t.cliReader.Read(data)
+		if err != nil {
+			return "", err
 		}
+		t.cliBufMx.Lock()
+		t.cliBuf.Write(data[:n])
+		t.cliBufMx.Unlock()
 	}
 }
 
@@ -240,7 +251,7 @@ var _ = Describe("Handshake", func() {
 
 	Context("When the handshake is sent as partial message to the server", func() {
 		It("should be connected", func() {
-			server, _ := NewServer(SimpleHubFactory(&invocationHub{}))
+			server, _ := NewServer(context.Background(), SimpleHubFactory(&invocationHub{}))
 			conn := newTestingConnectionBeforeHandshake()
 			go server.Run(conn)
 			conn.cliWriter.Write([]byte(`{"protocol"`))
@@ -252,7 +263,7 @@ var _ = Describe("Handshake", func() {
 	})
 	Context("When an invalid handshake is sent as partial message to the server", func() {
 		It("should not be connected", func() {
-			server, _ := NewServer(SimpleHubFactory(&invocationHub{}))
+			server, _ := NewServer(context.Background(), SimpleHubFactory(&invocationHub{}))
 			conn := newTestingConnectionBeforeHandshake()
 			go server.Run(conn)
 			conn.cliWriter.Write([]byte(`{"protocol"`))
@@ -269,7 +280,7 @@ var _ = Describe("Handshake", func() {
 	})
 	Context("When a handshake is sent with an unsupported protocol", func() {
 		It("should return an error handshake response and be not connected", func() {
-			server, _ := NewServer(SimpleHubFactory(&invocationHub{}))
+			server, _ := NewServer(context.Background(), SimpleHubFactory(&invocationHub{}))
 			conn := newTestingConnectionBeforeHandshake()
 			go server.Run(conn)
 			conn.ClientSend(`{"protocol": "bson","version": 1}`)
@@ -288,4 +299,100 @@ var _ = Describe("Handshake", func() {
 			}
 		})
 	})
+	Context("When a handshake is sent with an unsupported protocol version", func() {
+		It("should return an error handshake response and be not connected", func() {
+			server, _ := NewServer(context.Background(), SimpleHubFactory(&invocationHub{}))
+			conn := newTestingConnectionBeforeHandshake()
+			go server.Run(conn)
+			conn.ClientSend(`{"protocol": "json","version": 2}`)
+			response, err := conn.ClientReceive()
+			Expect(err).To(BeNil())
+			jsonMap := make(map[string]interface{})
+			err = json.Unmarshal([]byte(response), &jsonMap)
+			Expect(err).To(BeNil())
+			Expect(jsonMap["error"]).NotTo(BeNil())
+			conn.ClientSend(`{"type":1,"invocationId": "123","target":"simple"}`)
+			select {
+			case <-invocationQueue:
+				Fail("server connected with invalid handshake")
+			case <-time.After(100 * time.Millisecond):
+			}
+		})
+	})
+	Context("When the handshake is rejected", func() {
+		for request, reason := range map[string]string{
+			`{"protocol": "json", "version": "one"}`: "not valid JSON",
+			`{"version": 1}`:                         "has no protocol",
+			`{"protocol": "bson", "version": 1}`:     "supported protocols are json, messagepack",
+			`{"protocol": "json", "version": 3}`:     "the maximum version is 1",
+		} {
+			request, reason := request, reason
+			It(fmt.Sprintf("should describe the error in the response to %v and log the request", request), func() {
+				logger := &recordingLogger{}
+				server, _ := NewServer(context.Background(), SimpleHubFactory(&invocationHub{}), Logger(logger, false))
+				conn := newTestingConnectionBeforeHandshake()
+				go server.Run(conn)
+				conn.ClientSend(request)
+				response, err := conn.ClientReceive()
+				Expect(err).To(BeNil())
+				handshake := handshakeResponse{}
+				Expect(json.Unmarshal([]byte(response), &handshake)).To(BeNil())
+				Expect(handshake.Error).To(ContainSubstring(reason))
+				Eventually(func() []interface{} { return logger.event("handshake rejected") }).Should(ContainElement(request))
+			})
+		}
+	})
+	Context("When no handshake is sent during the HandshakeTimeout", func() {
+		It("should not be connected", func() {
+			server, _ := NewServer(context.Background(), SimpleHubFactory(&invocationHub{}), HandshakeTimeout(100*time.Millisecond))
+			conn := newTestingConnectionBeforeHandshake()
+			done := make(chan struct{})
+			go func() {
+				server.Run(conn)
+				close(done)
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				Fail("handshake did not time out")
+			}
+		})
+		It("should close the connection, so the reader of the handshake ends", func() {
+			server, _ := NewServer(context.Background(), SimpleHubFactory(&invocationHub{}), HandshakeTimeout(100*time.Millisecond),
+				Logger(log.NewNopLogger(), false))
+			reader, writer := io.Pipe()
+			conn := &closableConnection{reader: reader, readDone: make(chan struct{})}
+			go server.Run(conn)
+			Eventually(conn.readDone).Should(BeClosed())
+			_, err := writer.Write([]byte("{}"))
+			Expect(err).To(Equal(io.ErrClosedPipe))
+		})
+	})
 })
+
+// closableConnection blocks in Read until it is closed
+type closableConnection struct {
+	reader   *io.PipeReader
+	readDone chan struct{}
+	once     sync.Once
+}
+
+func (c *closableConnection) Read(p []byte) (int, error) {
+	n, err := c.reader.Read(p)
+	if err != nil {
+		c.once.Do(func() { close(c.readDone) })
+	}
+	return n, err
+}
+
+func (c *closableConnection) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (c *closableConnection) ConnectionID() string {
+	return "closable"
+}
+
+func (c *closableConnection) Close() error {
+	return c.reader.Close()
+}
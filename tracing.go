@@ -0,0 +1,78 @@
+package signalr
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer creates the spans for hub method invocations and for invocations sent to clients.
+// Adapters to tracing systems like OpenTelemetry implement it
+type Tracer interface {
+	// Extract returns a context which contains the trace context propagated by the headers of the
+	// http request which started the connection
+	Extract(ctx context.Context, header http.Header) context.Context
+	// Start starts a span as child of the span in ctx and returns the context containing the new span
+	Start(ctx context.Context, name string, attributes ...SpanAttribute) (context.Context, Span)
+}
+
+// Span is a span started by a Tracer
+type Span interface {
+	RecordError(err error)
+	End()
+}
+
+// SpanAttribute is an attribute of a Span
+type SpanAttribute struct {
+	Key   string
+	Value string
+}
+
+// Span attribute keys
+const (
+	SpanAttributeConnectionID = "signalr.connection_id"
+	SpanAttributeHub          = "signalr.hub"
+	SpanAttributeTarget       = "signalr.target"
+)
+
+type noSpan struct{}
+
+func (noSpan) RecordError(error) {}
+func (noSpan) End()              {}
+
+// tracedHubConnection creates a span for each invocation sent to the client
+type tracedHubConnection struct {
+	hubConnection
+	tracer Tracer
+}
+
+func (t *tracedHubConnection) SendInvocation(target string, args ...interface{}) <-chan error {
+	_, span := t.tracer.Start(t.Context(), "signalr.send/"+target,
+		SpanAttribute{SpanAttributeConnectionID, t.GetConnectionID()},
+		SpanAttribute{SpanAttributeTarget, target})
+	return t.trace(span, t.hubConnection.SendInvocation(target, args...))
+}
+
+func (t *tracedHubConnection) SendPrepared(message *preparedMessage) <-chan error {
+	target := ""
+	if invocation, ok := message.message.(sendOnlyHubInvocationMessage); ok {
+		target = invocation.Target
+	}
+	_, span := t.tracer.Start(t.Context(), "signalr.send/"+target,
+		SpanAttribute{SpanAttributeConnectionID, t.GetConnectionID()},
+		SpanAttribute{SpanAttributeTarget, target})
+	return t.trace(span, t.hubConnection.SendPrepared(message))
+}
+
+// trace ends span when result is received
+func (t *tracedHubConnection) trace(span Span, result <-chan error) <-chan error {
+	traced := make(chan error, 1)
+	go func() {
+		err := <-result
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+		traced <- err
+	}()
+	return traced
+}
@@ -0,0 +1,125 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+)
+
+type tracingHub struct {
+	Hub
+}
+
+func (t *tracingHub) Notify(message string) {
+	t.Clients().Caller().Send("notified", message)
+}
+
+type recordedSpan struct {
+	name       string
+	attributes []SpanAttribute
+	err        error
+	ended      bool
+}
+
+type recordingTracer struct {
+	mx        sync.Mutex
+	spans     []*recordedSpan
+	extracted http.Header
+}
+
+type traceParentKey struct{}
+
+func (r *recordingTracer) Extract(ctx context.Context, header http.Header) context.Context {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.extracted = header
+	return context.WithValue(ctx, traceParentKey{}, header.Get("traceparent"))
+}
+
+func (r *recordingTracer) Start(ctx context.Context, name string, attributes ...SpanAttribute) (context.Context, Span) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	span := &recordedSpan{name: name, attributes: attributes}
+	r.spans = append(r.spans, span)
+	return ctx, &recordingSpan{tracer: r, span: span}
+}
+
+func (r *recordingTracer) span(name string) *recordedSpan {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	for _, span := range r.spans {
+		if span.name == name && span.ended {
+			copied := *span
+			return &copied
+		}
+	}
+	return nil
+}
+
+type recordingSpan struct {
+	tracer *recordingTracer
+	span   *recordedSpan
+}
+
+func (r *recordingSpan) RecordError(err error) {
+	r.tracer.mx.Lock()
+	defer r.tracer.mx.Unlock()
+	r.span.err = err
+}
+
+func (r *recordingSpan) End() {
+	r.tracer.mx.Lock()
+	defer r.tracer.mx.Unlock()
+	r.span.ended = true
+}
+
+var _ = Describe("Tracer", func() {
+
+	Context("When a hub method is invoked", func() {
+		It("should create a span for the invocation and for the invocation sent to the client", func() {
+			tracer := &recordingTracer{}
+			server, err := NewServer(context.Background(), SimpleHubFactory(&tracingHub{}), UseTracer(tracer), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"notify","arguments":["hi"]}`)
+			Eventually(func() *recordedSpan { return tracer.span("tracingHub/notify") }).ShouldNot(BeNil())
+			span := tracer.span("tracingHub/notify")
+			Expect(span.attributes).To(ContainElement(SpanAttribute{SpanAttributeHub, "tracingHub"}))
+			Expect(span.attributes).To(ContainElement(SpanAttribute{SpanAttributeTarget, "notify"}))
+			Expect(span.attributes).To(ContainElement(SpanAttribute{SpanAttributeConnectionID, conn.ConnectionID()}))
+			Expect(span.err).To(BeNil())
+			Eventually(func() *recordedSpan { return tracer.span("signalr.send/notified") }).ShouldNot(BeNil())
+		})
+	})
+
+	Context("When an unknown method is invoked", func() {
+		It("should record the error in the span", func() {
+			tracer := &recordingTracer{}
+			server, err := NewServer(context.Background(), SimpleHubFactory(&tracingHub{}), UseTracer(tracer), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			conn := newTestingConnection()
+			go server.Run(conn)
+			conn.ClientSend(`{"type":1,"invocationId":"1","target":"unknown"}`)
+			Expect(receiveCompletion(conn).Error).NotTo(BeEmpty())
+			Eventually(func() *recordedSpan { return tracer.span("tracingHub/unknown") }).ShouldNot(BeNil())
+			Expect(tracer.span("tracingHub/unknown").err).NotTo(BeNil())
+		})
+	})
+
+	Context("When a connection is started by a http request", func() {
+		It("should extract the trace context from the request headers", func() {
+			tracer := &recordingTracer{}
+			server, err := NewServer(context.Background(), SimpleHubFactory(&tracingHub{}), UseTracer(tracer), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			req := httptest.NewRequest("GET", "/hub", nil)
+			req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+			ctx := server.connectionContext(req)
+			Expect(ctx.Value(traceParentKey{})).To(Equal("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"))
+		})
+	})
+})
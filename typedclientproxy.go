@@ -0,0 +1,74 @@
+package signalr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+var errorChanType = reflect.TypeOf((<-chan error)(nil))
+
+// BindClientProxy fills the exported func fields of the struct target points to with functions
+// which send to the client methods of proxy. This allows strongly typed client calls instead of Send("name", ...)
+//
+//	type ChatClient struct {
+//	    ReceiveMessage func(user, message string) <-chan error
+//	}
+//	var chat ChatClient
+//	err := BindClientProxy(h.Clients().All(), &chat)
+//	chat.ReceiveMessage("Bob", "Hi")
+//
+// The client method name is the field name or the value of the signalr field tag.
+// The func fields may return nothing or a <-chan error which receives the result of Send
+func BindClientProxy(proxy ClientProxy, target interface{}) error {
+	if proxy == nil {
+		return errors.New("proxy is nil")
+	}
+	value := reflect.ValueOf(target)
+	if value.Kind() != reflect.Ptr || value.IsNil() || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("target must be a non nil pointer to a struct, not %T", target)
+	}
+	structValue := value.Elem()
+	structType := structValue.Type()
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		if field.PkgPath != "" || field.Type.Kind() != reflect.Func {
+			// Unexported or no func
+			continue
+		}
+		returnsErrorChan := false
+		switch field.Type.NumOut() {
+		case 0:
+		case 1:
+			if field.Type.Out(0) != errorChanType {
+				return fmt.Errorf("field %v: the result type must be <-chan error, not %v", field.Name, field.Type.Out(0))
+			}
+			returnsErrorChan = true
+		default:
+			return fmt.Errorf("field %v: the func must return nothing or <-chan error", field.Name)
+		}
+		method := field.Name
+		if tag, ok := field.Tag.Lookup("signalr"); ok && tag != "" {
+			method = tag
+		}
+		funcType := field.Type
+		structValue.Field(i).Set(reflect.MakeFunc(funcType, func(in []reflect.Value) []reflect.Value {
+			args := make([]interface{}, 0, len(in))
+			for j, arg := range in {
+				if funcType.IsVariadic() && j == len(in)-1 {
+					for k := 0; k < arg.Len(); k++ {
+						args = append(args, arg.Index(k).Interface())
+					}
+				} else {
+					args = append(args, arg.Interface())
+				}
+			}
+			result := proxy.Send(method, args...)
+			if returnsErrorChan {
+				return []reflect.Value{reflect.ValueOf(result)}
+			}
+			return nil
+		}))
+	}
+	return nil
+}
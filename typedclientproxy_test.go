@@ -0,0 +1,56 @@
+package signalr
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type recordingClientProxy struct {
+	targets []string
+	args    [][]interface{}
+}
+
+func (r *recordingClientProxy) Send(target string, args ...interface{}) <-chan error {
+	r.targets = append(r.targets, target)
+	r.args = append(r.args, args)
+	return sendResult(nil)
+}
+
+type chatClient struct {
+	ReceiveMessage func(user, message string) <-chan error
+	Notify         func(text string) `signalr:"notification"`
+	Numbers        func(prefix string, n ...int) <-chan error
+	unexported     func()
+}
+
+var _ = Describe("BindClientProxy", func() {
+
+	Context("When the target has func fields", func() {
+		It("should send to the client method named like the field or its tag", func() {
+			proxy := &recordingClientProxy{}
+			var chat chatClient
+			Expect(BindClientProxy(proxy, &chat)).To(BeNil())
+			Expect(chat.unexported).To(BeNil())
+			Expect(<-chat.ReceiveMessage("Bob", "Hi")).To(BeNil())
+			chat.Notify("Note")
+			Expect(<-chat.Numbers("p", 1, 2)).To(BeNil())
+			Expect(proxy.targets).To(Equal([]string{"ReceiveMessage", "notification", "Numbers"}))
+			Expect(proxy.args[0]).To(Equal([]interface{}{"Bob", "Hi"}))
+			Expect(proxy.args[1]).To(Equal([]interface{}{"Note"}))
+			Expect(proxy.args[2]).To(Equal([]interface{}{"p", 1, 2}))
+		})
+	})
+
+	Context("When the target is invalid", func() {
+		It("should return an error", func() {
+			proxy := &recordingClientProxy{}
+			Expect(BindClientProxy(proxy, chatClient{})).NotTo(BeNil())
+			Expect(BindClientProxy(proxy, (*chatClient)(nil))).NotTo(BeNil())
+			Expect(BindClientProxy(nil, &chatClient{})).NotTo(BeNil())
+			var wrongResult struct {
+				Send func() error
+			}
+			Expect(BindClientProxy(proxy, &wrongResult)).NotTo(BeNil())
+		})
+	})
+})
@@ -0,0 +1,63 @@
+package signalr
+
+import (
+	"context"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+var _ = Describe("Unix domain socket transport", func() {
+
+	var dir string
+	var listener net.Listener
+	var server *Server
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "signalr")
+		Expect(err).To(BeNil())
+		listener, err = net.Listen("unix", filepath.Join(dir, "hub.sock"))
+		Expect(err).To(BeNil())
+		server, err = NewServer(context.Background(), SimpleHubFactory(&clientTestHub{}), Logger(log.NewNopLogger(), false))
+		Expect(err).To(BeNil())
+	})
+
+	AfterEach(func() {
+		_ = listener.Close()
+		_ = os.RemoveAll(dir)
+	})
+
+	Context("When the server runs the hub protocol directly on the socket", func() {
+		It("should be reachable with a unix address", func() {
+			go func() { _ = server.Serve(listener) }()
+			client, err := Dial("unix://"+filepath.Join(dir, "hub.sock"), WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 1, 2)).To(Succeed())
+			Expect(sum).To(Equal(3))
+		})
+	})
+
+	Context("When the server serves HTTP on the socket", func() {
+		It("should negotiate and connect over the socket", func() {
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub")
+			go func() { _ = http.Serve(listener, router) }()
+			client, err := Dial("http://localhost/hub", WithUnixSocket(filepath.Join(dir, "hub.sock")),
+				WithLogger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			defer client.Close()
+			Expect(client.ConnectionID()).NotTo(Equal(""))
+			var sum int
+			Expect(client.Invoke(context.Background(), "add", &sum, 2, 3)).To(Succeed())
+			Expect(sum).To(Equal(5))
+		})
+	})
+})
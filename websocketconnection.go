@@ -1,27 +1,179 @@
 package signalr
 
 import (
+	"bufio"
 	"bytes"
+	"errors"
 	"golang.org/x/net/websocket"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
 )
 
+// webSocketOptions are the deadlines and the ping interval of WebSocket connections. Zero values disable them
+type webSocketOptions struct {
+	// readTimeout is the time a connection may stay silent. Every received frame, including pongs, extends the deadline
+	readTimeout time.Duration
+	// writeTimeout limits each write
+	writeTimeout time.Duration
+	// pingInterval is the interval of WebSocket pings. The peer answers them with pongs
+	pingInterval time.Duration
+}
+
 type webSocketConnection struct {
 	ws           *websocket.Conn
 	connectionID string
+	writeTimeout time.Duration
+	// writeMx guards the PayloadType of ws, which pings change temporarily
+	writeMx sync.Mutex
+	// pending is the part of the last received frame which did not fit into the buffer passed to Read
+	pending   []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newWebSocketConnection(ws *websocket.Conn, connectionID string, options webSocketOptions) *webSocketConnection {
+	w := &webSocketConnection{ws: ws, connectionID: connectionID, writeTimeout: options.writeTimeout, closed: make(chan struct{})}
+	if options.pingInterval > 0 {
+		go w.pingLoop(options.pingInterval)
+	}
+	return w
 }
 
 func (w *webSocketConnection) ConnectionID() string {
 	return w.connectionID
 }
 
+// setBinary sends all following messages in binary frames. Clients do not accept binary messages in text frames
+func (w *webSocketConnection) setBinary() error {
+	w.setFrameType(BinaryFrame)
+	return nil
+}
+
+func (w *webSocketConnection) setFrameType(frameType FrameType) {
+	w.writeMx.Lock()
+	defer w.writeMx.Unlock()
+	if frameType == BinaryFrame {
+		w.ws.PayloadType = websocket.BinaryFrame
+	} else {
+		w.ws.PayloadType = websocket.TextFrame
+	}
+}
+
+func (w *webSocketConnection) Close() error {
+	w.closeOnce.Do(func() { close(w.closed) })
+	return w.ws.Close()
+}
+
 func (w *webSocketConnection) Write(p []byte) (n int, err error) {
+	w.writeMx.Lock()
+	defer w.writeMx.Unlock()
+	return w.write(p)
+}
+
+// write writes p with the write deadline. w.writeMx must be locked
+func (w *webSocketConnection) write(p []byte) (n int, err error) {
+	if w.writeTimeout > 0 {
+		if err = w.ws.SetWriteDeadline(time.Now().Add(w.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
 	return w.ws.Write(p)
 }
 
+// ping sends a ping frame
+func (w *webSocketConnection) ping() error {
+	w.writeMx.Lock()
+	defer w.writeMx.Unlock()
+	payloadType := w.ws.PayloadType
+	w.ws.PayloadType = websocket.PingFrame
+	_, err := w.write(nil)
+	w.ws.PayloadType = payloadType
+	return err
+}
+
+// pingLoop pings until the connection is closed. A failing ping closes the connection
+func (w *webSocketConnection) pingLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.closed:
+			return
+		case <-ticker.C:
+			if err := w.ping(); err != nil {
+				_ = w.Close()
+				return
+			}
+		}
+	}
+}
+
+// Read reads text and binary frames. A frame larger than p is returned by the following calls
 func (w *webSocketConnection) Read(p []byte) (n int, err error) {
-	var data []byte
-	if err = websocket.Message.Receive(w.ws, &data); err != nil {
-		return 0, err
+	if len(w.pending) == 0 {
+		var data []byte
+		if err = websocket.Message.Receive(w.ws, &data); err != nil {
+			return 0, err
+		}
+		w.pending = data
+	}
+	n = copy(p, w.pending)
+	w.pending = w.pending[n:]
+	return n, nil
+}
+
+// readDeadlineConn extends the read deadline of a net.Conn whenever data arrives.
+// golang.org/x/net/websocket answers pings and drops pongs internally, so this is the only place
+// where pongs are noticed
+type readDeadlineConn struct {
+	net.Conn
+	readTimeout time.Duration
+}
+
+// newReadDeadlineConn returns conn without changes if readTimeout is not positive
+func newReadDeadlineConn(conn net.Conn, readTimeout time.Duration) (net.Conn, error) {
+	if readTimeout <= 0 {
+		return conn, nil
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(readTimeout)); err != nil {
+		return nil, err
+	}
+	return &readDeadlineConn{Conn: conn, readTimeout: readTimeout}, nil
+}
+
+func (r *readDeadlineConn) Read(p []byte) (int, error) {
+	n, err := r.Conn.Read(p)
+	if n > 0 {
+		_ = r.Conn.SetReadDeadline(time.Now().Add(r.readTimeout))
+	}
+	return n, err
+}
+
+// readDeadlineResponseWriter hijacks the connection of a WebSocket upgrade as readDeadlineConn
+type readDeadlineResponseWriter struct {
+	http.ResponseWriter
+	readTimeout time.Duration
+}
+
+func (r *readDeadlineResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("WebSocket upgrade needs a http.Hijacker")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	deadlineConn, err := newReadDeadlineConn(conn, r.readTimeout)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
 	}
-	return bytes.NewReader(data).Read(p)
+	// The data already buffered by the http.Server is read before the connection
+	buffered, _ := rw.Reader.Peek(rw.Reader.Buffered())
+	reader := bufio.NewReader(io.MultiReader(bytes.NewReader(buffered), deadlineConn))
+	return deadlineConn, bufio.NewReadWriter(reader, bufio.NewWriter(deadlineConn)), nil
 }
@@ -2,6 +2,7 @@ package signalr
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/go-kit/kit/log"
@@ -12,6 +13,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 )
@@ -58,7 +60,7 @@ var _ = Describe("Websocket server", func() {
 			go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
 			waitForPort(port)
 			// Negotiate the wrong way
-			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%v/hub/negotiateWebSocketTestServer", port))
+			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%v/hub/negotiate", port))
 			Expect(err).To(BeNil())
 			Expect(resp).NotTo(BeNil())
 			Expect(resp.StatusCode).ToNot(Equal(200))
@@ -88,14 +90,152 @@ var _ = Describe("Websocket server", func() {
 			handShakeAndCallWebSocketTestServer(port, fmt.Sprint(jsonMap["connectionId"]))
 		})
 	})
-})
 
+	Context("When the client requests the messagepack protocol", func() {
+		It("should send binary frames", func() {
+			router := http.NewServeMux()
+			MapHub(router, "/hub", &webSocketHub{})
+			port := freePort()
+			go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			waitForPort(port)
+			ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub", port), "", "http://127.0.0.1")
+			Expect(err).To(BeNil())
+			defer ws.Close()
+			_, err = ws.Write(append([]byte(`{"protocol": "messagepack","version": 1}`), 30))
+			Expect(err).To(BeNil())
+			frame, err := ws.NewFrameReader()
+			Expect(err).To(BeNil())
+			Expect(frame.PayloadType()).To(Equal(byte(websocket.BinaryFrame)))
+		})
+	})
 
+	Context("When text frames are forced for the messagepack protocol", func() {
+		It("should send text frames", func() {
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), WebSocketFrames("messagepack", TextFrame),
+				Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub")
+			port := freePort()
+			go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			waitForPort(port)
+			ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub", port), "", "http://127.0.0.1")
+			Expect(err).To(BeNil())
+			defer ws.Close()
+			_, err = ws.Write(append([]byte(`{"protocol": "messagepack","version": 1}`), 30))
+			Expect(err).To(BeNil())
+			frame, err := ws.NewFrameReader()
+			Expect(err).To(BeNil())
+			Expect(frame.PayloadType()).To(Equal(byte(websocket.TextFrame)))
+		})
+	})
+
+	Context("When a WebSocketPingInterval is set", func() {
+		It("should send ping frames", func() {
+			ws := dialWebSocketTestServer(WebSocketPingInterval(50 * time.Millisecond))
+			defer ws.Close()
+			for {
+				frame, err := ws.NewFrameReader()
+				Expect(err).To(BeNil())
+				_, _ = ioutil.ReadAll(frame)
+				if frame.PayloadType() == websocket.PingFrame {
+					break
+				}
+			}
+		})
+		It("should keep connections which answer the pings beyond the read timeout", func() {
+			ws := dialWebSocketTestServer(WebSocketPingInterval(50*time.Millisecond), WebSocketReadTimeout(300*time.Millisecond))
+			defer ws.Close()
+			received := make(chan string, 10)
+			go func() {
+				for {
+					var message string
+					// Receive answers pings with pongs
+					if err := websocket.Message.Receive(ws, &message); err != nil {
+						close(received)
+						return
+					}
+					received <- message
+				}
+			}()
+			Consistently(received, time.Second).ShouldNot(BeClosed())
+			_, err := ws.Write([]byte(`{"type":1,"invocationId":"1","target":"add2","arguments":[1]}` + "\x1e"))
+			Expect(err).To(BeNil())
+			Eventually(received).Should(Receive(ContainSubstring(`"result":3`)))
+		})
+	})
+
+	Context("When a WebSocketReadTimeout is set", func() {
+		It("should close connections which send nothing", func() {
+			ws := dialWebSocketTestServer(WebSocketReadTimeout(200 * time.Millisecond))
+			defer ws.Close()
+			closed := make(chan error, 1)
+			go func() {
+				for {
+					var message string
+					if err := websocket.Message.Receive(ws, &message); err != nil {
+						closed <- err
+						return
+					}
+				}
+			}()
+			Eventually(closed, 2*time.Second).Should(Receive())
+		})
+	})
+
+	Context("When a frame is larger than the read buffer", func() {
+		It("should return the rest of the frame with the following reads", func() {
+			router := http.NewServeMux()
+			MapHub(router, "/hub", &webSocketHub{})
+			port := freePort()
+			go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+			waitForPort(port)
+			ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub", port), "", "http://127.0.0.1")
+			Expect(err).To(BeNil())
+			defer ws.Close()
+			_, err = ws.Write(append([]byte(`{"protocol": "json","version": 1}`), 30))
+			Expect(err).To(BeNil())
+			var handshakeResponse []byte
+			Expect(websocket.Message.Receive(ws, &handshakeResponse)).To(Succeed())
+			conn := newWebSocketConnection(ws, "", webSocketOptions{})
+			// One frame containing a large invocation followed by a small one
+			large := fmt.Sprintf(`{"type":1,"invocationId":"1","target":"add2","arguments":[1],"padding":"%v"}`,
+				string(bytes.Repeat([]byte("x"), readBufferSize)))
+			_, err = ws.Write([]byte(large + "\x1e" + `{"type":1,"invocationId":"2","target":"add2","arguments":[2]}` + "\x1e"))
+			Expect(err).To(BeNil())
+			protocol := &JSONHubProtocol{}
+			protocol.setDebugLogger(log.NewNopLogger())
+			hubConn := newHubConnection(context.Background(), conn, protocol, 0, log.NewNopLogger(), log.NewNopLogger())
+			message, err := hubConn.Receive()
+			Expect(err).To(BeNil())
+			Expect(message.(completionMessage).Result).To(Equal(3.0))
+		})
+	})
+})
+
+// dialWebSocketTestServer starts a server with options and connects with a WebSocket, which finished the handshake
+func dialWebSocketTestServer(options ...Option) *websocket.Conn {
+	server, err := NewServer(context.Background(), append([]Option{SimpleHubFactory(&webSocketHub{}),
+		Logger(log.NewNopLogger(), false)}, options...)...)
+	Expect(err).To(BeNil())
+	router := http.NewServeMux()
+	server.MapHTTP(router, "/hub")
+	port := freePort()
+	go http.ListenAndServe(fmt.Sprintf("127.0.0.1:%v", port), router)
+	waitForPort(port)
+	ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub", port), "", "http://127.0.0.1")
+	Expect(err).To(BeNil())
+	_, err = ws.Write(append([]byte(`{"protocol": "json","version": 1}`), 30))
+	Expect(err).To(BeNil())
+	var handshakeResponse []byte
+	Expect(websocket.Message.Receive(ws, &handshakeResponse)).To(Succeed())
+	return ws
+}
 
 func negotiateWebSocketTestServer(port int) map[string]interface{} {
 	waitForPort(port)
 	buf := bytes.Buffer{}
-	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%v/hub/negotiateWebSocketTestServer", port), "text/plain;charset=UTF-8", &buf)
+	resp, err := http.Post(fmt.Sprintf("http://127.0.0.1:%v/hub/negotiate", port), "text/plain;charset=UTF-8", &buf)
 	Expect(err).To(BeNil())
 	Expect(resp).ToNot(BeNil())
 	defer resp.Body.Close()
@@ -113,11 +253,11 @@ func handShakeAndCallWebSocketTestServer(port int, connectionID string) {
 	logger := log.NewLogfmtLogger(os.Stderr)
 	protocol := JSONHubProtocol{}
 	protocol.setDebugLogger(level.Debug(logger))
-	ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub?id=%v", port, connectionID), "json", "http://127.0.0.1")
+	ws, err := websocket.Dial(fmt.Sprintf("ws://127.0.0.1:%v/hub?id=%v", port, url.QueryEscape(connectionID)), "json", "http://127.0.0.1")
 	Expect(err).To(BeNil())
 	defer ws.Close()
-	wsConn := webSocketConnection{ws, connectionID}
-	cliConn := newHubConnection(&wsConn, &protocol, level.Info(logger), level.Debug(logger))
+	wsConn := webSocketConnection{ws: ws, connectionID: connectionID}
+	cliConn := newHubConnection(context.Background(), &wsConn, &protocol, 0, level.Info(logger), level.Debug(logger))
 	wsConn.Write(append([]byte(`{"protocol": "json","version": 1}`), 30))
 	wsConn.Write(append([]byte(`{"type":1,"invocationId":"666","target":"add2","arguments":[1]}`), 30))
 	cliConn.Start()
@@ -157,4 +297,4 @@ func waitForPort(port int) {
 		}
 		time.Sleep(100 * time.Millisecond)
 	}
-}
\ No newline at end of file
+}
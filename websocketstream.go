@@ -0,0 +1,439 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// webSocketCompression configures permessage-deflate for WebSocket transports
+type webSocketCompression struct {
+	level int
+	// threshold is the minimum size of a message which is compressed
+	threshold int
+}
+
+// WebSocket opcodes
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// deflateTail is removed from each compressed message and appended before decompressing, see RFC 7692 7.2.1
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// offersPermessageDeflate checks if the WebSocket upgrade request offers permessage-deflate with parameters
+// the server can accept. The server always uses a 32K window, so offers restricting server_max_window_bits are declined
+func offersPermessageDeflate(header http.Header) bool {
+	for _, extensions := range header["Sec-Websocket-Extensions"] {
+		for _, offer := range strings.Split(extensions, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			acceptable := true
+			for _, param := range params[1:] {
+				name, value := strings.TrimSpace(param), ""
+				if i := strings.IndexByte(name, '='); i >= 0 {
+					name, value = strings.TrimSpace(name[:i]), strings.Trim(strings.TrimSpace(name[i+1:]), `"`)
+				}
+				switch name {
+				case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+				case "server_max_window_bits":
+					acceptable = acceptable && value == "15"
+				default:
+					acceptable = false
+				}
+			}
+			if acceptable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// webSocketStream is a server side WebSocket connection over a byte stream, which is either a hijacked
+// HTTP/1.1 connection or a HTTP/2 stream opened by extended CONNECT (RFC 8441).
+// If compression is not nil, it uses permessage-deflate without context takeover, so each message is compressed independently
+type webSocketStream struct {
+	reader       *bufio.Reader
+	writer       io.Writer
+	closer       io.Closer
+	connectionID string
+	compression  *webSocketCompression
+	// maxMessageSize limits the size of a decompressed message. 0 means unlimited
+	maxMessageSize int64
+	writeMx        sync.Mutex
+	opcode         byte
+	closeOnce      sync.Once
+	// pending is the part of the last received message which did not fit into the buffer passed to Read
+	pending []byte
+}
+
+// negotiateCompression returns compression if it is not nil and the client offers permessage-deflate.
+// The response header accepts the offer
+func negotiateCompression(header http.Header, compression *webSocketCompression, response http.Header) *webSocketCompression {
+	if compression == nil || !offersPermessageDeflate(header) {
+		return nil
+	}
+	response.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	return compression
+}
+
+// acceptWebSocket upgrades the HTTP/1.1 request to a WebSocket connection
+func acceptWebSocket(w http.ResponseWriter, req *http.Request, compression *webSocketCompression,
+	maxMessageSize int64) (*webSocketStream, error) {
+	key := req.Header.Get("Sec-Websocket-Key")
+	if key == "" || req.Header.Get("Sec-Websocket-Version") != "13" ||
+		!strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade") {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, errors.New("invalid WebSocket upgrade request")
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil, errors.New("WebSocket upgrade needs a http.Hijacker")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	accept := sha1.Sum([]byte(key + webSocketGUID))
+	header := http.Header{}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", base64.StdEncoding.EncodeToString(accept[:]))
+	compression = negotiateCompression(req.Header, compression, header)
+	var response bytes.Buffer
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = header.Write(&response)
+	response.WriteString("\r\n")
+	if _, err = conn.Write(response.Bytes()); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &webSocketStream{
+		reader:         rw.Reader,
+		writer:         conn,
+		closer:         conn,
+		compression:    compression,
+		maxMessageSize: maxMessageSize,
+		opcode:         wsText,
+	}, nil
+}
+
+// isHTTP2WebSocket checks if the request is an extended CONNECT for a WebSocket over HTTP/2 (RFC 8441).
+// The Go HTTP/2 server only accepts them when GODEBUG contains http2xconnect=1
+func isHTTP2WebSocket(req *http.Request) bool {
+	return req.ProtoMajor == 2 && req.Method == http.MethodConnect && strings.EqualFold(req.Header.Get(":protocol"), "websocket")
+}
+
+// acceptHTTP2WebSocket accepts the extended CONNECT. The request body and the response are the WebSocket stream
+func acceptHTTP2WebSocket(w http.ResponseWriter, req *http.Request, compression *webSocketCompression,
+	maxMessageSize int64) (*webSocketStream, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok || req.Header.Get("Sec-Websocket-Version") != "13" {
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, errors.New("invalid WebSocket extended CONNECT request")
+	}
+	compression = negotiateCompression(req.Header, compression, w.Header())
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &webSocketStream{
+		reader:         bufio.NewReader(req.Body),
+		writer:         &flushWriter{writer: w, flusher: flusher},
+		closer:         req.Body,
+		compression:    compression,
+		maxMessageSize: maxMessageSize,
+		opcode:         wsText,
+	}, nil
+}
+
+// flushWriter sends each write immediately
+type flushWriter struct {
+	writer  io.Writer
+	flusher http.Flusher
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.writer.Write(p)
+	f.flusher.Flush()
+	return n, err
+}
+
+func (s *webSocketStream) ConnectionID() string {
+	return s.connectionID
+}
+
+// setBinary sends all following messages in binary frames
+func (s *webSocketStream) setBinary() error {
+	s.setFrameType(BinaryFrame)
+	return nil
+}
+
+func (s *webSocketStream) setFrameType(frameType FrameType) {
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+	if frameType == BinaryFrame {
+		s.opcode = wsBinary
+	} else {
+		s.opcode = wsText
+	}
+}
+
+// Write sends p as one message. With compression, messages of at least the threshold size are compressed
+func (s *webSocketStream) Write(p []byte) (n int, err error) {
+	payload, compressed := p, false
+	if s.compression != nil && len(p) >= s.compression.threshold {
+		if payload, err = deflateMessage(p, s.compression.level); err != nil {
+			return 0, err
+		}
+		compressed = true
+	}
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+	if err = writeWebSocketFrame(s.writer, s.opcode, compressed, payload, false); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Read reads text and binary messages. A message larger than p is returned by the following calls
+func (s *webSocketStream) Read(p []byte) (n int, err error) {
+	for len(s.pending) == 0 {
+		if s.pending, err = s.readMessage(); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, s.pending)
+	s.pending = s.pending[n:]
+	return n, nil
+}
+
+// readMessage reads the frames of the next data message and answers control frames
+func (s *webSocketStream) readMessage() ([]byte, error) {
+	var message []byte
+	compressed, started := false, false
+	for {
+		frame, err := readWebSocketFrame(s.reader)
+		if err != nil {
+			return nil, err
+		}
+		if !frame.masked {
+			return nil, s.fail(1002, "client frames must be masked")
+		}
+		switch frame.opcode {
+		case wsPing:
+			s.writeMx.Lock()
+			err = writeWebSocketFrame(s.writer, wsPong, false, frame.payload, false)
+			s.writeMx.Unlock()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			_ = s.closeWithStatus(1000)
+			return nil, io.EOF
+		case wsText, wsBinary:
+			if started {
+				return nil, s.fail(1002, "data frame inside of a fragmented message")
+			}
+			started, compressed = true, frame.rsv1
+		case wsContinuation:
+			if !started {
+				return nil, s.fail(1002, "continuation frame without message")
+			}
+		default:
+			return nil, s.fail(1002, fmt.Sprintf("unknown opcode %v", frame.opcode))
+		}
+		message = append(message, frame.payload...)
+		if s.maxMessageSize > 0 && int64(len(message)) > s.maxMessageSize {
+			return nil, s.fail(1009, "message too large")
+		}
+		if frame.fin {
+			break
+		}
+	}
+	if !compressed {
+		return message, nil
+	}
+	if s.compression == nil {
+		return nil, s.fail(1002, "compressed message without permessage-deflate")
+	}
+	message, err := inflateMessage(message, s.maxMessageSize)
+	if err != nil {
+		return nil, s.fail(1007, err.Error())
+	}
+	return message, nil
+}
+
+// fail closes the connection with the status and returns the reason as error
+func (s *webSocketStream) fail(status uint16, reason string) error {
+	_ = s.closeWithStatus(status)
+	return errors.New(reason)
+}
+
+func (s *webSocketStream) Close() error {
+	return s.closeWithStatus(1000)
+}
+
+func (s *webSocketStream) closeWithStatus(status uint16) error {
+	var err error
+	s.closeOnce.Do(func() {
+		payload := make([]byte, 2)
+		binary.BigEndian.PutUint16(payload, status)
+		s.writeMx.Lock()
+		_ = writeWebSocketFrame(s.writer, wsClose, false, payload, false)
+		s.writeMx.Unlock()
+		err = s.closer.Close()
+	})
+	return err
+}
+
+func deflateMessage(p []byte, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = fw.Write(p); err == nil {
+		err = fw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+func inflateMessage(p []byte, maxMessageSize int64) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(deflateTail)))
+	defer func() { _ = fr.Close() }()
+	var r io.Reader = fr
+	if maxMessageSize > 0 {
+		// Read one more byte to detect messages which are too large
+		r = io.LimitReader(fr, maxMessageSize+1)
+	}
+	message, err := ioutil.ReadAll(r)
+	if err == io.ErrUnexpectedEOF {
+		// The flushed stream ends without final block
+		err = nil
+	}
+	if err == nil && maxMessageSize > 0 && int64(len(message)) > maxMessageSize {
+		err = errors.New("message too large")
+	}
+	return message, err
+}
+
+type webSocketFrame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	masked  bool
+	payload []byte
+}
+
+// readWebSocketFrame reads a frame and unmasks its payload
+func readWebSocketFrame(r *bufio.Reader) (webSocketFrame, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return webSocketFrame{}, err
+	}
+	frame := webSocketFrame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: header[0] & 0x0f,
+		masked: header[1]&0x80 != 0,
+	}
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return frame, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return frame, err
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > 1<<31 {
+		return frame, errors.New("WebSocket frame too large")
+	}
+	var mask [4]byte
+	if frame.masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return frame, err
+		}
+	}
+	frame.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, frame.payload); err != nil {
+		return frame, err
+	}
+	if frame.masked {
+		for i := range frame.payload {
+			frame.payload[i] ^= mask[i%4]
+		}
+	}
+	return frame, nil
+}
+
+// writeWebSocketFrame writes payload as one final frame. Clients have to mask their frames
+func writeWebSocketFrame(w io.Writer, opcode byte, rsv1 bool, payload []byte, mask bool) error {
+	var buf bytes.Buffer
+	first := 0x80 | opcode
+	if rsv1 {
+		first |= 0x40
+	}
+	buf.WriteByte(first)
+	var maskBit byte
+	if mask {
+		maskBit = 0x80
+	}
+	switch {
+	case len(payload) < 126:
+		buf.WriteByte(maskBit | byte(len(payload)))
+	case len(payload) <= 0xffff:
+		buf.WriteByte(maskBit | 126)
+		_ = binary.Write(&buf, binary.BigEndian, uint16(len(payload)))
+	default:
+		buf.WriteByte(maskBit | 127)
+		_ = binary.Write(&buf, binary.BigEndian, uint64(len(payload)))
+	}
+	if mask {
+		var key [4]byte
+		if _, err := rand.Read(key[:]); err != nil {
+			return err
+		}
+		buf.Write(key[:])
+		for i, b := range payload {
+			buf.WriteByte(b ^ key[i%4])
+		}
+	} else {
+		buf.Write(payload)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,272 @@
+package signalr
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/go-kit/kit/log"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"golang.org/x/net/websocket"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dialDeflateWebSocket upgrades a connection to the test server with the extensions offer
+func dialDeflateWebSocket(server *httptest.Server, extensions string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	Expect(err).To(BeNil())
+	_, err = fmt.Fprintf(conn, "GET /hub HTTP/1.1\r\nHost: %v\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Origin: http://127.0.0.1\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n"+
+		"Sec-WebSocket-Extensions: %v\r\n\r\n", server.Listener.Addr(), extensions)
+	Expect(err).To(BeNil())
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	Expect(err).To(BeNil())
+	return conn, reader, resp
+}
+
+func startDeflateTestServer(threshold int) *httptest.Server {
+	server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}),
+		WebSocketCompression(6, threshold), Logger(log.NewNopLogger(), false))
+	Expect(err).To(BeNil())
+	router := http.NewServeMux()
+	server.MapHTTP(router, "/hub")
+	return httptest.NewServer(router)
+}
+
+// readDeflateMessage reads the next data frame and returns its decompressed payload
+func readDeflateMessage(conn net.Conn, reader *bufio.Reader) (string, bool) {
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		frame, err := readWebSocketFrame(reader)
+		Expect(err).To(BeNil())
+		Expect(frame.masked).To(BeFalse())
+		if frame.opcode != wsText && frame.opcode != wsBinary {
+			continue
+		}
+		payload := frame.payload
+		if frame.rsv1 {
+			payload, err = inflateMessage(payload, 0)
+			Expect(err).To(BeNil())
+		}
+		return string(payload), frame.rsv1
+	}
+}
+
+// http2WebSocket is a minimal HTTP/2 client for one WebSocket over extended CONNECT (RFC 8441).
+// The Go HTTP client rejects the :protocol pseudo header
+type http2WebSocket struct {
+	conn       net.Conn
+	framer     *http2.Framer
+	writeMx    sync.Mutex
+	data       *io.PipeReader
+	status     string
+	extensions string
+}
+
+func dialHTTP2WebSocket(address string) *http2WebSocket {
+	conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"h2"}})
+	Expect(err).To(BeNil())
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	Expect(err).To(BeNil())
+	framer := http2.NewFramer(conn, conn)
+	Expect(framer.WriteSettings()).To(BeNil())
+	// The server must allow extended CONNECT in its settings
+	frame, err := framer.ReadFrame()
+	Expect(err).To(BeNil())
+	settings := frame.(*http2.SettingsFrame)
+	enableConnect, _ := settings.Value(http2.SettingID(0x8))
+	Expect(enableConnect).To(Equal(uint32(1)))
+	Expect(framer.WriteSettingsAck()).To(BeNil())
+	var headers bytes.Buffer
+	encoder := hpack.NewEncoder(&headers)
+	for _, field := range [][2]string{{":method", "CONNECT"}, {":protocol", "websocket"}, {":scheme", "https"},
+		{":path", "/hub"}, {":authority", address}, {"sec-websocket-version", "13"},
+		{"sec-websocket-extensions", "permessage-deflate"}} {
+		Expect(encoder.WriteField(hpack.HeaderField{Name: field[0], Value: field[1]})).To(BeNil())
+	}
+	Expect(framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: headers.Bytes(), EndHeaders: true})).To(BeNil())
+	data, dataWriter := io.Pipe()
+	s := &http2WebSocket{conn: conn, framer: framer, data: data}
+	responded := make(chan struct{})
+	decoder := hpack.NewDecoder(4096, func(field hpack.HeaderField) {
+		switch field.Name {
+		case ":status":
+			s.status = field.Value
+		case "sec-websocket-extensions":
+			s.extensions = field.Value
+		}
+	})
+	go func() {
+		defer dataWriter.Close()
+		for {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				return
+			}
+			switch f := frame.(type) {
+			case *http2.HeadersFrame:
+				_, _ = decoder.Write(f.HeaderBlockFragment())
+				close(responded)
+			case *http2.DataFrame:
+				data := append([]byte(nil), f.Data()...)
+				if len(data) > 0 {
+					s.writeMx.Lock()
+					_ = framer.WriteWindowUpdate(0, uint32(len(data)))
+					_ = framer.WriteWindowUpdate(1, uint32(len(data)))
+					s.writeMx.Unlock()
+				}
+				if _, err = dataWriter.Write(data); err != nil {
+					return
+				}
+			case *http2.PingFrame:
+				s.writeMx.Lock()
+				_ = framer.WritePing(true, f.Data)
+				s.writeMx.Unlock()
+			}
+		}
+	}()
+	Eventually(responded).Should(BeClosed())
+	return s
+}
+
+func (s *http2WebSocket) Read(p []byte) (int, error) {
+	return s.data.Read(p)
+}
+
+func (s *http2WebSocket) Write(p []byte) (int, error) {
+	s.writeMx.Lock()
+	defer s.writeMx.Unlock()
+	return len(p), s.framer.WriteData(1, false, p)
+}
+
+func (s *http2WebSocket) Close() error {
+	return s.conn.Close()
+}
+
+var _ = Describe("WebSocketCompression", func() {
+
+	Context("When the client offers permessage-deflate", func() {
+		It("should accept it and compress messages above the threshold", func() {
+			server := startDeflateTestServer(20)
+			defer server.Close()
+			conn, reader, resp := dialDeflateWebSocket(server, "permessage-deflate; client_max_window_bits")
+			defer conn.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusSwitchingProtocols))
+			Expect(resp.Header.Get("Sec-WebSocket-Accept")).To(Equal("s3pPLMBiTxaQ9kYGzzhZRbK+xOo="))
+			Expect(resp.Header.Get("Sec-WebSocket-Extensions")).To(HavePrefix("permessage-deflate"))
+			// The client compresses the handshake, but not the invocation
+			handshake, err := deflateMessage(append([]byte(`{"protocol":"json","version":1}`), 30), 6)
+			Expect(err).To(BeNil())
+			Expect(writeWebSocketFrame(conn, wsText, true, handshake, true)).To(BeNil())
+			message, compressed := readDeflateMessage(conn, reader)
+			Expect(message).To(Equal("{}\x1e"))
+			Expect(compressed).To(BeFalse())
+			Expect(writeWebSocketFrame(conn, wsText, false,
+				[]byte(`{"type":1,"invocationId":"123","target":"add2","arguments":[1]}`+"\x1e"), true)).To(BeNil())
+			message, compressed = readDeflateMessage(conn, reader)
+			Expect(message).To(Equal(`{"type":3,"invocationId":"123","result":3}` + "\n\x1e"))
+			Expect(compressed).To(BeTrue())
+		})
+	})
+
+	Context("When the client sends a ping", func() {
+		It("should answer with a pong", func() {
+			server := startDeflateTestServer(0)
+			defer server.Close()
+			conn, reader, _ := dialDeflateWebSocket(server, "permessage-deflate")
+			defer conn.Close()
+			Expect(writeWebSocketFrame(conn, wsPing, false, []byte("hello"), true)).To(BeNil())
+			_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+			frame, err := readWebSocketFrame(reader)
+			Expect(err).To(BeNil())
+			Expect(frame.opcode).To(Equal(byte(wsPong)))
+			Expect(string(frame.payload)).To(Equal("hello"))
+		})
+	})
+
+	Context("When the client restricts the window of the server", func() {
+		It("should not offer permessage-deflate", func() {
+			Expect(offersPermessageDeflate(http.Header{
+				"Sec-Websocket-Extensions": []string{"permessage-deflate; server_max_window_bits=10"}})).To(BeFalse())
+			Expect(offersPermessageDeflate(http.Header{
+				"Sec-Websocket-Extensions": []string{"x-webkit-deflate-frame, permessage-deflate; server_max_window_bits=15"}})).To(BeTrue())
+		})
+	})
+
+	Context("When the client does not offer permessage-deflate", func() {
+		It("should use uncompressed WebSockets", func() {
+			server := startDeflateTestServer(0)
+			defer server.Close()
+			ws, err := websocket.Dial(strings.Replace(server.URL, "http", "ws", 1)+"/hub", "", "http://127.0.0.1")
+			Expect(err).To(BeNil())
+			defer ws.Close()
+			_, err = ws.Write(append([]byte(`{"protocol":"json","version":1}`), 30))
+			Expect(err).To(BeNil())
+			var response string
+			Expect(websocket.Message.Receive(ws, &response)).To(BeNil())
+			Expect(response).To(Equal("{}\x1e"))
+		})
+	})
+
+	Context("When a WebSocket is opened over HTTP/2", func() {
+		It("should run the connection on the stream of the extended CONNECT", func() {
+			if !strings.Contains(os.Getenv("GODEBUG"), "http2xconnect=1") {
+				// The Go HTTP/2 server reads GODEBUG=http2xconnect=1 only at startup, so the spec runs in a new test process
+				cmd := exec.Command(os.Args[0], "-test.run=^TestSignalR$", "-ginkgo.focus=When a WebSocket is opened over HTTP/2")
+				cmd.Env = append(os.Environ(), "GODEBUG="+strings.TrimPrefix(os.Getenv("GODEBUG")+",http2xconnect=1", ","))
+				output, err := cmd.CombinedOutput()
+				Expect(err).To(BeNil(), string(output))
+				Expect(string(output)).To(ContainSubstring("1 Passed"))
+				return
+			}
+			server, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}),
+				WebSocketCompression(6, 20), Logger(log.NewNopLogger(), false))
+			Expect(err).To(BeNil())
+			router := http.NewServeMux()
+			server.MapHTTP(router, "/hub")
+			httpServer := httptest.NewUnstartedServer(router)
+			httpServer.EnableHTTP2 = true
+			httpServer.StartTLS()
+			defer httpServer.Close()
+			stream := dialHTTP2WebSocket(httpServer.Listener.Addr().String())
+			defer stream.Close()
+			Expect(stream.status).To(Equal("200"))
+			Expect(stream.extensions).To(HavePrefix("permessage-deflate"))
+			reader := bufio.NewReader(stream)
+			readMessage := func() string {
+				frame, err := readWebSocketFrame(reader)
+				Expect(err).To(BeNil())
+				if frame.rsv1 {
+					frame.payload, err = inflateMessage(frame.payload, 0)
+					Expect(err).To(BeNil())
+				}
+				return string(frame.payload)
+			}
+			Expect(writeWebSocketFrame(stream, wsText, false, []byte(`{"protocol":"json","version":1}`+"\x1e"), true)).To(BeNil())
+			Expect(readMessage()).To(Equal("{}\x1e"))
+			Expect(writeWebSocketFrame(stream, wsText, false,
+				[]byte(`{"type":1,"invocationId":"123","target":"add2","arguments":[1]}`+"\x1e"), true)).To(BeNil())
+			Expect(readMessage()).To(Equal(`{"type":3,"invocationId":"123","result":3}` + "\n\x1e"))
+		})
+	})
+
+	Context("When the compression level is invalid", func() {
+		It("should return an error", func() {
+			_, err := NewServer(context.Background(), SimpleHubFactory(&webSocketHub{}), WebSocketCompression(10, 0))
+			Expect(err).NotTo(BeNil())
+		})
+	})
+})
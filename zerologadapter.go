@@ -0,0 +1,73 @@
+package signalr
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// NewZerologAdapter creates a StructuredLogger which logs to a *zerolog.Logger of github.com/rs/zerolog.
+// The message is the value of the "event" key, the other keyvals are added as fields of the event.
+// The logger is used by its Debug() and Info() methods and the Fields and Msg methods of the events they return,
+// so the module does not depend on zerolog. NewZerologAdapter fails if logger does not have these methods
+func NewZerologAdapter(logger interface{}) (StructuredLogger, error) {
+	l := reflect.ValueOf(logger)
+	debug, err := zerologLevelMethod(l, "Debug")
+	if err != nil {
+		return nil, err
+	}
+	info, err := zerologLevelMethod(l, "Info")
+	if err != nil {
+		return nil, err
+	}
+	return &zerologAdapter{debug: debug, info: info}, nil
+}
+
+var errNoZerologLogger = errors.New("logger must have the methods Debug() and Info() " +
+	"returning events with a Fields(map[string]interface{}) and a Msg(string) method like *zerolog.Logger")
+
+var fieldsType = reflect.TypeOf(map[string]interface{}{})
+
+// zerologLevelMethod returns the method of the logger which starts an event of the level, e.g. (*zerolog.Logger).Debug
+func zerologLevelMethod(logger reflect.Value, name string) (reflect.Value, error) {
+	if !logger.IsValid() {
+		return reflect.Value{}, errNoZerologLogger
+	}
+	method := logger.MethodByName(name)
+	if !method.IsValid() || method.Type().NumIn() != 0 || method.Type().NumOut() != 1 {
+		return reflect.Value{}, errNoZerologLogger
+	}
+	event := method.Type().Out(0)
+	fields, ok := event.MethodByName("Fields")
+	if !ok || fields.Type.NumIn() != 2 || !fieldsType.AssignableTo(fields.Type.In(1)) ||
+		fields.Type.NumOut() != 1 || fields.Type.Out(0) != event {
+		return reflect.Value{}, errNoZerologLogger
+	}
+	msg, ok := event.MethodByName("Msg")
+	if !ok || msg.Type.NumIn() != 2 || msg.Type.In(1).Kind() != reflect.String {
+		return reflect.Value{}, errNoZerologLogger
+	}
+	return method, nil
+}
+
+type zerologAdapter struct {
+	debug reflect.Value
+	info  reflect.Value
+}
+
+func (z *zerologAdapter) Log(keyvals ...interface{}) error {
+	eventLevel, msg, kvs := splitLogEvent(keyvals)
+	start := z.info
+	if eventLevel == LogLevelDebug {
+		start = z.debug
+	}
+	fields := make(map[string]interface{}, len(kvs)/2)
+	for i := 0; i < len(kvs)-1; i += 2 {
+		fields[fmt.Sprint(kvs[i])] = kvs[i+1]
+	}
+	// zerolog returns a nil *Event if the level is disabled. Its methods handle this
+	event := start.Call(nil)[0]
+	event = event.MethodByName("Fields").Call([]reflect.Value{reflect.ValueOf(fields)})[0]
+	event.MethodByName("Msg").Call([]reflect.Value{reflect.ValueOf(msg)})
+	return nil
+}
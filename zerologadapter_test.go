@@ -0,0 +1,76 @@
+package signalr
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// zerologLikeLogger has the methods of *zerolog.Logger used by NewZerologAdapter
+type zerologLikeLogger struct {
+	debugEnabled bool
+	events       []zerologLikeEvent
+}
+
+type zerologLikeEvent struct {
+	logger *zerologLikeLogger
+	level  string
+	fields map[string]interface{}
+}
+
+func (l *zerologLikeLogger) Debug() *zerologLikeEvent {
+	if !l.debugEnabled {
+		return nil
+	}
+	return &zerologLikeEvent{logger: l, level: "debug"}
+}
+
+func (l *zerologLikeLogger) Info() *zerologLikeEvent {
+	return &zerologLikeEvent{logger: l, level: "info"}
+}
+
+// Fields and Msg handle nil events like zerolog does for disabled levels
+func (e *zerologLikeEvent) Fields(fields interface{}) *zerologLikeEvent {
+	if e != nil {
+		e.fields = fields.(map[string]interface{})
+	}
+	return e
+}
+
+func (e *zerologLikeEvent) Msg(msg string) {
+	if e != nil {
+		e.fields["message"] = msg
+		e.logger.events = append(e.logger.events, *e)
+	}
+}
+
+var _ = Describe("ZerologAdapter", func() {
+
+	Context("When events are logged", func() {
+		It("should start the event of the level and log the keyvals as fields with the event as message", func() {
+			zl := &zerologLikeLogger{}
+			logger, err := NewZerologAdapter(zl)
+			Expect(err).To(BeNil())
+			info, dbg := buildInfoDebugLogger(logger, true, nil, nil)
+			_ = info.Log(evt, "handshake", "protocol", "json")
+			_ = dbg.Log(evt, "write")
+			Expect(zl.events).To(HaveLen(1))
+			Expect(zl.events[0].level).To(Equal("info"))
+			Expect(zl.events[0].fields).To(Equal(map[string]interface{}{"message": "handshake", "protocol": "json"}))
+			zl.debugEnabled = true
+			_ = dbg.Log(evt, "write")
+			Expect(zl.events).To(HaveLen(2))
+			Expect(zl.events[1].level).To(Equal("debug"))
+			Expect(zl.events[1].fields["message"]).To(Equal("write"))
+			Expect(zl.events[1].fields["caller"]).To(HavePrefix("zerologadapter_test.go:"))
+		})
+	})
+
+	Context("When the logger does not have the methods of a zerolog logger", func() {
+		It("should fail", func() {
+			for _, logger := range []interface{}{nil, zerologLikeLogger{}, &recordingLogger{}, &recordingLeveledLogger{}} {
+				_, err := NewZerologAdapter(logger)
+				Expect(err).NotTo(BeNil())
+			}
+		})
+	})
+})